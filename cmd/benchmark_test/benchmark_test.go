@@ -2,6 +2,7 @@ package benchmark_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"flag"
@@ -14,7 +15,9 @@ import (
 	"strconv"
 
 	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	"github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
 	"github.com/valinurovdenis/urlshortener/internal/app/handlers"
+	"github.com/valinurovdenis/urlshortener/internal/app/migrations"
 	"github.com/valinurovdenis/urlshortener/internal/app/service"
 	"github.com/valinurovdenis/urlshortener/internal/app/shortcutgenerator"
 	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
@@ -36,25 +39,31 @@ func main() {
 	var urlStorage urlstorage.URLStorage
 	var userURLStorage urlstorage.UserURLStorage
 	var userStorage userstorage.UserStorage
+	var clickStorage clickstorage.ClickStorage
 	if dbConnection != "" {
 		db, err := sql.Open("pgx", dbConnection)
 		if err != nil {
 			panic(err)
 		}
 		defer db.Close()
+		if err = migrations.Migrate(context.Background(), db); err != nil {
+			panic(err)
+		}
 		storage := urlstorage.NewDatabaseStorage(db)
 		urlStorage = storage
 		userURLStorage = storage
 		userStorage = userstorage.NewDatabaseUserStorage(db)
+		clickStorage = clickstorage.NewDatabaseClickStorage(db)
 	} else {
 		storage := urlstorage.NewSimpleMapLockStorage()
 		urlStorage = storage
 		userURLStorage = storage
 		userStorage = userstorage.NewSimpleUserStorage()
+		clickStorage = clickstorage.NewSimpleClickStorage()
 	}
 
 	generator := shortcutgenerator.NewRandBase64Generator(8)
-	service := service.NewShortenerService(urlStorage, userURLStorage, generator)
+	service := service.NewShortenerService(urlStorage, userURLStorage, clickStorage, generator)
 	auth := auth.NewAuthenticator("secret_benchmark", userStorage)
 	handler := handlers.NewShortenerHandler(*service, *auth, "/")
 	defer service.Stop()
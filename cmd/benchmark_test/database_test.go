@@ -0,0 +1,71 @@
+package benchmark_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/migrations"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Opens storage on database of DATABASE_DSN with batches saved by copy or by insert per url.
+func newDatabaseStorage(b *testing.B, copyBatches bool) *urlstorage.DatabaseStorage {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	db := stdlib.OpenDBFromPool(pool)
+	b.Cleanup(func() {
+		db.Close()
+		pool.Close()
+	})
+	if err = migrations.Migrate(ctx, db); err != nil {
+		b.Fatal(err)
+	}
+	if _, err = db.Exec("TRUNCATE shortener"); err != nil {
+		b.Fatal(err)
+	}
+	storage := urlstorage.NewDatabaseStorage(db)
+	if copyBatches {
+		storage.Pool = pool
+		storage.CopyThreshold = 1
+	}
+	return storage
+}
+
+func BenchmarkDatabaseStorage_StoreMany(b *testing.B) {
+	for _, batchSize := range []int{10, 100, 1000} {
+		for _, copyBatches := range []bool{false, true} {
+			name := "insert_"
+			if copyBatches {
+				name = "copy_"
+			}
+			b.Run(name+strconv.Itoa(batchSize), func(b *testing.B) {
+				storage := newDatabaseStorage(b, copyBatches)
+				ctx := context.Background()
+				batch := make([]urlstorage.URLPair, batchSize)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for j := range batch {
+						id := strconv.Itoa(i) + "_" + strconv.Itoa(j)
+						batch[j] = urlstorage.URLPair{Short: id, Long: "http://site.com/" + id}
+					}
+					if _, err := storage.StoreManyWithContext(ctx, batch, "user"); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "urls/s")
+			})
+		}
+	}
+}
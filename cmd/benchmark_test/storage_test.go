@@ -0,0 +1,79 @@
+package benchmark_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Number of urls stored before measuring.
+const preloadedURLs = 10000
+
+type storageUnderTest interface {
+	urlstorage.URLStorage
+	urlstorage.UserURLStorage
+}
+
+var storages = []struct {
+	name string
+	new  func() storageUnderTest
+}{
+	{name: "single_lock", new: func() storageUnderTest { return urlstorage.NewSimpleMapLockStorage() }},
+	{name: "sharded", new: func() storageUnderTest { return urlstorage.NewShardedStorage(urlstorage.DefaultShards) }},
+}
+
+// Runs redirects mixed with given percent of writes from parallel goroutines.
+func benchmarkMixedLoad(b *testing.B, storage storageUnderTest, writePercent int) {
+	ctx := context.Background()
+	for i := range preloadedURLs {
+		id := strconv.Itoa(i)
+		if err := storage.StoreWithContext(ctx, "http://site.com/"+id, id, "user_"+strconv.Itoa(i%100), urlstorage.URLOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var written atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if rand.IntN(100) < writePercent {
+				id := "new_" + strconv.FormatInt(written.Add(1), 10)
+				storage.StoreWithContext(ctx, "http://site.com/"+id, id, "user_"+id, urlstorage.URLOptions{})
+			} else {
+				storage.GetLongURLWithContext(ctx, strconv.Itoa(rand.IntN(preloadedURLs)))
+			}
+		}
+	})
+}
+
+func BenchmarkStorage_MixedLoad(b *testing.B) {
+	for _, writePercent := range []int{1, 10, 50} {
+		for _, s := range storages {
+			b.Run(s.name+"_writes_"+strconv.Itoa(writePercent), func(b *testing.B) {
+				benchmarkMixedLoad(b, s.new(), writePercent)
+			})
+		}
+	}
+}
+
+func BenchmarkStorage_UserURLs(b *testing.B) {
+	ctx := context.Background()
+	for _, s := range storages {
+		b.Run(s.name, func(b *testing.B) {
+			storage := s.new()
+			for i := range preloadedURLs {
+				id := strconv.Itoa(i)
+				storage.StoreWithContext(ctx, "http://site.com/"+id, id, "user_"+strconv.Itoa(i%100), urlstorage.URLOptions{})
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					storage.GetUserURLs(ctx, "user_"+strconv.Itoa(rand.IntN(100)))
+				}
+			})
+		})
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 
@@ -24,6 +26,26 @@ func main() {
 	stopped := make(chan struct{}, 1)
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer stop()
+
+	runner.GetConfig()
+	switch flag.Arg(0) {
+	case "migrate":
+		if err := runner.Migrate(ctx, flag.Args()[1:], os.Stdout); err != nil {
+			panic(err)
+		}
+		return
+	case "export":
+		if err := runner.Export(ctx, flag.Args()[1:], os.Stdout, os.Stderr); err != nil {
+			panic(err)
+		}
+		return
+	case "import":
+		if err := runner.Import(ctx, flag.Args()[1:], os.Stdin, os.Stderr); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	if err := runner.Run(ctx, stopped); err != http.ErrServerClosed {
 		panic(err)
 	}
@@ -3,9 +3,17 @@ package runner
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+	"github.com/valinurovdenis/urlshortener/internal/app/shortcutgenerator"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
 )
 
 // Struct contains all service settings.
@@ -21,6 +29,98 @@ type Config struct {
 	EnableHTTPS  bool   `env:"ENABLE_HTTPS" json:"enable_https"`
 	ShortLength  int
 	IsProduction bool
+
+	// Kind of short url generator: random, base62, base64url, counter or hash.
+	Generator string `env:"SHORT_GENERATOR" json:"short_generator"`
+	// Size of file storage in bytes after which it is compacted, zero disables compaction.
+	FileStorageCompactSize int64 `env:"FILE_STORAGE_COMPACT_SIZE" json:"file_storage_compact_size"`
+	// How often expired urls are purged, zero disables purging.
+	ExpiredSweepInterval time.Duration `env:"EXPIRED_SWEEP_INTERVAL"`
+	// How long expired urls are kept before purging.
+	ExpiredGracePeriod time.Duration `env:"EXPIRED_GRACE_PERIOD"`
+	// Characters allowed in custom short urls.
+	AliasCharset   string `env:"ALIAS_CHARSET" json:"alias_charset"`
+	AliasMinLength int    `env:"ALIAS_MIN_LENGTH" json:"alias_min_length"`
+	AliasMaxLength int    `env:"ALIAS_MAX_LENGTH" json:"alias_max_length"`
+	// Comma separated words forbidden as custom short urls in addition to service routes.
+	AliasReserved string `env:"ALIAS_RESERVED" json:"alias_reserved"`
+	// Subnet in CIDR notation allowed to get internal stats and metrics, empty forbids everyone.
+	TrustedSubnet string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	// Comma separated subnets in CIDR notation of proxies whose X-Real-IP and X-Forwarded-For headers are trusted.
+	TrustedProxies string `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	// Address and port to run grpc server, empty disables grpc.
+	// Grpc server uses tls config of http server if https is enabled.
+	GRPCAddress string `env:"GRPC_ADDRESS" json:"grpc_address"`
+	// File of deletion jobs, by default file storage path with .deletions suffix.
+	// Jobs are kept in database if it is set and in memory if neither file is set.
+	DeletionQueuePath string `env:"DELETION_QUEUE_PATH" json:"deletion_queue_path"`
+	// How often due deletion jobs are processed.
+	DeletionFlushInterval time.Duration `env:"DELETION_FLUSH_INTERVAL"`
+	// Maximal number of deletion jobs processed at once.
+	DeletionBatchSize int `env:"DELETION_BATCH_SIZE" json:"deletion_batch_size"`
+	// Number of failed attempts after which deletion job is marked failed.
+	DeletionMaxAttempts int `env:"DELETION_MAX_ATTEMPTS" json:"deletion_max_attempts"`
+	// Delay after first failed deletion attempt, doubled after every next one.
+	DeletionRetryBackoff time.Duration `env:"DELETION_RETRY_BACKOFF"`
+	// How long done and failed deletion jobs are kept after creation.
+	DeletionRetention time.Duration `env:"DELETION_RETENTION"`
+	// Requests creating short urls allowed per minute for every user or ip, zero disables limit.
+	RateLimitCreate int `env:"RATE_LIMIT_CREATE" json:"rate_limit_create"`
+	// Urls in batch requests allowed per minute for every user or ip, zero disables limit.
+	RateLimitBatchURLs int `env:"RATE_LIMIT_BATCH_URLS" json:"rate_limit_batch_urls"`
+	// Redirects allowed per minute for every user or ip, zero disables limit.
+	RateLimitRedirect int `env:"RATE_LIMIT_REDIRECT" json:"rate_limit_redirect"`
+	// Whether rate limits are shared by replicas through database.
+	RateLimitShared bool `env:"RATE_LIMIT_SHARED" json:"rate_limit_shared"`
+	// Comma separated schemes allowed in long urls.
+	URLSchemes string `env:"URL_ALLOWED_SCHEMES" json:"url_allowed_schemes"`
+	// File of domains forbidden in long urls, empty forbids nothing.
+	URLBlocklistPath string `env:"URL_BLOCKLIST_PATH" json:"url_blocklist_path"`
+	// File of the only domains allowed in long urls, empty allows everything.
+	URLAllowlistPath string `env:"URL_ALLOWLIST_PATH" json:"url_allowlist_path"`
+	// Whether long urls may point to literal loopback and private ips.
+	URLAllowPrivateIPs bool `env:"URL_ALLOW_PRIVATE_IPS" json:"url_allow_private_ips"`
+	// How often changed domain files are reread.
+	URLPolicyReloadInterval time.Duration `env:"URL_POLICY_RELOAD_INTERVAL"`
+	// Comma separated query parameters ignored when urls are deduplicated, names ending with * match by prefix.
+	URLStripParams string `env:"URL_STRIP_PARAMS" json:"url_strip_params"`
+	// Scope in which equal long urls share short url: global, user or none.
+	// Changed scope applies to urls saved after change.
+	DedupScope string `env:"DEDUP_SCOPE" json:"dedup_scope"`
+	// Number of rendered qr codes kept in memory, zero disables caching.
+	QRCacheSize int `env:"QR_CACHE_SIZE" json:"qr_cache_size"`
+	// Status of redirect for urls created without own one: 301, 302, 303, 307 or 308.
+	RedirectCode int `env:"REDIRECT_CODE" json:"redirect_code"`
+	// How long clients may cache permanent redirects.
+	RedirectMaxAge time.Duration `env:"REDIRECT_MAX_AGE"`
+	// Number of short urls whose lookups are cached in memory, zero disables caching.
+	URLCacheSize int `env:"URL_CACHE_SIZE" json:"url_cache_size"`
+	// How long looked up urls stay in cache.
+	URLCacheTTL time.Duration `env:"URL_CACHE_TTL"`
+	// How long absence of looked up urls stays in cache.
+	URLCacheNegativeTTL time.Duration `env:"URL_CACHE_NEGATIVE_TTL"`
+	// Number of shards of in-memory url storage, zero means storage with single lock.
+	MemoryShards int `env:"MEMORY_SHARDS" json:"memory_shards"`
+	// File of embedded url storage used instead of memory when database is not set.
+	StoragePath string `env:"STORAGE_PATH" json:"storage_path"`
+	// File of online backups of embedded url storage, empty disables backups.
+	StorageBackupPath string `env:"STORAGE_BACKUP_PATH" json:"storage_backup_path"`
+	// Interval of online backups of embedded url storage.
+	StorageBackupInterval time.Duration `env:"STORAGE_BACKUP_INTERVAL"`
+	// Whether database is accessed through native pgx connection pool.
+	DatabasePool bool `env:"DATABASE_POOL" json:"database_pool"`
+	// Maximal number of database connections, zero keeps default of driver.
+	DatabaseMaxConns int `env:"DATABASE_MAX_CONNS" json:"database_max_conns"`
+	// Number of database connections kept open by pool.
+	DatabaseMinConns int `env:"DATABASE_MIN_CONNS" json:"database_min_conns"`
+	// How long database connection is used before reconnecting.
+	DatabaseConnMaxLifetime time.Duration `env:"DATABASE_CONN_MAX_LIFETIME"`
+	// How long idle database connection is kept open.
+	DatabaseConnMaxIdleTime time.Duration `env:"DATABASE_CONN_MAX_IDLE_TIME"`
+	// Maximal duration of database statement, zero disables timeout.
+	DatabaseStatementTimeout time.Duration `env:"DATABASE_STATEMENT_TIMEOUT"`
+	// Number of urls in batch starting from which they are saved by copy through pool, zero disables copy.
+	DatabaseCopyThreshold int `env:"DATABASE_COPY_THRESHOLD" json:"database_copy_threshold"`
 }
 
 // Default config values.
@@ -34,6 +134,52 @@ var defaultConfig = Config{
 	EnableHTTPS:  false,
 	ShortLength:  8,
 	IsProduction: false,
+
+	Generator:                shortcutgenerator.KindBase62,
+	FileStorageCompactSize:   64 << 20,
+	ExpiredSweepInterval:     time.Hour,
+	ExpiredGracePeriod:       24 * time.Hour,
+	AliasCharset:             service.DefaultAliasCharset,
+	AliasMinLength:           3,
+	AliasMaxLength:           32,
+	AliasReserved:            "",
+	TrustedSubnet:            "",
+	TrustedProxies:           "",
+	GRPCAddress:              "",
+	DeletionQueuePath:        "",
+	DeletionFlushInterval:    service.DefaultDeletionSettings.FlushInterval,
+	DeletionBatchSize:        service.DefaultDeletionSettings.BatchSize,
+	DeletionMaxAttempts:      service.DefaultDeletionSettings.MaxAttempts,
+	DeletionRetryBackoff:     service.DefaultDeletionSettings.RetryBackoff,
+	DeletionRetention:        service.DefaultDeletionSettings.Retention,
+	RateLimitCreate:          0,
+	RateLimitBatchURLs:       0,
+	RateLimitRedirect:        0,
+	RateLimitShared:          false,
+	URLSchemes:               strings.Join(service.DefaultURLSchemes, ","),
+	URLBlocklistPath:         "",
+	URLAllowlistPath:         "",
+	URLAllowPrivateIPs:       false,
+	URLPolicyReloadInterval:  10 * time.Second,
+	URLStripParams:           "",
+	DedupScope:               string(urlstorage.DedupGlobal),
+	QRCacheSize:              1024,
+	RedirectCode:             service.DefaultRedirectCode,
+	RedirectMaxAge:           24 * time.Hour,
+	URLCacheSize:             0,
+	URLCacheTTL:              time.Minute,
+	URLCacheNegativeTTL:      10 * time.Second,
+	MemoryShards:             0,
+	StoragePath:              "",
+	StorageBackupPath:        "",
+	StorageBackupInterval:    time.Hour,
+	DatabasePool:             false,
+	DatabaseMaxConns:         0,
+	DatabaseMinConns:         0,
+	DatabaseConnMaxLifetime:  time.Hour,
+	DatabaseConnMaxIdleTime:  30 * time.Minute,
+	DatabaseStatementTimeout: 0,
+	DatabaseCopyThreshold:    100,
 }
 
 // Parse command line flags.
@@ -47,6 +193,51 @@ func parseFlags(config *Config) {
 	flag.StringVar(&config.SecretKey, "k", defaultConfig.SecretKey, "secret key")
 	flag.BoolVar(&config.IsProduction, "p", defaultConfig.IsProduction, "is production")
 	flag.BoolVar(&config.EnableHTTPS, "s", defaultConfig.EnableHTTPS, "is https enabled")
+	flag.StringVar(&config.TrustedSubnet, "t", defaultConfig.TrustedSubnet, "subnet allowed to get internal stats and metrics")
+	flag.StringVar(&config.TrustedProxies, "trusted-proxies", defaultConfig.TrustedProxies, "comma separated subnets of proxies whose client ip headers are trusted")
+	flag.StringVar(&config.Generator, "generator", defaultConfig.Generator, "kind of short url generator: random, base62, base64url, counter or hash")
+	flag.Int64Var(&config.FileStorageCompactSize, "file-compact-size", defaultConfig.FileStorageCompactSize, "file storage size in bytes to compact after")
+	flag.DurationVar(&config.ExpiredSweepInterval, "expired-sweep-interval", defaultConfig.ExpiredSweepInterval, "interval of purging expired urls")
+	flag.DurationVar(&config.ExpiredGracePeriod, "expired-grace-period", defaultConfig.ExpiredGracePeriod, "time to keep expired urls before purging")
+	flag.StringVar(&config.AliasCharset, "alias-charset", defaultConfig.AliasCharset, "characters allowed in custom short urls")
+	flag.IntVar(&config.AliasMinLength, "alias-min-length", defaultConfig.AliasMinLength, "minimal length of custom short url")
+	flag.IntVar(&config.AliasMaxLength, "alias-max-length", defaultConfig.AliasMaxLength, "maximal length of custom short url")
+	flag.StringVar(&config.AliasReserved, "alias-reserved", defaultConfig.AliasReserved, "comma separated words forbidden as custom short urls")
+	flag.StringVar(&config.GRPCAddress, "grpc-address", defaultConfig.GRPCAddress, "address and port to run grpc server, empty disables grpc")
+	flag.StringVar(&config.DeletionQueuePath, "deletion-queue-path", defaultConfig.DeletionQueuePath, "file of deletion jobs")
+	flag.DurationVar(&config.DeletionFlushInterval, "deletion-flush-interval", defaultConfig.DeletionFlushInterval, "interval of processing deletion jobs, non-positive means default")
+	flag.IntVar(&config.DeletionBatchSize, "deletion-batch-size", defaultConfig.DeletionBatchSize, "maximal number of deletion jobs processed at once, non-positive means default")
+	flag.IntVar(&config.DeletionMaxAttempts, "deletion-max-attempts", defaultConfig.DeletionMaxAttempts, "number of attempts of deletion job, non-positive means default")
+	flag.DurationVar(&config.DeletionRetryBackoff, "deletion-retry-backoff", defaultConfig.DeletionRetryBackoff, "delay after first failed deletion attempt")
+	flag.DurationVar(&config.DeletionRetention, "deletion-retention", defaultConfig.DeletionRetention, "how long finished deletion jobs are kept, non-positive means default")
+	flag.IntVar(&config.RateLimitCreate, "rate-limit-create", defaultConfig.RateLimitCreate, "requests creating short urls allowed per minute, zero disables limit")
+	flag.IntVar(&config.RateLimitBatchURLs, "rate-limit-batch-urls", defaultConfig.RateLimitBatchURLs, "urls in batch requests allowed per minute, zero disables limit")
+	flag.IntVar(&config.RateLimitRedirect, "rate-limit-redirect", defaultConfig.RateLimitRedirect, "redirects allowed per minute, zero disables limit")
+	flag.BoolVar(&config.RateLimitShared, "rate-limit-shared", defaultConfig.RateLimitShared, "share rate limits by replicas through database")
+	flag.StringVar(&config.URLSchemes, "url-allowed-schemes", defaultConfig.URLSchemes, "comma separated schemes allowed in long urls")
+	flag.StringVar(&config.URLBlocklistPath, "url-blocklist-path", defaultConfig.URLBlocklistPath, "file of domains forbidden in long urls")
+	flag.StringVar(&config.URLAllowlistPath, "url-allowlist-path", defaultConfig.URLAllowlistPath, "file of the only domains allowed in long urls")
+	flag.BoolVar(&config.URLAllowPrivateIPs, "url-allow-private-ips", defaultConfig.URLAllowPrivateIPs, "allow long urls to private ips")
+	flag.DurationVar(&config.URLPolicyReloadInterval, "url-policy-reload-interval", defaultConfig.URLPolicyReloadInterval, "interval of rereading changed domain files")
+	flag.StringVar(&config.URLStripParams, "url-strip-params", defaultConfig.URLStripParams, "comma separated query parameters ignored when urls are deduplicated, e.g. utm_*,fbclid")
+	flag.StringVar(&config.DedupScope, "dedup-scope", defaultConfig.DedupScope, "scope in which equal long urls share short url: global, user or none")
+	flag.IntVar(&config.QRCacheSize, "qr-cache-size", defaultConfig.QRCacheSize, "number of rendered qr codes kept in memory, 0 disables caching")
+	flag.IntVar(&config.RedirectCode, "redirect-code", defaultConfig.RedirectCode, "status of redirect for urls without own one: 301, 302, 303, 307 or 308")
+	flag.DurationVar(&config.RedirectMaxAge, "redirect-max-age", defaultConfig.RedirectMaxAge, "how long clients may cache permanent redirects")
+	flag.IntVar(&config.URLCacheSize, "url-cache-size", defaultConfig.URLCacheSize, "number of short urls whose lookups are cached in memory, 0 disables caching")
+	flag.DurationVar(&config.URLCacheTTL, "url-cache-ttl", defaultConfig.URLCacheTTL, "how long looked up urls stay in cache")
+	flag.DurationVar(&config.URLCacheNegativeTTL, "url-cache-negative-ttl", defaultConfig.URLCacheNegativeTTL, "how long absence of looked up urls stays in cache")
+	flag.IntVar(&config.MemoryShards, "memory-shards", defaultConfig.MemoryShards, "number of shards of in-memory url storage, 0 uses storage with single lock")
+	flag.StringVar(&config.StoragePath, "storage-path", defaultConfig.StoragePath, "file of embedded url storage used when database is not set")
+	flag.StringVar(&config.StorageBackupPath, "storage-backup-path", defaultConfig.StorageBackupPath, "file of online backups of embedded url storage")
+	flag.DurationVar(&config.StorageBackupInterval, "storage-backup-interval", defaultConfig.StorageBackupInterval, "interval of online backups of embedded url storage")
+	flag.BoolVar(&config.DatabasePool, "database-pool", defaultConfig.DatabasePool, "access database through native pgx connection pool")
+	flag.IntVar(&config.DatabaseMaxConns, "database-max-conns", defaultConfig.DatabaseMaxConns, "maximal number of database connections, 0 keeps default of driver")
+	flag.IntVar(&config.DatabaseMinConns, "database-min-conns", defaultConfig.DatabaseMinConns, "number of database connections kept open by pool")
+	flag.DurationVar(&config.DatabaseConnMaxLifetime, "database-conn-max-lifetime", defaultConfig.DatabaseConnMaxLifetime, "how long database connection is used before reconnecting")
+	flag.DurationVar(&config.DatabaseConnMaxIdleTime, "database-conn-max-idle-time", defaultConfig.DatabaseConnMaxIdleTime, "how long idle database connection is kept open")
+	flag.DurationVar(&config.DatabaseStatementTimeout, "database-statement-timeout", defaultConfig.DatabaseStatementTimeout, "maximal duration of database statement, 0 disables timeout")
+	flag.IntVar(&config.DatabaseCopyThreshold, "database-copy-threshold", defaultConfig.DatabaseCopyThreshold, "number of urls in batch saved by copy through pool, 0 disables copy")
 	flag.Parse()
 }
 
@@ -61,9 +252,48 @@ func updateFromEnv(config *Config) {
 			continue
 		}
 		if envVal := os.Getenv(envName); envVal != "" {
-			v.Field(i).SetString(envVal)
+			if err := setFromString(v.Field(i), envVal); err != nil {
+				log.Printf("Wrong value of %s: %v", envName, err)
+			}
+		}
+	}
+}
+
+// Sets config field from its string representation.
+func setFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int64:
+		if field.Type() != reflect.TypeOf(time.Duration(0)) {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetInt(parsed)
+			break
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Int:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
 		}
+		field.SetInt(parsed)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
 	}
+	return nil
 }
 
 // Get config from file.
@@ -25,4 +25,8 @@ func Test_GetConfig(t *testing.T) {
 	require.Equal(t, "env", config.BaseURL)
 	require.Equal(t, "flag", config.Database)
 	require.Equal(t, false, config.EnableHTTPS)
+	require.Empty(t, config.GRPCAddress)
+	require.Zero(t, config.RateLimitCreate)
+	require.Zero(t, config.RateLimitBatchURLs)
+	require.Zero(t, config.RateLimitRedirect)
 }
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Connections to configured database.
+//
+// Pool is nil unless native pool is enabled, DB is backed by pool then.
+type database struct {
+	DB   *sql.DB
+	Pool *pgxpool.Pool
+}
+
+// Closes database connections.
+func (d database) Close() error {
+	err := d.DB.Close()
+	if d.Pool != nil {
+		d.Pool.Close()
+	}
+	return err
+}
+
+// Opens configured database through native pool or database/sql pool.
+func openDatabase(ctx context.Context, config Config) (database, error) {
+	if config.DatabasePool {
+		poolConfig, err := pgxpool.ParseConfig(config.Database)
+		if err != nil {
+			return database{}, err
+		}
+		setStatementTimeout(poolConfig.ConnConfig, config)
+		if config.DatabaseMaxConns > 0 {
+			poolConfig.MaxConns = int32(config.DatabaseMaxConns)
+		}
+		poolConfig.MinConns = int32(config.DatabaseMinConns)
+		poolConfig.MaxConnLifetime = config.DatabaseConnMaxLifetime
+		poolConfig.MaxConnIdleTime = config.DatabaseConnMaxIdleTime
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err != nil {
+			return database{}, err
+		}
+		return database{DB: stdlib.OpenDBFromPool(pool), Pool: pool}, nil
+	}
+
+	connConfig, err := pgx.ParseConfig(config.Database)
+	if err != nil {
+		return database{}, err
+	}
+	setStatementTimeout(connConfig, config)
+	db := stdlib.OpenDB(*connConfig)
+	db.SetMaxOpenConns(config.DatabaseMaxConns)
+	db.SetConnMaxLifetime(config.DatabaseConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.DatabaseConnMaxIdleTime)
+	return database{DB: db}, nil
+}
+
+// Limits duration of every statement of connection.
+func setStatementTimeout(connConfig *pgx.ConnConfig, config Config) {
+	if config.DatabaseStatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(config.DatabaseStatementTimeout.Milliseconds(), 10)
+	}
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/migrations"
+)
+
+// Runs migrate subcommand with given arguments.
+//
+//	migrate up          apply all pending migrations
+//	migrate down [N]    revert N last applied migrations, one by default
+//	migrate status      print state of every migration
+func Migrate(ctx context.Context, args []string, out io.Writer) error {
+	config := GetConfig()
+	if config.Database == "" {
+		return errors.New("database address is required for migrations")
+	}
+	if len(args) == 0 {
+		return errors.New("migrate command expects up, down or status")
+	}
+
+	db, err := sql.Open("pgx", config.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up(ctx)
+		fmt.Fprintf(out, "Applied %d migrations\n", count)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("wrong number of steps %q", args[1])
+			}
+		}
+		count, err := migrator.Down(ctx, steps)
+		fmt.Fprintf(out, "Reverted %d migrations\n", count)
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
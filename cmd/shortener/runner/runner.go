@@ -3,17 +3,31 @@ package runner
 
 import (
 	"context"
-	"database/sql"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	"github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/grpcserver"
 	"github.com/valinurovdenis/urlshortener/internal/app/handlers"
 	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+	"github.com/valinurovdenis/urlshortener/internal/app/metrics"
+	"github.com/valinurovdenis/urlshortener/internal/app/migrations"
+	"github.com/valinurovdenis/urlshortener/internal/app/qrcode"
+	"github.com/valinurovdenis/urlshortener/internal/app/ratelimit"
 	"github.com/valinurovdenis/urlshortener/internal/app/service"
 	"github.com/valinurovdenis/urlshortener/internal/app/shortcutgenerator"
 	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
@@ -31,36 +45,161 @@ func Run(ctx context.Context, stopped chan struct{}) error {
 	var urlStorage urlstorage.URLStorage
 	var userURLStorage urlstorage.UserURLStorage
 	var userStorage userstorage.UserStorage
+	var clickStorage clickstorage.ClickStorage
+	var deletionStorage deletionstorage.DeletionStorage = deletionstorage.NewSimpleDeletionStorage()
+	var fileStorage *urlstorage.FileDumpWrapper
+	var boltStorage *urlstorage.BoltStorage
+	var fileDeletionStorage *deletionstorage.FileDeletionStorage
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	dedupScope, err := urlstorage.ParseDedupScope(config.DedupScope)
+	if err != nil {
+		return err
+	}
+	if err = service.ValidateRedirectCode(config.RedirectCode); err != nil {
+		return err
+	}
 	if config.Database != "" {
-		db, err := sql.Open("pgx", config.Database)
+		pg, err := openDatabase(ctx, config)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		defer pg.Close()
+		db := pg.DB
+		if err = migrations.Migrate(ctx, db); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
 		}
-		defer db.Close()
 		storage := urlstorage.NewDatabaseStorage(db)
+		storage.DedupScope = dedupScope
+		storage.Pool = pg.Pool
+		storage.CopyThreshold = config.DatabaseCopyThreshold
 		urlStorage = storage
 		userURLStorage = storage
 		userStorage = userstorage.NewDatabaseUserStorage(db)
-	} else {
-		storage := urlstorage.NewSimpleMapLockStorage()
+		clickStorage = clickstorage.NewDatabaseClickStorage(db)
+		deletionStorage = deletionstorage.NewDatabaseDeletionStorage(db)
+		if config.RateLimitShared {
+			rateLimitStore = ratelimit.NewDatabaseStore(db)
+		}
+	} else if config.StoragePath != "" {
+		storage, err := urlstorage.NewBoltStorage(config.StoragePath)
+		if err != nil {
+			return err
+		}
+		storage.DedupScope = dedupScope
 		urlStorage = storage
 		userURLStorage = storage
+		userStorage = storage
+		clickStorage = clickstorage.NewSimpleClickStorage()
+		boltStorage = storage
+		if config.StorageBackupPath != "" {
+			go storage.BackupEvery(ctx, config.StorageBackupPath, config.StorageBackupInterval)
+		}
+	} else {
+		if config.MemoryShards > 0 {
+			storage := urlstorage.NewShardedStorage(config.MemoryShards)
+			storage.DedupScope = dedupScope
+			urlStorage = storage
+			userURLStorage = storage
+		} else {
+			storage := urlstorage.NewSimpleMapLockStorage()
+			storage.DedupScope = dedupScope
+			urlStorage = storage
+			userURLStorage = storage
+		}
 		userStorage = userstorage.NewSimpleUserStorage()
+		clickStorage = clickstorage.NewSimpleClickStorage()
 		if config.FileStorage != "" {
 			fileStorageWrapper, err := urlstorage.NewFileDumpWrapper(
-				config.FileStorage, storage)
+				config.FileStorage, urlStorage, userURLStorage, config.FileStorageCompactSize)
 			if err != nil {
 				return err
 			}
-			fileStorageWrapper.RestoreFromDump()
+			if err = fileStorageWrapper.RestoreFromDump(); err != nil {
+				return err
+			}
 			urlStorage = fileStorageWrapper
+			userURLStorage = fileStorageWrapper
+			userStorage = fileStorageWrapper
+			fileStorage = fileStorageWrapper
+		}
+	}
+	if config.Database == "" {
+		deletionQueuePath := config.DeletionQueuePath
+		if deletionQueuePath == "" && config.StoragePath != "" {
+			deletionQueuePath = config.StoragePath + ".deletions"
+		} else if deletionQueuePath == "" && config.FileStorage != "" {
+			deletionQueuePath = config.FileStorage + ".deletions"
+		}
+		if deletionQueuePath != "" {
+			storage, err := deletionstorage.NewFileDeletionStorage(deletionQueuePath)
+			if err != nil {
+				return err
+			}
+			deletionStorage = storage
+			fileDeletionStorage = storage
 		}
 	}
 
-	generator := shortcutgenerator.NewRandBase64Generator(config.ShortLength)
-	service := service.NewShortenerService(urlStorage, userURLStorage, generator)
+	generator, err := shortcutgenerator.New(config.Generator, config.ShortLength, config.SecretKey)
+	if err != nil {
+		return err
+	}
+	aliasReserved := slices.Concat(service.DefaultReservedAliases, strings.Split(config.AliasReserved, ","))
+	aliasPolicy := service.NewAliasPolicy(config.AliasCharset, config.AliasMinLength, config.AliasMaxLength, aliasReserved...)
+	if config.URLCacheSize > 0 {
+		cache := urlstorage.NewCacheWrapper(urlStorage, userURLStorage,
+			config.URLCacheSize, config.URLCacheTTL, config.URLCacheNegativeTTL)
+		metrics.RegisterURLCache(cache.Stats)
+		urlStorage = cache
+		userURLStorage = cache
+	}
+	urlStorage = metrics.NewURLStorageWrapper(urlStorage)
+	deletionSettings := service.DeletionSettings{
+		FlushInterval: config.DeletionFlushInterval,
+		BatchSize:     config.DeletionBatchSize,
+		MaxAttempts:   config.DeletionMaxAttempts,
+		RetryBackoff:  config.DeletionRetryBackoff,
+		Retention:     config.DeletionRetention,
+	}
+	urlPolicy, err := newURLPolicy(ctx, config)
+	if err != nil {
+		return err
+	}
+	normalizer := service.URLNormalizer{StripParams: strings.Split(config.URLStripParams, ",")}
+	service := service.NewShortenerService(urlStorage, userURLStorage, clickStorage, generator,
+		service.WithDeletionStorage(deletionStorage), service.WithDeletionSettings(deletionSettings),
+		service.WithAliasPolicy(aliasPolicy), service.WithURLPolicy(urlPolicy), service.WithNormalizer(normalizer),
+		service.WithDedupScope(dedupScope))
+	metrics.RegisterDeletionQueue(service.DeletionQueueLength)
+	go service.SweepExpiredURLs(ctx, config.ExpiredSweepInterval, config.ExpiredGracePeriod)
 	auth := auth.NewAuthenticator(config.SecretKey, userStorage)
 	handler := handlers.NewShortenerHandler(*service, *auth, config.BaseURL+"/")
+	if config.TrustedSubnet != "" {
+		_, trustedSubnet, err := net.ParseCIDR(config.TrustedSubnet)
+		if err != nil {
+			return fmt.Errorf("wrong trusted subnet: %w", err)
+		}
+		handler.TrustedSubnet = trustedSubnet
+	}
+	for _, proxy := range strings.Split(config.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("wrong trusted proxy subnet: %w", err)
+		}
+		handler.TrustedProxies = append(handler.TrustedProxies, subnet)
+	}
+	handler.RateLimits = handlers.RateLimits{
+		Create:    ratelimit.NewLimiter("create", ratelimit.PerMinute(config.RateLimitCreate), rateLimitStore, handler.RateLimitKey),
+		BatchURLs: ratelimit.NewLimiter("batch", ratelimit.PerMinute(config.RateLimitBatchURLs), rateLimitStore, handler.RateLimitKey).WithCost(handlers.BatchSize),
+		Redirect:  ratelimit.NewLimiter("redirect", ratelimit.PerMinute(config.RateLimitRedirect), rateLimitStore, handler.RateLimitKey),
+	}
+	go ratelimit.PurgeIdleBuckets(ctx, rateLimitStore, time.Minute, 10*time.Minute)
+	handler.QRCodes = qrcode.NewCache(config.QRCacheSize)
+	handler.RedirectCode = config.RedirectCode
+	handler.RedirectMaxAge = config.RedirectMaxAge
 
 	router := handlers.ShortenerRouter(*handler, config.IsProduction)
 	var srv *http.Server
@@ -83,13 +222,53 @@ func Run(ctx context.Context, stopped chan struct{}) error {
 		}
 	}
 
+	var grpcSrv *grpc.Server
+	if config.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", config.GRPCAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen grpc address: %w", err)
+		}
+		opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(grpcserver.RateLimitInterceptor(grpcserver.RateLimits{
+			Create:    handler.RateLimits.Create,
+			BatchURLs: handler.RateLimits.BatchURLs,
+			Redirect:  handler.RateLimits.Redirect,
+		}, *auth))}
+		if srv.TLSConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(srv.TLSConfig)))
+		}
+		grpcSrv = grpcserver.NewServer(grpcserver.NewShortenerServer(*service, config.BaseURL+"/"), *auth, opts...)
+		go func() {
+			if err := grpcSrv.Serve(listener); err != nil {
+				log.Printf("Error when serving grpc: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		<-ctx.Done()
 		if err := srv.Shutdown(context.Background()); err != nil {
 			log.Printf("Error when shutdown server: %v", err)
 		}
+		if grpcSrv != nil {
+			grpcSrv.GracefulStop()
+		}
 		service.Stop()
 		<-service.Stopped
+		if fileStorage != nil {
+			if err := fileStorage.Close(); err != nil {
+				log.Printf("Error when closing file storage: %v", err)
+			}
+		}
+		if boltStorage != nil {
+			if err := boltStorage.Close(); err != nil {
+				log.Printf("Error when closing storage file: %v", err)
+			}
+		}
+		if fileDeletionStorage != nil {
+			if err := fileDeletionStorage.Close(); err != nil {
+				log.Printf("Error when closing deletion queue: %v", err)
+			}
+		}
 		close(stopped)
 	}()
 
@@ -98,3 +277,30 @@ func Run(ctx context.Context, stopped chan struct{}) error {
 	}
 	return srv.ListenAndServe()
 }
+
+// Builds url policy watching changes of its domain files.
+func newURLPolicy(ctx context.Context, config Config) (service.URLPolicy, error) {
+	var selfHosts []string
+	if baseURL, err := url.Parse(config.BaseURL); err == nil {
+		selfHosts = append(selfHosts, baseURL.Host)
+	}
+	policy := service.NewURLPolicy(strings.Split(config.URLSchemes, ","), selfHosts...)
+	policy.AllowPrivateIPs = config.URLAllowPrivateIPs
+	if config.URLBlocklistPath != "" {
+		blocked, err := service.NewDomainList(config.URLBlocklistPath)
+		if err != nil {
+			return policy, err
+		}
+		go blocked.Watch(ctx, config.URLPolicyReloadInterval)
+		policy.BlockedDomains = blocked
+	}
+	if config.URLAllowlistPath != "" {
+		allowed, err := service.NewDomainList(config.URLAllowlistPath)
+		if err != nil {
+			return policy, err
+		}
+		go allowed.Watch(ctx, config.URLPolicyReloadInterval)
+		policy.AllowedDomains = allowed
+	}
+	return policy, nil
+}
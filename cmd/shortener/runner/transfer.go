@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/migrations"
+	"github.com/valinurovdenis/urlshortener/internal/app/transfer"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Number of records between progress reports of export and import.
+const transferProgressEvery = 10000
+
+// Opens persistent url storage configured for service.
+//
+// Returned function closes storage, file storage is flushed by it.
+func openTransferStorage(ctx context.Context, config Config) (transfer.Target, func() error, error) {
+	dedupScope, err := urlstorage.ParseDedupScope(config.DedupScope)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case config.Database != "":
+		pg, err := openDatabase(ctx, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = migrations.Migrate(ctx, pg.DB); err != nil {
+			pg.Close()
+			return nil, nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+		storage := urlstorage.NewDatabaseStorage(pg.DB)
+		storage.DedupScope = dedupScope
+		storage.Pool = pg.Pool
+		storage.CopyThreshold = config.DatabaseCopyThreshold
+		return storage, pg.Close, nil
+	case config.StoragePath != "":
+		storage, err := urlstorage.NewBoltStorage(config.StoragePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		storage.DedupScope = dedupScope
+		return storage, storage.Close, nil
+	case config.FileStorage != "":
+		storage := urlstorage.NewSimpleMapLockStorage()
+		storage.DedupScope = dedupScope
+		fileStorage, err := urlstorage.NewFileDumpWrapper(config.FileStorage, storage, storage, config.FileStorageCompactSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = fileStorage.RestoreFromDump(); err != nil {
+			fileStorage.Close()
+			return nil, nil, err
+		}
+		return fileStorage, fileStorage.Close, nil
+	default:
+		return nil, nil, errors.New("database, storage path or file storage is required to transfer urls")
+	}
+}
+
+// Runs export subcommand writing every url of configured storage.
+//
+//	export [-format ndjson|csv] [-o file]
+func Export(ctx context.Context, args []string, out io.Writer, progress io.Writer) (err error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "interchange format: ndjson or csv, by extension of output file by default")
+	output := flags.String("o", "", "output file, standard output by default")
+	if err = flags.Parse(args); err != nil {
+		return err
+	}
+	interchangeFormat, err := transfer.ParseFormat(*format, *output)
+	if err != nil {
+		return err
+	}
+
+	storage, closeStorage, err := openTransferStorage(ctx, GetConfig())
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeStorage()) }()
+	if *output != "" {
+		f, createErr := os.Create(*output)
+		if createErr != nil {
+			return createErr
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+		out = f
+	}
+
+	writer, err := transfer.NewWriter(out, interchangeFormat)
+	if err != nil {
+		return err
+	}
+	_, err = transfer.Export(ctx, storage, writer, func(written int64) {
+		fmt.Fprintf(progress, "Exported %d urls\n", written)
+	}, transferProgressEvery)
+	return err
+}
+
+// Runs import subcommand saving urls of interchange file into configured storage.
+//
+//	import [-format ndjson|csv] [-on-conflict skip|overwrite|fail] [-dry-run] [file]
+//
+// Urls are read from standard input if file is not given.
+func Import(ctx context.Context, args []string, in io.Reader, progress io.Writer) (err error) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "interchange format: ndjson or csv, by extension of input file by default")
+	onConflict := flags.String("on-conflict", string(transfer.ConflictSkip), "what to do with already saved urls: skip, overwrite or fail")
+	dryRun := flags.Bool("dry-run", false, "only count urls that would be imported")
+	if err = flags.Parse(args); err != nil {
+		return err
+	}
+	interchangeFormat, err := transfer.ParseFormat(*format, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	policy, err := transfer.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+
+	if flags.Arg(0) != "" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	reader, err := transfer.NewReader(in, interchangeFormat)
+	if err != nil {
+		return err
+	}
+	storage, closeStorage, err := openTransferStorage(ctx, GetConfig())
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeStorage()) }()
+
+	prefix := ""
+	if *dryRun {
+		prefix = "Dry run: "
+	}
+	_, err = transfer.Import(ctx, reader, storage, transfer.ImportOptions{
+		Policy: policy,
+		DryRun: *dryRun,
+		Progress: func(stats transfer.Stats) {
+			fmt.Fprintf(progress, "%sread %d urls: %d imported, %d overwritten, %d skipped\n",
+				prefix, stats.Read, stats.Imported, stats.Overwritten, stats.Skipped)
+		},
+		ProgressEvery: transferProgressEvery,
+	})
+	return err
+}
@@ -0,0 +1,45 @@
+// Package clickstorage contains storage of redirect clicks.
+package clickstorage
+
+import (
+	"context"
+	"time"
+)
+
+// Format of day in click statistics.
+const DayFormat = "2006-01-02"
+
+// Single redirect by short url.
+type Click struct {
+	Time      time.Time
+	ShortURL  string
+	Referrer  string
+	UserAgent string
+	IP        string
+}
+
+// Number of clicks made during one day.
+type DailyClicks struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+}
+
+// Click statistics of short url, days are given in UTC in ascending order.
+type ClickStats struct {
+	Total int64         `json:"total"`
+	Daily []DailyClicks `json:"daily"`
+}
+
+// Storage of clicks by short urls.
+//
+//go:generate mockery --name ClickStorage
+type ClickStorage interface {
+	// Saves batch of clicks.
+	StoreClicksWithContext(context context.Context, clicks ...Click) error
+
+	// Returns click statistics of short url.
+	GetStatsWithContext(context context.Context, shortURL string) (ClickStats, error)
+
+	// Removes all clicks of short urls.
+	DeleteClicksWithContext(context context.Context, shortURLs ...string) error
+}
@@ -0,0 +1,78 @@
+package clickstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Storage keeping clicks in postgresql.
+type DatabaseClickStorage struct {
+	DB *sql.DB
+}
+
+// New postgresql click storage.
+//
+// Schema is expected to be created by migrations package.
+func NewDatabaseClickStorage(db *sql.DB) *DatabaseClickStorage {
+	return &DatabaseClickStorage{DB: db}
+}
+
+// Saves batch of clicks.
+func (s *DatabaseClickStorage) StoreClicksWithContext(ctx context.Context, clicks ...Click) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, ip) VALUES($1, $2, $3, $4, $5)")
+	if err != nil {
+		return fmt.Errorf("failed to insert clicks: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.ShortURL, click.Time, click.Referrer, click.UserAgent, click.IP)
+		if err != nil {
+			return fmt.Errorf("failed to insert clicks: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Returns click statistics of short url.
+func (s *DatabaseClickStorage) GetStatsWithContext(ctx context.Context, shortURL string) (ClickStats, error) {
+	stats := ClickStats{Daily: []DailyClicks{}}
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT (clicked_at AT TIME ZONE 'UTC')::date AS day, count(*) FROM clicks
+		WHERE short_url = $1 GROUP BY day ORDER BY day`, shortURL)
+	if err != nil {
+		return stats, fmt.Errorf("failed to select clicks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		var count int64
+		if err = rows.Scan(&day, &count); err != nil {
+			return stats, err
+		}
+		stats.Total += count
+		stats.Daily = append(stats.Daily, DailyClicks{Day: day.Format(DayFormat), Count: count})
+	}
+	if err = rows.Err(); err != nil {
+		return stats, fmt.Errorf("failed to get rows: %w", err)
+	}
+	return stats, nil
+}
+
+// Removes all clicks of short urls.
+func (s *DatabaseClickStorage) DeleteClicksWithContext(ctx context.Context, shortURLs ...string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM clicks WHERE short_url = ANY($1)", shortURLs)
+	if err != nil {
+		return fmt.Errorf("failed to delete clicks: %w", err)
+	}
+	return nil
+}
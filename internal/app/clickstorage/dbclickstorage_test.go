@@ -0,0 +1,78 @@
+package clickstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseClickStorage_StoreClicks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseClickStorage(db)
+	now := time.Now()
+	mock.ExpectBegin()
+	prepare := mock.ExpectPrepare("INSERT INTO clicks")
+	prepare.ExpectExec().WithArgs("a", now, "ref", "agent", "127.0.0.1").WillReturnResult(sqlmock.NewResult(1, 1))
+	prepare.ExpectExec().WithArgs("b", now, "", "", "").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	err = storage.StoreClicksWithContext(context.Background(),
+		Click{Time: now, ShortURL: "a", Referrer: "ref", UserAgent: "agent", IP: "127.0.0.1"},
+		Click{Time: now, ShortURL: "b"})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseClickStorage_GetStats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseClickStorage(db)
+	mock.ExpectQuery("SELECT (.+) FROM clicks").WithArgs("a").
+		WillReturnRows(sqlmock.NewRows([]string{"day", "count"}).
+			AddRow(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 2).
+			AddRow(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 1))
+
+	stats, err := storage.GetStatsWithContext(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, ClickStats{Total: 3, Daily: []DailyClicks{
+		{Day: "2024-03-01", Count: 2}, {Day: "2024-03-02", Count: 1}}}, stats)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Passes short urls array as is like pgx driver does.
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if shortURLs, ok := v.([]string); ok {
+		return shortURLs, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestDatabaseClickStorage_DeleteClicks(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseClickStorage(db)
+	mock.ExpectExec("DELETE FROM clicks").WithArgs([]string{"a", "b"}).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	require.NoError(t, storage.DeleteClicksWithContext(context.Background(), "a", "b"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,59 @@
+package clickstorage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Storage keeping number of clicks per day in memory.
+type SimpleClickStorage struct {
+	DailyClicks map[string]map[string]int64
+	sync.Mutex
+}
+
+// New in memory click storage.
+func NewSimpleClickStorage() *SimpleClickStorage {
+	return &SimpleClickStorage{DailyClicks: make(map[string]map[string]int64)}
+}
+
+// Saves batch of clicks.
+func (s *SimpleClickStorage) StoreClicksWithContext(_ context.Context, clicks ...Click) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, click := range clicks {
+		days, ok := s.DailyClicks[click.ShortURL]
+		if !ok {
+			days = make(map[string]int64)
+			s.DailyClicks[click.ShortURL] = days
+		}
+		days[click.Time.UTC().Format(DayFormat)]++
+	}
+	return nil
+}
+
+// Returns click statistics of short url.
+func (s *SimpleClickStorage) GetStatsWithContext(_ context.Context, shortURL string) (ClickStats, error) {
+	s.Lock()
+	defer s.Unlock()
+	stats := ClickStats{Daily: []DailyClicks{}}
+	for day, count := range s.DailyClicks[shortURL] {
+		stats.Total += count
+		stats.Daily = append(stats.Daily, DailyClicks{Day: day, Count: count})
+	}
+	slices.SortFunc(stats.Daily, func(a, b DailyClicks) int {
+		return strings.Compare(a.Day, b.Day)
+	})
+	return stats, nil
+}
+
+// Removes all clicks of short urls.
+func (s *SimpleClickStorage) DeleteClicksWithContext(_ context.Context, shortURLs ...string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, shortURL := range shortURLs {
+		delete(s.DailyClicks, shortURL)
+	}
+	return nil
+}
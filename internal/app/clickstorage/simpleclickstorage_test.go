@@ -0,0 +1,56 @@
+package clickstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
+)
+
+func TestSimpleClickStorage_GetStats(t *testing.T) {
+	storage := clickstorage.NewSimpleClickStorage()
+	day := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	err := storage.StoreClicksWithContext(context.Background(),
+		clickstorage.Click{Time: day.Add(2 * time.Hour), ShortURL: "a"},
+		clickstorage.Click{Time: day, ShortURL: "a"},
+		clickstorage.Click{Time: day.Add(time.Minute), ShortURL: "a"},
+		clickstorage.Click{Time: day, ShortURL: "b"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		shortURL string
+		want     clickstorage.ClickStats
+	}{
+		{name: "clicked", shortURL: "a", want: clickstorage.ClickStats{Total: 3, Daily: []clickstorage.DailyClicks{
+			{Day: "2024-03-01", Count: 2}, {Day: "2024-03-02", Count: 1}}}},
+		{name: "not_clicked", shortURL: "c", want: clickstorage.ClickStats{Total: 0, Daily: []clickstorage.DailyClicks{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storage.GetStatsWithContext(context.Background(), tt.shortURL)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSimpleClickStorage_DeleteClicks(t *testing.T) {
+	storage := clickstorage.NewSimpleClickStorage()
+	now := time.Now()
+	err := storage.StoreClicksWithContext(context.Background(),
+		clickstorage.Click{Time: now, ShortURL: "a"},
+		clickstorage.Click{Time: now, ShortURL: "b"})
+	require.NoError(t, err)
+
+	require.NoError(t, storage.DeleteClicksWithContext(context.Background(), "a", "c"))
+	stats, err := storage.GetStatsWithContext(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Total)
+	stats, err = storage.GetStatsWithContext(context.Background(), "b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Total)
+}
@@ -0,0 +1,134 @@
+package deletionstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Storage keeping deletion jobs in postgresql.
+type DatabaseDeletionStorage struct {
+	DB *sql.DB
+}
+
+// New postgresql deletion storage.
+//
+// Schema is expected to be created by migrations package.
+func NewDatabaseDeletionStorage(db *sql.DB) *DatabaseDeletionStorage {
+	return &DatabaseDeletionStorage{DB: db}
+}
+
+const selectJob = `SELECT id, user_id, short_urls, status, attempts, next_attempt_at, last_error, created_at FROM deletion_jobs`
+
+// Scans job from row.
+func scanJob(scan func(dest ...any) error) (Job, error) {
+	var job Job
+	var shortURLs []byte
+	err := scan(&job.ID, &job.UserID, &shortURLs, &job.Status, &job.Attempts, &job.NextAttemptAt, &job.LastError, &job.CreatedAt)
+	if err != nil {
+		return job, err
+	}
+	return job, json.Unmarshal(shortURLs, &job.ShortURLs)
+}
+
+// Saves new pending job due immediately.
+func (s *DatabaseDeletionStorage) AddJobWithContext(ctx context.Context, userID string, shortURLs []string) (Job, error) {
+	if shortURLs == nil {
+		shortURLs = []string{}
+	}
+	encoded, err := json.Marshal(shortURLs)
+	if err != nil {
+		return Job{}, err
+	}
+	row := s.DB.QueryRowContext(ctx,
+		`INSERT INTO deletion_jobs (user_id, short_urls, status, next_attempt_at, created_at)
+		VALUES($1, $2, $3, now(), now()) RETURNING id, user_id, short_urls, status, attempts, next_attempt_at, last_error, created_at`,
+		userID, string(encoded), StatusPending)
+	job, err := scanJob(row.Scan)
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to insert deletion job: %w", err)
+	}
+	return job, nil
+}
+
+// Returns up to limit pending jobs due by given moment in order of creation.
+func (s *DatabaseDeletionStorage) GetDueJobsWithContext(ctx context.Context, now time.Time, limit int) ([]Job, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		selectJob+" WHERE status = $1 AND next_attempt_at <= $2 ORDER BY id LIMIT $3", StatusPending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select deletion jobs: %w", err)
+	}
+	defer rows.Close()
+	var res []Job
+	for rows.Next() {
+		job, err := scanJob(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get rows: %w", err)
+	}
+	return res, nil
+}
+
+// Saves status, attempts, next attempt time and error of given jobs.
+func (s *DatabaseDeletionStorage) UpdateJobsWithContext(ctx context.Context, jobs ...Job) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		"UPDATE deletion_jobs SET status = $2, attempts = $3, next_attempt_at = $4, last_error = $5 WHERE id = $1")
+	if err != nil {
+		return fmt.Errorf("failed to update deletion jobs: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, job := range jobs {
+		_, err = stmt.ExecContext(ctx, job.ID, job.Status, job.Attempts, job.NextAttemptAt, job.LastError)
+		if err != nil {
+			return fmt.Errorf("failed to update deletion jobs: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Returns job by id.
+func (s *DatabaseDeletionStorage) GetJobWithContext(ctx context.Context, id int64) (Job, error) {
+	job, err := scanJob(s.DB.QueryRowContext(ctx, selectJob+" WHERE id = $1", id).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, ErrNoSuchJob
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to select deletion job: %w", err)
+	}
+	return job, nil
+}
+
+// Returns number of pending jobs.
+func (s *DatabaseDeletionStorage) CountPendingWithContext(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT count(*) FROM deletion_jobs WHERE status = $1", StatusPending).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deletion jobs: %w", err)
+	}
+	return count, nil
+}
+
+// Removes done and failed jobs created before given moment.
+func (s *DatabaseDeletionStorage) PurgeFinishedWithContext(ctx context.Context, createdBefore time.Time) (int64, error) {
+	res, err := s.DB.ExecContext(ctx,
+		"DELETE FROM deletion_jobs WHERE status <> $1 AND created_at < $2", StatusPending, createdBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete finished deletion jobs: %w", err)
+	}
+	return res.RowsAffected()
+}
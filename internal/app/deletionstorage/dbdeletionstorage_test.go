@@ -0,0 +1,115 @@
+package deletionstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var jobColumns = []string{"id", "user_id", "short_urls", "status", "attempts", "next_attempt_at", "last_error", "created_at"}
+
+func TestDatabaseDeletionStorage_AddJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseDeletionStorage(db)
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO deletion_jobs").WithArgs("user_1", `["a","b"]`, StatusPending).
+		WillReturnRows(sqlmock.NewRows(jobColumns).AddRow(1, "user_1", []byte(`["a","b"]`), "pending", 0, now, "", now))
+
+	job, err := storage.AddJobWithContext(context.Background(), "user_1", []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, Job{ID: 1, UserID: "user_1", ShortURLs: []string{"a", "b"}, Status: StatusPending,
+		NextAttemptAt: now, CreatedAt: now}, job)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseDeletionStorage_GetDueJobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseDeletionStorage(db)
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM deletion_jobs WHERE status").WithArgs(StatusPending, now, 10).
+		WillReturnRows(sqlmock.NewRows(jobColumns).
+			AddRow(1, "user_1", []byte(`["a"]`), "pending", 1, now, "failed", now).
+			AddRow(2, "user_2", []byte(`["b"]`), "pending", 0, now, "", now))
+
+	jobs, err := storage.GetDueJobsWithContext(context.Background(), now, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "failed", jobs[0].LastError)
+	assert.Equal(t, []string{"b"}, jobs[1].ShortURLs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseDeletionStorage_UpdateJobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseDeletionStorage(db)
+	now := time.Now()
+	mock.ExpectBegin()
+	prepare := mock.ExpectPrepare("UPDATE deletion_jobs")
+	prepare.ExpectExec().WithArgs(int64(1), StatusDone, 1, now, "").WillReturnResult(sqlmock.NewResult(0, 1))
+	prepare.ExpectExec().WithArgs(int64(2), StatusFailed, 5, now, "error").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = storage.UpdateJobsWithContext(context.Background(),
+		Job{ID: 1, Status: StatusDone, Attempts: 1, NextAttemptAt: now},
+		Job{ID: 2, Status: StatusFailed, Attempts: 5, NextAttemptAt: now, LastError: "error"})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseDeletionStorage_GetJob(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseDeletionStorage(db)
+	mock.ExpectQuery("SELECT (.+) FROM deletion_jobs WHERE id").WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows(jobColumns))
+	mock.ExpectQuery("SELECT count").WithArgs(StatusPending).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	_, err = storage.GetJobWithContext(context.Background(), 7)
+	require.ErrorIs(t, err, ErrNoSuchJob)
+	count, err := storage.CountPendingWithContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseDeletionStorage_PurgeFinished(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseDeletionStorage(db)
+	createdBefore := time.Now()
+	mock.ExpectExec("DELETE FROM deletion_jobs").WithArgs(StatusPending, createdBefore).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	purged, err := storage.PurgeFinishedWithContext(context.Background(), createdBefore)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,57 @@
+// Package deletionstorage contains durable queue of url deletion jobs.
+package deletionstorage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Error in case job does not exist.
+var ErrNoSuchJob = errors.New("no such deletion job")
+
+// Status of deletion job.
+type JobStatus string
+
+const (
+	StatusPending JobStatus = "pending" // waiting for next attempt
+	StatusDone    JobStatus = "done"    // urls deleted
+	StatusFailed  JobStatus = "failed"  // dead letter, all attempts failed
+)
+
+// Request of user to delete urls.
+type Job struct {
+	ID            int64     `json:"id"`
+	UserID        string    `json:"user_id"`
+	ShortURLs     []string  `json:"short_urls"`
+	Status        JobStatus `json:"status"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Storage of deletion jobs.
+//
+//go:generate mockery --name DeletionStorage
+type DeletionStorage interface {
+	// Saves new pending job due immediately.
+	// Returns saved job with assigned id.
+	AddJobWithContext(context context.Context, userID string, shortURLs []string) (Job, error)
+
+	// Returns up to limit pending jobs due by given moment in order of creation.
+	GetDueJobsWithContext(context context.Context, now time.Time, limit int) ([]Job, error)
+
+	// Saves status, attempts, next attempt time and error of given jobs.
+	UpdateJobsWithContext(context context.Context, jobs ...Job) error
+
+	// Returns job by id.
+	GetJobWithContext(context context.Context, id int64) (Job, error)
+
+	// Returns number of pending jobs.
+	CountPendingWithContext(context context.Context) (int64, error)
+
+	// Removes done and failed jobs created before given moment.
+	// Returns number of removed jobs.
+	PurgeFinishedWithContext(context context.Context, createdBefore time.Time) (int64, error)
+}
@@ -0,0 +1,174 @@
+package deletionstorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// In memory deletion storage that logs every job change into file.
+//
+// Every line of file is latest known state of job, so jobs survive restarts.
+// Line of job without status only keeps last issued id once that job is purged.
+// File is rewritten with one line per job on opening and after purge.
+type FileDeletionStorage struct {
+	*SimpleDeletionStorage
+	filename string
+	file     *os.File
+	mutex    sync.Mutex
+}
+
+// New file deletion storage restoring jobs from given file.
+func NewFileDeletionStorage(filename string) (*FileDeletionStorage, error) {
+	storage := NewSimpleDeletionStorage()
+	if err := readJobs(filename, storage); err != nil {
+		return nil, err
+	}
+	f := &FileDeletionStorage{SimpleDeletionStorage: storage, filename: filename}
+	if err := f.rewrite(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reads job states from file, later lines override earlier ones.
+func readJobs(filename string, storage *SimpleDeletionStorage) error {
+	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	jobs := make(map[int64]Job)
+	reader := bufio.NewReader(file)
+	data, err := reader.ReadBytes('\n')
+	for err == nil {
+		var job Job
+		if err = json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		storage.LastID = max(storage.LastID, job.ID)
+		if job.Status != "" {
+			jobs[job.ID] = job
+		}
+		data, err = reader.ReadBytes('\n')
+	}
+	if err != io.EOF {
+		return err
+	}
+	for _, id := range slices.Sorted(maps.Keys(jobs)) {
+		storage.Jobs = append(storage.Jobs, jobs[id])
+	}
+	return nil
+}
+
+// Writes jobs into new file followed by last issued id if its job is purged.
+func writeJobs(filename string, jobs []Job, lastID int64) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, job := range jobs {
+		if err = encoder.Encode(job); err != nil {
+			break
+		}
+	}
+	if err == nil && (len(jobs) == 0 || jobs[len(jobs)-1].ID < lastID) && lastID != 0 {
+		err = encoder.Encode(Job{ID: lastID})
+	}
+	return errors.Join(err, writer.Flush(), file.Sync(), file.Close())
+}
+
+// Replaces file with current state of jobs and reopens it. Must be called under lock.
+func (f *FileDeletionStorage) rewrite() error {
+	f.SimpleDeletionStorage.Mutex.Lock()
+	jobs, lastID := slices.Clone(f.Jobs), f.LastID
+	f.SimpleDeletionStorage.Mutex.Unlock()
+	if err := writeJobs(f.filename+".tmp", jobs, lastID); err != nil {
+		return err
+	}
+	if err := os.Rename(f.filename+".tmp", f.filename); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	// Every record of replaced file is synced already.
+	if f.file != nil {
+		err = f.file.Close()
+	}
+	f.file = file
+	return err
+}
+
+// Appends job states to file and syncs it.
+func (f *FileDeletionStorage) append(jobs ...Job) error {
+	var data []byte
+	for _, job := range jobs {
+		line, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if _, err := f.file.Write(data); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// Saves new pending job and logs it before returning.
+func (f *FileDeletionStorage) AddJobWithContext(ctx context.Context, userID string, shortURLs []string) (Job, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	job, err := f.SimpleDeletionStorage.AddJobWithContext(ctx, userID, shortURLs)
+	if err != nil {
+		return job, err
+	}
+	return job, f.append(job)
+}
+
+// Saves and logs new states of given jobs.
+func (f *FileDeletionStorage) UpdateJobsWithContext(ctx context.Context, jobs ...Job) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.SimpleDeletionStorage.UpdateJobsWithContext(ctx, jobs...); err != nil {
+		return err
+	}
+	updated := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		stored, err := f.SimpleDeletionStorage.GetJobWithContext(ctx, job.ID)
+		if err != nil {
+			return err
+		}
+		updated = append(updated, stored)
+	}
+	return f.append(updated...)
+}
+
+// Removes done and failed jobs created before given moment and rewrites file without them.
+func (f *FileDeletionStorage) PurgeFinishedWithContext(ctx context.Context, createdBefore time.Time) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	purged, err := f.SimpleDeletionStorage.PurgeFinishedWithContext(ctx, createdBefore)
+	if err != nil || purged == 0 {
+		return purged, err
+	}
+	return purged, f.rewrite()
+}
+
+// Closes log file.
+func (f *FileDeletionStorage) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,78 @@
+package deletionstorage_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
+)
+
+func TestFileDeletionStorage_Restore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "deletions")
+	ctx := context.Background()
+	storage, err := deletionstorage.NewFileDeletionStorage(filename)
+	require.NoError(t, err)
+	first, err := storage.AddJobWithContext(ctx, "user_1", []string{"a"})
+	require.NoError(t, err)
+	second, err := storage.AddJobWithContext(ctx, "user_2", []string{"b"})
+	require.NoError(t, err)
+	first.Status = deletionstorage.StatusDone
+	first.Attempts = 1
+	require.NoError(t, storage.UpdateJobsWithContext(ctx, first))
+	require.NoError(t, storage.Close())
+
+	restored, err := deletionstorage.NewFileDeletionStorage(filename)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	got, err := restored.GetJobWithContext(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, deletionstorage.StatusDone, got.Status)
+	assert.Equal(t, 1, got.Attempts)
+	due, err := restored.GetDueJobsWithContext(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, second.ID, due[0].ID)
+	assert.Equal(t, []string{"b"}, due[0].ShortURLs)
+
+	third, err := restored.AddJobWithContext(ctx, "user_1", []string{"c"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), third.ID)
+}
+
+func TestFileDeletionStorage_PurgeFinished(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "deletions")
+	ctx := context.Background()
+	storage, err := deletionstorage.NewFileDeletionStorage(filename)
+	require.NoError(t, err)
+	first, err := storage.AddJobWithContext(ctx, "user_1", []string{"a"})
+	require.NoError(t, err)
+	second, err := storage.AddJobWithContext(ctx, "user_2", []string{"b"})
+	require.NoError(t, err)
+	first.Status = deletionstorage.StatusDone
+	second.Status = deletionstorage.StatusFailed
+	require.NoError(t, storage.UpdateJobsWithContext(ctx, first, second))
+	purged, err := storage.PurgeFinishedWithContext(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	require.NoError(t, storage.Close())
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte("\n")), "file keeps only last issued id")
+
+	restored, err := deletionstorage.NewFileDeletionStorage(filename)
+	require.NoError(t, err)
+	defer restored.Close()
+	_, err = restored.GetJobWithContext(ctx, first.ID)
+	require.ErrorIs(t, err, deletionstorage.ErrNoSuchJob)
+	third, err := restored.AddJobWithContext(ctx, "user_1", []string{"c"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), third.ID, "ids of purged jobs are not issued again")
+}
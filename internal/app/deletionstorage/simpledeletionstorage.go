@@ -0,0 +1,117 @@
+package deletionstorage
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Storage keeping deletion jobs in memory.
+//
+// Jobs are stored in order of ids, ids of purged jobs are not issued again.
+type SimpleDeletionStorage struct {
+	Jobs   []Job
+	LastID int64
+	Mutex  sync.Mutex
+}
+
+// New in memory deletion storage.
+func NewSimpleDeletionStorage() *SimpleDeletionStorage {
+	return &SimpleDeletionStorage{}
+}
+
+// Saves new pending job due immediately.
+func (s *SimpleDeletionStorage) AddJobWithContext(_ context.Context, userID string, shortURLs []string) (Job, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	now := time.Now()
+	s.LastID++
+	job := Job{
+		ID:            s.LastID,
+		UserID:        userID,
+		ShortURLs:     slices.Clone(shortURLs),
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	s.Jobs = append(s.Jobs, job)
+	return job, nil
+}
+
+// Returns up to limit pending jobs due by given moment in order of creation.
+func (s *SimpleDeletionStorage) GetDueJobsWithContext(_ context.Context, now time.Time, limit int) ([]Job, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var res []Job
+	for _, job := range s.Jobs {
+		if len(res) == limit {
+			break
+		}
+		if job.Status == StatusPending && !job.NextAttemptAt.After(now) {
+			res = append(res, job)
+		}
+	}
+	return res, nil
+}
+
+// Returns position of job with given id. Must be called under lock.
+func (s *SimpleDeletionStorage) find(id int64) (int, bool) {
+	return slices.BinarySearchFunc(s.Jobs, id, func(job Job, id int64) int {
+		return cmp.Compare(job.ID, id)
+	})
+}
+
+// Saves status, attempts, next attempt time and error of given jobs.
+func (s *SimpleDeletionStorage) UpdateJobsWithContext(_ context.Context, jobs ...Job) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for _, job := range jobs {
+		i, has := s.find(job.ID)
+		if !has {
+			return ErrNoSuchJob
+		}
+		stored := &s.Jobs[i]
+		stored.Status = job.Status
+		stored.Attempts = job.Attempts
+		stored.NextAttemptAt = job.NextAttemptAt
+		stored.LastError = job.LastError
+	}
+	return nil
+}
+
+// Returns job by id.
+func (s *SimpleDeletionStorage) GetJobWithContext(_ context.Context, id int64) (Job, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	i, has := s.find(id)
+	if !has {
+		return Job{}, ErrNoSuchJob
+	}
+	return s.Jobs[i], nil
+}
+
+// Returns number of pending jobs.
+func (s *SimpleDeletionStorage) CountPendingWithContext(_ context.Context) (int64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var count int64
+	for _, job := range s.Jobs {
+		if job.Status == StatusPending {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Removes done and failed jobs created before given moment.
+func (s *SimpleDeletionStorage) PurgeFinishedWithContext(_ context.Context, createdBefore time.Time) (int64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	count := len(s.Jobs)
+	s.Jobs = slices.DeleteFunc(s.Jobs, func(job Job) bool {
+		return job.Status != StatusPending && job.CreatedAt.Before(createdBefore)
+	})
+	return int64(count - len(s.Jobs)), nil
+}
@@ -0,0 +1,78 @@
+package deletionstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
+)
+
+func TestSimpleDeletionStorage_Jobs(t *testing.T) {
+	storage := deletionstorage.NewSimpleDeletionStorage()
+	ctx := context.Background()
+	first, err := storage.AddJobWithContext(ctx, "user_1", []string{"a", "b"})
+	require.NoError(t, err)
+	second, err := storage.AddJobWithContext(ctx, "user_2", []string{"c"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.ID)
+	assert.Equal(t, int64(2), second.ID)
+	assert.Equal(t, deletionstorage.StatusPending, first.Status)
+
+	due, err := storage.GetDueJobsWithContext(ctx, time.Now(), 1)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, first.ID, due[0].ID)
+
+	first.Attempts = 1
+	first.NextAttemptAt = time.Now().Add(time.Hour)
+	first.LastError = "failed"
+	second.Status = deletionstorage.StatusDone
+	require.NoError(t, storage.UpdateJobsWithContext(ctx, first, second))
+
+	due, err = storage.GetDueJobsWithContext(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+	count, err := storage.CountPendingWithContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	got, err := storage.GetJobWithContext(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Attempts)
+	assert.Equal(t, "failed", got.LastError)
+	assert.Equal(t, []string{"a", "b"}, got.ShortURLs)
+
+	_, err = storage.GetJobWithContext(ctx, 3)
+	require.ErrorIs(t, err, deletionstorage.ErrNoSuchJob)
+	require.ErrorIs(t, storage.UpdateJobsWithContext(ctx, deletionstorage.Job{ID: 3}), deletionstorage.ErrNoSuchJob)
+}
+
+func TestSimpleDeletionStorage_PurgeFinished(t *testing.T) {
+	storage := deletionstorage.NewSimpleDeletionStorage()
+	ctx := context.Background()
+	done, err := storage.AddJobWithContext(ctx, "user_1", []string{"a"})
+	require.NoError(t, err)
+	pending, err := storage.AddJobWithContext(ctx, "user_1", []string{"b"})
+	require.NoError(t, err)
+	done.Status = deletionstorage.StatusDone
+	require.NoError(t, storage.UpdateJobsWithContext(ctx, done))
+
+	purged, err := storage.PurgeFinishedWithContext(ctx, done.CreatedAt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purged, "jobs created at given moment are kept")
+	purged, err = storage.PurgeFinishedWithContext(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	_, err = storage.GetJobWithContext(ctx, done.ID)
+	require.ErrorIs(t, err, deletionstorage.ErrNoSuchJob)
+	got, err := storage.GetJobWithContext(ctx, pending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, deletionstorage.StatusPending, got.Status)
+	next, err := storage.AddJobWithContext(ctx, "user_1", []string{"c"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), next.ID)
+}
@@ -0,0 +1,68 @@
+package grpcserver
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	pb "github.com/valinurovdenis/urlshortener/internal/app/proto"
+)
+
+// Metadata key containing authorization token.
+const AuthorizationKey = "authorization"
+
+type userIDKey struct{}
+
+// Returns user id set by auth interceptor.
+func userIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
+
+// Methods that cannot create new user.
+var onlyWithAuth = map[string]bool{
+	pb.Shortener_DeleteUserURLs_FullMethodName: true,
+}
+
+// Returns user id from authorization token in metadata.
+func userIDFromMetadata(ctx context.Context, a auth.JwtAuthenticator) (int64, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(AuthorizationKey)
+	if len(tokens) == 0 {
+		return 0, status.Error(codes.Unauthenticated, "no authorization token")
+	}
+	return a.GetUserID(strings.TrimPrefix(tokens[0], "Bearer "))
+}
+
+// Interceptor authorizes user by token in metadata same as http middlewares.
+// Creates new user if no valid token provided and returns its token in header.
+// Returns Unauthenticated for methods requiring existing user.
+func AuthInterceptor(a auth.JwtAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		userID, err := userIDFromMetadata(ctx, a)
+		if err != nil {
+			if onlyWithAuth[info.FullMethod] {
+				return nil, status.Error(codes.Unauthenticated, "valid authorization token required")
+			}
+			userID, err = a.UserStorage.GenerateUUID(ctx)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "cannot create user: %v", err)
+			}
+			token, err := a.BuildJWTString(userID)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "cannot create token: %v", err)
+			}
+			if err = grpc.SetHeader(ctx, metadata.Pairs(AuthorizationKey, token)); err != nil {
+				return nil, err
+			}
+		}
+		ctx = context.WithValue(ctx, userIDKey{}, strconv.FormatInt(userID, 10))
+		return handler(ctx, req)
+	}
+}
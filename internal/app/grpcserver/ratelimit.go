@@ -0,0 +1,101 @@
+package grpcserver
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+	pb "github.com/valinurovdenis/urlshortener/internal/app/proto"
+	"github.com/valinurovdenis/urlshortener/internal/app/ratelimit"
+)
+
+// Metadata key containing seconds until rejected call could be allowed.
+const RetryAfterKey = "retry-after"
+
+// Limiters of calls, nil limiter does not limit anything.
+//
+// Limiters of http api should be used so that both apis take tokens from the same buckets.
+type RateLimits struct {
+	Create    *ratelimit.Limiter // calls creating short urls
+	BatchURLs *ratelimit.Limiter // urls in batch calls
+	Redirect  *ratelimit.Limiter // calls expanding short urls
+}
+
+// Limiter taking given number of tokens for call.
+type limitedCost struct {
+	limiter *ratelimit.Limiter
+	cost    int
+}
+
+// Returns limiters applied to call of method same as to corresponding http handler.
+func (l RateLimits) forMethod(method string, req any) []limitedCost {
+	switch method {
+	case pb.Shortener_Shorten_FullMethodName:
+		return []limitedCost{{l.Create, 1}}
+	case pb.Shortener_ShortenBatch_FullMethodName:
+		batch, _ := req.(*pb.ShortenBatchRequest)
+		return []limitedCost{{l.Create, 1}, {l.BatchURLs, max(len(batch.GetUrls()), 1)}}
+	case pb.Shortener_Expand_FullMethodName:
+		return []limitedCost{{l.Redirect, 1}}
+	}
+	return nil
+}
+
+// Returns rate limit key of call same as http api does.
+//
+// Calls with valid authorization token are limited by user, others by peer ip.
+func rateLimitKey(ctx context.Context, a auth.JwtAuthenticator) string {
+	if userID, err := userIDFromMetadata(ctx, a); err == nil {
+		return "user:" + strconv.FormatInt(userID, 10)
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "ip:"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:" + host
+}
+
+// Interceptor rejecting calls exceeding rate limits with ResourceExhausted.
+//
+// Must go before auth interceptor so that clients without token are limited by ip.
+// Sets retry-after header for rejected call, storage errors let call through.
+func RateLimitInterceptor(limits RateLimits, a auth.JwtAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var key string
+		for _, limited := range limits.forMethod(info.FullMethod, req) {
+			if limited.limiter == nil {
+				continue
+			}
+			if key == "" {
+				key = rateLimitKey(ctx, a)
+			}
+			res, err := limited.limiter.Take(ctx, key, limited.cost)
+			if err != nil {
+				logger.Log.Error("cannot check rate limit", zap.String("limit", limited.limiter.Name), zap.Error(err))
+				continue
+			}
+			if !res.Allowed {
+				if res.RetryAfter > 0 {
+					retryAfter := max(int(math.Ceil(res.RetryAfter.Seconds())), 1)
+					grpc.SetHeader(ctx, metadata.Pairs(RetryAfterKey, strconv.Itoa(retryAfter)))
+				}
+				return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+			}
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,58 @@
+package grpcserver_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	"github.com/valinurovdenis/urlshortener/internal/app/grpcserver"
+	pb "github.com/valinurovdenis/urlshortener/internal/app/proto"
+	"github.com/valinurovdenis/urlshortener/internal/app/ratelimit"
+)
+
+func TestRateLimitInterceptor(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	key := func(r *http.Request) string { return "" }
+	limits := grpcserver.RateLimits{
+		Create:    ratelimit.NewLimiter("create", ratelimit.PerMinute(10), store, key),
+		BatchURLs: ratelimit.NewLimiter("batch", ratelimit.PerMinute(2), store, key),
+		Redirect:  ratelimit.NewLimiter("redirect", ratelimit.PerMinute(1), store, key),
+	}
+	a := auth.NewAuthenticator("SECRET_KEY", nil)
+	s := newTestServer(t, grpc.ChainUnaryInterceptor(grpcserver.RateLimitInterceptor(limits, *a)))
+	s.storage.On("GetLongURLWithContext", mock.Anything, "short").Return("http://long.ru", nil).Times(3)
+	s.users.On("GenerateUUID", mock.Anything).Return(int64(3), nil).Once()
+
+	_, err := s.client.Expand(s.withUser(t, 1), &pb.ExpandRequest{ShortUrl: "short"})
+	require.NoError(t, err)
+	var header metadata.MD
+	_, err = s.client.Expand(s.withUser(t, 1), &pb.ExpandRequest{ShortUrl: "short"}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"60"}, header.Get(grpcserver.RetryAfterKey))
+
+	// Other user and clients without token have their own buckets.
+	_, err = s.client.Expand(s.withUser(t, 2), &pb.ExpandRequest{ShortUrl: "short"})
+	require.NoError(t, err)
+	_, err = s.client.Expand(context.Background(), &pb.ExpandRequest{ShortUrl: "short"})
+	require.NoError(t, err)
+
+	_, err = s.client.ShortenBatch(s.withUser(t, 1), &pb.ShortenBatchRequest{Urls: []*pb.ShortenBatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://a.ru"},
+		{CorrelationId: "2", OriginalUrl: "http://b.ru"},
+		{CorrelationId: "3", OriginalUrl: "http://c.ru"},
+	}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	remaining, err := store.TakeWithContext(context.Background(), "create:user:1", limits.Create.Limit, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 9, remaining.Remaining)
+}
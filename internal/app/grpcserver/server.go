@@ -0,0 +1,174 @@
+// Package grpcserver contains grpc api of shortener service.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	pb "github.com/valinurovdenis/urlshortener/internal/app/proto"
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/utils"
+)
+
+// Grpc shortener server on top of shortener service.
+type ShortenerServer struct {
+	pb.UnimplementedShortenerServer
+	Service service.ShortenerService
+	Host    string
+}
+
+// New grpc shortener server, short urls are returned prefixed by host.
+func NewShortenerServer(service service.ShortenerService, host string) *ShortenerServer {
+	return &ShortenerServer{Service: service, Host: host}
+}
+
+// New grpc server with registered shortener server and auth interceptor.
+// Interceptors given in options run before auth interceptor.
+func NewServer(shortenerServer *ShortenerServer, a auth.JwtAuthenticator, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(AuthInterceptor(a)))
+	server := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(server, shortenerServer)
+	return server
+}
+
+// Converts optional timestamp to time.
+func timeFromProto(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
+
+// Converts time to optional timestamp.
+func timeToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// Returns grpc status for error of generating short url.
+func generateErrorStatus(err error) error {
+	if errors.Is(err, service.ErrAliasTaken) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	var violation *service.PolicyViolation
+	if errors.As(err, &violation) {
+		return status.Errorf(codes.InvalidArgument, "%s: %s", violation.Rule, violation.Reason)
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// Generates short url from long url.
+func (s *ShortenerServer) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	expiration := service.Expiration{ExpiresAt: timeFromProto(req.GetExpiresAt()), TTL: req.GetTtl()}
+	options, err := expiration.Options(time.Now())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	options.Interstitial = req.GetInterstitial()
+	options.RedirectCode = int(req.GetRedirectCode())
+	shortURL, err := s.Service.GenerateShortURLWithContext(ctx,
+		urlstorage.URLPair{Long: req.GetUrl(), Short: req.GetAlias(), URLOptions: options}, userIDFromContext(ctx))
+	existed := errors.Is(err, urlstorage.ErrConflictURL)
+	if err != nil && !existed {
+		return nil, generateErrorStatus(err)
+	}
+	return &pb.ShortenResponse{ShortUrl: utils.AddStrings(s.Host, shortURL), Existed: existed}, nil
+}
+
+// Generates short urls in batch mode.
+func (s *ShortenerServer) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	var longURLs []urlstorage.URLPair
+	now := time.Now()
+	for _, item := range req.GetUrls() {
+		expiration := service.Expiration{ExpiresAt: timeFromProto(item.GetExpiresAt()), TTL: item.GetTtl()}
+		options, err := expiration.Options(now)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		options.Interstitial = item.GetInterstitial()
+		options.RedirectCode = int(item.GetRedirectCode())
+		longURLs = append(longURLs, urlstorage.URLPair{Long: item.GetOriginalUrl(), Short: item.GetAlias(), URLOptions: options})
+	}
+	shortURLs, errs, err := s.Service.GenerateShortURLBatchWithContext(ctx, longURLs, userIDFromContext(ctx))
+	if err != nil {
+		return nil, generateErrorStatus(err)
+	}
+	var resp pb.ShortenBatchResponse
+	for i, shortURL := range shortURLs {
+		result := &pb.ShortenBatchResult{CorrelationId: req.GetUrls()[i].GetCorrelationId()}
+		if errs[i] != nil {
+			result.Error = errs[i].Error()
+		} else if shortURL != "" {
+			result.ShortUrl = utils.AddStrings(s.Host, shortURL)
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return &resp, nil
+}
+
+// Returns long url by short url.
+//
+// Deleted and expired urls are reported as failed precondition.
+func (s *ShortenerServer) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	longURL, err := s.Service.GetLongURLWithContext(ctx, req.GetShortUrl())
+	switch {
+	case errors.Is(err, urlstorage.ErrNotFoundURL):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, service.ErrDeletedURL) || errors.Is(err, service.ErrExpiredURL):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.ExpandResponse{OriginalUrl: longURL}, nil
+}
+
+// Returns page of urls saved by user, all urls are returned if limit is not set.
+func (s *ShortenerServer) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	if req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	query := urlstorage.UserURLsQuery{Limit: int(req.GetLimit()), Cursor: req.GetCursor()}
+	page, err := s.Service.GetUserURLsPage(ctx, userIDFromContext(ctx), query)
+	if errors.Is(err, urlstorage.ErrInvalidCursor) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := pb.ListUserURLsResponse{NextCursor: page.NextCursor}
+	for _, userURL := range page.URLs {
+		resp.Urls = append(resp.Urls, &pb.UserURL{
+			ShortUrl:    utils.AddStrings(s.Host, userURL.Short),
+			OriginalUrl: userURL.Long,
+			ExpiresAt:   timeToProto(userURL.ExpiresAt),
+		})
+	}
+	return &resp, nil
+}
+
+// Schedules deletion of urls saved by user.
+func (s *ShortenerServer) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	id, err := s.Service.DeleteUserURLs(ctx, userIDFromContext(ctx), req.GetShortUrls()...)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.DeleteUserURLsResponse{JobId: id}, nil
+}
+
+// Checks whether service is alive.
+func (s *ShortenerServer) Ping(_ context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.Service.Ping(); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &pb.PingResponse{}, nil
+}
@@ -0,0 +1,189 @@
+package grpcserver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	"github.com/valinurovdenis/urlshortener/internal/app/grpcserver"
+	"github.com/valinurovdenis/urlshortener/internal/app/mocks"
+	pb "github.com/valinurovdenis/urlshortener/internal/app/proto"
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+type testServer struct {
+	client      pb.ShortenerClient
+	auth        *auth.JwtAuthenticator
+	storage     *mocks.URLStorage
+	userStorage *mocks.UserURLStorage
+	users       *mocks.UserStorage
+}
+
+func newTestServer(t *testing.T, opts ...grpc.ServerOption) testServer {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("short", nil).Maybe()
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	server := grpcserver.NewServer(grpcserver.NewShortenerServer(*shortenerService, "host/"), *auth, opts...)
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return testServer{
+		client:      pb.NewShortenerClient(conn),
+		auth:        auth,
+		storage:     mockStorage,
+		userStorage: mockUserStorage,
+		users:       userStorage,
+	}
+}
+
+func (s testServer) withUser(t *testing.T, userID int64) context.Context {
+	token, err := s.auth.BuildJWTString(userID)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(context.Background(), grpcserver.AuthorizationKey, token)
+}
+
+func TestShortenerServer_Shorten(t *testing.T) {
+	s := newTestServer(t)
+	s.users.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Once()
+	s.storage.On("StoreWithContext", mock.Anything, "http://new.ru", "short", "1", urlstorage.URLOptions{}).Return(nil).Once()
+	s.storage.On("StoreWithContext", mock.Anything, "http://existing.ru", "short", "2", urlstorage.URLOptions{}).Return(urlstorage.ErrConflictURL).Once()
+	s.storage.On("GetShortURLWithContext", mock.Anything, "http://existing.ru", mock.Anything).Return("existing", nil).Once()
+	s.storage.On("StoreWithContext", mock.Anything, "http://taken.ru", "taken", "2", urlstorage.URLOptions{}).Return(urlstorage.ErrShortURLTaken).Once()
+
+	var header metadata.MD
+	resp, err := s.client.Shorten(context.Background(), &pb.ShortenRequest{Url: "new.ru"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "host/short", resp.GetShortUrl())
+	assert.False(t, resp.GetExisted())
+	require.Len(t, header.Get(grpcserver.AuthorizationKey), 1)
+	userID, err := s.auth.GetUserID(header.Get(grpcserver.AuthorizationKey)[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), userID)
+
+	resp, err = s.client.Shorten(s.withUser(t, 2), &pb.ShortenRequest{Url: "existing.ru"})
+	require.NoError(t, err)
+	assert.Equal(t, "host/existing", resp.GetShortUrl())
+	assert.True(t, resp.GetExisted())
+
+	_, err = s.client.Shorten(s.withUser(t, 2), &pb.ShortenRequest{Url: "taken.ru", Alias: "taken"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = s.client.Shorten(s.withUser(t, 2), &pb.ShortenRequest{Url: "ttl.ru", Ttl: "-1h"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestShortenerServer_ShortenBatch(t *testing.T) {
+	s := newTestServer(t)
+	s.storage.On("StoreManyWithContext", mock.Anything, []urlstorage.URLPair{
+		{Long: "http://a.ru", Short: "short"},
+		{Long: "http://b.ru", Short: "taken"}}, "1").
+		Return([]error{nil, urlstorage.ErrShortURLTaken}, nil).Once()
+
+	resp, err := s.client.ShortenBatch(s.withUser(t, 1), &pb.ShortenBatchRequest{Urls: []*pb.ShortenBatchItem{
+		{CorrelationId: "1", OriginalUrl: "a.ru"},
+		{CorrelationId: "2", OriginalUrl: "b.ru", Alias: "taken"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetResults(), 2)
+	assert.Equal(t, "1", resp.GetResults()[0].GetCorrelationId())
+	assert.Equal(t, "host/short", resp.GetResults()[0].GetShortUrl())
+	assert.Equal(t, "2", resp.GetResults()[1].GetCorrelationId())
+	assert.Equal(t, service.ErrAliasTaken.Error(), resp.GetResults()[1].GetError())
+}
+
+func TestShortenerServer_Expand(t *testing.T) {
+	s := newTestServer(t)
+	s.storage.On("GetLongURLWithContext", mock.Anything, "existing").Return("http://existing.ru", nil).Once()
+	s.storage.On("GetLongURLWithContext", mock.Anything, "missing").Return("", urlstorage.ErrNotFoundURL).Once()
+	s.storage.On("GetLongURLWithContext", mock.Anything, "deleted").Return("", urlstorage.ErrDeletedURL).Once()
+	s.storage.On("GetLongURLWithContext", mock.Anything, "expired").Return("", urlstorage.ErrExpiredURL).Once()
+	s.storage.On("GetLongURLWithContext", mock.Anything, "broken").Return("", errors.New("connection refused")).Once()
+
+	resp, err := s.client.Expand(s.withUser(t, 1), &pb.ExpandRequest{ShortUrl: "existing"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://existing.ru", resp.GetOriginalUrl())
+
+	for shortURL, code := range map[string]codes.Code{
+		"missing": codes.NotFound,
+		"deleted": codes.FailedPrecondition,
+		"expired": codes.FailedPrecondition,
+		"broken":  codes.Internal,
+	} {
+		_, err = s.client.Expand(s.withUser(t, 1), &pb.ExpandRequest{ShortUrl: shortURL})
+		assert.Equal(t, code, status.Code(err), shortURL)
+	}
+}
+
+func TestShortenerServer_ListUserURLs(t *testing.T) {
+	s := newTestServer(t)
+	s.userStorage.On("GetUserURLsPageWithContext", mock.Anything, "1", urlstorage.UserURLsQuery{}).
+		Return(urlstorage.UserURLsPage{URLs: []urlstorage.StoredURL{{URLPair: urlstorage.URLPair{Short: "short", Long: "http://long.ru"}}}}, nil).Once()
+
+	resp, err := s.client.ListUserURLs(s.withUser(t, 1), &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetUrls(), 1)
+	assert.Equal(t, "host/short", resp.GetUrls()[0].GetShortUrl())
+	assert.Equal(t, "http://long.ru", resp.GetUrls()[0].GetOriginalUrl())
+	assert.Nil(t, resp.GetUrls()[0].GetExpiresAt())
+	assert.Empty(t, resp.GetNextCursor())
+
+	s.userStorage.On("GetUserURLsPageWithContext", mock.Anything, "1", urlstorage.UserURLsQuery{Limit: service.MaxPageSize, Cursor: "cursor"}).
+		Return(urlstorage.UserURLsPage{NextCursor: "next"}, nil).Once()
+	resp, err = s.client.ListUserURLs(s.withUser(t, 1), &pb.ListUserURLsRequest{Limit: service.MaxPageSize + 1, Cursor: "cursor"})
+	require.NoError(t, err)
+	assert.Empty(t, resp.GetUrls())
+	assert.Equal(t, "next", resp.GetNextCursor())
+
+	s.userStorage.On("GetUserURLsPageWithContext", mock.Anything, "1", urlstorage.UserURLsQuery{Cursor: "???"}).
+		Return(urlstorage.UserURLsPage{}, urlstorage.ErrInvalidCursor).Once()
+	_, err = s.client.ListUserURLs(s.withUser(t, 1), &pb.ListUserURLsRequest{Cursor: "???"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.client.ListUserURLs(s.withUser(t, 1), &pb.ListUserURLsRequest{Limit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestShortenerServer_DeleteUserURLs(t *testing.T) {
+	s := newTestServer(t)
+
+	_, err := s.client.DeleteUserURLs(context.Background(), &pb.DeleteUserURLsRequest{ShortUrls: []string{"short"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	resp, err := s.client.DeleteUserURLs(s.withUser(t, 1), &pb.DeleteUserURLsRequest{ShortUrls: []string{"short"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetJobId())
+}
+
+func TestShortenerServer_Ping(t *testing.T) {
+	s := newTestServer(t)
+	s.userStorage.On("Ping").Return(nil).Once()
+	s.storage.On("Ping").Return(nil).Once()
+
+	_, err := s.client.Ping(s.withUser(t, 1), &pb.PingRequest{})
+	require.NoError(t, err)
+}
@@ -34,9 +34,8 @@ func (c *compressWriter) Write(p []byte) (int, error) {
 
 // Compress writer write function.
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
-	}
+	// body is compressed whatever the status is, error descriptions included
+	c.w.Header().Set("Content-Encoding", "gzip")
 	c.w.WriteHeader(statusCode)
 }
 
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+)
+
+// Page describing where short url leads.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Preview of {{.ShortURL}}</title></head>
+<body>
+<h1>{{.ShortURL}}</h1>
+<dl>
+<dt>Destination</dt><dd>{{if .Available}}<a href="{{.LongURL}}" rel="nofollow noopener">{{.LongURL}}</a>{{else}}{{.LongURL}}{{end}}</dd>
+{{if not .CreatedAt.IsZero}}<dt>Created</dt><dd>{{.CreatedAt.UTC.Format "2006-01-02 15:04 MST"}}</dd>{{end}}
+{{if not .ExpiresAt.IsZero}}<dt>Expires</dt><dd>{{.ExpiresAt.UTC.Format "2006-01-02 15:04 MST"}}</dd>{{end}}
+<dt>Clicks</dt><dd>{{.Clicks}}</dd>
+<dt>Status</dt><dd>{{if .Deleted}}deleted{{else if .Expired}}expired{{else}}active{{end}}</dd>
+</dl>
+</body>
+</html>
+`))
+
+// Warning page shown instead of redirect to interstitial url.
+var interstitialPage = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>You are leaving {{.Host}}</title></head>
+<body>
+<h1>You are leaving {{.Host}}</h1>
+<p>This link leads to:</p>
+<p><code>{{.LongURL}}</code></p>
+<p>Make sure you trust this site before continuing.</p>
+<p><a href="{{.LongURL}}" rel="nofollow noopener">Continue</a></p>
+</body>
+</html>
+`))
+
+// Data of preview page.
+type previewData struct {
+	service.URLPreview
+	Available bool // whether url can be followed
+}
+
+// Data of interstitial page.
+type interstitialData struct {
+	Host    string
+	LongURL string
+}
+
+// Renders html page with given status.
+func writePage(w http.ResponseWriter, page *template.Template, status int, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	page.Execute(w, data)
+}
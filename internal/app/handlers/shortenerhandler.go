@@ -2,16 +2,30 @@
 package handlers
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	"github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
 	"github.com/valinurovdenis/urlshortener/internal/app/gzip"
 	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+	"github.com/valinurovdenis/urlshortener/internal/app/metrics"
+	"github.com/valinurovdenis/urlshortener/internal/app/qrcode"
+	"github.com/valinurovdenis/urlshortener/internal/app/ratelimit"
 	"github.com/valinurovdenis/urlshortener/internal/app/service"
 	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
 	"github.com/valinurovdenis/urlshortener/internal/app/utils"
@@ -19,9 +33,22 @@ import (
 
 // Main class for chi handlers.
 type ShortenerHandler struct {
-	Service service.ShortenerService
-	Auth    auth.JwtAuthenticator
-	Host    string
+	Service        service.ShortenerService
+	Auth           auth.JwtAuthenticator
+	Host           string
+	TrustedSubnet  *net.IPNet   // subnet allowed to get internal stats and metrics, nil forbids everyone
+	TrustedProxies []*net.IPNet // subnets of proxies whose client ip headers are trusted
+	RateLimits     RateLimits
+	QRCodes        *qrcode.Cache // rendered qr codes, nil renders every time
+	RedirectCode   int           // status of redirect for urls without own one, zero means 307
+	RedirectMaxAge time.Duration // how long permanent redirects may be cached
+}
+
+// Limiters of requests, nil limiter does not limit anything.
+type RateLimits struct {
+	Create    *ratelimit.Limiter // requests creating short urls
+	BatchURLs *ratelimit.Limiter // urls in batch requests
+	Redirect  *ratelimit.Limiter // redirects by short url
 }
 
 // Shortener handler contains shortener service, authenticator.
@@ -32,8 +59,8 @@ func NewShortenerHandler(service service.ShortenerService, auth auth.JwtAuthenti
 // Handler for redirecting to long url by short url.
 func (h *ShortenerHandler) Redirect(w http.ResponseWriter, r *http.Request) {
 	shortURL := chi.URLParam(r, "url")
-	url, err := h.Service.GetLongURLWithContext(r.Context(), shortURL)
-	if errors.Is(err, service.ErrDeletedURL) {
+	url, err := h.Service.GetURLWithContext(r.Context(), shortURL)
+	if errors.Is(err, service.ErrDeletedURL) || errors.Is(err, service.ErrExpiredURL) {
 		w.WriteHeader(http.StatusGone)
 		return
 	}
@@ -41,7 +68,168 @@ func (h *ShortenerHandler) Redirect(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	if r.Method != http.MethodHead {
+		h.Service.RecordClick(clickstorage.Click{
+			Time:      time.Now(),
+			ShortURL:  shortURL,
+			Referrer:  r.Referer(),
+			UserAgent: r.UserAgent(),
+			IP:        h.clientIP(r),
+		})
+	}
+	if url.Interstitial {
+		writePage(w, interstitialPage, http.StatusOK, interstitialData{Host: r.Host, LongURL: url.Long})
+		return
+	}
+	code := cmp.Or(url.RedirectCode, h.RedirectCode, service.DefaultRedirectCode)
+	w.Header().Set("Cache-Control", redirectCacheControl(code, url.ExpiresAt, h.RedirectMaxAge, time.Now()))
+	http.Redirect(w, r, url.Long, code)
+}
+
+// Returns Cache-Control of redirect.
+//
+// Permanent redirects are cached until url expires but not longer than maxAge,
+// temporary ones are not cached so that every click reaches service.
+func redirectCacheControl(code int, expiresAt time.Time, maxAge time.Duration, now time.Time) string {
+	if !service.IsPermanentRedirect(code) {
+		return "private, no-cache"
+	}
+	if !expiresAt.IsZero() {
+		maxAge = min(maxAge, expiresAt.Sub(now))
+	}
+	if maxAge < time.Second {
+		return "private, no-cache"
+	}
+	return "public, max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+}
+
+// Handler for page describing short url without redirecting.
+//
+// Deleted and expired urls are described too.
+func (h *ShortenerHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	shortURL := chi.URLParam(r, "url")
+	preview, err := h.Service.GetURLPreview(r.Context(), shortURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writePage(w, previewPage, http.StatusOK, previewData{URLPreview: preview, Available: !preview.Deleted && !preview.Expired})
+}
+
+// Parses qr code options from request parameters size, level, margin and format.
+func parseQROptions(params url.Values) (qrcode.Options, error) {
+	options := qrcode.DefaultOptions
+	var err error
+	if size := params.Get("size"); size != "" {
+		if options.Size, err = strconv.Atoi(size); err != nil {
+			return options, fmt.Errorf("wrong size: %w", err)
+		}
+	}
+	if level := params.Get("level"); level != "" {
+		options.Level = level
+	}
+	if margin := params.Get("margin"); margin != "" {
+		if options.Margin, err = strconv.Atoi(margin); err != nil {
+			return options, fmt.Errorf("wrong margin: %w", err)
+		}
+	}
+	if format := params.Get("format"); format != "" {
+		options.Format = qrcode.Format(strings.ToLower(format))
+	}
+	return options, options.Validate()
+}
+
+// Handler returning qr code of short url under configured host.
+//
+// Host of request is ignored since clients set it freely, so images are cached by short url and options.
+//
+// Deleted and expired urls have no qr codes.
+func (h *ShortenerHandler) QRCode(w http.ResponseWriter, r *http.Request) {
+	shortURL := chi.URLParam(r, "url")
+	options, err := parseQROptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = h.Service.GetLongURLWithContext(r.Context(), shortURL)
+	if errors.Is(err, service.ErrDeletedURL) || errors.Is(err, service.ErrExpiredURL) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	image, err := h.QRCodes.Render(utils.AddStrings(h.Host, shortURL), options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", options.Format.ContentType())
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(image)
+}
+
+// Whether ip belongs to trusted proxy.
+func (h *ShortenerHandler) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && slices.ContainsFunc(h.TrustedProxies, func(subnet *net.IPNet) bool {
+		return subnet.Contains(parsed)
+	})
+}
+
+// Returns client ip taken from remote address.
+//
+// Proxy headers are read only from trusted proxies, since clients set them freely.
+func (h *ShortenerHandler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !h.trustedProxy(host) {
+		return host
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	// Every proxy appends address it got request from, so the last address not of trusted proxy is client.
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		host = ip
+		if !h.trustedProxy(ip) {
+			break
+		}
+	}
+	return host
+}
+
+// Returns rate limit key of request.
+//
+// Requests with valid authorization cookie are limited by user, others by client ip.
+func (h *ShortenerHandler) RateLimitKey(r *http.Request) string {
+	if cookie, err := r.Cookie("Authorization"); err == nil {
+		if userID, err := h.Auth.GetUserID(cookie.Value); err == nil {
+			return "user:" + strconv.FormatInt(userID, 10)
+		}
+	}
+	return "ip:" + h.clientIP(r)
+}
+
+// Returns number of urls in batch request leaving request body intact.
+// Malformed batch costs one url and is rejected by handler later.
+func BatchSize(r *http.Request) int {
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	var batch []json.RawMessage
+	if err != nil || json.Unmarshal(body, &batch) != nil || len(batch) == 0 {
+		return 1
+	}
+	return len(batch)
 }
 
 // Handler for generating short url from long url.
@@ -52,7 +240,7 @@ func (h *ShortenerHandler) Generate(w http.ResponseWriter, r *http.Request) {
 	var err error
 	rawURL, err = io.ReadAll(r.Body)
 	if err == nil {
-		url, err = h.Service.GenerateShortURLWithContext(r.Context(), string(rawURL), userID)
+		url, err = h.Service.GenerateShortURLWithContext(r.Context(), urlstorage.URLPair{Long: string(rawURL)}, userID)
 	}
 
 	if err == nil {
@@ -60,16 +248,61 @@ func (h *ShortenerHandler) Generate(w http.ResponseWriter, r *http.Request) {
 	} else if errors.Is(err, urlstorage.ErrConflictURL) {
 		w.WriteHeader(http.StatusConflict)
 	} else {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeGenerateError(w, err)
 		return
 	}
 
 	w.Write([]byte(utils.AddStrings(h.Host, url)))
 }
 
+// Description of rejected long url.
+type PolicyViolation struct {
+	Error  string `json:"error"`
+	Rule   string `json:"rule"`
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+	ID     string `json:"correlation_id,omitempty"`
+}
+
+// Writes 422 with description of url policy violation if error is such violation.
+// Correlation ids of batch are used to point to rejected url.
+func writePolicyViolation(w http.ResponseWriter, err error, batch []InputBatch) bool {
+	var violation *service.PolicyViolation
+	if !errors.As(err, &violation) {
+		return false
+	}
+	res := PolicyViolation{Error: service.ErrURLPolicy.Error(), Rule: violation.Rule, URL: violation.URL, Reason: violation.Reason}
+	if violation.Index < len(batch) {
+		res.ID = batch[violation.Index].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(res)
+	return true
+}
+
+// Writes error of generating short url.
+func writeGenerateError(w http.ResponseWriter, err error) {
+	if !writePolicyViolation(w, err, nil) {
+		http.Error(w, err.Error(), generateErrorStatus(err))
+	}
+}
+
+// Returns response status for error of generating short url.
+func generateErrorStatus(err error) int {
+	if errors.Is(err, service.ErrAliasTaken) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
 // Input type for json handler.
 type InputURL struct {
-	URL string `json:"url"`
+	URL          string `json:"url"`
+	Alias        string `json:"alias,omitempty"`
+	Interstitial bool   `json:"interstitial,omitempty"`
+	RedirectCode int    `json:"redirect_code,omitempty"`
+	service.Expiration
 }
 
 // Output type for json handler.
@@ -88,17 +321,26 @@ func (h *ShortenerHandler) GenerateJSON(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	options, err := longURL.Options(time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options.Interstitial = longURL.Interstitial
+	options.RedirectCode = longURL.RedirectCode
 
-	shortURL, err = h.Service.GenerateShortURLWithContext(r.Context(), longURL.URL, userID)
+	shortURL, err = h.Service.GenerateShortURLWithContext(r.Context(),
+		urlstorage.URLPair{Long: longURL.URL, Short: longURL.Alias, URLOptions: options}, userID)
 
+	if err != nil && !errors.Is(err, urlstorage.ErrConflictURL) {
+		writeGenerateError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	if err == nil {
 		w.WriteHeader(http.StatusCreated)
-	} else if errors.Is(err, urlstorage.ErrConflictURL) {
-		w.WriteHeader(http.StatusConflict)
 	} else {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		w.WriteHeader(http.StatusConflict)
 	}
 
 	json.NewEncoder(w).Encode(ResultURL{utils.AddStrings(h.Host, shortURL)})
@@ -106,14 +348,19 @@ func (h *ShortenerHandler) GenerateJSON(w http.ResponseWriter, r *http.Request)
 
 // Input type for generating batch.
 type InputBatch struct {
-	URL string `json:"original_url"`
-	ID  string `json:"correlation_id"`
+	URL          string `json:"original_url"`
+	ID           string `json:"correlation_id"`
+	Alias        string `json:"alias,omitempty"`
+	Interstitial bool   `json:"interstitial,omitempty"`
+	RedirectCode int    `json:"redirect_code,omitempty"`
+	service.Expiration
 }
 
 // Output type for generating batch.
 type ResultBatch struct {
-	URL string `json:"short_url"`
-	ID  string `json:"correlation_id"`
+	URL   string `json:"short_url,omitempty"`
+	ID    string `json:"correlation_id"`
+	Error string `json:"error,omitempty"`
 }
 
 // Handler for generating long urls in batch mode.
@@ -121,22 +368,34 @@ func (h *ShortenerHandler) GenerateBatch(w http.ResponseWriter, r *http.Request)
 	userID := r.Header.Get("user_id")
 	var input []InputBatch
 	var result []ResultBatch
-	var longURLs []string
+	var longURLs []urlstorage.URLPair
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	now := time.Now()
 	for _, v := range input {
-		longURLs = append(longURLs, v.URL)
+		options, err := v.Options(now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		options.Interstitial = v.Interstitial
+		options.RedirectCode = v.RedirectCode
+		longURLs = append(longURLs, urlstorage.URLPair{Long: v.URL, Short: v.Alias, URLOptions: options})
 	}
-	shortURLs, err := h.Service.GenerateShortURLBatchWithContext(r.Context(), longURLs, userID)
+	shortURLs, errs, err := h.Service.GenerateShortURLBatchWithContext(r.Context(), longURLs, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		if !writePolicyViolation(w, err, input) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
 	for i, shortURL := range shortURLs {
-		if shortURL != "" {
+		if errs[i] != nil {
+			result = append(result, ResultBatch{ID: input[i].ID, Error: errs[i].Error()})
+		} else if shortURL != "" {
 			result = append(result, ResultBatch{ID: input[i].ID, URL: h.Host + shortURL})
 		}
 	}
@@ -157,28 +416,93 @@ func (h *ShortenerHandler) Ping(w http.ResponseWriter, r *http.Request) {
 
 // Main structure for pair mapping LongURL <-> ShortURL
 type UserURL struct {
-	ShortURL string `json:"short_url"`
-	LongURL  string `json:"original_url"`
+	ShortURL  string    `json:"short_url"`
+	LongURL   string    `json:"original_url"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	Deleted   bool      `json:"deleted,omitempty"`
 }
 
-// Get all urls saved by user.
+// Parses query of page of user urls from request parameters.
+//
+// Supported parameters are limit, cursor, contains, created_after, created_before,
+// include_deleted and sort that is either created_at or -created_at.
+func parseUserURLsQuery(params url.Values) (urlstorage.UserURLsQuery, error) {
+	query := urlstorage.UserURLsQuery{
+		Cursor:      params.Get("cursor"),
+		LongURLPart: params.Get("contains"),
+	}
+	var err error
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			return query, errors.New("limit must be positive integer")
+		}
+	}
+	if after := params.Get("created_after"); after != "" {
+		if query.CreatedAfter, err = time.Parse(time.RFC3339, after); err != nil {
+			return query, fmt.Errorf("wrong created_after: %w", err)
+		}
+	}
+	if before := params.Get("created_before"); before != "" {
+		if query.CreatedBefore, err = time.Parse(time.RFC3339, before); err != nil {
+			return query, fmt.Errorf("wrong created_before: %w", err)
+		}
+	}
+	if includeDeleted := params.Get("include_deleted"); includeDeleted != "" {
+		if query.IncludeDeleted, err = strconv.ParseBool(includeDeleted); err != nil {
+			return query, fmt.Errorf("wrong include_deleted: %w", err)
+		}
+	}
+	switch params.Get("sort") {
+	case "", "created_at":
+	case "-created_at":
+		query.Descending = true
+	default:
+		return query, errors.New("sort must be created_at or -created_at")
+	}
+	return query, nil
+}
+
+// Get page of urls saved by user.
+//
+// Link header with rel="next" refers to next page if there is one.
 func (h *ShortenerHandler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 	userID := r.Header.Get("user_id")
-	userURLs, err := h.Service.GetUserURLs(r.Context(), userID)
-	var resultURLs []UserURL
+	query, err := parseUserURLsQuery(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := h.Service.GetUserURLsPage(r.Context(), userID, query)
+	if errors.Is(err, urlstorage.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	if page.NextCursor != "" {
+		params := r.URL.Query()
+		params.Set("cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%sapi/user/urls?%s>; rel="next"`, h.Host, params.Encode()))
+	}
 	w.Header().Set("Content-Type", "application/json")
-	if len(userURLs) == 0 {
+	if len(page.URLs) == 0 {
 		w.WriteHeader(http.StatusNoContent)
-	} else {
-		w.WriteHeader(http.StatusOK)
+		return
 	}
-	for i := range userURLs {
-		url := UserURL{ShortURL: h.Host + userURLs[i].Short, LongURL: userURLs[i].Long}
-		resultURLs = append(resultURLs, url)
+	w.WriteHeader(http.StatusOK)
+	resultURLs := make([]UserURL, 0, len(page.URLs))
+	for _, userURL := range page.URLs {
+		resultURLs = append(resultURLs, UserURL{
+			ShortURL:  h.Host + userURL.Short,
+			LongURL:   userURL.Long,
+			ExpiresAt: userURL.ExpiresAt,
+			CreatedAt: userURL.CreatedAt,
+			Deleted:   userURL.Deleted,
+		})
 	}
 	json.NewEncoder(w).Encode(resultURLs)
 }
@@ -191,12 +515,179 @@ func (h *ShortenerHandler) DeleteUserURLs(w http.ResponseWriter, r *http.Request
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	err := h.Service.DeleteUserURLs(r.Context(), userID, urls...)
+	id, err := h.Service.DeleteUserURLs(r.Context(), userID, urls...)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	} else {
-		w.WriteHeader(http.StatusAccepted)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/user/deletions/%d", id))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(DeletionJobID{ID: id})
+}
+
+// Id of scheduled deletion job.
+type DeletionJobID struct {
+	ID int64 `json:"id"`
+}
+
+// Status of deletion job.
+type DeletionJob struct {
+	ID            int64                     `json:"id"`
+	ShortURLs     []string                  `json:"short_urls"`
+	Status        deletionstorage.JobStatus `json:"status"`
+	Attempts      int                       `json:"attempts"`
+	NextAttemptAt time.Time                 `json:"next_attempt_at,omitzero"`
+	LastError     string                    `json:"last_error,omitempty"`
+	CreatedAt     time.Time                 `json:"created_at"`
+}
+
+// Get status of deletion job created by user.
+func (h *ShortenerHandler) GetDeletionJob(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("user_id")
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid deletion job id", http.StatusBadRequest)
+		return
+	}
+	job, err := h.Service.GetDeletionJob(r.Context(), userID, id)
+	if errors.Is(err, service.ErrNoSuchDeletionJob) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var nextAttemptAt time.Time
+	if job.Status == deletionstorage.StatusPending {
+		nextAttemptAt = job.NextAttemptAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DeletionJob{
+		ID:            job.ID,
+		ShortURLs:     job.ShortURLs,
+		Status:        job.Status,
+		Attempts:      job.Attempts,
+		NextAttemptAt: nextAttemptAt,
+		LastError:     job.LastError,
+		CreatedAt:     job.CreatedAt,
+	})
+}
+
+// Input type for changing long url.
+type UpdateURL struct {
+	URL string `json:"url"`
+}
+
+// Point short url saved by user to new long url.
+func (h *ShortenerHandler) UpdateUserURL(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("user_id")
+	shortURL := chi.URLParam(r, "short")
+	var input UpdateURL
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	change, err := h.Service.UpdateUserURL(r.Context(), userID, shortURL, input.URL)
+	switch {
+	case errors.Is(err, service.ErrNotUserURL):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrDeletedURL):
+		w.WriteHeader(http.StatusGone)
+		return
+	case errors.Is(err, urlstorage.ErrConflictURL):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrURLPolicy):
+		writePolicyViolation(w, err, nil)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UserURL{ShortURL: h.Host + shortURL, LongURL: change.LongURL})
+}
+
+// Change of long url of short url.
+type URLChange struct {
+	PreviousURL string    `json:"previous_url"`
+	LongURL     string    `json:"original_url"`
+	ChangedBy   string    `json:"changed_by"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// Get changes of long url of short url saved by user.
+func (h *ShortenerHandler) GetURLHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("user_id")
+	history, err := h.Service.GetURLHistory(r.Context(), userID, chi.URLParam(r, "short"))
+	if errors.Is(err, service.ErrNotUserURL) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := make([]URLChange, 0, len(history))
+	for _, change := range history {
+		result = append(result, URLChange{
+			PreviousURL: change.PreviousURL,
+			LongURL:     change.LongURL,
+			ChangedBy:   change.ChangedBy,
+			ChangedAt:   change.ChangedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// Get click statistics of url saved by user.
+func (h *ShortenerHandler) GetURLStats(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("user_id")
+	stats, err := h.Service.GetURLStats(r.Context(), userID, chi.URLParam(r, "short"))
+	if errors.Is(err, service.ErrNotUserURL) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Get number of stored urls and users.
+func (h *ShortenerHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.Service.GetStats(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Middleware allows only requests of clients from trusted subnet.
+// Client ip is taken from proxy headers only behind trusted proxies.
+// Returns 403 otherwise.
+func (h *ShortenerHandler) OnlyTrustedSubnet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(h.clientIP(r))
+		if h.TrustedSubnet == nil || ip == nil || !h.TrustedSubnet.Contains(ip) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // Defines all handlers.
@@ -205,21 +696,39 @@ func ShortenerRouter(handler ShortenerHandler, isProduction bool) chi.Router {
 	if !isProduction {
 		r.Mount("/debug", middleware.Profiler())
 	}
+	r.With(handler.OnlyTrustedSubnet).Handle("/metrics", metrics.Handler())
 
 	r.Group(func(r chi.Router) {
 		r.Use(logger.RequestLoggerMiddleware)
+		r.Use(metrics.Middleware)
 		r.Use(gzip.GzipMiddleware)
 		r.Route("/", func(r chi.Router) {
-			r.Use(handler.Auth.CreateUserIfNeeded)
-			r.Post("/", handler.Generate)
-			r.Post("/api/shorten", handler.GenerateJSON)
-			r.Post("/api/shorten/batch", handler.GenerateBatch)
-			r.Get("/{url}", handler.Redirect)
-			r.Get("/ping", handler.Ping)
-			r.Get("/api/user/urls", handler.GetUserURLs)
+			// Limits go before user creation so that clients without cookie are limited by ip.
+			r.Group(func(r chi.Router) {
+				r.Use(handler.RateLimits.Create.Middleware)
+				r.With(handler.Auth.CreateUserIfNeeded).Post("/", handler.Generate)
+				r.With(handler.Auth.CreateUserIfNeeded).Post("/api/shorten", handler.GenerateJSON)
+				r.With(handler.RateLimits.BatchURLs.Middleware, handler.Auth.CreateUserIfNeeded).
+					Post("/api/shorten/batch", handler.GenerateBatch)
+			})
+			r.With(handler.RateLimits.Redirect.Middleware, handler.Auth.CreateUserIfNeeded).Get("/{url}", handler.Redirect)
+			r.With(handler.RateLimits.Redirect.Middleware).Head("/{url}", handler.Redirect)
+			r.With(handler.RateLimits.Redirect.Middleware).Get("/{url}/qr", handler.QRCode)
+			r.With(handler.RateLimits.Redirect.Middleware).Get("/{url}/preview", handler.Preview)
+			r.With(handler.RateLimits.Redirect.Middleware).Get("/{url}+", handler.Preview)
+			r.Group(func(r chi.Router) {
+				r.Use(handler.Auth.CreateUserIfNeeded)
+				r.Get("/ping", handler.Ping)
+				r.Get("/api/user/urls", handler.GetUserURLs)
+			})
 		})
 
 		r.With(handler.Auth.OnlyWithAuth).Delete("/api/user/urls", handler.DeleteUserURLs)
+		r.With(handler.Auth.OnlyWithAuth).Patch("/api/user/urls/{short}", handler.UpdateUserURL)
+		r.With(handler.Auth.OnlyWithAuth).Get("/api/user/urls/{short}/history", handler.GetURLHistory)
+		r.With(handler.Auth.OnlyWithAuth).Get("/api/user/urls/{short}/stats", handler.GetURLStats)
+		r.With(handler.Auth.OnlyWithAuth).Get("/api/user/deletions/{id}", handler.GetDeletionJob)
+		r.With(handler.OnlyTrustedSubnet).Get("/api/internal/stats", handler.GetStats)
 	})
 
 	return r
@@ -3,21 +3,28 @@ package handlers_test
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/valinurovdenis/urlshortener/internal/app/auth"
+	"github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
 	"github.com/valinurovdenis/urlshortener/internal/app/handlers"
 	"github.com/valinurovdenis/urlshortener/internal/app/mocks"
+	"github.com/valinurovdenis/urlshortener/internal/app/qrcode"
+	"github.com/valinurovdenis/urlshortener/internal/app/ratelimit"
 	"github.com/valinurovdenis/urlshortener/internal/app/service"
 	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
 )
@@ -49,12 +56,15 @@ func TestShortenerHandler_redirect(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
 	mockStorage := mocks.NewURLStorage(t)
 	userStorage := mocks.NewUserStorage(t)
-	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(2)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(3)
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
-	mockStorage.On("GetLongURLWithContext", mock.Anything, "existing").Return(existingURL, nil).Once()
-	mockStorage.On("GetLongURLWithContext", mock.Anything, "non-existing").Return("", errors.New("some error")).Once()
+	mockStorage.On("GetURLWithContext", mock.Anything, "existing").Return(
+		urlstorage.StoredURL{URLPair: urlstorage.URLPair{Short: "existing", Long: existingURL}}, nil).Once()
+	mockStorage.On("GetURLWithContext", mock.Anything, "non-existing").Return(urlstorage.StoredURL{}, errors.New("some error")).Once()
+	mockStorage.On("GetURLWithContext", mock.Anything, "expired").Return(urlstorage.StoredURL{URLPair: urlstorage.URLPair{
+		Short: "expired", Long: existingURL, URLOptions: urlstorage.URLOptions{ExpiresAt: time.Now().Add(-time.Hour)}}}, nil).Once()
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -69,6 +79,8 @@ func TestShortenerHandler_redirect(t *testing.T) {
 			expectedCode: http.StatusTemporaryRedirect, expectedLocation: existingURL},
 		{name: "non-existing", method: http.MethodGet, shortURL: "/non-existing",
 			expectedCode: http.StatusBadRequest, expectedLocation: ""},
+		{name: "expired", method: http.MethodGet, shortURL: "/expired",
+			expectedCode: http.StatusGone, expectedLocation: ""},
 	}
 
 	for _, tc := range testCases {
@@ -84,17 +96,17 @@ func TestShortenerHandler_redirect(t *testing.T) {
 
 func TestShortenerHandler_generateSimple(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
-	mockGenerator.On("Generate").Return("existing1", nil).Times(3)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("existing1", nil).Times(3)
 	mockStorage := mocks.NewURLStorage(t)
 	userStorage := mocks.NewUserStorage(t)
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(5)
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
-	mockStorage.On("GetShortURLWithContext", mock.Anything, "http://existing1.ru").Return("existing1", nil).Twice()
-	mockStorage.On("StoreWithContext", mock.Anything, "http://existing1.ru", "existing1", "1").Return(urlstorage.ErrConflictURL).Twice()
-	mockStorage.On("StoreWithContext", mock.Anything, "https://existing1.ru", "existing1", "1").Return(nil).Once()
+	mockStorage.On("GetShortURLWithContext", mock.Anything, "http://existing1.ru", mock.Anything).Return("existing1", nil).Twice()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://existing1.ru", "existing1", "1", urlstorage.URLOptions{}).Return(urlstorage.ErrConflictURL).Twice()
+	mockStorage.On("StoreWithContext", mock.Anything, "https://existing1.ru", "existing1", "1", urlstorage.URLOptions{}).Return(nil).Once()
 	shortURLHost := "host/"
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, shortURLHost)
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -133,17 +145,17 @@ func TestShortenerHandler_generateSimple(t *testing.T) {
 
 func TestShortenerHandler_generateJSON(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
-	mockGenerator.On("Generate").Return("existing1", nil).Times(3)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("existing1", nil).Times(3)
 	mockStorage := mocks.NewURLStorage(t)
 	userStorage := mocks.NewUserStorage(t)
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(5)
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
-	mockStorage.On("GetShortURLWithContext", mock.Anything, "http://existing1.ru").Return("existing1", nil).Twice()
-	mockStorage.On("StoreWithContext", mock.Anything, "http://existing1.ru", "existing1", "1").Return(urlstorage.ErrConflictURL).Twice()
-	mockStorage.On("StoreWithContext", mock.Anything, "https://existing1.ru", "existing1", "1").Return(nil).Once()
+	mockStorage.On("GetShortURLWithContext", mock.Anything, "http://existing1.ru", mock.Anything).Return("existing1", nil).Twice()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://existing1.ru", "existing1", "1", urlstorage.URLOptions{}).Return(urlstorage.ErrConflictURL).Twice()
+	mockStorage.On("StoreWithContext", mock.Anything, "https://existing1.ru", "existing1", "1", urlstorage.URLOptions{}).Return(nil).Once()
 	shortURLHost := "host/"
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, shortURLHost)
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -169,7 +181,7 @@ func TestShortenerHandler_generateJSON(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			var input bytes.Buffer
-			json.NewEncoder(&input).Encode(handlers.InputURL{tc.URL})
+			json.NewEncoder(&input).Encode(handlers.InputURL{URL: tc.URL})
 			resp, resShortURL := testRequest(t, ts, tc.method, "/api/shorten", &input, nil)
 			defer resp.Body.Close()
 
@@ -189,19 +201,19 @@ func BenchmarkShortenerHandler_generateJSON(b *testing.B) {
 	userStorage := mocks.NewUserStorage(b)
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil)
-	mockStorage.On("StoreWithContext", mock.Anything, mock.Anything, mock.Anything, "1").Return(nil)
+	mockStorage.On("StoreWithContext", mock.Anything, mock.Anything, mock.Anything, "1", urlstorage.URLOptions{}).Return(nil)
 	shortURLHost := "host/"
 	mockUserStorage := mocks.NewUserURLStorage(b)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, shortURLHost)
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
 
 	for i := 0; i < 100; i++ {
 		longURL := strconv.Itoa(i) + ".com"
-		mockGenerator.On("Generate").Return(longURL, nil)
+		mockGenerator.On("Generate", mock.Anything, mock.Anything).Return(longURL, nil)
 		var input bytes.Buffer
-		json.NewEncoder(&input).Encode(handlers.InputURL{longURL})
+		json.NewEncoder(&input).Encode(handlers.InputURL{URL: longURL})
 		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten", &input)
 		resp, err := ts.Client().Do(req)
 		if err == nil {
@@ -216,11 +228,11 @@ func TestShortenerHandler_generateGzip(t *testing.T) {
 	userStorage := mocks.NewUserStorage(t)
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Once()
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
-	mockGenerator.On("Generate").Return("existing1", nil).Once()
-	mockStorage.On("StoreWithContext", mock.Anything, "http://existing1.ru", "existing1", "1").Return(nil).Once()
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("existing1", nil).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://existing1.ru", "existing1", "1", urlstorage.URLOptions{}).Return(nil).Once()
 	shortURLHost := "host/"
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, shortURLHost)
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -259,11 +271,11 @@ func TestShortenerHandler_generateGzip(t *testing.T) {
 func TestShortenerHandler_ServeHTTPBadRequest(t *testing.T) {
 	mockStorage := mocks.NewURLStorage(t)
 	mockGenerator := mocks.NewShortCutGenerator(t)
+	// unroutable requests must not create users
 	userStorage := mocks.NewUserStorage(t)
-	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(3)
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -293,7 +305,7 @@ func TestShortenerHandler_CheckUnauthorized(t *testing.T) {
 	userStorage := mocks.NewUserStorage(t)
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -310,7 +322,7 @@ func TestShortenerHandler_CheckNewUserCreated(t *testing.T) {
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Once()
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -330,11 +342,12 @@ func TestShortenerHandler_GetUserURLs(t *testing.T) {
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Twice()
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
-	emptyUrls := []urlstorage.URLPair{}
-	notEmptyURLs := []urlstorage.URLPair{{Short: "short", Long: "long"}}
-	mockUserStorage.On("GetUserURLs", mock.Anything, "1").Return(emptyUrls, nil).Once()
-	mockUserStorage.On("GetUserURLs", mock.Anything, "1").Return(notEmptyURLs, nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	defaultQuery := urlstorage.UserURLsQuery{}
+	emptyPage := urlstorage.UserURLsPage{}
+	notEmptyPage := urlstorage.UserURLsPage{URLs: []urlstorage.StoredURL{{URLPair: urlstorage.URLPair{Short: "short", Long: "long"}}}}
+	mockUserStorage.On("GetUserURLsPageWithContext", mock.Anything, "1", defaultQuery).Return(emptyPage, nil).Once()
+	mockUserStorage.On("GetUserURLsPageWithContext", mock.Anything, "1", defaultQuery).Return(notEmptyPage, nil).Once()
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -361,6 +374,55 @@ func TestShortenerHandler_GetUserURLs(t *testing.T) {
 	}
 }
 
+func TestShortenerHandler_GetUserURLsPage(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	query := urlstorage.UserURLsQuery{
+		Limit:          1,
+		LongURLPart:    "ya.ru",
+		CreatedAfter:   createdAt.Add(-time.Hour),
+		IncludeDeleted: true,
+		Descending:     true,
+	}
+	mockUserStorage.On("GetUserURLsPageWithContext", mock.Anything, "1", query).Return(urlstorage.UserURLsPage{
+		URLs: []urlstorage.StoredURL{{
+			URLPair: urlstorage.URLPair{Short: "short", Long: "http://ya.ru", URLOptions: urlstorage.URLOptions{CreatedAt: createdAt}},
+			Deleted: true}},
+		NextCursor: "next",
+	}, nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+	cookie := map[string]string{"Cookie": "Authorization=" + token}
+
+	resp, body := testRequest(t, ts, http.MethodGet,
+		"/api/user/urls?limit=1&contains=ya.ru&created_after=2024-03-01T11:00:00Z&include_deleted=true&sort=-created_at",
+		nil, cookie)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `[{"short_url":"host/short","original_url":"http://ya.ru","created_at":"2024-03-01T12:00:00Z","deleted":true}]`, body)
+	assert.Equal(t, `<host/api/user/urls?contains=ya.ru&created_after=2024-03-01T11%3A00%3A00Z&cursor=next&`+
+		`include_deleted=true&limit=1&sort=-created_at>; rel="next"`, resp.Header.Get("Link"))
+
+	for _, path := range []string{
+		"/api/user/urls?limit=0",
+		"/api/user/urls?created_before=yesterday",
+		"/api/user/urls?include_deleted=maybe",
+		"/api/user/urls?sort=short_url",
+	} {
+		resp, _ := testRequest(t, ts, http.MethodGet, path, nil, cookie)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, path)
+	}
+}
+
 func TestShortenerHandler_Ping(t *testing.T) {
 	mockStorage := mocks.NewURLStorage(t)
 	mockGenerator := mocks.NewShortCutGenerator(t)
@@ -368,7 +430,7 @@ func TestShortenerHandler_Ping(t *testing.T) {
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(2)
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
 	defer ts.Close()
@@ -406,12 +468,12 @@ func TestShortenerHandler_Ping(t *testing.T) {
 func TestShortenerHandler_GenerateBatch(t *testing.T) {
 	mockStorage := mocks.NewURLStorage(t)
 	mockGenerator := mocks.NewShortCutGenerator(t)
-	mockGenerator.On("Generate").Return("short", nil).Times(2)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("short", nil).Times(2)
 	userStorage := mocks.NewUserStorage(t)
 	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Once()
 	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	mockStorage.On("StoreManyWithContext", mock.Anything, mock.Anything, mock.Anything).Return([]error{nil, nil}, nil).Once()
 	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
 	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
@@ -428,3 +490,619 @@ func TestShortenerHandler_GenerateBatch(t *testing.T) {
 	json.NewDecoder(resp.Body).Decode(&res)
 	require.Equal(t, []handlers.ResultBatch{{URL: "host/short", ID: "1"}, {URL: "host/short", ID: "2"}}, res)
 }
+
+func TestShortenerHandler_RateLimit(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("short", nil).Times(4)
+	userStorage := mocks.NewUserStorage(t)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Once()
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	mockStorage.On("StoreManyWithContext", mock.Anything, mock.Anything, mock.Anything).Return([]error{nil, nil}, nil).Twice()
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	store := ratelimit.NewMemoryStore()
+	handler.RateLimits = handlers.RateLimits{
+		Create:    ratelimit.NewLimiter("create", ratelimit.PerMinute(10), store, handler.RateLimitKey),
+		BatchURLs: ratelimit.NewLimiter("batch", ratelimit.PerMinute(3), store, handler.RateLimitKey).WithCost(handlers.BatchSize),
+	}
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+	token, _ := auth.BuildJWTString(1)
+
+	batch := `[{"original_url":"long1","correlation_id":"1"},{"original_url":"long2","correlation_id":"2"}]`
+	tests := []struct {
+		name              string
+		headers           map[string]string
+		expectedCode      int
+		expectedRemaining string
+		expectedRetry     string
+	}{
+		{name: "new user", headers: map[string]string{"X-Real-IP": "1.2.3.4"},
+			expectedCode: http.StatusCreated, expectedRemaining: "1"},
+		{name: "same ip without cookie is limited before user creation", headers: map[string]string{"X-Real-IP": "1.2.3.4"},
+			expectedCode: http.StatusTooManyRequests, expectedRemaining: "1", expectedRetry: "20"},
+		{name: "spoofed ip header of untrusted client is ignored", headers: map[string]string{"X-Real-IP": "5.6.7.8", "X-Forwarded-For": "5.6.7.8"},
+			expectedCode: http.StatusTooManyRequests, expectedRemaining: "1", expectedRetry: "20"},
+		{name: "user is limited separately from ip", headers: map[string]string{"X-Real-IP": "1.2.3.4", "Cookie": "Authorization=" + token},
+			expectedCode: http.StatusCreated, expectedRemaining: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := testRequest(t, ts, http.MethodPost, "/api/shorten/batch", strings.NewReader(batch), tt.headers)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode)
+			assert.Equal(t, "3", resp.Header.Get("X-RateLimit-Limit"))
+			assert.Equal(t, tt.expectedRemaining, resp.Header.Get("X-RateLimit-Remaining"))
+			assert.Equal(t, tt.expectedRetry, resp.Header.Get("Retry-After"))
+		})
+	}
+}
+
+func TestShortenerHandler_RateLimitKey(t *testing.T) {
+	handler := handlers.NewShortenerHandler(nil, *auth.NewAuthenticator("SECRET_KEY", mocks.NewUserStorage(t)), "host/")
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	handler.TrustedProxies = []*net.IPNet{proxies}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{name: "remote address", remoteAddr: "1.2.3.4:5000", want: "ip:1.2.3.4"},
+		{name: "spoofed header", remoteAddr: "1.2.3.4:5000",
+			headers: map[string]string{"X-Real-IP": "5.6.7.8", "X-Forwarded-For": "5.6.7.8"}, want: "ip:1.2.3.4"},
+		{name: "real ip of trusted proxy", remoteAddr: "10.0.0.1:5000",
+			headers: map[string]string{"X-Real-IP": "5.6.7.8"}, want: "ip:5.6.7.8"},
+		{name: "forwarded by trusted proxies", remoteAddr: "10.0.0.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 10.0.0.2"}, want: "ip:5.6.7.8"},
+		{name: "trusted proxy without headers", remoteAddr: "10.0.0.1:5000", want: "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, handler.RateLimitKey(r))
+		})
+	}
+}
+
+func TestShortenerHandler_PolicyViolation(t *testing.T) {
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	token, _ := auth.BuildJWTString(1)
+	shortenerService := service.NewShortenerService(mocks.NewURLStorage(t), mocks.NewUserURLStorage(t), nil, mocks.NewShortCutGenerator(t),
+		service.WithURLPolicy(service.NewURLPolicy(service.DefaultURLSchemes, "host")))
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "http://host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		expected handlers.PolicyViolation
+	}{
+		{name: "plain", path: "/", body: "javascript:alert(1)",
+			expected: handlers.PolicyViolation{Rule: service.RuleScheme, URL: "javascript:alert(1)", Reason: `scheme "javascript" is not allowed`}},
+		{name: "json", path: "/api/shorten", body: `{"url":"http://host/abc"}`,
+			expected: handlers.PolicyViolation{Rule: service.RuleSelfReference, URL: "http://host/abc", Reason: "url points to shortener itself"}},
+		{name: "batch", path: "/api/shorten/batch", body: `[{"original_url":"https://ok.com","correlation_id":"1"},{"original_url":"http://10.0.0.1","correlation_id":"2"}]`,
+			expected: handlers.PolicyViolation{Rule: service.RulePrivateIP, URL: "http://10.0.0.1", Reason: "ip 10.0.0.1 is not public", ID: "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodPost, tt.path, strings.NewReader(tt.body),
+				map[string]string{"Cookie": "Authorization=" + token})
+			assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+			var violation handlers.PolicyViolation
+			require.NoError(t, json.Unmarshal([]byte(body), &violation))
+			tt.expected.Error = service.ErrURLPolicy.Error()
+			assert.Equal(t, tt.expected, violation)
+		})
+	}
+}
+
+func TestShortenerHandler_generateJSONWithTTL(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("expiring", nil).Once()
+	mockStorage := mocks.NewURLStorage(t)
+	userStorage := mocks.NewUserStorage(t)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(2)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockStorage.On("StoreWithContext", mock.Anything, "http://expiring.ru", "expiring", "1",
+		mock.MatchedBy(func(options urlstorage.URLOptions) bool {
+			return time.Until(options.ExpiresAt).Round(time.Minute) == time.Hour
+		})).Return(nil).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	var input bytes.Buffer
+	json.NewEncoder(&input).Encode(handlers.InputURL{URL: "http://expiring.ru", Expiration: service.Expiration{TTL: "1h"}})
+	resp, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", &input, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	input.Reset()
+	json.NewEncoder(&input).Encode(handlers.InputURL{URL: "http://expiring.ru", Expiration: service.Expiration{TTL: "forever"}})
+	resp, _ = testRequest(t, ts, http.MethodPost, "/api/shorten", &input, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestShortenerHandler_generateWithAlias(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	userStorage := mocks.NewUserStorage(t)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Times(4)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockStorage.On("StoreWithContext", mock.Anything, "http://free.ru", "free", "1", urlstorage.URLOptions{}).Return(nil).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://taken.ru", "taken", "1", urlstorage.URLOptions{}).Return(urlstorage.ErrShortURLTaken).Once()
+	mockStorage.On("StoreManyWithContext", mock.Anything, []urlstorage.URLPair{
+		{Long: "http://free.ru", Short: "free"},
+		{Long: "http://taken.ru", Short: "taken"}}, "1").
+		Return([]error{nil, urlstorage.ErrShortURLTaken}, nil).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	testCases := []struct {
+		name         string
+		URL          string
+		alias        string
+		expectedCode int
+	}{
+		{name: "free", URL: "free.ru", alias: "free", expectedCode: http.StatusCreated},
+		{name: "taken", URL: "taken.ru", alias: "taken", expectedCode: http.StatusConflict},
+		{name: "reserved", URL: "reserved.ru", alias: "ping", expectedCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var input bytes.Buffer
+			json.NewEncoder(&input).Encode(handlers.InputURL{URL: tc.URL, Alias: tc.alias})
+			resp, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", &input, nil)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedCode, resp.StatusCode)
+		})
+	}
+
+	var input bytes.Buffer
+	json.NewEncoder(&input).Encode([]handlers.InputBatch{
+		{URL: "free.ru", ID: "1", Alias: "free"},
+		{URL: "taken.ru", ID: "2", Alias: "taken"}})
+	resp, body := testRequest(t, ts, http.MethodPost, "/api/shorten/batch", &input, nil)
+	defer resp.Body.Close()
+	var res []handlers.ResultBatch
+	json.NewDecoder(strings.NewReader(body)).Decode(&res)
+	require.Equal(t, []handlers.ResultBatch{
+		{URL: "host/free", ID: "1"},
+		{ID: "2", Error: service.ErrAliasTaken.Error()}}, res)
+}
+
+func TestShortenerHandler_GetURLStats(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "1", "short").Return(true, nil).Once()
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "1", "other").Return(false, nil).Once()
+	mockClickStorage := mocks.NewClickStorage(t)
+	stats := clickstorage.ClickStats{Total: 2, Daily: []clickstorage.DailyClicks{{Day: "2024-03-01", Count: 2}}}
+	mockClickStorage.On("GetStatsWithContext", mock.Anything, "short").Return(stats, nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockClickStorage, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		shortURL     string
+		cookie       string
+		expectedCode int
+		want         clickstorage.ClickStats
+	}{
+		{name: "owned", shortURL: "short", cookie: "Authorization=" + token, expectedCode: http.StatusOK, want: stats},
+		{name: "not_owned", shortURL: "other", cookie: "Authorization=" + token, expectedCode: http.StatusNotFound},
+		{name: "unauthorized", shortURL: "short", expectedCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, "/api/user/urls/"+tt.shortURL+"/stats", nil,
+				map[string]string{"Cookie": tt.cookie})
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode == http.StatusOK {
+				var got clickstorage.ClickStats
+				require.NoError(t, json.Unmarshal([]byte(body), &got))
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestShortenerHandler_DeletionJob(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+	otherToken, err := auth.BuildJWTString(2)
+	require.NoError(t, err)
+
+	resp, body := testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["a","b"]`),
+		map[string]string{"Cookie": "Authorization=" + token})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "/api/user/deletions/1", resp.Header.Get("Location"))
+	assert.JSONEq(t, `{"id":1}`, body)
+
+	tests := []struct {
+		name         string
+		path         string
+		cookie       string
+		expectedCode int
+	}{
+		{name: "own_job", path: "/api/user/deletions/1", cookie: "Authorization=" + token, expectedCode: http.StatusOK},
+		{name: "other_user_job", path: "/api/user/deletions/1", cookie: "Authorization=" + otherToken, expectedCode: http.StatusNotFound},
+		{name: "unknown_job", path: "/api/user/deletions/2", cookie: "Authorization=" + token, expectedCode: http.StatusNotFound},
+		{name: "bad_id", path: "/api/user/deletions/abc", cookie: "Authorization=" + token, expectedCode: http.StatusBadRequest},
+		{name: "unauthorized", path: "/api/user/deletions/1", expectedCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tt.path, nil, map[string]string{"Cookie": tt.cookie})
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode == http.StatusOK {
+				var got handlers.DeletionJob
+				require.NoError(t, json.Unmarshal([]byte(body), &got))
+				assert.Equal(t, int64(1), got.ID)
+				assert.Equal(t, []string{"a", "b"}, got.ShortURLs)
+				assert.Equal(t, deletionstorage.StatusPending, got.Status)
+				assert.NotContains(t, body, "user_id")
+			}
+		})
+	}
+}
+
+func TestShortenerHandler_UpdateUserURL(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockStorage.On("UpdateWithContext", mock.Anything, mock.MatchedBy(func(change urlstorage.URLChange) bool {
+		return change.ShortURL == "short" && change.ChangedBy == "1"
+	})).Return(urlstorage.URLChange{ShortURL: "short", PreviousURL: "http://old.ru", LongURL: "http://new.ru"}, nil).Once()
+	mockStorage.On("UpdateWithContext", mock.Anything, mock.MatchedBy(func(change urlstorage.URLChange) bool {
+		return change.ShortURL == "other"
+	})).Return(urlstorage.URLChange{}, urlstorage.ErrNotOwnedURL).Once()
+	mockStorage.On("UpdateWithContext", mock.Anything, mock.MatchedBy(func(change urlstorage.URLChange) bool {
+		return change.ShortURL == "conflict"
+	})).Return(urlstorage.URLChange{}, urlstorage.ErrConflictURL).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		shortURL     string
+		body         string
+		cookie       string
+		expectedCode int
+		want         string
+	}{
+		{name: "updated", shortURL: "short", body: `{"url":"new.ru"}`, cookie: "Authorization=" + token,
+			expectedCode: http.StatusOK, want: `{"short_url":"host/short","original_url":"http://new.ru"}`},
+		{name: "not_owned", shortURL: "other", body: `{"url":"new.ru"}`, cookie: "Authorization=" + token,
+			expectedCode: http.StatusNotFound},
+		{name: "conflict", shortURL: "conflict", body: `{"url":"new.ru"}`, cookie: "Authorization=" + token,
+			expectedCode: http.StatusConflict},
+		{name: "bad_body", shortURL: "short", body: `{`, cookie: "Authorization=" + token,
+			expectedCode: http.StatusBadRequest},
+		{name: "unauthorized", shortURL: "short", body: `{"url":"new.ru"}`, expectedCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodPatch, "/api/user/urls/"+tt.shortURL, strings.NewReader(tt.body),
+				map[string]string{"Cookie": tt.cookie})
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.want != "" {
+				assert.JSONEq(t, tt.want, body)
+			}
+		})
+	}
+}
+
+func TestShortenerHandler_GetURLHistory(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "1", "short").Return(true, nil)
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "1", "other").Return(false, nil)
+	changedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mockStorage.On("GetHistoryWithContext", mock.Anything, "short").Return([]urlstorage.URLChange{
+		{ShortURL: "short", PreviousURL: "http://old.ru", LongURL: "http://new.ru", ChangedBy: "1", ChangedAt: changedAt}}, nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+	token, err := auth.BuildJWTString(1)
+	require.NoError(t, err)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/user/urls/short/history", nil,
+		map[string]string{"Cookie": "Authorization=" + token})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `[{"previous_url":"http://old.ru","original_url":"http://new.ru","changed_by":"1",`+
+		`"changed_at":"2024-03-01T12:00:00Z"}]`, body)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/user/urls/other/history", nil,
+		map[string]string{"Cookie": "Authorization=" + token})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestShortenerHandler_GetStats(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("CountURLsWithContext", mock.Anything).Return(int64(10), nil).Once()
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	userStorage := mocks.NewUserStorage(t)
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("CountUsersWithContext", mock.Anything).Return(int64(3), nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	_, handler.TrustedSubnet, _ = net.ParseCIDR("192.168.1.0/24")
+	directServer := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer directServer.Close()
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	handler.TrustedProxies = []*net.IPNet{loopback}
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, directServer, http.MethodGet, "/api/internal/stats", nil,
+		map[string]string{"X-Real-IP": "192.168.1.10"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "ip header of client not behind trusted proxy is ignored")
+
+	tests := []struct {
+		name         string
+		realIP       string
+		expectedCode int
+	}{
+		{name: "trusted", realIP: "192.168.1.10", expectedCode: http.StatusOK},
+		{name: "untrusted", realIP: "10.0.0.1", expectedCode: http.StatusForbidden},
+		{name: "no_ip", realIP: "", expectedCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, "/api/internal/stats", nil,
+				map[string]string{"X-Real-IP": tt.realIP})
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode == http.StatusOK {
+				assert.JSONEq(t, `{"urls": 10, "users": 3}`, body)
+			}
+		})
+	}
+}
+
+func TestShortenerHandler_Metrics(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	userStorage := mocks.NewUserStorage(t)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Once()
+	auth := auth.NewAuthenticator("SECRET_KEY", userStorage)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("Ping").Return(nil).Once()
+	mockStorage.On("Ping").Return(nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth, "host/")
+	_, handler.TrustedSubnet, _ = net.ParseCIDR("192.168.1.0/24")
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	handler.TrustedProxies = []*net.IPNet{loopback}
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodGet, "/ping", nil, nil)
+	defer resp.Body.Close()
+	resp, _ = testRequest(t, ts, http.MethodGet, "/metrics", nil, map[string]string{"X-Real-IP": "10.0.0.1"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "metrics are hidden from untrusted subnet")
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics", nil, map[string]string{"X-Real-IP": "192.168.1.10"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/ping",status="200"}`)
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestShortenerHandler_QRCode(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("GetLongURLWithContext", mock.Anything, "existing").Return("http://existing.ru", nil)
+	mockStorage.On("GetLongURLWithContext", mock.Anything, "deleted").Return("", urlstorage.ErrDeletedURL).Once()
+	mockStorage.On("GetLongURLWithContext", mock.Anything, "non-existing").Return("", errors.New("some error")).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mocks.NewUserURLStorage(t), nil, mocks.NewShortCutGenerator(t))
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth.NewAuthenticator("SECRET_KEY", mocks.NewUserStorage(t)), "host/")
+	handler.QRCodes = qrcode.NewCache(8)
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	testCases := []struct {
+		name                string
+		path                string
+		expectedCode        int
+		expectedContentType string
+	}{
+		{name: "png", path: "/existing/qr", expectedCode: http.StatusOK, expectedContentType: "image/png"},
+		{name: "svg", path: "/existing/qr?format=svg&size=128&level=h&margin=0",
+			expectedCode: http.StatusOK, expectedContentType: "image/svg+xml"},
+		{name: "wrong_size", path: "/existing/qr?size=big", expectedCode: http.StatusBadRequest},
+		{name: "wrong_level", path: "/existing/qr?level=X", expectedCode: http.StatusBadRequest},
+		{name: "wrong_format", path: "/existing/qr?format=gif", expectedCode: http.StatusBadRequest},
+		{name: "deleted", path: "/deleted/qr", expectedCode: http.StatusGone},
+		{name: "non-existing", path: "/non-existing/qr", expectedCode: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tc.path, nil, nil)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedCode, resp.StatusCode, body)
+			if tc.expectedContentType != "" {
+				assert.Equal(t, tc.expectedContentType, resp.Header.Get("Content-Type"))
+				assert.NotEmpty(t, body)
+			}
+		})
+	}
+	resp, plain := testRequest(t, ts, http.MethodGet, "/existing/qr", nil, nil)
+	defer resp.Body.Close()
+	resp, spoofed := testRequest(t, ts, http.MethodGet, "/existing/qr", nil,
+		map[string]string{"X-Forwarded-Proto": "ftp", "X-Forwarded-Host": "evil.ru"})
+	defer resp.Body.Close()
+	assert.Equal(t, plain, spoofed, "qr code points to configured host")
+	assert.Equal(t, 2, handler.QRCodes.Len())
+}
+
+func TestShortenerHandler_Preview(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	ctx := context.Background()
+	require.NoError(t, storage.StoreWithContext(ctx, "http://plain.ru", "plain", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(ctx, "http://warned.ru", "warned", "user_1", urlstorage.URLOptions{Interstitial: true}))
+	require.NoError(t, storage.StoreWithContext(ctx, "http://deleted.ru", "deleted", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"deleted"}}))
+	userStorage := mocks.NewUserStorage(t)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Maybe()
+	shortenerService := service.NewShortenerService(storage, storage, nil, mocks.NewShortCutGenerator(t))
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth.NewAuthenticator("SECRET_KEY", userStorage), "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	testCases := []struct {
+		name             string
+		path             string
+		expectedCode     int
+		expectedLocation string
+		expectedBody     []string
+	}{
+		{name: "plus", path: "/plain+", expectedCode: http.StatusOK,
+			expectedBody: []string{`<a href="http://plain.ru"`, "<dt>Clicks</dt><dd>0</dd>", "<dd>active</dd>"}},
+		{name: "preview", path: "/warned/preview", expectedCode: http.StatusOK,
+			expectedBody: []string{"http://warned.ru", "<dd>active</dd>"}},
+		{name: "deleted", path: "/deleted+", expectedCode: http.StatusOK,
+			expectedBody: []string{"<dd>http://deleted.ru</dd>", "<dd>deleted</dd>"}},
+		{name: "unknown", path: "/unknown+", expectedCode: http.StatusNotFound},
+		{name: "redirect", path: "/plain", expectedCode: http.StatusTemporaryRedirect, expectedLocation: "http://plain.ru"},
+		{name: "interstitial", path: "/warned", expectedCode: http.StatusOK,
+			expectedBody: []string{`<a href="http://warned.ru" rel="nofollow noopener">Continue</a>`}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := testRequest(t, ts, http.MethodGet, tc.path, nil, nil)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedCode, resp.StatusCode, body)
+			assert.Equal(t, tc.expectedLocation, resp.Header.Get("Location"))
+			for _, expected := range tc.expectedBody {
+				assert.Contains(t, body, expected)
+			}
+		})
+	}
+}
+
+func TestShortenerHandler_RedirectCode(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	ctx := context.Background()
+	require.NoError(t, storage.StoreWithContext(ctx, "http://default.ru", "default", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(ctx, "http://permanent.ru", "permanent", "user_1",
+		urlstorage.URLOptions{RedirectCode: http.StatusPermanentRedirect}))
+	require.NoError(t, storage.StoreWithContext(ctx, "http://expiring.ru", "expiring", "user_1",
+		urlstorage.URLOptions{RedirectCode: http.StatusMovedPermanently, ExpiresAt: time.Now().Add(time.Minute)}))
+	userStorage := mocks.NewUserStorage(t)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil).Maybe()
+	clicks := clickstorage.NewSimpleClickStorage()
+	shortenerService := service.NewShortenerService(storage, storage, clicks, mocks.NewShortCutGenerator(t))
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth.NewAuthenticator("SECRET_KEY", userStorage), "host/")
+	handler.RedirectCode = http.StatusFound
+	handler.RedirectMaxAge = time.Hour
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	testCases := []struct {
+		name                 string
+		method               string
+		path                 string
+		expectedCode         int
+		expectedCacheControl string
+	}{
+		{name: "default", method: http.MethodGet, path: "/default",
+			expectedCode: http.StatusFound, expectedCacheControl: "private, no-cache"},
+		{name: "permanent", method: http.MethodGet, path: "/permanent",
+			expectedCode: http.StatusPermanentRedirect, expectedCacheControl: "public, max-age=3600"},
+		{name: "expiring", method: http.MethodGet, path: "/expiring",
+			expectedCode: http.StatusMovedPermanently, expectedCacheControl: "public, max-age=59"},
+		{name: "head", method: http.MethodHead, path: "/permanent",
+			expectedCode: http.StatusPermanentRedirect, expectedCacheControl: "public, max-age=3600"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _ := testRequest(t, ts, tc.method, tc.path, nil, nil)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedCode, resp.StatusCode)
+			assert.Equal(t, tc.expectedCacheControl, resp.Header.Get("Cache-Control"))
+			assert.NotEmpty(t, resp.Header.Get("Location"))
+		})
+	}
+
+	shortenerService.Stop()
+	<-shortenerService.Stopped
+	stats, err := clicks.GetStatsWithContext(ctx, "permanent")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Total, "head request is not click")
+}
+
+func TestShortenerHandler_generateWithRedirectCode(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("short", nil).Once()
+	userStorage := mocks.NewUserStorage(t)
+	userStorage.On("GenerateUUID", mock.Anything).Return(int64(1), nil)
+	shortenerService := service.NewShortenerService(storage, storage, nil, mockGenerator)
+	handler := handlers.NewShortenerHandler(*shortenerService, *auth.NewAuthenticator("SECRET_KEY", userStorage), "host/")
+	ts := httptest.NewServer(handlers.ShortenerRouter(*handler, false))
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "http://a.ru", "redirect_code": 200}`), nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+
+	resp, body = testRequest(t, ts, http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "http://a.ru", "redirect_code": 301}`), nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+	url, err := storage.GetURLWithContext(context.Background(), "short")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMovedPermanently, url.RedirectCode)
+}
@@ -0,0 +1,108 @@
+// Package metrics for exporting service metrics in prometheus format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Number of processed http requests.
+var RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "shortener_http_requests_total",
+	Help: "Number of processed http requests.",
+}, []string{"method", "route", "status"})
+
+// Duration of processing http requests.
+var RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "shortener_http_request_duration_seconds",
+	Help:    "Duration of processing http requests.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "route", "status"})
+
+// Duration of url storage operations.
+var StorageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "shortener_storage_operation_duration_seconds",
+	Help:    "Duration of url storage operations.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation", "error"})
+
+// Number of requests rejected by rate limits.
+var RateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "shortener_rate_limited_requests_total",
+	Help: "Number of requests rejected by rate limits.",
+}, []string{"limit"})
+
+// Registers gauge of deletion queue length reported by given function.
+func RegisterDeletionQueue(length func() int64) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "shortener_deletion_queue_length",
+		Help: "Number of deletion requests waiting to be flushed.",
+	}, func() float64 { return float64(length()) }))
+}
+
+// Registers hit and miss counters and size gauge of url cache reported by given function.
+func RegisterURLCache(stats func() urlstorage.CacheStats) {
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "shortener_url_cache_hits_total",
+		Help: "Number of url lookups answered from cache.",
+	}, func() float64 { return float64(stats().Hits) }))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "shortener_url_cache_misses_total",
+		Help: "Number of url lookups that queried storage.",
+	}, func() float64 { return float64(stats().Misses) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "shortener_url_cache_size",
+		Help: "Number of urls kept in cache.",
+	}, func() float64 { return float64(stats().Size) }))
+}
+
+// Handler exposing all registered metrics including go runtime stats.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// Overrides writer function and saves response status code.
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	w.ResponseWriter.WriteHeader(statusCode)
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+// Middleware counting requests and their durations by chi route pattern and status.
+func Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := statusResponseWriter{ResponseWriter: w}
+
+		h.ServeHTTP(&sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		route := "unknown"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(sw.status)}
+		RequestsTotal.With(labels).Inc()
+		RequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Observes duration of storage operation started at given moment.
+func observeStorage(operation string, start time.Time, err error) {
+	StorageDuration.WithLabelValues(operation, strconv.FormatBool(err != nil)).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,48 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/metrics"
+	"github.com/valinurovdenis/urlshortener/internal/app/mocks"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(metrics.Middleware)
+	r.Get("/{url}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, path := range []string{"/a", "/b", "/ping"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("GET", "/{url}", "307")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("GET", "/ping", "200")))
+}
+
+func TestURLStorageWrapper(t *testing.T) {
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("GetLongURLWithContext", mock.Anything, "a").Return("url_a", nil).Once()
+	mockStorage.On("GetLongURLWithContext", mock.Anything, "b").Return("", urlstorage.ErrDeletedURL).Once()
+	storage := metrics.NewURLStorageWrapper(mockStorage)
+
+	longURL, err := storage.GetLongURLWithContext(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "url_a", longURL)
+	_, err = storage.GetLongURLWithContext(context.Background(), "b")
+	require.ErrorIs(t, err, urlstorage.ErrDeletedURL)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.StorageDuration, "shortener_storage_operation_duration_seconds"))
+}
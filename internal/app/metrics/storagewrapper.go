@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Wrapper over url storage observing duration of every operation.
+type URLStorageWrapper struct {
+	URLStorage urlstorage.URLStorage
+}
+
+// New url storage wrapper reporting operation latencies.
+func NewURLStorageWrapper(storage urlstorage.URLStorage) *URLStorageWrapper {
+	return &URLStorageWrapper{URLStorage: storage}
+}
+
+// Returns longURL from shortURL.
+func (s *URLStorageWrapper) GetLongURLWithContext(ctx context.Context, shortURL string) (string, error) {
+	start := time.Now()
+	longURL, err := s.URLStorage.GetLongURLWithContext(ctx, shortURL)
+	observeStorage("GetLongURLWithContext", start, err)
+	return longURL, err
+}
+
+// Returns saved url with its options.
+func (s *URLStorageWrapper) GetURLWithContext(ctx context.Context, shortURL string) (urlstorage.StoredURL, error) {
+	start := time.Now()
+	url, err := s.URLStorage.GetURLWithContext(ctx, shortURL)
+	observeStorage("GetURLWithContext", start, err)
+	return url, err
+}
+
+// Returns shortURL from longURL.
+func (s *URLStorageWrapper) GetShortURLWithContext(ctx context.Context, normalizedURL string, userID string) (string, error) {
+	start := time.Now()
+	shortURL, err := s.URLStorage.GetShortURLWithContext(ctx, normalizedURL, userID)
+	observeStorage("GetShortURLWithContext", start, err)
+	return shortURL, err
+}
+
+// Adds mapping longURL -> shortURL.
+func (s *URLStorageWrapper) StoreWithContext(ctx context.Context, longURL string, shortURL string, userID string, options urlstorage.URLOptions) error {
+	start := time.Now()
+	err := s.URLStorage.StoreWithContext(ctx, longURL, shortURL, userID, options)
+	observeStorage("StoreWithContext", start, err)
+	return err
+}
+
+// Adds number of mappings longURL -> shortURL.
+func (s *URLStorageWrapper) StoreManyWithContext(ctx context.Context, long2ShortUrls []urlstorage.URLPair, userID string) ([]error, error) {
+	start := time.Now()
+	errs, err := s.URLStorage.StoreManyWithContext(ctx, long2ShortUrls, userID)
+	observeStorage("StoreManyWithContext", start, err)
+	return errs, err
+}
+
+// Points short url of user to new long url and saves change to history.
+func (s *URLStorageWrapper) UpdateWithContext(ctx context.Context, change urlstorage.URLChange) (urlstorage.URLChange, error) {
+	start := time.Now()
+	change, err := s.URLStorage.UpdateWithContext(ctx, change)
+	observeStorage("UpdateWithContext", start, err)
+	return change, err
+}
+
+// Returns changes of short url in order they were made.
+func (s *URLStorageWrapper) GetHistoryWithContext(ctx context.Context, shortURL string) ([]urlstorage.URLChange, error) {
+	start := time.Now()
+	history, err := s.URLStorage.GetHistoryWithContext(ctx, shortURL)
+	observeStorage("GetHistoryWithContext", start, err)
+	return history, err
+}
+
+// Removes urls expired before given moment.
+func (s *URLStorageWrapper) PurgeExpiredWithContext(ctx context.Context, expiredBefore time.Time) ([]string, error) {
+	start := time.Now()
+	purged, err := s.URLStorage.PurgeExpiredWithContext(ctx, expiredBefore)
+	observeStorage("PurgeExpiredWithContext", start, err)
+	return purged, err
+}
+
+// Returns number of stored not deleted urls.
+func (s *URLStorageWrapper) CountURLsWithContext(ctx context.Context) (int64, error) {
+	start := time.Now()
+	count, err := s.URLStorage.CountURLsWithContext(ctx)
+	observeStorage("CountURLsWithContext", start, err)
+	return count, err
+}
+
+// Clear all mappings.
+func (s *URLStorageWrapper) Clear() error {
+	start := time.Now()
+	err := s.URLStorage.Clear()
+	observeStorage("Clear", start, err)
+	return err
+}
+
+// Check whether storage alive.
+func (s *URLStorageWrapper) Ping() error {
+	start := time.Now()
+	err := s.URLStorage.Ping()
+	observeStorage("Ping", start, err)
+	return err
+}
@@ -0,0 +1,129 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_second.up.sql":   {Data: []byte("CREATE TABLE b()")},
+		"sql/0001_first.up.sql":    {Data: []byte("CREATE TABLE a()")},
+		"sql/0001_first.down.sql":  {Data: []byte("DROP TABLE a")},
+		"sql/0010_tenth.up.sql":    {Data: []byte("CREATE TABLE c()")},
+		"sql/0010_tenth.down.sql":  {Data: []byte("DROP TABLE c")},
+		"sql/0002_second.down.sql": {Data: []byte("DROP TABLE b")},
+	}
+	migrations, err := Load(fsys, "sql")
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b()", Down: "DROP TABLE b"},
+		{Version: 10, Name: "tenth", Up: "CREATE TABLE c()", Down: "DROP TABLE c"},
+	}, migrations)
+
+	_, err = Load(fstest.MapFS{"sql/first.up.sql": {}}, "sql")
+	require.Error(t, err)
+	_, err = Load(fstest.MapFS{"sql/0001_first.down.sql": {Data: []byte("DROP TABLE a")}}, "sql")
+	require.Error(t, err)
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrator, err := NewMigrator(nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrator.Migrations)
+	for i, migration := range migrator.Migrations {
+		assert.Equal(t, int64(i+1), migration.Version, "versions must be sequential")
+		assert.NotEmpty(t, migration.Down, "migration %d must be reversible", migration.Version)
+	}
+}
+
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(advisoryLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestMigrator_Up(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator := &Migrator{DB: db, Migrations: []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b", Down: "DROP TABLE b"},
+		{Version: 3, Name: "third", Up: "CREATE TABLE c", Down: "DROP TABLE c"},
+	}}
+
+	expectLock(mock)
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "second").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE c").WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(advisoryLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	count, err := migrator.Up(context.Background())
+	require.ErrorContains(t, err, "failed to apply migration 3_third")
+	assert.Equal(t, 1, count)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator := &Migrator{DB: db, Migrations: []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b", Down: "DROP TABLE b"},
+	}}
+
+	expectLock(mock)
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(2).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(advisoryLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	count, err := migrator.Down(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Status(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator := &Migrator{DB: db, Migrations: []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b", Down: "DROP TABLE b"},
+	}}
+	appliedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expectLock(mock)
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, appliedAt))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(advisoryLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	statuses, err := migrator.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []MigrationStatus{
+		{Version: 1, Name: "first", Applied: true, AppliedAt: appliedAt},
+		{Version: 2, Name: "second", Applied: false},
+	}, statuses)
+}
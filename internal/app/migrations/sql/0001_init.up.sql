@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS shortener("user_id" TEXT, "short_url" TEXT, "long_url" TEXT, "deleted" BOOLEAN DEFAULT false);
+CREATE INDEX IF NOT EXISTS user_id_index ON shortener USING btree(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS long_url_index ON shortener USING btree(long_url);
+CREATE TABLE IF NOT EXISTS user_id("id" SERIAL);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS expires_at_index;
+ALTER TABLE shortener DROP COLUMN IF EXISTS "expires_at";
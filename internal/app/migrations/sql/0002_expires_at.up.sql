@@ -0,0 +1,2 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS "expires_at" TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS expires_at_index ON shortener USING btree(expires_at) WHERE expires_at IS NOT NULL;
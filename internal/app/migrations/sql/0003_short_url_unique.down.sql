@@ -0,0 +1 @@
+DROP INDEX IF EXISTS short_url_index;
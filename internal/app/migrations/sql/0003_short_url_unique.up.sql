@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS short_url_index ON shortener USING btree(short_url);
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS clicks("id" BIGSERIAL PRIMARY KEY, "short_url" TEXT NOT NULL, "clicked_at" TIMESTAMPTZ NOT NULL, "referrer" TEXT, "user_agent" TEXT, "ip" TEXT);
+CREATE INDEX IF NOT EXISTS clicks_short_url_index ON clicks USING btree(short_url, clicked_at);
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS deletion_jobs("id" BIGSERIAL PRIMARY KEY, "user_id" TEXT NOT NULL, "short_urls" JSONB NOT NULL, "status" TEXT NOT NULL, "attempts" INTEGER NOT NULL DEFAULT 0, "next_attempt_at" TIMESTAMPTZ NOT NULL, "last_error" TEXT NOT NULL DEFAULT '', "created_at" TIMESTAMPTZ NOT NULL);
+CREATE INDEX IF NOT EXISTS deletion_jobs_due_index ON deletion_jobs USING btree(next_attempt_at) WHERE status = 'pending';
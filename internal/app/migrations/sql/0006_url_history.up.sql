@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS url_history("id" BIGSERIAL PRIMARY KEY, "short_url" TEXT NOT NULL, "previous_url" TEXT NOT NULL, "long_url" TEXT NOT NULL, "changed_by" TEXT NOT NULL, "changed_at" TIMESTAMPTZ NOT NULL);
+CREATE INDEX IF NOT EXISTS url_history_short_url_index ON url_history USING btree(short_url, id);
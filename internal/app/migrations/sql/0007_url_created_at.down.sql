@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS user_created_at_index;
+ALTER TABLE shortener DROP COLUMN IF EXISTS "created_at";
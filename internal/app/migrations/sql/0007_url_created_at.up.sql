@@ -0,0 +1,2 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS "created_at" TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS user_created_at_index ON shortener USING btree(user_id, created_at, short_url);
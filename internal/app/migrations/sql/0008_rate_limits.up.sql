@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS rate_limits("key" TEXT PRIMARY KEY, "tokens" DOUBLE PRECISION NOT NULL, "updated_at" TIMESTAMPTZ NOT NULL);
+CREATE INDEX IF NOT EXISTS rate_limits_updated_at_index ON rate_limits USING btree(updated_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS normalized_url_index;
+CREATE UNIQUE INDEX IF NOT EXISTS long_url_index ON shortener USING btree(long_url);
+ALTER TABLE shortener DROP COLUMN IF EXISTS "normalized_url";
@@ -0,0 +1,5 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS "normalized_url" TEXT;
+UPDATE shortener SET normalized_url = long_url WHERE normalized_url IS NULL;
+ALTER TABLE shortener ALTER COLUMN "normalized_url" SET NOT NULL;
+DROP INDEX IF EXISTS long_url_index;
+CREATE UNIQUE INDEX IF NOT EXISTS normalized_url_index ON shortener USING btree(normalized_url);
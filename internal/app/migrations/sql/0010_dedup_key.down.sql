@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS dedup_key_index;
+DROP INDEX IF EXISTS normalized_url_index;
+CREATE UNIQUE INDEX IF NOT EXISTS normalized_url_index ON shortener USING btree(normalized_url);
+ALTER TABLE shortener DROP COLUMN IF EXISTS "dedup_key";
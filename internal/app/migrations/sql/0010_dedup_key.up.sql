@@ -0,0 +1,5 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS "dedup_key" TEXT;
+UPDATE shortener SET dedup_key = normalized_url WHERE dedup_key IS NULL;
+DROP INDEX IF EXISTS normalized_url_index;
+CREATE INDEX IF NOT EXISTS normalized_url_index ON shortener USING btree(normalized_url);
+CREATE UNIQUE INDEX IF NOT EXISTS dedup_key_index ON shortener USING btree(dedup_key);
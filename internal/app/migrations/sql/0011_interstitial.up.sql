@@ -0,0 +1 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS "interstitial" BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE shortener DROP COLUMN IF EXISTS "redirect_code";
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS deletion_jobs_finished_index;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS deletion_jobs_finished_index ON deletion_jobs USING btree(created_at) WHERE status <> 'pending';
@@ -0,0 +1,108 @@
+// Code generated by mockery v2.46.3. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	clickstorage "github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// ClickStorage is an autogenerated mock type for the ClickStorage type
+type ClickStorage struct {
+	mock.Mock
+}
+
+// DeleteClicksWithContext provides a mock function with given fields: _a0, shortURLs
+func (_m *ClickStorage) DeleteClicksWithContext(_a0 context.Context, shortURLs ...string) error {
+	_va := make([]interface{}, len(shortURLs))
+	for _i := range shortURLs {
+		_va[_i] = shortURLs[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteClicksWithContext")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ...string) error); ok {
+		r0 = rf(_a0, shortURLs...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetStatsWithContext provides a mock function with given fields: _a0, shortURL
+func (_m *ClickStorage) GetStatsWithContext(_a0 context.Context, shortURL string) (clickstorage.ClickStats, error) {
+	ret := _m.Called(_a0, shortURL)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetStatsWithContext")
+	}
+
+	var r0 clickstorage.ClickStats
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (clickstorage.ClickStats, error)); ok {
+		return rf(_a0, shortURL)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) clickstorage.ClickStats); ok {
+		r0 = rf(_a0, shortURL)
+	} else {
+		r0 = ret.Get(0).(clickstorage.ClickStats)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, shortURL)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// StoreClicksWithContext provides a mock function with given fields: _a0, clicks
+func (_m *ClickStorage) StoreClicksWithContext(_a0 context.Context, clicks ...clickstorage.Click) error {
+	_va := make([]interface{}, len(clicks))
+	for _i := range clicks {
+		_va[_i] = clicks[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for StoreClicksWithContext")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ...clickstorage.Click) error); ok {
+		r0 = rf(_a0, clicks...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// NewClickStorage creates a new instance of ClickStorage. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewClickStorage(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *ClickStorage {
+	mock := &ClickStorage{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
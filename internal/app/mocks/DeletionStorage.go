@@ -0,0 +1,198 @@
+// Code generated by mockery v2.46.3. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+	deletionstorage "github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
+
+	time "time"
+)
+
+// DeletionStorage is an autogenerated mock type for the DeletionStorage type
+type DeletionStorage struct {
+	mock.Mock
+}
+
+// AddJobWithContext provides a mock function with given fields: _a0, userID, shortURLs
+func (_m *DeletionStorage) AddJobWithContext(_a0 context.Context, userID string, shortURLs []string) (deletionstorage.Job, error) {
+	ret := _m.Called(_a0, userID, shortURLs)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AddJobWithContext")
+	}
+
+	var r0 deletionstorage.Job
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) (deletionstorage.Job, error)); ok {
+		return rf(_a0, userID, shortURLs)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) deletionstorage.Job); ok {
+		r0 = rf(_a0, userID, shortURLs)
+	} else {
+		r0 = ret.Get(0).(deletionstorage.Job)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string) error); ok {
+		r1 = rf(_a0, userID, shortURLs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountPendingWithContext provides a mock function with given fields: _a0
+func (_m *DeletionStorage) CountPendingWithContext(_a0 context.Context) (int64, error) {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountPendingWithContext")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (int64, error)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDueJobsWithContext provides a mock function with given fields: _a0, now, limit
+func (_m *DeletionStorage) GetDueJobsWithContext(_a0 context.Context, now time.Time, limit int) ([]deletionstorage.Job, error) {
+	ret := _m.Called(_a0, now, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetDueJobsWithContext")
+	}
+
+	var r0 []deletionstorage.Job
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) ([]deletionstorage.Job, error)); ok {
+		return rf(_a0, now, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) []deletionstorage.Job); ok {
+		r0 = rf(_a0, now, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]deletionstorage.Job)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, int) error); ok {
+		r1 = rf(_a0, now, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetJobWithContext provides a mock function with given fields: _a0, id
+func (_m *DeletionStorage) GetJobWithContext(_a0 context.Context, id int64) (deletionstorage.Job, error) {
+	ret := _m.Called(_a0, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetJobWithContext")
+	}
+
+	var r0 deletionstorage.Job
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (deletionstorage.Job, error)); ok {
+		return rf(_a0, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) deletionstorage.Job); ok {
+		r0 = rf(_a0, id)
+	} else {
+		r0 = ret.Get(0).(deletionstorage.Job)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(_a0, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeFinishedWithContext provides a mock function with given fields: _a0, createdBefore
+func (_m *DeletionStorage) PurgeFinishedWithContext(_a0 context.Context, createdBefore time.Time) (int64, error) {
+	ret := _m.Called(_a0, createdBefore)
+
+	if len(ret) == 0 {
+		panic("no return value specified for PurgeFinishedWithContext")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int64, error)); ok {
+		return rf(_a0, createdBefore)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(_a0, createdBefore)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(_a0, createdBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateJobsWithContext provides a mock function with given fields: _a0, jobs
+func (_m *DeletionStorage) UpdateJobsWithContext(_a0 context.Context, jobs ...deletionstorage.Job) error {
+	_va := make([]interface{}, len(jobs))
+	for _i := range jobs {
+		_va[_i] = jobs[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateJobsWithContext")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ...deletionstorage.Job) error); ok {
+		r0 = rf(_a0, jobs...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// NewDeletionStorage creates a new instance of DeletionStorage. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewDeletionStorage(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *DeletionStorage {
+	mock := &DeletionStorage{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -1,6 +1,5 @@
 // Code generated by mockery v2.46.3. DO NOT EDIT.
 
-// Package mocks contains mocks for testing.
 package mocks
 
 import mock "github.com/stretchr/testify/mock"
@@ -10,9 +9,9 @@ type ShortCutGenerator struct {
 	mock.Mock
 }
 
-// Generate provides a mock function with given fields:
-func (_m *ShortCutGenerator) Generate() (string, error) {
-	ret := _m.Called()
+// Generate provides a mock function with given fields: longURL, attempt
+func (_m *ShortCutGenerator) Generate(longURL string, attempt int) (string, error) {
+	ret := _m.Called(longURL, attempt)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Generate")
@@ -20,17 +19,17 @@ func (_m *ShortCutGenerator) Generate() (string, error) {
 
 	var r0 string
 	var r1 error
-	if rf, ok := ret.Get(0).(func() (string, error)); ok {
-		return rf()
+	if rf, ok := ret.Get(0).(func(string, int) (string, error)); ok {
+		return rf(longURL, attempt)
 	}
-	if rf, ok := ret.Get(0).(func() string); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(string, int) string); ok {
+		r0 = rf(longURL, attempt)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(longURL, attempt)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -4,8 +4,10 @@ package mocks
 
 import (
 	context "context"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
+
 	urlstorage "github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
 )
 
@@ -32,6 +34,64 @@ func (_m *URLStorage) Clear() error {
 	return r0
 }
 
+// CountURLsWithContext provides a mock function with given fields: _a0
+func (_m *URLStorage) CountURLsWithContext(_a0 context.Context) (int64, error) {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountURLsWithContext")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (int64, error)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetHistoryWithContext provides a mock function with given fields: _a0, shortURL
+func (_m *URLStorage) GetHistoryWithContext(_a0 context.Context, shortURL string) ([]urlstorage.URLChange, error) {
+	ret := _m.Called(_a0, shortURL)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetHistoryWithContext")
+	}
+
+	var r0 []urlstorage.URLChange
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]urlstorage.URLChange, error)); ok {
+		return rf(_a0, shortURL)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []urlstorage.URLChange); ok {
+		r0 = rf(_a0, shortURL)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]urlstorage.URLChange)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, shortURL)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetLongURLWithContext provides a mock function with given fields: _a0, shortURL
 func (_m *URLStorage) GetLongURLWithContext(_a0 context.Context, shortURL string) (string, error) {
 	ret := _m.Called(_a0, shortURL)
@@ -60,9 +120,9 @@ func (_m *URLStorage) GetLongURLWithContext(_a0 context.Context, shortURL string
 	return r0, r1
 }
 
-// GetShortURLWithContext provides a mock function with given fields: _a0, longURL
-func (_m *URLStorage) GetShortURLWithContext(_a0 context.Context, longURL string) (string, error) {
-	ret := _m.Called(_a0, longURL)
+// GetShortURLWithContext provides a mock function with given fields: _a0, normalizedURL, userID
+func (_m *URLStorage) GetShortURLWithContext(_a0 context.Context, normalizedURL string, userID string) (string, error) {
+	ret := _m.Called(_a0, normalizedURL, userID)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetShortURLWithContext")
@@ -70,17 +130,45 @@ func (_m *URLStorage) GetShortURLWithContext(_a0 context.Context, longURL string
 
 	var r0 string
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) (string, error)); ok {
-		return rf(_a0, longURL)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (string, error)); ok {
+		return rf(_a0, normalizedURL, userID)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
-		r0 = rf(_a0, longURL)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
+		r0 = rf(_a0, normalizedURL, userID)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(_a0, normalizedURL, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetURLWithContext provides a mock function with given fields: _a0, shortURL
+func (_m *URLStorage) GetURLWithContext(_a0 context.Context, shortURL string) (urlstorage.StoredURL, error) {
+	ret := _m.Called(_a0, shortURL)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetURLWithContext")
+	}
+
+	var r0 urlstorage.StoredURL
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (urlstorage.StoredURL, error)); ok {
+		return rf(_a0, shortURL)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) urlstorage.StoredURL); ok {
+		r0 = rf(_a0, shortURL)
+	} else {
+		r0 = ret.Get(0).(urlstorage.StoredURL)
+	}
+
 	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(_a0, longURL)
+		r1 = rf(_a0, shortURL)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -106,6 +194,36 @@ func (_m *URLStorage) Ping() error {
 	return r0
 }
 
+// PurgeExpiredWithContext provides a mock function with given fields: _a0, expiredBefore
+func (_m *URLStorage) PurgeExpiredWithContext(_a0 context.Context, expiredBefore time.Time) ([]string, error) {
+	ret := _m.Called(_a0, expiredBefore)
+
+	if len(ret) == 0 {
+		panic("no return value specified for PurgeExpiredWithContext")
+	}
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) ([]string, error)); ok {
+		return rf(_a0, expiredBefore)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) []string); ok {
+		r0 = rf(_a0, expiredBefore)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(_a0, expiredBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // StoreManyWithContext provides a mock function with given fields: _a0, long2ShortUrls, userID
 func (_m *URLStorage) StoreManyWithContext(_a0 context.Context, long2ShortUrls []urlstorage.URLPair, userID string) ([]error, error) {
 	ret := _m.Called(_a0, long2ShortUrls, userID)
@@ -136,17 +254,17 @@ func (_m *URLStorage) StoreManyWithContext(_a0 context.Context, long2ShortUrls [
 	return r0, r1
 }
 
-// StoreWithContext provides a mock function with given fields: _a0, longURL, shortURL, userID
-func (_m *URLStorage) StoreWithContext(_a0 context.Context, longURL string, shortURL string, userID string) error {
-	ret := _m.Called(_a0, longURL, shortURL, userID)
+// StoreWithContext provides a mock function with given fields: _a0, longURL, shortURL, userID, options
+func (_m *URLStorage) StoreWithContext(_a0 context.Context, longURL string, shortURL string, userID string, options urlstorage.URLOptions) error {
+	ret := _m.Called(_a0, longURL, shortURL, userID, options)
 
 	if len(ret) == 0 {
 		panic("no return value specified for StoreWithContext")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
-		r0 = rf(_a0, longURL, shortURL, userID)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, urlstorage.URLOptions) error); ok {
+		r0 = rf(_a0, longURL, shortURL, userID, options)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -154,6 +272,34 @@ func (_m *URLStorage) StoreWithContext(_a0 context.Context, longURL string, shor
 	return r0
 }
 
+// UpdateWithContext provides a mock function with given fields: _a0, change
+func (_m *URLStorage) UpdateWithContext(_a0 context.Context, change urlstorage.URLChange) (urlstorage.URLChange, error) {
+	ret := _m.Called(_a0, change)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateWithContext")
+	}
+
+	var r0 urlstorage.URLChange
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, urlstorage.URLChange) (urlstorage.URLChange, error)); ok {
+		return rf(_a0, change)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, urlstorage.URLChange) urlstorage.URLChange); ok {
+		r0 = rf(_a0, change)
+	} else {
+		r0 = ret.Get(0).(urlstorage.URLChange)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, urlstorage.URLChange) error); ok {
+		r1 = rf(_a0, change)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewURLStorage creates a new instance of URLStorage. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewURLStorage(t interface {
@@ -32,6 +32,34 @@ func (_m *UserURLStorage) Clear() error {
 	return r0
 }
 
+// CountUsersWithContext provides a mock function with given fields: _a0
+func (_m *UserURLStorage) CountUsersWithContext(_a0 context.Context) (int64, error) {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountUsersWithContext")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (int64, error)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DeleteUserURLs provides a mock function with given fields: _a0, urls
 func (_m *UserURLStorage) DeleteUserURLs(_a0 context.Context, urls ...urlstorage.URLsForDelete) error {
 	_va := make([]interface{}, len(urls))
@@ -87,6 +115,62 @@ func (_m *UserURLStorage) GetUserURLs(_a0 context.Context, userID string) ([]url
 	return r0, r1
 }
 
+// GetUserURLsPageWithContext provides a mock function with given fields: _a0, userID, query
+func (_m *UserURLStorage) GetUserURLsPageWithContext(_a0 context.Context, userID string, query urlstorage.UserURLsQuery) (urlstorage.UserURLsPage, error) {
+	ret := _m.Called(_a0, userID, query)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUserURLsPageWithContext")
+	}
+
+	var r0 urlstorage.UserURLsPage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, urlstorage.UserURLsQuery) (urlstorage.UserURLsPage, error)); ok {
+		return rf(_a0, userID, query)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, urlstorage.UserURLsQuery) urlstorage.UserURLsPage); ok {
+		r0 = rf(_a0, userID, query)
+	} else {
+		r0 = ret.Get(0).(urlstorage.UserURLsPage)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, urlstorage.UserURLsQuery) error); ok {
+		r1 = rf(_a0, userID, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// OwnsURLWithContext provides a mock function with given fields: _a0, userID, shortURL
+func (_m *UserURLStorage) OwnsURLWithContext(_a0 context.Context, userID string, shortURL string) (bool, error) {
+	ret := _m.Called(_a0, userID, shortURL)
+
+	if len(ret) == 0 {
+		panic("no return value specified for OwnsURLWithContext")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (bool, error)); ok {
+		return rf(_a0, userID, shortURL)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) bool); ok {
+		r0 = rf(_a0, userID, shortURL)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(_a0, userID, shortURL)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Ping provides a mock function with given fields:
 func (_m *UserURLStorage) Ping() error {
 	ret := _m.Called()
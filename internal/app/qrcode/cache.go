@@ -0,0 +1,79 @@
+package qrcode
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache of rendered qr codes evicting least recently used ones.
+type Cache struct {
+	Capacity int
+	mutex    sync.Mutex
+	order    *list.List // most recently used first
+	entries  map[string]*list.Element
+}
+
+// Cached image.
+type entry struct {
+	key   string
+	image []byte
+}
+
+// New cache keeping up to capacity images.
+func NewCache(capacity int) *Cache {
+	return &Cache{Capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Returns cached qr code of content or renders and caches it.
+//
+// Nil cache renders every time.
+func (c *Cache) Render(content string, options Options) ([]byte, error) {
+	if c == nil || c.Capacity <= 0 {
+		return Render(content, options)
+	}
+	key := options.key(content)
+	if image, has := c.get(key); has {
+		return image, nil
+	}
+	image, err := Render(content, options)
+	if err != nil {
+		return nil, err
+	}
+	c.add(key, image)
+	return image, nil
+}
+
+// Returns cached image and marks it as recently used.
+func (c *Cache) get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, has := c.entries[key]
+	if !has {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(entry).image, true
+}
+
+// Caches image evicting least recently used ones above capacity.
+func (c *Cache) add(key string, image []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, has := c.entries[key]; has {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry{key: key, image: image})
+	for c.order.Len() > c.Capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(entry).key)
+	}
+}
+
+// Returns number of cached images.
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
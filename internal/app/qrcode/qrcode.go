@@ -0,0 +1,158 @@
+// Package qrcode renders qr codes of short urls.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	qr "github.com/skip2/go-qrcode"
+)
+
+// Error in case rendering options are out of bounds.
+var ErrInvalidOptions = errors.New("invalid qr code options")
+
+// Image format of qr code.
+type Format string
+
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+)
+
+// Returns mime type of format.
+func (f Format) ContentType() string {
+	if f == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Error correction levels by their letters.
+var levels = map[string]qr.RecoveryLevel{
+	"L": qr.Low,
+	"M": qr.Medium,
+	"Q": qr.High,
+	"H": qr.Highest,
+}
+
+// Bounds of rendering options.
+const (
+	MinSize   = 64
+	MaxSize   = 2048
+	MaxMargin = 16
+)
+
+// Options of rendered qr code.
+type Options struct {
+	Size   int    // width and height in pixels
+	Level  string // error correction level: L, M, Q or H
+	Margin int    // quiet zone around code in modules
+	Format Format
+}
+
+// Options used for missing query parameters.
+var DefaultOptions = Options{Size: 256, Level: "M", Margin: 4, Format: FormatPNG}
+
+// Checks that options are within bounds.
+func (o Options) Validate() error {
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("%w: size must be between %d and %d", ErrInvalidOptions, MinSize, MaxSize)
+	}
+	if _, has := levels[strings.ToUpper(o.Level)]; !has {
+		return fmt.Errorf("%w: level must be one of L, M, Q, H", ErrInvalidOptions)
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("%w: margin must be between 0 and %d", ErrInvalidOptions, MaxMargin)
+	}
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return fmt.Errorf("%w: format must be png or svg", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// Returns key of rendered image of content in cache.
+func (o Options) key(content string) string {
+	return fmt.Sprintf("%s %d %s %d %s", o.Format, o.Size, strings.ToUpper(o.Level), o.Margin, content)
+}
+
+// Returns modules of qr code surrounded by margin, true is dark module.
+func modules(content string, options Options) ([][]bool, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	code, err := qr.New(content, levels[strings.ToUpper(options.Level)])
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode qr code: %w", err)
+	}
+	code.DisableBorder = true
+	symbol := code.Bitmap()
+	size := len(symbol) + 2*options.Margin
+	res := make([][]bool, size)
+	for y := range res {
+		res[y] = make([]bool, size)
+		if y >= options.Margin && y < options.Margin+len(symbol) {
+			copy(res[y][options.Margin:], symbol[y-options.Margin])
+		}
+	}
+	return res, nil
+}
+
+// Renders qr code of content in given format.
+//
+// Png is exactly options.Size pixels wide unless code has more modules than pixels.
+func Render(content string, options Options) ([]byte, error) {
+	bitmap, err := modules(content, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.Format == FormatSVG {
+		return renderSVG(bitmap, options.Size), nil
+	}
+	return renderPNG(bitmap, options.Size)
+}
+
+// Draws modules scaled to size pixels.
+func renderPNG(bitmap [][]bool, size int) ([]byte, error) {
+	size = max(size, len(bitmap))
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < size; y++ {
+		row := bitmap[y*len(bitmap)/size]
+		for x := 0; x < size; x++ {
+			if row[x*len(bitmap)/size] {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("cannot encode png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Draws modules as single path, one module is one svg unit.
+func renderSVG(bitmap [][]bool, size int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, len(bitmap), len(bitmap))
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
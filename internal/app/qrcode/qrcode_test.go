@@ -0,0 +1,86 @@
+package qrcode_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/qrcode"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options qrcode.Options
+		wantErr bool
+	}{
+		{name: "default", options: qrcode.DefaultOptions},
+		{name: "lowercase_level", options: qrcode.Options{Size: 64, Level: "q", Margin: 0, Format: qrcode.FormatSVG}},
+		{name: "small", options: qrcode.Options{Size: 10, Level: "M", Format: qrcode.FormatPNG}, wantErr: true},
+		{name: "large", options: qrcode.Options{Size: 5000, Level: "M", Format: qrcode.FormatPNG}, wantErr: true},
+		{name: "level", options: qrcode.Options{Size: 256, Level: "Z", Format: qrcode.FormatPNG}, wantErr: true},
+		{name: "margin", options: qrcode.Options{Size: 256, Level: "M", Margin: -1, Format: qrcode.FormatPNG}, wantErr: true},
+		{name: "format", options: qrcode.Options{Size: 256, Level: "M", Format: "gif"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate()
+			if tt.wantErr {
+				require.ErrorIs(t, err, qrcode.ErrInvalidOptions)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	image, err := qrcode.Render("http://localhost:8080/abc", qrcode.Options{Size: 300, Level: "M", Margin: 4, Format: qrcode.FormatPNG})
+	require.NoError(t, err)
+	decoded, err := png.Decode(bytes.NewReader(image))
+	require.NoError(t, err)
+	assert.Equal(t, 300, decoded.Bounds().Dx())
+	assert.Equal(t, 300, decoded.Bounds().Dy())
+	r, _, _, _ := decoded.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r, "margin is white")
+
+	// Version 2 code has 25 modules.
+	image, err = qrcode.Render("http://localhost:8080/abc", qrcode.Options{Size: 128, Level: "L", Margin: 0, Format: qrcode.FormatSVG})
+	require.NoError(t, err)
+	svg := string(image)
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="128" height="128" viewBox="0 0 25 25"`), svg)
+	assert.Contains(t, svg, "M0 0h7v1h-7z", "finder pattern starts at corner without margin")
+
+	image, err = qrcode.Render("http://localhost:8080/abc", qrcode.Options{Size: 128, Level: "L", Margin: 2, Format: qrcode.FormatSVG})
+	require.NoError(t, err)
+	assert.Contains(t, string(image), `viewBox="0 0 29 29"`)
+	assert.Contains(t, string(image), "M2 2h7v1h-7z")
+}
+
+func TestCache_Render(t *testing.T) {
+	cache := qrcode.NewCache(2)
+	first, err := cache.Render("a", qrcode.DefaultOptions)
+	require.NoError(t, err)
+	again, err := cache.Render("a", qrcode.DefaultOptions)
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+	assert.Equal(t, 1, cache.Len())
+
+	svg := qrcode.DefaultOptions
+	svg.Format = qrcode.FormatSVG
+	_, err = cache.Render("a", svg)
+	require.NoError(t, err)
+	_, err = cache.Render("b", qrcode.DefaultOptions)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cache.Len(), "least recently used image is evicted")
+
+	_, err = cache.Render("a", qrcode.Options{Size: 1})
+	require.ErrorIs(t, err, qrcode.ErrInvalidOptions)
+
+	var disabled *qrcode.Cache
+	_, err = disabled.Render("a", qrcode.DefaultOptions)
+	require.NoError(t, err)
+}
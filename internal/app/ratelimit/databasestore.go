@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Store keeping buckets in postgresql shared by all replicas.
+type DatabaseStore struct {
+	DB  *sql.DB
+	Now func() time.Time
+}
+
+// New postgresql bucket store.
+//
+// Schema is expected to be created by migrations package.
+func NewDatabaseStore(db *sql.DB) *DatabaseStore {
+	return &DatabaseStore{DB: db, Now: time.Now}
+}
+
+// Takes cost tokens from bucket of key if there are enough of them.
+//
+// Bucket row is locked until tokens are taken so concurrent requests of replicas are serialized.
+func (s *DatabaseStore) TakeWithContext(ctx context.Context, key string, limit Limit, cost int) (Result, error) {
+	now := s.Now()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO rate_limits (key, tokens, updated_at) VALUES($1, $2, $3) ON CONFLICT (key) DO NOTHING",
+		key, float64(limit.Burst), now)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to insert bucket: %w", err)
+	}
+	var b bucket
+	err = tx.QueryRowContext(ctx,
+		"SELECT tokens, updated_at FROM rate_limits WHERE key = $1 FOR UPDATE", key).Scan(&b.Tokens, &b.UpdatedAt)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to select bucket: %w", err)
+	}
+	b, res := b.take(limit, cost, now)
+	_, err = tx.ExecContext(ctx,
+		"UPDATE rate_limits SET tokens = $2, updated_at = $3 WHERE key = $1", key, b.Tokens, b.UpdatedAt)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to update bucket: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return Result{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return res, nil
+}
+
+// Removes buckets not used since given moment.
+func (s *DatabaseStore) PurgeIdleWithContext(ctx context.Context, idleSince time.Time) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM rate_limits WHERE updated_at < $1", idleSince)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete buckets: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseStore_Take(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := NewDatabaseStore(db)
+	now := time.Now()
+	store.Now = func() time.Time { return now }
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO rate_limits").WithArgs("create:ip:1.2.3.4", float64(60), now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT tokens, updated_at FROM rate_limits WHERE key = (.+) FOR UPDATE").WithArgs("create:ip:1.2.3.4").
+		WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at"}).AddRow(0.5, now.Add(-time.Second)))
+	mock.ExpectExec("UPDATE rate_limits SET tokens").WithArgs("create:ip:1.2.3.4", 0.5, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	res, err := store.TakeWithContext(context.Background(), "create:ip:1.2.3.4", PerMinute(60), 1)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStore_PurgeIdle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := NewDatabaseStore(db)
+	idleSince := time.Now()
+	mock.ExpectExec("DELETE FROM rate_limits WHERE updated_at").WithArgs(idleSince).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	purged, err := store.PurgeIdleWithContext(context.Background(), idleSince)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
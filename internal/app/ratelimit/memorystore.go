@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeping buckets in memory of single replica.
+type MemoryStore struct {
+	Buckets map[string]bucket
+	Mutex   sync.Mutex
+	Now     func() time.Time
+}
+
+// New in memory bucket store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{Buckets: make(map[string]bucket), Now: time.Now}
+}
+
+// Takes cost tokens from bucket of key if there are enough of them.
+func (s *MemoryStore) TakeWithContext(_ context.Context, key string, limit Limit, cost int) (Result, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	b, res := s.Buckets[key].take(limit, cost, s.Now())
+	s.Buckets[key] = b
+	return res, nil
+}
+
+// Removes buckets not used since given moment.
+func (s *MemoryStore) PurgeIdleWithContext(_ context.Context, idleSince time.Time) (int64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var purged int64
+	for key, b := range s.Buckets {
+		if b.UpdatedAt.Before(idleSince) {
+			delete(s.Buckets, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,65 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/ratelimit"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.Now = func() time.Time { return now }
+	ctx := context.Background()
+	limit := ratelimit.PerMinute(60)
+
+	res, err := store.TakeWithContext(ctx, "a", limit, 59)
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Result{Allowed: true, Remaining: 1, Reset: 59 * time.Second}, res)
+
+	res, err = store.TakeWithContext(ctx, "a", limit, 3)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, 2*time.Second, res.RetryAfter)
+
+	res, err = store.TakeWithContext(ctx, "b", limit, 3)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed, "buckets of other keys are independent")
+
+	now = now.Add(2 * time.Second)
+	res, err = store.TakeWithContext(ctx, "a", limit, 3)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	now = now.Add(time.Hour)
+	res, err = store.TakeWithContext(ctx, "a", limit, 61)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Zero(t, res.RetryAfter, "cost above burst is never allowed")
+	assert.Equal(t, 60, res.Remaining, "bucket is refilled up to burst only")
+}
+
+func TestMemoryStore_PurgeIdle(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	now := time.Now()
+	store.Now = func() time.Time { return now }
+	ctx := context.Background()
+	limit := ratelimit.PerMinute(10)
+
+	_, err := store.TakeWithContext(ctx, "old", limit, 1)
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = store.TakeWithContext(ctx, "new", limit, 1)
+	require.NoError(t, err)
+
+	purged, err := store.PurgeIdleWithContext(ctx, now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.Len(t, store.Buckets, 1)
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+	"github.com/valinurovdenis/urlshortener/internal/app/metrics"
+	"go.uber.org/zap"
+)
+
+// Limiter of requests sharing one limit.
+//
+// Every key gets its own bucket, by default request costs one token.
+type Limiter struct {
+	Name  string // prefix of bucket keys separating limiters in one store
+	Limit Limit
+	Store Store
+	Key   func(r *http.Request) string
+	Cost  func(r *http.Request) int
+}
+
+// New limiter taking one token per request.
+func NewLimiter(name string, limit Limit, store Store, key func(r *http.Request) string) *Limiter {
+	return &Limiter{Name: name, Limit: limit, Store: store, Key: key}
+}
+
+// Returns copy of limiter with given cost of request.
+func (l *Limiter) WithCost(cost func(r *http.Request) int) *Limiter {
+	res := *l
+	res.Cost = cost
+	return &res
+}
+
+// Takes cost tokens from bucket of key, rejected requests are counted in metrics.
+//
+// Nil limiter or disabled limit allows everything.
+func (l *Limiter) Take(ctx context.Context, key string, cost int) (Result, error) {
+	if l == nil || !l.Limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	res, err := l.Store.TakeWithContext(ctx, l.Name+":"+key, l.Limit, cost)
+	if err == nil && !res.Allowed {
+		metrics.RateLimitedTotal.WithLabelValues(l.Name).Inc()
+	}
+	return res, err
+}
+
+// Middleware taking tokens for request and rejecting it with 429 when there are not enough of them.
+//
+// Sets X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers
+// and Retry-After header for rejected request.
+// Nil limiter or disabled limit lets everything through, storage errors too.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l == nil || !l.Limit.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cost := 1
+		if l.Cost != nil {
+			cost = l.Cost(r)
+		}
+		res, err := l.Take(r.Context(), l.Key(r), cost)
+		if err != nil {
+			logger.Log.Error("cannot check rate limit", zap.String("limit", l.Name), zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.Limit.Burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		if !res.Allowed {
+			if res.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(res.RetryAfter), 1)))
+			}
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns duration in whole seconds rounded up.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Periodically removes buckets idle for given time, they are full by then anyway.
+func PurgeIdleBuckets(ctx context.Context, store Store, interval time.Duration, idle time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := store.PurgeIdleWithContext(ctx, time.Now().Add(-idle))
+			if err != nil {
+				logger.Log.Error("cannot purge idle rate limit buckets", zap.Error(err))
+				continue
+			}
+			if purged != 0 {
+				logger.Log.Debug("purged idle rate limit buckets", zap.Int64("count", purged))
+			}
+		}
+	}
+}
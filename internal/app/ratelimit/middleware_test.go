@@ -0,0 +1,54 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valinurovdenis/urlshortener/internal/app/ratelimit"
+)
+
+func TestLimiter_Middleware(t *testing.T) {
+	limiter := ratelimit.NewLimiter("test", ratelimit.PerMinute(2), ratelimit.NewMemoryStore(),
+		func(r *http.Request) string { return r.Header.Get("X-Key") })
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	testCases := []struct {
+		name              string
+		key               string
+		expectedCode      int
+		expectedRemaining string
+		expectedRetry     string
+	}{
+		{name: "first", key: "a", expectedCode: http.StatusOK, expectedRemaining: "1"},
+		{name: "second", key: "a", expectedCode: http.StatusOK, expectedRemaining: "0"},
+		{name: "limited", key: "a", expectedCode: http.StatusTooManyRequests, expectedRemaining: "0", expectedRetry: "30"},
+		{name: "other key", key: "b", expectedCode: http.StatusOK, expectedRemaining: "1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Key", tc.key)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+			assert.Equal(t, tc.expectedRemaining, w.Header().Get("X-RateLimit-Remaining"))
+			assert.Equal(t, tc.expectedRetry, w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestLimiter_MiddlewareDisabled(t *testing.T) {
+	var limiter *ratelimit.Limiter
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+}
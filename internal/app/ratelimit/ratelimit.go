@@ -0,0 +1,73 @@
+// Package ratelimit for limiting request rate with token buckets.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Token bucket limit refilled with Rate tokens per second up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limit allowing n requests per minute with bursts up to n.
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+// Whether limit allows anything at all.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Result of taking tokens from bucket.
+type Result struct {
+	Allowed    bool
+	Remaining  int           // tokens left in bucket
+	Reset      time.Duration // time until bucket is full again
+	RetryAfter time.Duration // time until request could be allowed, zero if it never could
+}
+
+// Storage of token buckets.
+type Store interface {
+	// Takes cost tokens from bucket of key if there are enough of them.
+	TakeWithContext(ctx context.Context, key string, limit Limit, cost int) (Result, error)
+
+	// Removes buckets not used since given moment.
+	PurgeIdleWithContext(ctx context.Context, idleSince time.Time) (int64, error)
+}
+
+// State of single bucket.
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Refills bucket by given moment and takes cost tokens if there are enough of them.
+// New bucket is considered full.
+func (b bucket) take(limit Limit, cost int, now time.Time) (bucket, Result) {
+	burst := float64(limit.Burst)
+	tokens := burst
+	if !b.UpdatedAt.IsZero() {
+		elapsed := max(now.Sub(b.UpdatedAt), 0)
+		tokens = min(burst, b.Tokens+elapsed.Seconds()*limit.Rate)
+	}
+	var res Result
+	if float64(cost) <= tokens {
+		tokens -= float64(cost)
+		res.Allowed = true
+	} else if cost <= limit.Burst {
+		res.RetryAfter = secondsToDuration((float64(cost) - tokens) / limit.Rate)
+	}
+	res.Remaining = int(math.Floor(tokens))
+	res.Reset = secondsToDuration((burst - tokens) / limit.Rate)
+	return bucket{Tokens: tokens, UpdatedAt: now}, res
+}
+
+// Converts seconds to duration rounding up to whole milliseconds.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds*1000)) * time.Millisecond
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error in case custom short url does not satisfy alias policy.
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// Error in case custom short url is already used for another url.
+var ErrAliasTaken = errors.New("alias is already taken")
+
+// Rules for custom short urls chosen by user.
+type AliasPolicy struct {
+	Charset   string              // allowed characters
+	MinLength int                 // minimal alias length
+	MaxLength int                 // maximal alias length
+	Reserved  map[string]struct{} // lowercase words that cannot be used as alias
+}
+
+// Characters allowed in alias by default.
+const DefaultAliasCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// Words used by service routes.
+var DefaultReservedAliases = []string{"api", "ping", "debug", "metrics"}
+
+// New alias policy, reserved words are compared case insensitively.
+func NewAliasPolicy(charset string, minLength int, maxLength int, reserved ...string) AliasPolicy {
+	policy := AliasPolicy{
+		Charset:   charset,
+		MinLength: minLength,
+		MaxLength: maxLength,
+		Reserved:  make(map[string]struct{}),
+	}
+	for _, word := range reserved {
+		if word = strings.TrimSpace(word); word != "" {
+			policy.Reserved[strings.ToLower(word)] = struct{}{}
+		}
+	}
+	return policy
+}
+
+// Default alias policy.
+var DefaultAliasPolicy = NewAliasPolicy(DefaultAliasCharset, 3, 32, DefaultReservedAliases...)
+
+// Checks whether alias satisfies policy.
+func (p AliasPolicy) Validate(alias string) error {
+	length := len([]rune(alias))
+	if length < p.MinLength || length > p.MaxLength {
+		return fmt.Errorf("%w: length must be from %d to %d", ErrInvalidAlias, p.MinLength, p.MaxLength)
+	}
+	for _, r := range alias {
+		if !strings.ContainsRune(p.Charset, r) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidAlias, r)
+		}
+	}
+	if _, has := p.Reserved[strings.ToLower(alias)]; has {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+	return nil
+}
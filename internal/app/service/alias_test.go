@@ -0,0 +1,39 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+)
+
+func TestAliasPolicy_Validate(t *testing.T) {
+	policy := service.NewAliasPolicy("abc-", 2, 5, "API", " ab ")
+	tests := []struct {
+		alias   string
+		wantErr bool
+	}{
+		{alias: "abc", wantErr: false},
+		{alias: "a-b-c", wantErr: false},
+		{alias: "a", wantErr: true},
+		{alias: "abcabc", wantErr: true},
+		{alias: "abd", wantErr: true},
+		{alias: "a/b", wantErr: true},
+		{alias: "ab", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			err := policy.Validate(tt.alias)
+			if tt.wantErr {
+				require.ErrorIs(t, err, service.ErrInvalidAlias)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	for _, reserved := range service.DefaultReservedAliases {
+		require.ErrorIs(t, service.DefaultAliasPolicy.Validate(reserved), service.ErrInvalidAlias)
+	}
+	require.ErrorIs(t, service.DefaultAliasPolicy.Validate("PING"), service.ErrInvalidAlias)
+}
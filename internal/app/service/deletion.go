@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+	"go.uber.org/zap"
+)
+
+// Settings of flushing deletion jobs.
+type DeletionSettings struct {
+	FlushInterval time.Duration // how often due jobs are processed
+	BatchSize     int           // maximal number of jobs deleted at once
+	MaxAttempts   int           // number of failed attempts after which job is dead
+	RetryBackoff  time.Duration // delay after first failure, doubled after every next one
+	Retention     time.Duration // how long done and failed jobs are kept after creation
+}
+
+// Maximal delay between attempts of deletion job.
+const maxRetryBackoff = time.Hour
+
+// Default deletion settings.
+var DefaultDeletionSettings = DeletionSettings{
+	FlushInterval: 10 * time.Second,
+	BatchSize:     100,
+	MaxAttempts:   5,
+	RetryBackoff:  time.Second,
+	Retention:     24 * time.Hour,
+}
+
+// Replaces settings that would stop or break flushing by default ones.
+func (d DeletionSettings) withDefaults() DeletionSettings {
+	if d.FlushInterval <= 0 {
+		d.FlushInterval = DefaultDeletionSettings.FlushInterval
+	}
+	if d.BatchSize <= 0 {
+		d.BatchSize = DefaultDeletionSettings.BatchSize
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = DefaultDeletionSettings.MaxAttempts
+	}
+	if d.RetryBackoff < 0 {
+		d.RetryBackoff = DefaultDeletionSettings.RetryBackoff
+	}
+	if d.Retention <= 0 {
+		d.Retention = DefaultDeletionSettings.Retention
+	}
+	return d
+}
+
+// Returns delay before next attempt after given number of failed attempts.
+func (d DeletionSettings) backoff(attempts int) time.Duration {
+	delay := d.RetryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+// Error in case deletion job is not created by user.
+var ErrNoSuchDeletionJob = errors.New("no such deletion job")
+
+// Schedules deletion of given user urls.
+// Job is saved to deletion storage before return, returns its id.
+func (s ShortenerServiceImpl) DeleteUserURLs(ctx context.Context, userID string, shortURLs ...string) (int64, error) {
+	job, err := s.Deletions.AddJobWithContext(ctx, userID, shortURLs)
+	if err != nil {
+		return 0, fmt.Errorf("cannot save deletion job: %w", err)
+	}
+	return job.ID, nil
+}
+
+// Returns deletion job created by user.
+func (s ShortenerServiceImpl) GetDeletionJob(ctx context.Context, userID string, id int64) (deletionstorage.Job, error) {
+	job, err := s.Deletions.GetJobWithContext(ctx, id)
+	if errors.Is(err, deletionstorage.ErrNoSuchJob) || err == nil && job.UserID != userID {
+		return deletionstorage.Job{}, ErrNoSuchDeletionJob
+	}
+	return job, err
+}
+
+// Returns number of deletion jobs not done yet.
+func (s ShortenerServiceImpl) DeletionQueueLength() int64 {
+	count, err := s.Deletions.CountPendingWithContext(context.Background())
+	if err != nil {
+		logger.Log.Error("cannot count deletion jobs", zap.Error(err))
+	}
+	return count
+}
+
+// Processes batches of due deletion jobs until none left.
+func (s ShortenerServiceImpl) processDeletionJobs(ctx context.Context) {
+	for {
+		now := time.Now()
+		jobs, err := s.Deletions.GetDueJobsWithContext(ctx, now, s.deletion.BatchSize)
+		if err != nil {
+			logger.Log.Error("cannot get deletion jobs", zap.Error(err))
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+
+		urlsByUser := make([]urlstorage.URLsForDelete, 0, len(jobs))
+		for _, job := range jobs {
+			urlsByUser = append(urlsByUser, urlstorage.URLsForDelete{UserID: job.UserID, ShortURLs: job.ShortURLs})
+		}
+		errDelete := s.UserURLStorage.DeleteUserURLs(ctx, urlsByUser...)
+		for i := range jobs {
+			jobs[i].Attempts++
+			switch {
+			case errDelete == nil:
+				jobs[i].Status = deletionstorage.StatusDone
+				jobs[i].LastError = ""
+			case jobs[i].Attempts >= s.deletion.MaxAttempts:
+				jobs[i].Status = deletionstorage.StatusFailed
+				jobs[i].LastError = errDelete.Error()
+			default:
+				jobs[i].NextAttemptAt = now.Add(s.deletion.backoff(jobs[i].Attempts))
+				jobs[i].LastError = errDelete.Error()
+			}
+		}
+		if errDelete != nil {
+			logger.Log.Error("cannot delete urls", zap.Error(errDelete))
+		}
+		if err = s.Deletions.UpdateJobsWithContext(ctx, jobs...); err != nil {
+			logger.Log.Error("cannot update deletion jobs", zap.Error(err))
+			return
+		}
+		if errDelete != nil || len(jobs) < s.deletion.BatchSize {
+			return
+		}
+	}
+}
+
+// Removes finished deletion jobs older than retention.
+func (s ShortenerServiceImpl) purgeDeletionJobs(ctx context.Context) {
+	purged, err := s.Deletions.PurgeFinishedWithContext(ctx, time.Now().Add(-s.deletion.Retention))
+	if err != nil {
+		logger.Log.Error("cannot purge deletion jobs", zap.Error(err))
+		return
+	}
+	if purged != 0 {
+		logger.Log.Info("purged finished deletion jobs", zap.Int64("count", purged))
+	}
+}
+
+// Deletes urls of due deletion jobs every flush interval.
+// Failed jobs are retried with exponential backoff until MaxAttempts is reached.
+// Finished jobs are purged every tenth of retention, but not more often than flush interval.
+// Due jobs are processed once more on stop.
+func (s ShortenerServiceImpl) FlushDeletedUserURLs(ctx context.Context) {
+	ticker := time.NewTicker(s.deletion.FlushInterval)
+	defer ticker.Stop()
+	purgeTicker := time.NewTicker(max(s.deletion.Retention/10, s.deletion.FlushInterval))
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.processDeletionJobs(context.Background())
+			return
+		case <-ticker.C:
+			s.processDeletionJobs(ctx)
+		case <-purgeTicker.C:
+			s.purgeDeletionJobs(ctx)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Optional expiration of short url given either by exact time or by time to live.
+type Expiration struct {
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	TTL       string    `json:"ttl,omitempty"`
+}
+
+// Converts expiration to url options.
+// Returns error if expiration is ambiguous or already passed.
+func (e Expiration) Options(now time.Time) (urlstorage.URLOptions, error) {
+	var options urlstorage.URLOptions
+	if e.TTL != "" && !e.ExpiresAt.IsZero() {
+		return options, errors.New("only one of expires_at and ttl can be given")
+	}
+	if e.TTL != "" {
+		ttl, err := time.ParseDuration(e.TTL)
+		if err != nil {
+			return options, fmt.Errorf("wrong ttl: %w", err)
+		}
+		if ttl <= 0 {
+			return options, errors.New("ttl must be positive")
+		}
+		options.ExpiresAt = now.Add(ttl)
+	} else if !e.ExpiresAt.IsZero() {
+		if !e.ExpiresAt.After(now) {
+			return options, errors.New("expires_at must be in future")
+		}
+		options.ExpiresAt = e.ExpiresAt
+	}
+	return options, nil
+}
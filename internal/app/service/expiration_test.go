@@ -0,0 +1,39 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+)
+
+func TestExpiration_Options(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		expiration service.Expiration
+		want       time.Time
+		wantErr    bool
+	}{
+		{name: "no_expiration", expiration: service.Expiration{}, want: time.Time{}},
+		{name: "ttl", expiration: service.Expiration{TTL: "2h"}, want: now.Add(2 * time.Hour)},
+		{name: "expires_at", expiration: service.Expiration{ExpiresAt: now.Add(time.Hour)}, want: now.Add(time.Hour)},
+		{name: "both", expiration: service.Expiration{TTL: "2h", ExpiresAt: now.Add(time.Hour)}, wantErr: true},
+		{name: "wrong_ttl", expiration: service.Expiration{TTL: "two hours"}, wantErr: true},
+		{name: "negative_ttl", expiration: service.Expiration{TTL: "-2h"}, wantErr: true},
+		{name: "past", expiration: service.Expiration{ExpiresAt: now.Add(-time.Hour)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := tt.expiration.Options(now)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, options.ExpiresAt)
+		})
+	}
+}
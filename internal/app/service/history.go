@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Points short url saved by user to new long url.
+// Returns saved change, its previous url equals long url if nothing changed.
+func (s ShortenerServiceImpl) UpdateUserURL(ctx context.Context, userID string, shortURL string, longURL string) (urlstorage.URLChange, error) {
+	sanitizedLongURL, normalizedURL, err := s.prepareLongURL(longURL)
+	if err != nil {
+		return urlstorage.URLChange{}, err
+	}
+	change, err := s.URLStorage.UpdateWithContext(ctx, urlstorage.URLChange{
+		ShortURL:      shortURL,
+		LongURL:       sanitizedLongURL,
+		ChangedBy:     userID,
+		ChangedAt:     time.Now(),
+		NormalizedURL: normalizedURL,
+	})
+	switch {
+	case errors.Is(err, urlstorage.ErrNotOwnedURL):
+		return change, ErrNotUserURL
+	case errors.Is(err, urlstorage.ErrDeletedURL):
+		return change, ErrDeletedURL
+	case errors.Is(err, urlstorage.ErrConflictURL):
+		return change, urlstorage.ErrConflictURL
+	case err != nil:
+		return change, fmt.Errorf("cannot update url: %w", err)
+	}
+	return change, nil
+}
+
+// Returns changes of short url saved by user in order they were made.
+func (s ShortenerServiceImpl) GetURLHistory(ctx context.Context, userID string, shortURL string) ([]urlstorage.URLChange, error) {
+	owns, err := s.UserURLStorage.OwnsURLWithContext(ctx, userID, shortURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get url owner: %w", err)
+	}
+	if !owns {
+		return nil, ErrNotUserURL
+	}
+	history, err := s.URLStorage.GetHistoryWithContext(ctx, shortURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get url history: %w", err)
+	}
+	return history, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Default ports dropped from normalized urls.
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21"}
+
+// Builds canonical form of long urls so that equivalent urls are deduplicated.
+type URLNormalizer struct {
+	// Query parameters removed from urls, names ending with * match by prefix, case is ignored.
+	StripParams []string
+}
+
+// Default normalizer keeping all query parameters.
+var DefaultURLNormalizer = URLNormalizer{}
+
+// Whether query parameter must be removed.
+func (n URLNormalizer) stripped(param string) bool {
+	param = strings.ToLower(param)
+	for _, pattern := range n.StripParams {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if prefix, found := strings.CutSuffix(pattern, "*"); found && strings.HasPrefix(param, prefix) || param == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns canonical form of sanitized url.
+//
+// Scheme and host are lowercased, international host is converted to punycode,
+// default port is removed, dot segments of path are resolved,
+// query parameters are sorted and stripped ones are removed.
+func (n URLNormalizer) Normalize(sanitizedURL string) (string, error) {
+	parsed, err := url.Parse(sanitizedURL)
+	if err != nil {
+		return "", fmt.Errorf("cannot normalize url: %w", err)
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Host != "" {
+		host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+		if net.ParseIP(host) == nil {
+			if host, err = idna.Lookup.ToASCII(host); err != nil {
+				return "", fmt.Errorf("cannot normalize host: %w", err)
+			}
+		}
+		if port := parsed.Port(); port != "" && port != defaultPorts[parsed.Scheme] {
+			parsed.Host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			parsed.Host = "[" + host + "]"
+		} else {
+			parsed.Host = host
+		}
+	}
+	parsed.Path = removeDotSegments(parsed.Path)
+	parsed.RawPath = ""
+
+	query := parsed.Query()
+	for param := range query {
+		if n.stripped(param) {
+			query.Del(param)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	parsed.ForceQuery = false
+	return parsed.String(), nil
+}
+
+// Resolves dot segments keeping trailing slash.
+func removeDotSegments(urlPath string) string {
+	if urlPath == "" {
+		return ""
+	}
+	cleaned := path.Clean(urlPath)
+	if cleaned == "." {
+		cleaned = ""
+	}
+	trailing := strings.HasSuffix(urlPath, "/") || strings.HasSuffix(urlPath, "/.") || strings.HasSuffix(urlPath, "/..")
+	if trailing && !strings.HasSuffix(cleaned, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
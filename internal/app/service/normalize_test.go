@@ -0,0 +1,96 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/mocks"
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+	"github.com/valinurovdenis/urlshortener/internal/app/shortcutgenerator"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+func TestURLNormalizer_Normalize(t *testing.T) {
+	normalizer := service.URLNormalizer{StripParams: []string{"utm_*", " FBCLID "}}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "HTTP://Example.com:80/a/../b?utm_source=x", want: "http://example.com/b"},
+		{url: "http://example.com/b", want: "http://example.com/b"},
+		{url: "https://example.com:443/", want: "https://example.com/"},
+		{url: "https://example.com:8443/x", want: "https://example.com:8443/x"},
+		{url: "http://example.com/a/./b/../c/", want: "http://example.com/a/c/"},
+		{url: "http://example.com/a/b/..", want: "http://example.com/a/"},
+		{url: "http://example.com?b=2&a=1&fbclid=abc&a=0", want: "http://example.com?a=1&a=0&b=2"},
+		{url: "http://example.com/?", want: "http://example.com/"},
+		{url: "http://пример.рф/путь", want: "http://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{url: "http://EXAMPLE.com./#Frag", want: "http://example.com/#Frag"},
+		{url: "http://[::1]:80/", want: "http://[::1]/"},
+		{url: "http://[::1]:81/", want: "http://[::1]:81/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := normalizer.Normalize(tt.url)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	got, err := service.DefaultURLNormalizer.Normalize("http://example.com/?utm_source=x")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/?utm_source=x", got, "parameters are kept by default")
+}
+
+func TestShortenerService_DeduplicatesNormalizedURLs(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	generator := mocks.NewShortCutGenerator(t)
+	generator.On("Generate", mock.Anything, mock.Anything).Return("short", nil).Once()
+	generator.On("Generate", mock.Anything, mock.Anything).Return("other", nil).Twice()
+	s := service.NewShortenerService(storage, storage, nil, generator,
+		service.WithNormalizer(service.URLNormalizer{StripParams: []string{"utm_*"}}))
+	ctx := context.Background()
+
+	shortURL, err := s.GenerateShortURLWithContext(ctx,
+		urlstorage.URLPair{Long: "HTTP://Example.com:80/a/../b?utm_source=x"}, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, "short", shortURL)
+
+	shortURL, err = s.GenerateShortURLWithContext(ctx, urlstorage.URLPair{Long: "http://example.com/b"}, "user_2")
+	require.ErrorIs(t, err, urlstorage.ErrConflictURL)
+	assert.Equal(t, "short", shortURL)
+
+	shortURLs, errs, err := s.GenerateShortURLBatchWithContext(ctx,
+		[]urlstorage.URLPair{{Long: "http://EXAMPLE.com/b?utm_medium=y"}}, "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"short"}, shortURLs)
+	assert.Equal(t, []error{nil}, errs)
+
+	longURL, err := s.GetLongURLWithContext(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "http://Example.com:80/a/../b?utm_source=x", longURL, "sanitized original url is kept for redirect")
+}
+
+func TestShortenerService_DedupPerUser(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	storage.DedupScope = urlstorage.DedupPerUser
+	s := service.NewShortenerService(storage, storage, nil, shortcutgenerator.NewHashGenerator(8, "salt"),
+		service.WithDedupScope(urlstorage.DedupPerUser))
+	ctx := context.Background()
+
+	shortURLs := make(map[string]bool)
+	for i := 0; i < 2*service.MaxGenerateAttempts; i++ {
+		shortURL, err := s.GenerateShortURLWithContext(ctx, urlstorage.URLPair{Long: "http://example.com"}, fmt.Sprintf("user_%d", i))
+		require.NoError(t, err)
+		shortURLs[shortURL] = true
+	}
+	assert.Len(t, shortURLs, 2*service.MaxGenerateAttempts)
+
+	shortURL, err := s.GenerateShortURLWithContext(ctx, urlstorage.URLPair{Long: "http://EXAMPLE.com"}, "user_0")
+	require.ErrorIs(t, err, urlstorage.ErrConflictURL)
+	assert.True(t, shortURLs[shortURL])
+}
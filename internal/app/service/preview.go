@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Description of short url shown before following it.
+type URLPreview struct {
+	ShortURL     string    `json:"short_url"`
+	LongURL      string    `json:"original_url"`
+	CreatedAt    time.Time `json:"created_at,omitzero"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
+	Clicks       int64     `json:"clicks"`
+	Deleted      bool      `json:"deleted,omitempty"`
+	Expired      bool      `json:"expired,omitempty"`
+	Interstitial bool      `json:"interstitial,omitempty"`
+}
+
+// Gets saved url with its options for redirect.
+// Deleted and expired urls give ErrDeletedURL and ErrExpiredURL.
+func (s ShortenerServiceImpl) GetURLWithContext(ctx context.Context, shortURL string) (urlstorage.URLPair, error) {
+	url, err := s.URLStorage.GetURLWithContext(ctx, shortURL)
+	if err != nil {
+		return urlstorage.URLPair{}, fmt.Errorf("no such short url: %w", err)
+	}
+	if url.Deleted {
+		return urlstorage.URLPair{}, ErrDeletedURL
+	}
+	if url.ExpiredAt(time.Now()) {
+		return urlstorage.URLPair{}, ErrExpiredURL
+	}
+	return url.URLPair, nil
+}
+
+// Returns preview of short url, deleted and expired urls are previewed too.
+// Clicks are zero if click storage is nil.
+func (s ShortenerServiceImpl) GetURLPreview(ctx context.Context, shortURL string) (URLPreview, error) {
+	url, err := s.URLStorage.GetURLWithContext(ctx, shortURL)
+	if err != nil {
+		return URLPreview{}, fmt.Errorf("no such short url: %w", err)
+	}
+	preview := URLPreview{
+		ShortURL:     url.Short,
+		LongURL:      url.Long,
+		CreatedAt:    url.CreatedAt,
+		ExpiresAt:    url.ExpiresAt,
+		Deleted:      url.Deleted,
+		Expired:      url.ExpiredAt(time.Now()),
+		Interstitial: url.Interstitial,
+	}
+	if s.ClickStorage != nil {
+		stats, err := s.ClickStorage.GetStatsWithContext(ctx, shortURL)
+		if err != nil {
+			return URLPreview{}, fmt.Errorf("cannot get clicks: %w", err)
+		}
+		preview.Clicks = stats.Total
+	}
+	return preview, nil
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// Error in case redirect code is not redirect status.
+var ErrRedirectCode = errors.New("redirect code must be one of 301, 302, 303, 307, 308")
+
+// Statuses short urls can redirect with.
+var RedirectCodes = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// Default redirect status of short urls.
+const DefaultRedirectCode = http.StatusTemporaryRedirect
+
+// Checks that code is redirect status, zero means default status.
+func ValidateRedirectCode(code int) error {
+	if code != 0 && !slices.Contains(RedirectCodes, code) {
+		return ErrRedirectCode
+	}
+	return nil
+}
+
+// Whether redirect status may be cached by clients.
+func IsPermanentRedirect(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect
+}
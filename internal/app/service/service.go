@@ -6,8 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
 	"github.com/valinurovdenis/urlshortener/internal/app/logger"
 	"github.com/valinurovdenis/urlshortener/internal/app/shortcutgenerator"
 	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
@@ -31,16 +36,34 @@ func SanitizeURL(origURL string) (string, error) {
 
 //go:generate mockery --name ShortenerService
 type ShortenerService interface {
-	// Generating short url.
-	GenerateShortURLWithContext(context context.Context, longURL string, userID string) (string, error)
+	// Generating short url, given short url is used as alias.
+	GenerateShortURLWithContext(context context.Context, userURL urlstorage.URLPair, userID string) (string, error)
 	// Get long url from short.
 	GetLongURLWithContext(context context.Context, shortURL string) (string, error)
-	// Generate short url in batch mode.
-	GenerateShortURLBatchWithContext(context context.Context, longURLs []string, userID string) ([]string, error)
+	// Get saved url with its options for redirect.
+	GetURLWithContext(ctx context.Context, shortURL string) (urlstorage.URLPair, error)
+	// Returns preview of short url including deleted and expired ones.
+	GetURLPreview(ctx context.Context, shortURL string) (URLPreview, error)
+	// Generate short url in batch mode, given short urls are used as aliases.
+	GenerateShortURLBatchWithContext(context context.Context, userURLs []urlstorage.URLPair, userID string) ([]string, []error, error)
 	// Returns all user urls.
 	GetUserURLs(context context.Context, userID string) ([]urlstorage.URLPair, error)
-	// Deletes all user urls.
-	DeleteUserURLs(ctx context.Context, userID string, shortURLs ...string) error
+	// Returns page of user urls ordered by creation time.
+	GetUserURLsPage(ctx context.Context, userID string, query urlstorage.UserURLsQuery) (urlstorage.UserURLsPage, error)
+	// Schedules deletion of user urls, returns deletion job id.
+	DeleteUserURLs(ctx context.Context, userID string, shortURLs ...string) (int64, error)
+	// Returns deletion job created by user.
+	GetDeletionJob(ctx context.Context, userID string, id int64) (deletionstorage.Job, error)
+	// Registers redirect by short url.
+	RecordClick(click clickstorage.Click)
+	// Returns click statistics of short url saved by user.
+	GetURLStats(ctx context.Context, userID string, shortURL string) (clickstorage.ClickStats, error)
+	// Returns number of stored urls and users.
+	GetStats(ctx context.Context) (Stats, error)
+	// Points short url saved by user to new long url.
+	UpdateUserURL(ctx context.Context, userID string, shortURL string, longURL string) (urlstorage.URLChange, error)
+	// Returns changes of short url saved by user.
+	GetURLHistory(ctx context.Context, userID string, shortURL string) ([]urlstorage.URLChange, error)
 	// Check whether service is alive.
 	Ping() error
 }
@@ -49,41 +72,172 @@ type ShortenerService interface {
 type ShortenerServiceImpl struct {
 	URLStorage     urlstorage.URLStorage
 	UserURLStorage urlstorage.UserURLStorage
+	ClickStorage   clickstorage.ClickStorage
 	Generator      shortcutgenerator.ShortCutGenerator
-	deleteChan     chan urlstorage.URLsForDelete
+	AliasPolicy    AliasPolicy
+	URLPolicy      URLPolicy
+	Normalizer     URLNormalizer
+	DedupScope     urlstorage.DedupScope
+	Deletions      deletionstorage.DeletionStorage
+	deletion       DeletionSettings
+	clickChan      chan clickstorage.Click
 	Stop           func()
 	Stopped        chan struct{}
 }
 
+// Option of shortener service that must be set before its background workers start.
+type Option func(*ShortenerServiceImpl)
+
+// Sets storage of deletion jobs, in memory storage is used by default.
+func WithDeletionStorage(storage deletionstorage.DeletionStorage) Option {
+	return func(s *ShortenerServiceImpl) {
+		s.Deletions = storage
+	}
+}
+
+// Sets flushing and retrying of deletion jobs, DefaultDeletionSettings are used by default.
+// Non-positive settings are replaced by default ones, zero retry backoff retries at once.
+func WithDeletionSettings(settings DeletionSettings) Option {
+	return func(s *ShortenerServiceImpl) {
+		s.deletion = settings.withDefaults()
+	}
+}
+
+// Sets policy of custom short urls, DefaultAliasPolicy is used by default.
+func WithAliasPolicy(policy AliasPolicy) Option {
+	return func(s *ShortenerServiceImpl) {
+		s.AliasPolicy = policy
+	}
+}
+
+// Sets policy of long urls, DefaultURLPolicy is used by default.
+func WithURLPolicy(policy URLPolicy) Option {
+	return func(s *ShortenerServiceImpl) {
+		s.URLPolicy = policy
+	}
+}
+
+// Sets normalizer of long urls, DefaultURLNormalizer is used by default.
+func WithNormalizer(normalizer URLNormalizer) Option {
+	return func(s *ShortenerServiceImpl) {
+		s.Normalizer = normalizer
+	}
+}
+
+// Sets scope in which equal long urls share short url, global scope is used by default.
+//
+// Scope must match dedup scope of url storage.
+func WithDedupScope(scope urlstorage.DedupScope) Option {
+	return func(s *ShortenerServiceImpl) {
+		s.DedupScope = scope
+	}
+}
+
+// Size of click buffer, clicks exceeding it are dropped.
+const clickBufferSize = 4096
+
+// Maximal number of clicks saved at once.
+const clickBatchSize = 512
+
 // New shortener service that facades storages and short url generator.
-func NewShortenerService(storage urlstorage.URLStorage, userStorage urlstorage.UserURLStorage, generator shortcutgenerator.ShortCutGenerator) *ShortenerServiceImpl {
+// Clicks are not recorded if click storage is nil.
+func NewShortenerService(storage urlstorage.URLStorage, userStorage urlstorage.UserURLStorage, clickStorage clickstorage.ClickStorage, generator shortcutgenerator.ShortCutGenerator, opts ...Option) *ShortenerServiceImpl {
 	ctx, stop := context.WithCancel(context.Background())
 	ret := &ShortenerServiceImpl{
 		URLStorage:     storage,
 		UserURLStorage: userStorage,
+		ClickStorage:   clickStorage,
 		Generator:      generator,
-		deleteChan:     make(chan urlstorage.URLsForDelete, 1024),
+		AliasPolicy:    DefaultAliasPolicy,
+		URLPolicy:      DefaultURLPolicy,
+		Normalizer:     DefaultURLNormalizer,
+		Deletions:      deletionstorage.NewSimpleDeletionStorage(),
+		deletion:       DefaultDeletionSettings,
+		clickChan:      make(chan clickstorage.Click, clickBufferSize),
 		Stop:           stop,
 		Stopped:        make(chan struct{}, 1),
 	}
-	go ret.FlushDeletedUserURLs(ctx)
+	for _, opt := range opts {
+		opt(ret)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ret.FlushDeletedUserURLs(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		ret.FlushClicks(ctx)
+	}()
+	go func() {
+		wg.Wait()
+		close(ret.Stopped)
+	}()
 	return ret
 }
 
+// Number of attempts to generate short url not taken by another url.
+const MaxGenerateAttempts = 5
+
+// Returns validated alias or newly generated short url if alias is empty.
+// Attempt is number of previous collisions of generated url.
+func (s ShortenerServiceImpl) shortURLFor(alias string, longURL string, attempt int) (string, error) {
+	if alias != "" {
+		return alias, s.AliasPolicy.Validate(alias)
+	}
+	shortURL, err := s.Generator.Generate(longURL, attempt)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate new url: %w", err)
+	}
+	return shortURL, nil
+}
+
+// Returns string from which short url of user is generated.
+//
+// Urls that are not deduplicated together get different inputs, so that deterministic
+// generators do not run out of attempts for them.
+func (s ShortenerServiceImpl) generatorInput(longURL string, userID string) string {
+	switch s.DedupScope {
+	case urlstorage.DedupPerUser:
+		key, _ := s.DedupScope.Key(userID, longURL)
+		return key
+	case urlstorage.DedupNone:
+		return longURL + " " + strconv.FormatInt(time.Now().UnixNano(), 10)
+	default:
+		return longURL
+	}
+}
+
 // Generates shortURL from longURL for given user.
-func (s ShortenerServiceImpl) GenerateShortURLWithContext(context context.Context, longURL string, userID string) (string, error) {
-	longURL, err := SanitizeURL(longURL)
+// Short url of given pair is used as alias if not empty.
+// Generated short url is regenerated up to MaxGenerateAttempts times if it is taken.
+func (s ShortenerServiceImpl) GenerateShortURLWithContext(context context.Context, userURL urlstorage.URLPair, userID string) (string, error) {
+	longURL, normalizedURL, err := s.prepareLongURL(userURL.Long)
 	if err != nil {
 		return "", err
 	}
+	if err = ValidateRedirectCode(userURL.RedirectCode); err != nil {
+		return "", err
+	}
+	userURL.NormalizedURL = normalizedURL
 
-	shortURL, err := s.Generator.Generate()
-	if err != nil {
-		return "", fmt.Errorf("cannot generate new url: %w", err)
+	var shortURL string
+	for attempt := 0; attempt < MaxGenerateAttempts; attempt++ {
+		shortURL, err = s.shortURLFor(userURL.Short, s.generatorInput(longURL, userID), attempt)
+		if err != nil {
+			return "", err
+		}
+		err = s.URLStorage.StoreWithContext(context, longURL, shortURL, userID, userURL.URLOptions)
+		if !errors.Is(err, urlstorage.ErrShortURLTaken) {
+			break
+		}
+		if userURL.Short != "" {
+			return "", ErrAliasTaken
+		}
 	}
-	err = s.URLStorage.StoreWithContext(context, longURL, shortURL, userID)
 	if errors.Is(err, urlstorage.ErrConflictURL) {
-		existingShortURL, errGet := s.URLStorage.GetShortURLWithContext(context, longURL)
+		existingShortURL, errGet := s.URLStorage.GetShortURLWithContext(context, userURL.DedupKey(longURL), userID)
 		if errGet == nil {
 			return existingShortURL, urlstorage.ErrConflictURL
 		}
@@ -99,12 +253,18 @@ func (s ShortenerServiceImpl) GenerateShortURLWithContext(context context.Contex
 // Error in case of long url is already deleted.
 var ErrDeletedURL = errors.New("conflict long url")
 
+// Error in case of short url has expired.
+var ErrExpiredURL = errors.New("url has expired")
+
 // Gets longURL from shortURL.
 func (s ShortenerServiceImpl) GetLongURLWithContext(context context.Context, shortURL string) (string, error) {
 	longURL, err := s.URLStorage.GetLongURLWithContext(context, shortURL)
 	if errors.Is(err, urlstorage.ErrDeletedURL) {
 		return "", ErrDeletedURL
 	}
+	if errors.Is(err, urlstorage.ErrExpiredURL) {
+		return "", ErrExpiredURL
+	}
 	if err != nil {
 		return "", fmt.Errorf("no such short url: %w", err)
 	}
@@ -112,77 +272,226 @@ func (s ShortenerServiceImpl) GetLongURLWithContext(context context.Context, sho
 }
 
 // Generates batch of shortURLs for user.
-func (s ShortenerServiceImpl) GenerateShortURLBatchWithContext(context context.Context, longURLs []string, userID string) ([]string, error) {
-	var shortURLs []string
-	var urls2Store []urlstorage.URLPair
-	for _, longURL := range longURLs {
-		sanitizedLongURL, err := SanitizeURL(longURL)
+// Short urls of given pairs are used as aliases if not empty.
+//
+// Returns error for every url that cannot be saved, such urls get empty short url.
+// Urls saved before are not errors, their existing short urls are returned.
+// Whole batch is rejected with *PolicyViolation if any url violates url policy.
+func (s ShortenerServiceImpl) GenerateShortURLBatchWithContext(context context.Context, userURLs []urlstorage.URLPair, userID string) ([]string, []error, error) {
+	sanitizedLongURLs := make([]string, len(userURLs))
+	normalizedURLs := make([]string, len(userURLs))
+	for i, userURL := range userURLs {
+		sanitizedLongURL, normalizedURL, err := s.prepareLongURL(userURL.Long)
+		var violation *PolicyViolation
+		if errors.As(err, &violation) {
+			violation.Index = i
+		}
+		if err == nil {
+			err = ValidateRedirectCode(userURL.RedirectCode)
+		}
 		if err != nil {
-			return []string{}, err
+			return []string{}, nil, err
 		}
+		sanitizedLongURLs[i] = sanitizedLongURL
+		normalizedURLs[i] = normalizedURL
+	}
 
-		shortURL, err := s.Generator.Generate()
+	var shortURLs []string
+	var urls2Store []urlstorage.URLPair
+	for i, userURL := range userURLs {
+		shortURL, err := s.shortURLFor(userURL.Short, s.generatorInput(sanitizedLongURLs[i], userID), 0)
 		if err != nil {
-			return nil, errors.New("cannot generate new short url")
+			return nil, nil, err
 		}
-		userURL := urlstorage.URLPair{Short: shortURL, Long: sanitizedLongURL}
-		urls2Store = append(urls2Store, userURL)
+		options := userURL.URLOptions
+		options.NormalizedURL = normalizedURLs[i]
+		urls2Store = append(urls2Store, urlstorage.URLPair{
+			Short: shortURL, Long: sanitizedLongURLs[i], URLOptions: options})
 		shortURLs = append(shortURLs, shortURL)
 	}
+	errs, err := s.storeManyWithRetries(context, urls2Store, userURLs, userID)
+	if err != nil {
+		return []string{}, nil, err
+	}
+	resultErrs := make([]error, len(urls2Store))
+	for i := range urls2Store {
+		if errs[i] == nil {
+			shortURLs[i] = urls2Store[i].Short
+			continue
+		}
+		shortURLs[i] = ""
+		if errors.Is(errs[i], urlstorage.ErrShortURLTaken) && userURLs[i].Short != "" {
+			resultErrs[i] = ErrAliasTaken
+			continue
+		}
+		shortURL, err := s.URLStorage.GetShortURLWithContext(context, urls2Store[i].DedupKey(urls2Store[i].Long), userID)
+		if err == nil {
+			shortURLs[i] = shortURL
+		} else {
+			resultErrs[i] = errs[i]
+		}
+	}
+	return shortURLs, resultErrs, nil
+}
+
+// Stores urls regenerating taken generated short urls up to MaxGenerateAttempts times.
+// Returns store error for every url.
+func (s ShortenerServiceImpl) storeManyWithRetries(context context.Context, urls2Store []urlstorage.URLPair, userURLs []urlstorage.URLPair, userID string) ([]error, error) {
 	errs, err := s.URLStorage.StoreManyWithContext(context, urls2Store, userID)
 	if err != nil {
-		return []string{}, err
-	}
-	for i, longURL := range longURLs {
-		if errs[i] != nil {
-			shortURL, err := s.URLStorage.GetShortURLWithContext(context, longURL)
-			if err == nil {
-				shortURLs[i] = shortURL
-			} else {
-				shortURLs[i] = ""
+		return nil, err
+	}
+	for attempt := 1; attempt < MaxGenerateAttempts; attempt++ {
+		var retried []int
+		var retryURLs []urlstorage.URLPair
+		for i := range urls2Store {
+			if !errors.Is(errs[i], urlstorage.ErrShortURLTaken) || userURLs[i].Short != "" {
+				continue
+			}
+			shortURL, err := s.shortURLFor("", s.generatorInput(urls2Store[i].Long, userID), attempt)
+			if err != nil {
+				return nil, err
 			}
+			urls2Store[i].Short = shortURL
+			retried = append(retried, i)
+			retryURLs = append(retryURLs, urls2Store[i])
+		}
+		if len(retried) == 0 {
+			break
+		}
+		retryErrs, err := s.URLStorage.StoreManyWithContext(context, retryURLs, userID)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range retried {
+			errs[i] = retryErrs[j]
 		}
 	}
-	return shortURLs, nil
+	return errs, nil
 }
 
-// Deletes given user urls.
-func (s ShortenerServiceImpl) DeleteUserURLs(ctx context.Context, userID string, shortURLs ...string) error {
-	urls := urlstorage.URLsForDelete{UserID: userID, ShortURLs: shortURLs}
-	s.deleteChan <- urls
-	return nil
+// Registers redirect by short url.
+// Click is dropped if click buffer is full.
+func (s ShortenerServiceImpl) RecordClick(click clickstorage.Click) {
+	if s.ClickStorage == nil {
+		return
+	}
+	select {
+	case s.clickChan <- click:
+	default:
+		logger.Log.Warn("click buffer is full, click dropped", zap.String("short_url", click.ShortURL))
+	}
 }
 
-// Collects urls for deleting.
-// Calls deleting function for collected urls every 10 seconds.
-func (s ShortenerServiceImpl) FlushDeletedUserURLs(ctx context.Context) {
-	ticker := time.NewTicker(10 * time.Second)
+// Saves clicks collected since last flush.
+func (s ShortenerServiceImpl) saveClicks(clicks []clickstorage.Click) {
+	if err := s.ClickStorage.StoreClicksWithContext(context.TODO(), clicks...); err != nil {
+		logger.Log.Error("cannot save clicks", zap.Error(err))
+	}
+}
+
+// Collects recorded clicks.
+// Saves collected clicks every second or as soon as batch is full.
+func (s ShortenerServiceImpl) FlushClicks(ctx context.Context) {
+	if s.ClickStorage == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-	var urlsByUser []urlstorage.URLsForDelete
+	var clicks []clickstorage.Click
 
 	for {
 		select {
 		case <-ctx.Done():
-			fmt.Println("done")
-			if len(urlsByUser) != 0 {
-				if err := s.UserURLStorage.DeleteUserURLs(context.TODO(), urlsByUser...); err != nil {
-					logger.Log.Error("cannot delete urls", zap.Error(err))
-				}
+			for len(s.clickChan) != 0 {
+				clicks = append(clicks, <-s.clickChan)
+			}
+			for batch := range slices.Chunk(clicks, clickBatchSize) {
+				s.saveClicks(batch)
 			}
-			close(s.Stopped)
 			return
-		case urls := <-s.deleteChan:
-			urlsByUser = append(urlsByUser, urls)
+		case click := <-s.clickChan:
+			clicks = append(clicks, click)
+			if len(clicks) >= clickBatchSize {
+				s.saveClicks(clicks)
+				clicks = nil
+			}
 		case <-ticker.C:
-			if len(urlsByUser) == 0 {
-				continue
+			if len(clicks) != 0 {
+				s.saveClicks(clicks)
+				clicks = nil
 			}
-			err := s.UserURLStorage.DeleteUserURLs(context.TODO(), urlsByUser...)
+		}
+	}
+}
+
+// Error in case short url is not saved by user.
+var ErrNotUserURL = errors.New("url is not saved by user")
+
+// Returns click statistics of short url saved by user.
+func (s ShortenerServiceImpl) GetURLStats(ctx context.Context, userID string, shortURL string) (clickstorage.ClickStats, error) {
+	owns, err := s.UserURLStorage.OwnsURLWithContext(ctx, userID, shortURL)
+	if err != nil {
+		return clickstorage.ClickStats{}, fmt.Errorf("cannot get url owner: %w", err)
+	}
+	if !owns {
+		return clickstorage.ClickStats{}, ErrNotUserURL
+	}
+	if s.ClickStorage == nil {
+		return clickstorage.ClickStats{Daily: []clickstorage.DailyClicks{}}, nil
+	}
+	return s.ClickStorage.GetStatsWithContext(ctx, shortURL)
+}
+
+// Overall service statistics.
+type Stats struct {
+	URLs  int64 `json:"urls"`
+	Users int64 `json:"users"`
+}
+
+// Returns number of stored urls and users.
+func (s ShortenerServiceImpl) GetStats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	var err error
+	if stats.URLs, err = s.URLStorage.CountURLsWithContext(ctx); err != nil {
+		return stats, fmt.Errorf("cannot count urls: %w", err)
+	}
+	if stats.Users, err = s.UserURLStorage.CountUsersWithContext(ctx); err != nil {
+		return stats, fmt.Errorf("cannot count users: %w", err)
+	}
+	return stats, nil
+}
+
+// Purges urls expired more than gracePeriod ago every interval.
+// Until purged expired urls are answered as gone.
+func (s ShortenerServiceImpl) SweepExpiredURLs(ctx context.Context, interval time.Duration, gracePeriod time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.URLStorage.PurgeExpiredWithContext(ctx, time.Now().Add(-gracePeriod))
 			if err != nil {
-				logger.Log.Error("cannot delete urls", zap.Error(err))
+				logger.Log.Error("cannot purge expired urls", zap.Error(err))
+				continue
+			}
+			if len(purged) == 0 {
 				continue
 			}
-			urlsByUser = nil
+			logger.Log.Info("purged expired urls", zap.Int("count", len(purged)))
+			// Short url of purged url may be saved again, so its clicks must not be counted for new url.
+			if s.ClickStorage != nil {
+				if err = s.ClickStorage.DeleteClicksWithContext(ctx, purged...); err != nil {
+					logger.Log.Error("cannot delete clicks of purged urls", zap.Error(err))
+				}
+			}
 		}
 	}
 }
@@ -200,3 +509,15 @@ func (s ShortenerServiceImpl) Ping() error {
 func (s ShortenerServiceImpl) GetUserURLs(context context.Context, userID string) ([]urlstorage.URLPair, error) {
 	return s.UserURLStorage.GetUserURLs(context, userID)
 }
+
+// Maximal size of page of user urls.
+const MaxPageSize = 1000
+
+// Returns page of user urls ordered by creation time.
+// All urls are returned if limit is not positive, otherwise limit is bounded by MaxPageSize.
+func (s ShortenerServiceImpl) GetUserURLsPage(ctx context.Context, userID string, query urlstorage.UserURLsQuery) (urlstorage.UserURLsPage, error) {
+	if query.Limit > 0 {
+		query.Limit = min(query.Limit, MaxPageSize)
+	}
+	return s.UserURLStorage.GetUserURLsPageWithContext(ctx, userID, query)
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/clickstorage"
+	"github.com/valinurovdenis/urlshortener/internal/app/deletionstorage"
 	"github.com/valinurovdenis/urlshortener/internal/app/mocks"
 	"github.com/valinurovdenis/urlshortener/internal/app/service"
 	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
@@ -40,13 +43,13 @@ func TestSanitizeURL(t *testing.T) {
 
 func TestShortenerService_GenerateShortURL(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
-	mockGenerator.On("Generate").Return("non-existing", nil).Twice()
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("non-existing", nil).Twice()
 	mockStorage := mocks.NewURLStorage(t)
-	mockStorage.On("GetShortURLWithContext", mock.Anything, "http://existing.ru").Return("existing", nil).Once()
-	mockStorage.On("StoreWithContext", mock.Anything, "http://non-existing.ru", "non-existing", "").Return(nil).Once()
-	mockStorage.On("StoreWithContext", mock.Anything, "http://existing.ru", "non-existing", "").Return(urlstorage.ErrConflictURL).Once()
+	mockStorage.On("GetShortURLWithContext", mock.Anything, "http://existing.ru", mock.Anything).Return("existing", nil).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://non-existing.ru", "non-existing", "", urlstorage.URLOptions{}).Return(nil).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://existing.ru", "non-existing", "", urlstorage.URLOptions{}).Return(urlstorage.ErrConflictURL).Once()
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	service := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	service := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	testCases := []struct {
 		name          string
 		longURL       string
@@ -60,13 +63,42 @@ func TestShortenerService_GenerateShortURL(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			shortURL, err := service.GenerateShortURLWithContext(context.Background(), tc.longURL, "")
+			shortURL, err := service.GenerateShortURLWithContext(context.Background(), urlstorage.URLPair{Long: tc.longURL}, "")
 			require.Equal(t, tc.expectedError, err, "Ошибка не совпадает")
 			require.Equal(t, tc.expectedShort, shortURL, "Короткий урл не совпадает")
 		})
 	}
 }
 
+func TestShortenerService_GenerateShortURLWithAlias(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("StoreWithContext", mock.Anything, "http://free.ru", "free", "", urlstorage.URLOptions{}).Return(nil).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://taken.ru", "taken", "", urlstorage.URLOptions{}).Return(urlstorage.ErrShortURLTaken).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	testCases := []struct {
+		name          string
+		longURL       string
+		alias         string
+		expectedShort string
+		expectedError error
+	}{
+		{name: "free", longURL: "free.ru", alias: "free", expectedShort: "free", expectedError: nil},
+		{name: "taken", longURL: "taken.ru", alias: "taken", expectedShort: "", expectedError: service.ErrAliasTaken},
+		{name: "reserved", longURL: "reserved.ru", alias: "api", expectedShort: "", expectedError: service.ErrInvalidAlias},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			shortURL, err := shortenerService.GenerateShortURLWithContext(context.Background(),
+				urlstorage.URLPair{Long: tc.longURL, Short: tc.alias}, "")
+			require.ErrorIs(t, err, tc.expectedError)
+			require.Equal(t, tc.expectedShort, shortURL)
+		})
+	}
+}
+
 func TestShortenerService_GetShortURL(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
 	mockStorage := mocks.NewURLStorage(t)
@@ -74,7 +106,7 @@ func TestShortenerService_GetShortURL(t *testing.T) {
 	mockStorage.On("GetLongURLWithContext", mock.Anything, "existing").Return("existing.ru", nil).Once()
 	mockStorage.On("GetLongURLWithContext", mock.Anything, "non-existing").Return("", storageErr).Once()
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	service := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	service := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	testCases := []struct {
 		name          string
 		shortURL      string
@@ -95,6 +127,46 @@ func TestShortenerService_GetShortURL(t *testing.T) {
 	}
 }
 
+func TestShortenerService_GetExpiredURL(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("GetLongURLWithContext", mock.Anything, "expired").Return("", urlstorage.ErrExpiredURL).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	_, err := shortenerService.GetLongURLWithContext(context.Background(), "expired")
+	require.Equal(t, service.ErrExpiredURL, err)
+}
+
+func TestShortenerService_SweepExpiredURLs(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	gracePeriod := time.Hour
+	swept := make(chan struct{}, 1)
+	mockStorage.On("PurgeExpiredWithContext", mock.Anything, mock.MatchedBy(func(before time.Time) bool {
+		return time.Until(before) < -gracePeriod+time.Minute
+	})).Return([]string{"a"}, nil)
+	mockClickStorage := mocks.NewClickStorage(t)
+	mockClickStorage.On("DeleteClicksWithContext", mock.Anything, "a").Return(nil).Run(func(mock.Arguments) {
+		select {
+		case swept <- struct{}{}:
+		default:
+		}
+	})
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	service := service.NewShortenerService(mockStorage, mockUserStorage, mockClickStorage, mockGenerator)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.SweepExpiredURLs(ctx, time.Millisecond, gracePeriod)
+		close(done)
+	}()
+	<-swept
+	cancel()
+	<-done
+}
+
 func TestShortenerService_GetUserURLs(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
 	mockStorage := mocks.NewURLStorage(t)
@@ -103,7 +175,7 @@ func TestShortenerService_GetUserURLs(t *testing.T) {
 	notEmptyURLs := []urlstorage.URLPair{{Short: "short", Long: "long"}}
 	mockUserStorage.On("GetUserURLs", mock.Anything, "user_1").Return(emptyUrls, nil).Once()
 	mockUserStorage.On("GetUserURLs", mock.Anything, "user_2").Return(notEmptyURLs, nil).Once()
-	service := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	service := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	tests := []struct {
 		name string
 		user string
@@ -125,9 +197,10 @@ func TestShortenerService_DeleteUserURLs(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
 	mockStorage := mocks.NewURLStorage(t)
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	service := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
-	err := service.DeleteUserURLs(context.Background(), "user_1")
+	service := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	id, err := service.DeleteUserURLs(context.Background(), "user_1")
 	require.NoError(t, err)
+	assert.Equal(t, int64(1), id)
 }
 
 func TestShortenerService_Ping(t *testing.T) {
@@ -136,18 +209,18 @@ func TestShortenerService_Ping(t *testing.T) {
 	mockStorage.On("Ping").Return(nil).Once()
 	mockUserStorage := mocks.NewUserURLStorage(t)
 	mockUserStorage.On("Ping").Return(nil).Once()
-	service := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	service := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 	err := service.Ping()
 	require.NoError(t, err)
 }
 
 func TestShortenerService_GenerateShortURLBatchWithContext(t *testing.T) {
 	mockGenerator := mocks.NewShortCutGenerator(t)
-	mockGenerator.On("Generate").Return("short", nil).Times(4)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("short", nil).Times(4)
 	mockStorage := mocks.NewURLStorage(t)
-	mockStorage.On("GetShortURLWithContext", mock.Anything, "long").Return("old_short", nil).Once()
+	mockStorage.On("GetShortURLWithContext", mock.Anything, "http://long", mock.Anything).Return("old_short", nil).Once()
 	mockUserStorage := mocks.NewUserURLStorage(t)
-	service := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	service := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 
 	tests := []struct {
 		name string
@@ -161,9 +234,10 @@ func TestShortenerService_GenerateShortURLBatchWithContext(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockStorage.On("StoreManyWithContext", mock.Anything, mock.Anything, "user_1").Return(tt.errs, nil).Once()
-			res, err := service.GenerateShortURLBatchWithContext(context.Background(), []string{"long", "long"}, "user_1")
+			res, errs, err := service.GenerateShortURLBatchWithContext(context.Background(), []urlstorage.URLPair{{Long: "long"}, {Long: "long"}}, "user_1")
 			require.NoError(t, err)
 			require.Equal(t, tt.want, res)
+			require.Equal(t, []error{nil, nil}, errs)
 		})
 	}
 }
@@ -174,12 +248,345 @@ func TestShortenerServiceImpl_FlushDeletedUserURLs(t *testing.T) {
 	mockUserStorage := mocks.NewUserURLStorage(t)
 	mockUserStorage.On("DeleteUserURLs", mock.Anything,
 		urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"short"}}).Return(nil).Once()
-	service := service.NewShortenerService(mockStorage, mockUserStorage, mockGenerator)
+	service := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
 
-	err := service.DeleteUserURLs(context.Background(), "user_1", "short")
+	id, err := service.DeleteUserURLs(context.Background(), "user_1", "short")
 	require.NoError(t, err)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	service.FlushDeletedUserURLs(ctx)
+	service.Stop()
+	<-service.Stopped
+	job, err := service.GetDeletionJob(context.Background(), "user_1", id)
+	require.NoError(t, err)
+	assert.Equal(t, deletionstorage.StatusDone, job.Status)
+	assert.Equal(t, 1, job.Attempts)
+}
+
+func TestShortenerService_GenerateShortURLBatchWithAlias(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", mock.Anything, mock.Anything).Return("short", nil).Once()
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("StoreManyWithContext", mock.Anything, []urlstorage.URLPair{
+		{Long: "http://long1", Short: "short"},
+		{Long: "http://long2", Short: "taken"}}, "user_1").
+		Return([]error{nil, urlstorage.ErrShortURLTaken}, nil).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	res, errs, err := shortenerService.GenerateShortURLBatchWithContext(context.Background(),
+		[]urlstorage.URLPair{{Long: "long1"}, {Long: "long2", Short: "taken"}}, "user_1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"short", ""}, res)
+	require.Equal(t, []error{nil, service.ErrAliasTaken}, errs)
+
+	_, _, err = shortenerService.GenerateShortURLBatchWithContext(context.Background(),
+		[]urlstorage.URLPair{{Long: "long1", Short: "a"}}, "user_1")
+	require.ErrorIs(t, err, service.ErrInvalidAlias)
+}
+
+func TestShortenerServiceImpl_FlushClicks(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockClickStorage := mocks.NewClickStorage(t)
+	click := clickstorage.Click{Time: time.Now(), ShortURL: "short", IP: "127.0.0.1"}
+	mockClickStorage.On("StoreClicksWithContext", mock.Anything, click).Return(nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockClickStorage, mockGenerator)
+
+	shortenerService.RecordClick(click)
+	shortenerService.Stop()
+	<-shortenerService.Stopped
+}
+
+func TestShortenerServiceImpl_GetURLStats(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "user_1", "short").Return(true, nil).Once()
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "user_1", "other").Return(false, nil).Once()
+	mockClickStorage := mocks.NewClickStorage(t)
+	stats := clickstorage.ClickStats{Total: 1, Daily: []clickstorage.DailyClicks{{Day: "2024-03-01", Count: 1}}}
+	mockClickStorage.On("GetStatsWithContext", mock.Anything, "short").Return(stats, nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, mockClickStorage, mockGenerator)
+
+	got, err := shortenerService.GetURLStats(context.Background(), "user_1", "short")
+	require.NoError(t, err)
+	require.Equal(t, stats, got)
+
+	_, err = shortenerService.GetURLStats(context.Background(), "user_1", "other")
+	require.ErrorIs(t, err, service.ErrNotUserURL)
+}
+
+func TestShortenerServiceImpl_GetStats(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("CountURLsWithContext", mock.Anything).Return(int64(10), nil).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("CountUsersWithContext", mock.Anything).Return(int64(3), nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	stats, err := shortenerService.GetStats(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, service.Stats{URLs: 10, Users: 3}, stats)
+}
+
+func TestShortenerServiceImpl_DeletionQueueLength(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("DeleteUserURLs", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	_, err := shortenerService.DeleteUserURLs(context.Background(), "user_1", "a")
+	require.NoError(t, err)
+	_, err = shortenerService.DeleteUserURLs(context.Background(), "user_2", "b")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), shortenerService.DeletionQueueLength())
+
+	shortenerService.Stop()
+	<-shortenerService.Stopped
+	require.Equal(t, int64(0), shortenerService.DeletionQueueLength())
+}
+
+func TestShortenerService_GenerateShortURLRetries(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", "http://long", 0).Return("taken", nil).Once()
+	mockGenerator.On("Generate", "http://long", 1).Return("free", nil).Once()
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("StoreWithContext", mock.Anything, "http://long", "taken", "", urlstorage.URLOptions{}).
+		Return(urlstorage.ErrShortURLTaken).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://long", "free", "", urlstorage.URLOptions{}).
+		Return(nil).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	shortURL, err := shortenerService.GenerateShortURLWithContext(context.Background(), urlstorage.URLPair{Long: "long"}, "")
+	require.NoError(t, err)
+	require.Equal(t, "free", shortURL)
+}
+
+func TestShortenerService_GenerateShortURLRetriesExhausted(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", "http://long", mock.Anything).Return("taken", nil).Times(service.MaxGenerateAttempts)
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("StoreWithContext", mock.Anything, "http://long", "taken", "", urlstorage.URLOptions{}).
+		Return(urlstorage.ErrShortURLTaken).Times(service.MaxGenerateAttempts)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	_, err := shortenerService.GenerateShortURLWithContext(context.Background(), urlstorage.URLPair{Long: "long"}, "")
+	require.ErrorIs(t, err, urlstorage.ErrShortURLTaken)
+}
+
+func TestShortenerService_GenerateShortURLBatchRetries(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockGenerator.On("Generate", "http://long1", 0).Return("short1", nil).Once()
+	mockGenerator.On("Generate", "http://long2", 0).Return("taken", nil).Once()
+	mockGenerator.On("Generate", "http://long2", 1).Return("short2", nil).Once()
+	mockStorage := mocks.NewURLStorage(t)
+	mockStorage.On("StoreManyWithContext", mock.Anything, []urlstorage.URLPair{
+		{Long: "http://long1", Short: "short1"},
+		{Long: "http://long2", Short: "taken"}}, "user_1").
+		Return([]error{nil, urlstorage.ErrShortURLTaken}, nil).Once()
+	mockStorage.On("StoreManyWithContext", mock.Anything, []urlstorage.URLPair{
+		{Long: "http://long2", Short: "short2"}}, "user_1").
+		Return([]error{nil}, nil).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	res, errs, err := shortenerService.GenerateShortURLBatchWithContext(context.Background(),
+		[]urlstorage.URLPair{{Long: "long1"}, {Long: "long2"}}, "user_1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"short1", "short2"}, res)
+	require.Equal(t, []error{nil, nil}, errs)
+}
+
+func TestShortenerServiceImpl_GetDeletionJob(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockDeletions := mocks.NewDeletionStorage(t)
+	mockDeletions.On("GetJobWithContext", mock.Anything, int64(1)).Return(deletionstorage.Job{ID: 1, UserID: "user_1"}, nil)
+	mockDeletions.On("GetJobWithContext", mock.Anything, int64(2)).Return(deletionstorage.Job{}, deletionstorage.ErrNoSuchJob)
+	mockDeletions.On("GetDueJobsWithContext", mock.Anything, mock.Anything, mock.Anything).Return(nil, nil).Maybe()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator,
+		service.WithDeletionStorage(mockDeletions))
+	defer func() {
+		shortenerService.Stop()
+		<-shortenerService.Stopped
+	}()
+
+	tests := []struct {
+		name    string
+		user    string
+		id      int64
+		wantErr error
+	}{
+		{name: "own_job", user: "user_1", id: 1, wantErr: nil},
+		{name: "other_user_job", user: "user_2", id: 1, wantErr: service.ErrNoSuchDeletionJob},
+		{name: "unknown_job", user: "user_1", id: 2, wantErr: service.ErrNoSuchDeletionJob},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := shortenerService.GetDeletionJob(context.Background(), tt.user, tt.id)
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, tt.id, job.ID)
+			}
+		})
+	}
+}
+
+func TestShortenerServiceImpl_DeletionRetries(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("DeleteUserURLs", mock.Anything, mock.Anything).Return(errors.New("db is down")).Times(3)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator,
+		service.WithDeletionSettings(service.DeletionSettings{
+			FlushInterval: 5 * time.Millisecond,
+			BatchSize:     10,
+			MaxAttempts:   3,
+			RetryBackoff:  time.Millisecond,
+		}))
+
+	id, err := shortenerService.DeleteUserURLs(context.Background(), "user_1", "a")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := shortenerService.GetDeletionJob(context.Background(), "user_1", id)
+		return err == nil && job.Status == deletionstorage.StatusFailed
+	}, time.Second, 5*time.Millisecond)
+	shortenerService.Stop()
+	<-shortenerService.Stopped
+
+	job, err := shortenerService.GetDeletionJob(context.Background(), "user_1", id)
+	require.NoError(t, err)
+	assert.Equal(t, 3, job.Attempts)
+	assert.Equal(t, "db is down", job.LastError)
+	assert.Equal(t, int64(0), shortenerService.DeletionQueueLength())
+}
+
+func TestShortenerServiceImpl_PurgeDeletionJobs(t *testing.T) {
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("DeleteUserURLs", mock.Anything, mock.Anything).Return(nil).Once()
+	shortenerService := service.NewShortenerService(mocks.NewURLStorage(t), mockUserStorage, nil, mocks.NewShortCutGenerator(t),
+		service.WithDeletionSettings(service.DeletionSettings{
+			FlushInterval: 5 * time.Millisecond,
+			Retention:     20 * time.Millisecond,
+		}))
+	defer func() {
+		shortenerService.Stop()
+		<-shortenerService.Stopped
+	}()
+
+	id, err := shortenerService.DeleteUserURLs(context.Background(), "user_1", "a")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := shortenerService.GetDeletionJob(context.Background(), "user_1", id)
+		return errors.Is(err, service.ErrNoSuchDeletionJob)
+	}, time.Second, 5*time.Millisecond, "finished job is purged after retention")
+}
+
+func TestShortenerServiceImpl_InvalidDeletionSettings(t *testing.T) {
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("DeleteUserURLs", mock.Anything,
+		urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"a"}}).Return(nil).Once()
+	shortenerService := service.NewShortenerService(mocks.NewURLStorage(t), mockUserStorage, nil, mocks.NewShortCutGenerator(t),
+		service.WithDeletionSettings(service.DeletionSettings{FlushInterval: -time.Second, RetryBackoff: -time.Second}))
+
+	id, err := shortenerService.DeleteUserURLs(context.Background(), "user_1", "a")
+	require.NoError(t, err)
+	shortenerService.Stop()
+	<-shortenerService.Stopped
+	job, err := shortenerService.GetDeletionJob(context.Background(), "user_1", id)
+	require.NoError(t, err)
+	assert.Equal(t, deletionstorage.StatusDone, job.Status, "jobs are flushed with default batch size")
+}
+
+func TestShortenerServiceImpl_UpdateUserURL(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+	changeFor := func(shortURL string) any {
+		return mock.MatchedBy(func(change urlstorage.URLChange) bool {
+			return change.ShortURL == shortURL && change.LongURL == "http://new.ru" && change.ChangedBy == "user_1" &&
+				!change.ChangedAt.IsZero()
+		})
+	}
+	mockStorage.On("UpdateWithContext", mock.Anything, changeFor("a")).
+		Return(urlstorage.URLChange{ShortURL: "a", PreviousURL: "http://old.ru", LongURL: "http://new.ru"}, nil).Once()
+	mockStorage.On("UpdateWithContext", mock.Anything, changeFor("other")).
+		Return(urlstorage.URLChange{}, urlstorage.ErrNotOwnedURL).Once()
+	mockStorage.On("UpdateWithContext", mock.Anything, changeFor("deleted")).
+		Return(urlstorage.URLChange{}, urlstorage.ErrDeletedURL).Once()
+	mockStorage.On("UpdateWithContext", mock.Anything, changeFor("conflict")).
+		Return(urlstorage.URLChange{}, urlstorage.ErrConflictURL).Once()
+
+	tests := []struct {
+		name     string
+		shortURL string
+		longURL  string
+		wantErr  error
+	}{
+		{name: "updated", shortURL: "a", longURL: "new.ru"},
+		{name: "not_user_url", shortURL: "other", longURL: "new.ru", wantErr: service.ErrNotUserURL},
+		{name: "deleted", shortURL: "deleted", longURL: "new.ru", wantErr: service.ErrDeletedURL},
+		{name: "conflict", shortURL: "conflict", longURL: "new.ru", wantErr: urlstorage.ErrConflictURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change, err := shortenerService.UpdateUserURL(context.Background(), "user_1", tt.shortURL, tt.longURL)
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, "http://old.ru", change.PreviousURL)
+			}
+		})
+	}
+
+	_, err := shortenerService.UpdateUserURL(context.Background(), "user_1", "a", "")
+	require.Error(t, err)
+}
+
+func TestShortenerServiceImpl_GetURLHistory(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "user_1", "a").Return(true, nil).Once()
+	mockUserStorage.On("OwnsURLWithContext", mock.Anything, "user_1", "b").Return(false, nil).Once()
+	history := []urlstorage.URLChange{{ShortURL: "a", PreviousURL: "http://old.ru", LongURL: "http://new.ru", ChangedBy: "user_1"}}
+	mockStorage.On("GetHistoryWithContext", mock.Anything, "a").Return(history, nil).Once()
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	got, err := shortenerService.GetURLHistory(context.Background(), "user_1", "a")
+	require.NoError(t, err)
+	assert.Equal(t, history, got)
+
+	_, err = shortenerService.GetURLHistory(context.Background(), "user_1", "b")
+	require.ErrorIs(t, err, service.ErrNotUserURL)
+}
+
+func TestShortenerServiceImpl_GetUserURLsPage(t *testing.T) {
+	mockGenerator := mocks.NewShortCutGenerator(t)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	shortenerService := service.NewShortenerService(mockStorage, mockUserStorage, nil, mockGenerator)
+
+	tests := []struct {
+		name      string
+		limit     int
+		wantLimit int
+	}{
+		{name: "all", limit: 0, wantLimit: 0},
+		{name: "given", limit: 10, wantLimit: 10},
+		{name: "too_large", limit: service.MaxPageSize + 1, wantLimit: service.MaxPageSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserStorage.On("GetUserURLsPageWithContext", mock.Anything, "user_1",
+				urlstorage.UserURLsQuery{Limit: tt.wantLimit, Descending: true}).Return(urlstorage.UserURLsPage{}, nil).Once()
+			_, err := shortenerService.GetUserURLsPage(context.Background(), "user_1",
+				urlstorage.UserURLsQuery{Limit: tt.limit, Descending: true})
+			require.NoError(t, err)
+		})
+	}
 }
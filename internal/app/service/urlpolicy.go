@@ -0,0 +1,235 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// Error in case long url violates url policy.
+var ErrURLPolicy = errors.New("url violates policy")
+
+// Rules of url policy.
+const (
+	RuleScheme           = "scheme"
+	RuleHost             = "host"
+	RuleBlockedDomain    = "blocked_domain"
+	RuleNotAllowedDomain = "not_allowed_domain"
+	RulePrivateIP        = "private_ip"
+	RuleSelfReference    = "self_reference"
+)
+
+// Violation of url policy by long url.
+type PolicyViolation struct {
+	Rule   string // violated rule
+	URL    string // sanitized long url
+	Reason string // human readable explanation
+	Index  int    // position of url in batch
+}
+
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("%s: %s", ErrURLPolicy, v.Reason)
+}
+
+func (v *PolicyViolation) Unwrap() error {
+	return ErrURLPolicy
+}
+
+// List of domains read from file, one domain per line, lines starting with # are comments.
+//
+// Domain matches itself and all its subdomains.
+type DomainList struct {
+	Path    string
+	mutex   sync.RWMutex
+	domains map[string]struct{}
+	modTime time.Time
+}
+
+// Reads domain list from file.
+func NewDomainList(path string) (*DomainList, error) {
+	list := &DomainList{Path: path}
+	if err := list.Reload(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Rereads file if it changed since last reading.
+func (l *DomainList) Reload() error {
+	info, err := os.Stat(l.Path)
+	if err != nil {
+		return fmt.Errorf("cannot stat domain list: %w", err)
+	}
+	l.mutex.RLock()
+	unchanged := l.domains != nil && info.ModTime().Equal(l.modTime)
+	l.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	file, err := os.Open(l.Path)
+	if err != nil {
+		return fmt.Errorf("cannot open domain list: %w", err)
+	}
+	defer file.Close()
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[normalizeDomain(line)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read domain list: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.domains = domains
+	l.modTime = info.ModTime()
+	return nil
+}
+
+// Periodically rereads changed file until context is done.
+// Failed reading keeps previous domains.
+func (l *DomainList) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Reload(); err != nil {
+				logger.Log.Error("cannot reload domain list", zap.String("path", l.Path), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Checks whether host or any of its parent domains is in list.
+func (l *DomainList) Contains(host string) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	host = normalizeDomain(host)
+	for host != "" {
+		if _, has := l.domains[host]; has {
+			return true
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return false
+}
+
+// Returns lowercase domain without trailing dot.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// Rules for long urls.
+type URLPolicy struct {
+	Schemes         map[string]struct{} // allowed lowercase schemes
+	BlockedDomains  *DomainList         // forbidden domains, nil forbids nothing
+	AllowedDomains  *DomainList         // the only allowed domains, nil allows everything
+	AllowPrivateIPs bool                // whether literal loopback and private ips are allowed
+	SelfHosts       map[string]struct{} // lowercase hosts of service itself
+}
+
+// Schemes allowed by default.
+var DefaultURLSchemes = []string{"http", "https"}
+
+// New url policy, self hosts are compared case insensitively and without port.
+func NewURLPolicy(schemes []string, selfHosts ...string) URLPolicy {
+	policy := URLPolicy{
+		Schemes:   make(map[string]struct{}),
+		SelfHosts: make(map[string]struct{}),
+	}
+	for _, scheme := range schemes {
+		if scheme = strings.TrimSpace(scheme); scheme != "" {
+			policy.Schemes[strings.ToLower(scheme)] = struct{}{}
+		}
+	}
+	for _, host := range selfHosts {
+		if host = strings.TrimSpace(host); host != "" {
+			if hostname, _, err := net.SplitHostPort(host); err == nil {
+				host = hostname
+			}
+			policy.SelfHosts[normalizeDomain(host)] = struct{}{}
+		}
+	}
+	return policy
+}
+
+// Default url policy.
+var DefaultURLPolicy = NewURLPolicy(DefaultURLSchemes)
+
+// Checks whether sanitized long url satisfies policy.
+// Returns *PolicyViolation otherwise.
+func (p URLPolicy) Validate(longURL string) error {
+	violation := func(rule string, format string, args ...any) error {
+		return &PolicyViolation{Rule: rule, URL: longURL, Reason: fmt.Sprintf(format, args...)}
+	}
+	parsed, err := url.Parse(longURL)
+	if err != nil {
+		return violation(RuleHost, "url cannot be parsed")
+	}
+	if _, has := p.Schemes[strings.ToLower(parsed.Scheme)]; !has {
+		return violation(RuleScheme, "scheme %q is not allowed", parsed.Scheme)
+	}
+	host := normalizeDomain(parsed.Hostname())
+	if host == "" {
+		return violation(RuleHost, "url has no host")
+	}
+	if _, has := p.SelfHosts[host]; has {
+		return violation(RuleSelfReference, "url points to shortener itself")
+	}
+	if ip := net.ParseIP(host); ip != nil && !p.AllowPrivateIPs && isPrivateIP(ip) {
+		return violation(RulePrivateIP, "ip %s is not public", ip)
+	}
+	if p.BlockedDomains != nil && p.BlockedDomains.Contains(host) {
+		return violation(RuleBlockedDomain, "domain %q is blocked", host)
+	}
+	if p.AllowedDomains != nil && !p.AllowedDomains.Contains(host) {
+		return violation(RuleNotAllowedDomain, "domain %q is not allowed", host)
+	}
+	return nil
+}
+
+// Checks whether ip is loopback, private, link local or unspecified.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// Sanitizes long url, checks it against url policy and normalizes it.
+// Returns sanitized url and its normalized form if it differs.
+func (s ShortenerServiceImpl) prepareLongURL(longURL string) (string, string, error) {
+	sanitized, err := SanitizeURL(longURL)
+	if err != nil {
+		return "", "", err
+	}
+	if err = s.URLPolicy.Validate(sanitized); err != nil {
+		return "", "", err
+	}
+	normalized, err := s.Normalizer.Normalize(sanitized)
+	if err != nil || normalized == sanitized {
+		return sanitized, "", err
+	}
+	return sanitized, normalized, nil
+}
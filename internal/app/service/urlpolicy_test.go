@@ -0,0 +1,83 @@
+package service_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/service"
+)
+
+func TestURLPolicy_Validate(t *testing.T) {
+	dir := t.TempDir()
+	blockedPath := filepath.Join(dir, "blocked.txt")
+	require.NoError(t, os.WriteFile(blockedPath, []byte("# comment\nEvil.com\n\nbad.org.\n"), 0o644))
+	blocked, err := service.NewDomainList(blockedPath)
+	require.NoError(t, err)
+
+	policy := service.NewURLPolicy([]string{"http", "HTTPS"}, "short.ly:8080")
+	policy.BlockedDomains = blocked
+	tests := []struct {
+		url      string
+		wantRule string
+	}{
+		{url: "https://example.com/path", wantRule: ""},
+		{url: "http://8.8.8.8", wantRule: ""},
+		{url: "javascript:alert(1)", wantRule: service.RuleScheme},
+		{url: "ftp://example.com", wantRule: service.RuleScheme},
+		{url: "http:///path", wantRule: service.RuleHost},
+		{url: "http://Short.ly/abc", wantRule: service.RuleSelfReference},
+		{url: "http://127.0.0.1:8080/admin", wantRule: service.RulePrivateIP},
+		{url: "http://192.168.1.1", wantRule: service.RulePrivateIP},
+		{url: "http://[::1]/", wantRule: service.RulePrivateIP},
+		{url: "http://169.254.169.254/latest", wantRule: service.RulePrivateIP},
+		{url: "https://evil.com", wantRule: service.RuleBlockedDomain},
+		{url: "https://www.EVIL.com/x", wantRule: service.RuleBlockedDomain},
+		{url: "https://notevil.com", wantRule: ""},
+		{url: "https://bad.org", wantRule: service.RuleBlockedDomain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := policy.Validate(tt.url)
+			if tt.wantRule == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, service.ErrURLPolicy)
+			var violation *service.PolicyViolation
+			require.ErrorAs(t, err, &violation)
+			assert.Equal(t, tt.wantRule, violation.Rule)
+			assert.Equal(t, tt.url, violation.URL)
+		})
+	}
+
+	policy.AllowPrivateIPs = true
+	require.NoError(t, policy.Validate("http://10.0.0.1"))
+}
+
+func TestURLPolicy_AllowedDomains(t *testing.T) {
+	allowedPath := filepath.Join(t.TempDir(), "allowed.txt")
+	require.NoError(t, os.WriteFile(allowedPath, []byte("example.com\n"), 0o644))
+	allowed, err := service.NewDomainList(allowedPath)
+	require.NoError(t, err)
+	policy := service.NewURLPolicy(service.DefaultURLSchemes)
+	policy.AllowedDomains = allowed
+
+	require.NoError(t, policy.Validate("https://docs.example.com"))
+	require.ErrorIs(t, policy.Validate("https://other.com"), service.ErrURLPolicy)
+
+	require.NoError(t, os.WriteFile(allowedPath, []byte("other.com\n"), 0o644))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(allowedPath, future, future))
+	require.NoError(t, allowed.Reload())
+	require.NoError(t, policy.Validate("https://other.com"))
+	require.ErrorIs(t, policy.Validate("https://example.com"), service.ErrURLPolicy)
+}
+
+func TestDomainList_Missing(t *testing.T) {
+	_, err := service.NewDomainList(filepath.Join(t.TempDir(), "missing.txt"))
+	require.Error(t, err)
+}
@@ -0,0 +1,68 @@
+package shortcutgenerator
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Maximal length of counter url, larger url space does not fit in uint64.
+const maxCounterLength = 10
+
+// Generates urls from sequential counter mixed by bijection, similar to hashids.
+//
+// Counter value n is mapped to (n * Multiplier + Offset) mod 62^Length,
+// which is bijection because multiplier is coprime with 62.
+// Counter starts from current time in milliseconds, so restarts do not repeat urls.
+type CounterGenerator struct {
+	Length     int
+	Multiplier uint64
+	Offset     uint64
+	space      uint64
+	counter    atomic.Uint64
+}
+
+// New counter generator, multiplier and offset are derived from salt.
+func NewCounterGenerator(length int, salt string) (*CounterGenerator, error) {
+	if length <= 0 || length > maxCounterLength {
+		return nil, errors.New("counter generator length must be from 1 to 10")
+	}
+	space := uint64(1)
+	for i := 0; i < length; i++ {
+		space *= uint64(len(Base62Alphabet))
+	}
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	seed := h.Sum64()
+	multiplier := seed%space | 1
+	for multiplier%31 == 0 {
+		multiplier += 2
+	}
+	g := &CounterGenerator{
+		Length:     length,
+		Multiplier: multiplier,
+		Offset:     bits.RotateLeft64(seed, 32) % space,
+		space:      space,
+	}
+	g.counter.Store(uint64(time.Now().UnixMilli()))
+	return g, nil
+}
+
+// Generates url from next counter value.
+func (g *CounterGenerator) Generate(_ string, _ int) (string, error) {
+	hi, lo := bits.Mul64(g.counter.Add(1), g.Multiplier)
+	n := (bits.Rem64(hi, lo, g.space) + g.Offset) % g.space
+	return encodeBase62(n, g.Length), nil
+}
+
+// Encodes number in base62 padded to given length.
+func encodeBase62(n uint64, length int) string {
+	res := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		res[i] = Base62Alphabet[n%uint64(len(Base62Alphabet))]
+		n /= uint64(len(Base62Alphabet))
+	}
+	return string(res)
+}
@@ -0,0 +1,42 @@
+package shortcutgenerator
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Alphabets for random generator.
+const (
+	Base62Alphabet    = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Base64URLAlphabet = Base62Alphabet + "-_"
+)
+
+// Generates cryptographically random strings over alphabet.
+type CryptoRandGenerator struct {
+	Length   int
+	Alphabet string
+}
+
+// New crypto random generator. Generates strings of length `Length` over given alphabet up to 256 symbols.
+func NewCryptoRandGenerator(length int, alphabet string) *CryptoRandGenerator {
+	return &CryptoRandGenerator{Length: length, Alphabet: alphabet}
+}
+
+// Generates random string, every symbol of alphabet is equally likely.
+func (g CryptoRandGenerator) Generate(_ string, _ int) (string, error) {
+	// bytes above largest multiple of alphabet size are rejected to avoid bias
+	limit := 256 - 256%len(g.Alphabet)
+	res := make([]byte, 0, g.Length)
+	buf := make([]byte, g.Length)
+	for len(res) < g.Length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("cannot read random bytes: %w", err)
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(res) < g.Length {
+				res = append(res, g.Alphabet[int(b)%len(g.Alphabet)])
+			}
+		}
+	}
+	return string(res), nil
+}
@@ -0,0 +1,36 @@
+package shortcutgenerator
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"strconv"
+)
+
+// Generates urls deterministically from hash of long url.
+type HashGenerator struct {
+	Length int
+	Salt   string
+}
+
+// New hash generator. Generates strings of length `Length` up to 43.
+func NewHashGenerator(length int, salt string) *HashGenerator {
+	return &HashGenerator{Length: length, Salt: salt}
+}
+
+// Generates base62 prefix of salted hash of long url.
+// Attempt is mixed into hash so that collisions produce another url.
+func (g HashGenerator) Generate(longURL string, attempt int) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(g.Salt))
+	h.Write([]byte{0})
+	h.Write([]byte(longURL))
+	if attempt != 0 {
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.Itoa(attempt)))
+	}
+	encoded := new(big.Int).SetBytes(h.Sum(nil)).Text(62)
+	for len(encoded) < g.Length {
+		encoded = "0" + encoded
+	}
+	return encoded[:g.Length], nil
+}
@@ -18,7 +18,7 @@ func NewRandBase64Generator(length int) *RandBase64Generator {
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 // Generates random string of given length from above rune sequence.
-func (s RandBase64Generator) Generate() (string, error) {
+func (s RandBase64Generator) Generate(_ string, _ int) (string, error) {
 	b := make([]rune, s.Length)
 	for i := range b {
 		b[i] = letterRunes[rand.Intn(len(letterRunes))]
@@ -17,7 +17,7 @@ func TestRandBase64Generator_Generate(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			generator := NewRandBase64Generator(tt.length)
-			got, err := generator.Generate()
+			got, err := generator.Generate("", 0)
 			require.NoError(t, err)
 			assert.Equal(t, len(got), tt.length)
 		})
@@ -28,6 +28,6 @@ func BenchmarkRandBase64Generator_Generate(b *testing.B) {
 	generator := NewRandBase64Generator(5)
 
 	for i := 0; i < 1000; i++ {
-		generator.Generate()
+		generator.Generate("", 0)
 	}
 }
@@ -1,7 +1,41 @@
-// Package shortcutgenerator contains random string generator.
+// Package shortcutgenerator contains short url generators.
 package shortcutgenerator
 
+import "fmt"
+
+// Generator of short urls.
+//
 //go:generate mockery --name ShortCutGenerator
 type ShortCutGenerator interface {
-	Generate() (string, error)
+	// Generates short url for long url.
+	// Attempt is number of previous collisions, so that deterministic generators can produce another url.
+	Generate(longURL string, attempt int) (string, error)
+}
+
+// Kinds of generators selectable by config.
+const (
+	KindRandom    = "random"    // math/rand letters
+	KindBase62    = "base62"    // crypto/rand base62
+	KindBase64URL = "base64url" // crypto/rand base64url
+	KindCounter   = "counter"   // obfuscated sequential counter
+	KindHash      = "hash"      // hash of long url
+)
+
+// New generator of given kind generating urls of given length.
+// Salt is used by counter and hash generators to make urls unpredictable.
+func New(kind string, length int, salt string) (ShortCutGenerator, error) {
+	switch kind {
+	case KindRandom:
+		return NewRandBase64Generator(length), nil
+	case KindBase62:
+		return NewCryptoRandGenerator(length, Base62Alphabet), nil
+	case KindBase64URL:
+		return NewCryptoRandGenerator(length, Base64URLAlphabet), nil
+	case KindCounter:
+		return NewCounterGenerator(length, salt)
+	case KindHash:
+		return NewHashGenerator(length, salt), nil
+	default:
+		return nil, fmt.Errorf("unknown generator %q", kind)
+	}
 }
@@ -0,0 +1,78 @@
+package shortcutgenerator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	for _, kind := range []string{KindRandom, KindBase62, KindBase64URL, KindCounter, KindHash} {
+		t.Run(kind, func(t *testing.T) {
+			generator, err := New(kind, 8, "salt")
+			require.NoError(t, err)
+			got, err := generator.Generate("http://long.ru", 0)
+			require.NoError(t, err)
+			assert.Len(t, got, 8)
+		})
+	}
+	_, err := New("unknown", 8, "salt")
+	require.Error(t, err)
+}
+
+func TestCryptoRandGenerator_Generate(t *testing.T) {
+	tests := []struct {
+		name     string
+		alphabet string
+	}{
+		{name: "base62", alphabet: Base62Alphabet},
+		{name: "base64url", alphabet: Base64URLAlphabet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := NewCryptoRandGenerator(100, tt.alphabet)
+			got, err := generator.Generate("", 0)
+			require.NoError(t, err)
+			require.Len(t, got, 100)
+			for _, r := range got {
+				assert.True(t, strings.ContainsRune(tt.alphabet, r))
+			}
+		})
+	}
+}
+
+func TestCounterGenerator_Generate(t *testing.T) {
+	generator, err := NewCounterGenerator(2, "salt")
+	require.NoError(t, err)
+	generator.counter.Store(0)
+	seen := make(map[string]bool)
+	for i := 0; i < 62*62; i++ {
+		got, err := generator.Generate("", 0)
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		require.False(t, seen[got], "counter generator must not repeat until url space is exhausted")
+		seen[got] = true
+	}
+
+	_, err = NewCounterGenerator(11, "salt")
+	require.Error(t, err)
+}
+
+func TestHashGenerator_Generate(t *testing.T) {
+	generator := NewHashGenerator(8, "salt")
+	first, err := generator.Generate("http://long.ru", 0)
+	require.NoError(t, err)
+	second, err := generator.Generate("http://long.ru", 0)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	retry, err := generator.Generate("http://long.ru", 1)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, retry)
+
+	salted, err := NewHashGenerator(8, "other").Generate("http://long.ru", 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, salted)
+}
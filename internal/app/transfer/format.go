@@ -0,0 +1,296 @@
+// Package transfer for moving urls between storages through interchange files.
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Version of interchange format written by export.
+const FormatVersion = 1
+
+// Name of interchange format in file headers.
+const formatName = "urlshortener"
+
+// Error in case format of interchange file is unknown.
+var ErrUnknownFormat = errors.New("unknown interchange format")
+
+// Error in case interchange file is written by newer version.
+var ErrUnsupportedVersion = errors.New("unsupported interchange format version")
+
+// Error in case record of interchange file cannot be read.
+var ErrMalformedRecord = errors.New("malformed record")
+
+// Encoding of interchange file.
+type Format string
+
+const (
+	NDJSON Format = "ndjson" // header object followed by json object per line
+	CSV    Format = "csv"    // version comment and header row followed by row per record
+)
+
+// Parses format, empty string means format by extension of file name, ndjson by default.
+func ParseFormat(format string, filename string) (Format, error) {
+	switch Format(format) {
+	case NDJSON, CSV:
+		return Format(format), nil
+	case "":
+		if strings.EqualFold(filepath.Ext(filename), ".csv") {
+			return CSV, nil
+		}
+		return NDJSON, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+}
+
+// Url with its owner as written to interchange file.
+type Record struct {
+	Short         string    `json:"short_url"`
+	Long          string    `json:"original_url"`
+	UserID        string    `json:"user_id"`
+	Deleted       bool      `json:"deleted,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at,omitzero"` // zero time means url never expires
+	NormalizedURL string    `json:"normalized_url,omitempty"`
+	Interstitial  bool      `json:"interstitial,omitempty"`
+	RedirectCode  int       `json:"redirect_code,omitempty"`
+}
+
+// Record of saved url.
+func NewRecord(url urlstorage.OwnedURL) Record {
+	return Record{
+		Short:         url.Short,
+		Long:          url.Long,
+		UserID:        url.UserID,
+		Deleted:       url.Deleted,
+		CreatedAt:     url.CreatedAt,
+		ExpiresAt:     url.ExpiresAt,
+		NormalizedURL: url.NormalizedURL,
+		Interstitial:  url.Interstitial,
+		RedirectCode:  url.RedirectCode,
+	}
+}
+
+// Url to save from record.
+func (r Record) URL() urlstorage.OwnedURL {
+	options := urlstorage.URLOptions{
+		CreatedAt:     r.CreatedAt,
+		ExpiresAt:     r.ExpiresAt,
+		NormalizedURL: r.NormalizedURL,
+		Interstitial:  r.Interstitial,
+		RedirectCode:  r.RedirectCode,
+	}
+	return urlstorage.OwnedURL{
+		StoredURL: urlstorage.StoredURL{URLPair: urlstorage.URLPair{Short: r.Short, Long: r.Long, URLOptions: options}, Deleted: r.Deleted},
+		UserID:    r.UserID,
+	}
+}
+
+// Checks that record can be saved.
+func (r Record) validate() error {
+	if r.Short == "" || r.Long == "" {
+		return fmt.Errorf("%w: short and original urls are required", ErrMalformedRecord)
+	}
+	return nil
+}
+
+// First line of ndjson file.
+type header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// Columns of csv file.
+var csvColumns = []string{"short_url", "original_url", "user_id", "deleted", "created_at",
+	"expires_at", "normalized_url", "interstitial", "redirect_code"}
+
+// Writes records to interchange file.
+type Writer struct {
+	format Format
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// New writer of interchange file writing its header at once.
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	writer := &Writer{format: format, buf: bufio.NewWriter(w)}
+	var err error
+	switch format {
+	case NDJSON:
+		writer.json = json.NewEncoder(writer.buf)
+		err = writer.json.Encode(header{Format: formatName, Version: FormatVersion})
+	case CSV:
+		writer.csv = csv.NewWriter(writer.buf)
+		if _, err = fmt.Fprintf(writer.buf, "#%s %d\n", formatName, FormatVersion); err == nil {
+			err = writer.csv.Write(csvColumns)
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+	return writer, err
+}
+
+// Formats time as csv field, zero time is empty field.
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// Writes record.
+func (w *Writer) Write(record Record) error {
+	if w.format == NDJSON {
+		return w.json.Encode(record)
+	}
+	return w.csv.Write([]string{
+		record.Short, record.Long, record.UserID, strconv.FormatBool(record.Deleted), csvTime(record.CreatedAt),
+		csvTime(record.ExpiresAt), record.NormalizedURL, strconv.FormatBool(record.Interstitial), strconv.Itoa(record.RedirectCode),
+	})
+}
+
+// Flushes written records.
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.buf.Flush()
+}
+
+// Reads records from interchange file.
+type Reader struct {
+	format Format
+	buf    *bufio.Reader
+	csv    *csv.Reader
+	line   int
+}
+
+// New reader of interchange file checking its header at once.
+func NewReader(r io.Reader, format Format) (*Reader, error) {
+	reader := &Reader{format: format, buf: bufio.NewReader(r)}
+	first, err := reader.buf.ReadString('\n')
+	if err != nil && (err != io.EOF || first == "") {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	reader.line = 1
+	var h header
+	switch format {
+	case NDJSON:
+		if err = json.Unmarshal([]byte(first), &h); err != nil {
+			return nil, fmt.Errorf("%w: wrong header", ErrMalformedRecord)
+		}
+	case CSV:
+		if _, err = fmt.Sscanf(strings.TrimSpace(first), "#"+formatName+" %d", &h.Version); err != nil {
+			return nil, fmt.Errorf("%w: wrong header", ErrMalformedRecord)
+		}
+		h.Format = formatName
+		reader.csv = csv.NewReader(reader.buf)
+		reader.csv.FieldsPerRecord = len(csvColumns)
+		if _, err = reader.csv.Read(); err != nil {
+			return nil, fmt.Errorf("%w: wrong columns: %w", ErrMalformedRecord, err)
+		}
+		reader.line++
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+	if h.Format != formatName {
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, h.Format)
+	}
+	if h.Version < 1 || h.Version > FormatVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, h.Version)
+	}
+	return reader, nil
+}
+
+// Returns line of file last record was read from.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Reads next record, returns io.EOF after last record.
+func (r *Reader) Read() (Record, error) {
+	var record Record
+	var err error
+	if r.format == NDJSON {
+		record, err = r.readJSON()
+	} else {
+		record, err = r.readCSV()
+	}
+	if err == nil {
+		err = record.validate()
+	}
+	if err != nil && err != io.EOF {
+		return record, fmt.Errorf("line %d: %w", r.line, err)
+	}
+	return record, err
+}
+
+func (r *Reader) readJSON() (Record, error) {
+	var record Record
+	for {
+		data, err := r.buf.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return record, err
+		}
+		r.line++
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+		if err = json.Unmarshal(data, &record); err != nil {
+			return record, fmt.Errorf("%w: %w", ErrMalformedRecord, err)
+		}
+		return record, nil
+	}
+}
+
+func (r *Reader) readCSV() (Record, error) {
+	var record Record
+	fields, err := r.csv.Read()
+	if err != nil {
+		if err == io.EOF {
+			return record, err
+		}
+		return record, fmt.Errorf("%w: %w", ErrMalformedRecord, err)
+	}
+	r.line, _ = r.csv.FieldPos(0)
+	r.line++
+	record.Short, record.Long, record.UserID, record.NormalizedURL = fields[0], fields[1], fields[2], fields[6]
+	parseErrs := make([]error, 0, 5)
+	record.Deleted, err = strconv.ParseBool(fields[3])
+	parseErrs = append(parseErrs, err)
+	record.CreatedAt, err = parseCSVTime(fields[4])
+	parseErrs = append(parseErrs, err)
+	record.ExpiresAt, err = parseCSVTime(fields[5])
+	parseErrs = append(parseErrs, err)
+	record.Interstitial, err = strconv.ParseBool(fields[7])
+	parseErrs = append(parseErrs, err)
+	record.RedirectCode, err = strconv.Atoi(fields[8])
+	parseErrs = append(parseErrs, err)
+	if err = errors.Join(parseErrs...); err != nil {
+		return record, fmt.Errorf("%w: %w", ErrMalformedRecord, err)
+	}
+	return record, nil
+}
+
+// Parses csv time field, empty field is zero time.
+func parseCSVTime(field string) (time.Time, error) {
+	if field == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, field)
+}
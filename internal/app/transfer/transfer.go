@@ -0,0 +1,172 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+// Error in case imported url conflicts with saved one under fail policy.
+var ErrConflict = errors.New("url is already saved")
+
+// Error in case conflict policy is unknown.
+var ErrUnknownPolicy = errors.New("unknown conflict policy")
+
+// How import treats records whose short url or long url is already saved.
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"      // keep saved url
+	ConflictOverwrite ConflictPolicy = "overwrite" // replace url saved under the same short url
+	ConflictFail      ConflictPolicy = "fail"      // stop import
+)
+
+// Parses conflict policy, empty string means skip.
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(policy) {
+	case ConflictSkip, "":
+		return ConflictSkip, nil
+	case ConflictOverwrite, ConflictFail:
+		return ConflictPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownPolicy, policy)
+	}
+}
+
+// Storage urls are imported into.
+type Target interface {
+	urlstorage.TransferStorage
+
+	// Returns saved url with its options and creation time, deleted and expired urls are returned too.
+	GetURLWithContext(context context.Context, shortURL string) (urlstorage.StoredURL, error)
+
+	// Returns shortURL by normalized form of longURL in dedup scope of user.
+	GetShortURLWithContext(context context.Context, normalizedURL string, userID string) (string, error)
+}
+
+// Counters of processed records.
+type Stats struct {
+	Read        int64 // records read from file
+	Imported    int64 // records saved as new urls
+	Overwritten int64 // records replaced saved urls
+	Skipped     int64 // records conflicting with saved urls
+}
+
+// Settings of import.
+type ImportOptions struct {
+	Policy ConflictPolicy
+	// Only count what would be imported, conflicts between records of file itself are not found then.
+	DryRun bool
+	// Called after every ProgressEvery records and after last record.
+	Progress      func(stats Stats)
+	ProgressEvery int64
+}
+
+// Writes every url of storage to writer.
+//
+// Progress is called with number of written records after every progressEvery records and after last record.
+func Export(ctx context.Context, source urlstorage.TransferStorage, w *Writer, progress func(written int64), progressEvery int64) (int64, error) {
+	var written int64
+	err := source.ForEachURLWithContext(ctx, func(url urlstorage.OwnedURL) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := w.Write(NewRecord(url)); err != nil {
+			return err
+		}
+		written++
+		if progress != nil && progressEvery > 0 && written%progressEvery == 0 {
+			progress(written)
+		}
+		return nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if progress != nil {
+		progress(written)
+	}
+	return written, err
+}
+
+// Saves every record of reader into storage resolving conflicts by policy.
+func Import(ctx context.Context, r *Reader, target Target, options ImportOptions) (Stats, error) {
+	var stats Stats
+	report := func() {
+		if options.Progress != nil {
+			options.Progress(stats)
+		}
+	}
+	defer report()
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		record, err := r.Read()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Read++
+		if err = importRecord(ctx, target, record, options, &stats); err != nil {
+			return stats, fmt.Errorf("line %d: %w", r.Line(), err)
+		}
+		if options.ProgressEvery > 0 && stats.Read%options.ProgressEvery == 0 {
+			report()
+		}
+	}
+}
+
+// Finds whether record conflicts with saved urls and whether it conflicts with url under the same short url only.
+func findConflict(ctx context.Context, target Target, url urlstorage.OwnedURL) (conflict bool, replaceable bool, err error) {
+	_, err = target.GetURLWithContext(ctx, url.Short)
+	if err != nil && !errors.Is(err, urlstorage.ErrNotFoundURL) {
+		return false, false, err
+	}
+	shortTaken := err == nil
+	// Lookup fails for absent and not deduplicated urls alike, so any error means no conflict by long url.
+	shortURL, err := target.GetShortURLWithContext(ctx, url.DedupKey(url.Long), url.UserID)
+	longTaken := err == nil && shortURL != url.Short
+	return shortTaken || longTaken, !longTaken, nil
+}
+
+// Saves record according to policy and counts it.
+func importRecord(ctx context.Context, target Target, record Record, options ImportOptions, stats *Stats) error {
+	url := record.URL()
+	conflict, replaceable, err := findConflict(ctx, target, url)
+	if err != nil {
+		return err
+	}
+	if conflict && options.Policy == ConflictFail {
+		return fmt.Errorf("%w: %s", ErrConflict, url.Short)
+	}
+	if conflict && (options.Policy != ConflictOverwrite || !replaceable) {
+		stats.Skipped++
+		return nil
+	}
+	if !options.DryRun {
+		// Replacing absent url saves it, while conflicts appeared since check are reported by storage.
+		err = target.ReplaceWithContext(ctx, url)
+		if errors.Is(err, urlstorage.ErrConflictURL) || errors.Is(err, urlstorage.ErrShortURLTaken) {
+			if options.Policy == ConflictFail {
+				return fmt.Errorf("%w: %s", ErrConflict, url.Short)
+			}
+			stats.Skipped++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if conflict {
+		stats.Overwritten++
+	} else {
+		stats.Imported++
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/transfer"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+var createdAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newSource(t *testing.T) *urlstorage.SimpleMapLockStorage {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	ctx := context.Background()
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1",
+		urlstorage.URLOptions{CreatedAt: createdAt, ExpiresAt: createdAt.Add(time.Hour), RedirectCode: 301, Interstitial: true}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url,\"b\"", "b", "user_2", urlstorage.URLOptions{CreatedAt: createdAt.Add(time.Minute)}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_c", "c", "user_1", urlstorage.URLOptions{CreatedAt: createdAt.Add(2 * time.Minute)}))
+	require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"c"}}))
+	return storage
+}
+
+func exportURLs(t *testing.T, source urlstorage.TransferStorage, format transfer.Format) []byte {
+	var buf bytes.Buffer
+	writer, err := transfer.NewWriter(&buf, format)
+	require.NoError(t, err)
+	var reported []int64
+	written, err := transfer.Export(context.Background(), source, writer, func(written int64) {
+		reported = append(reported, written)
+	}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), written)
+	assert.Equal(t, []int64{2, 3}, reported)
+	return buf.Bytes()
+}
+
+func listURLs(t *testing.T, storage urlstorage.TransferStorage) []urlstorage.OwnedURL {
+	var urls []urlstorage.OwnedURL
+	require.NoError(t, storage.ForEachURLWithContext(context.Background(), func(url urlstorage.OwnedURL) error {
+		urls = append(urls, url)
+		return nil
+	}))
+	return urls
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	source := newSource(t)
+	for _, format := range []transfer.Format{transfer.NDJSON, transfer.CSV} {
+		t.Run(string(format), func(t *testing.T) {
+			data := exportURLs(t, source, format)
+			reader, err := transfer.NewReader(bytes.NewReader(data), format)
+			require.NoError(t, err)
+			target := urlstorage.NewSimpleMapLockStorage()
+			stats, err := transfer.Import(context.Background(), reader, target, transfer.ImportOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, transfer.Stats{Read: 3, Imported: 3}, stats)
+			assert.Equal(t, listURLs(t, source), listURLs(t, target))
+		})
+	}
+}
+
+func TestImport_ConflictPolicy(t *testing.T) {
+	data := exportURLs(t, newSource(t), transfer.NDJSON)
+	newTarget := func() *urlstorage.SimpleMapLockStorage {
+		target := urlstorage.NewSimpleMapLockStorage()
+		ctx := context.Background()
+		require.NoError(t, target.StoreWithContext(ctx, "url_x", "a", "user_3", urlstorage.URLOptions{}))
+		require.NoError(t, target.StoreWithContext(ctx, "url_c", "y", "user_3", urlstorage.URLOptions{}))
+		return target
+	}
+	tests := []struct {
+		name      string
+		options   transfer.ImportOptions
+		wantStats transfer.Stats
+		wantErr   error
+		wantLongA string
+	}{
+		{name: "skip", options: transfer.ImportOptions{Policy: transfer.ConflictSkip},
+			wantStats: transfer.Stats{Read: 3, Imported: 1, Skipped: 2}, wantLongA: "url_x"},
+		{name: "overwrite", options: transfer.ImportOptions{Policy: transfer.ConflictOverwrite},
+			wantStats: transfer.Stats{Read: 3, Imported: 1, Overwritten: 1, Skipped: 1}, wantLongA: "url_a"},
+		{name: "dry_run", options: transfer.ImportOptions{Policy: transfer.ConflictOverwrite, DryRun: true},
+			wantStats: transfer.Stats{Read: 3, Imported: 1, Overwritten: 1, Skipped: 1}, wantLongA: "url_x"},
+		{name: "fail", options: transfer.ImportOptions{Policy: transfer.ConflictFail},
+			wantStats: transfer.Stats{Read: 1}, wantErr: transfer.ErrConflict, wantLongA: "url_x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := transfer.NewReader(bytes.NewReader(data), transfer.NDJSON)
+			require.NoError(t, err)
+			target := newTarget()
+			stats, err := transfer.Import(context.Background(), reader, target, tt.options)
+			require.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantStats, stats)
+			url, err := target.GetURLWithContext(context.Background(), "a")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLongA, url.Long)
+		})
+	}
+}
+
+func TestNewReader_Header(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  transfer.Format
+		data    string
+		wantErr error
+	}{
+		{name: "ndjson", format: transfer.NDJSON, data: `{"format":"urlshortener","version":1}` + "\n"},
+		{name: "csv", format: transfer.CSV, data: "#urlshortener 1\nshort_url,original_url,user_id,deleted,created_at,expires_at,normalized_url,interstitial,redirect_code\n"},
+		{name: "newer_version", format: transfer.NDJSON, data: `{"format":"urlshortener","version":2}` + "\n",
+			wantErr: transfer.ErrUnsupportedVersion},
+		{name: "other_format", format: transfer.NDJSON, data: `{"format":"other","version":1}` + "\n",
+			wantErr: transfer.ErrUnknownFormat},
+		{name: "no_header", format: transfer.CSV, data: "a,url_a\n", wantErr: transfer.ErrMalformedRecord},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := transfer.NewReader(strings.NewReader(tt.data), tt.format)
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReader_MalformedRecord(t *testing.T) {
+	data := `{"format":"urlshortener","version":1}` + "\n" + `{"short_url":"a","original_url":"url_a"}` + "\n" + `{"short_url":"b"}` + "\n"
+	reader, err := transfer.NewReader(strings.NewReader(data), transfer.NDJSON)
+	require.NoError(t, err)
+	record, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "a", record.Short)
+	_, err = reader.Read()
+	require.ErrorIs(t, err, transfer.ErrMalformedRecord)
+	assert.Contains(t, err.Error(), "line 3")
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := transfer.ParseFormat("", "urls.CSV")
+	require.NoError(t, err)
+	assert.Equal(t, transfer.CSV, format)
+	format, err = transfer.ParseFormat("", "")
+	require.NoError(t, err)
+	assert.Equal(t, transfer.NDJSON, format)
+	_, err = transfer.ParseFormat("xml", "")
+	require.ErrorIs(t, err, transfer.ErrUnknownFormat)
+	_, err = transfer.ParseConflictPolicy("merge")
+	require.ErrorIs(t, err, transfer.ErrUnknownPolicy)
+}
@@ -0,0 +1,694 @@
+package urlstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+)
+
+// Buckets of bolt storage.
+var (
+	boltURLs    = []byte("urls")     // saved urls by short urls
+	boltDedup   = []byte("dedup")    // short urls by dedup keys of normalized long urls
+	boltUsers   = []byte("users")    // bucket of every user with short urls by sequence numbers
+	boltExpiry  = []byte("expiry")   // expiring short urls by expiration time followed by short url
+	boltHistory = []byte("history")  // changes of long urls by short urls
+	boltUserIDs = []byte("user_ids") // sequence of bucket is last issued user id, kept by Clear
+)
+
+// Saved url record of bolt storage.
+type boltURL struct {
+	Long    string `json:"original_url"`
+	UserID  string `json:"user_id"`
+	Deleted bool   `json:"deleted,omitempty"`
+	UserSeq uint64 `json:"user_seq"` // key of url in bucket of user
+	URLOptions
+}
+
+// Storage storing urls in embedded bolt database file.
+//
+// Long urls and users are indexed by separate buckets updated in the same transactions as urls.
+type BoltStorage struct {
+	DB         *bolt.DB
+	DedupScope DedupScope // scope of dedup keys, zero value is global
+}
+
+// Opens bolt storage in given file creating it if needed.
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage file: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltURLs, boltDedup, boltUsers, boltExpiry, boltHistory} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		userIDs, err := tx.CreateBucketIfNotExists(boltUserIDs)
+		if err != nil || userIDs.Sequence() != 0 {
+			return err
+		}
+		// Files created before user ids were saved only have ids in buckets of users.
+		return tx.Bucket(boltUsers).ForEach(func(key, _ []byte) error {
+			return seeBoltUserID(tx, string(key[1:]))
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+	return &BoltStorage{DB: db}, nil
+}
+
+// Close database file.
+func (s *BoltStorage) Close() error {
+	return s.DB.Close()
+}
+
+// Encodes number as big endian bytes keeping order of numbers.
+func boltUint64(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+// Moves sequence of user ids past numeric id of user, so that it is never issued again.
+func seeBoltUserID(tx *bolt.Tx, userID string) error {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	userIDs := tx.Bucket(boltUserIDs)
+	if err != nil || id <= userIDs.Sequence() {
+		return nil
+	}
+	return userIDs.SetSequence(id)
+}
+
+// Generates id for new user after every id issued before or saved with urls.
+func (s *BoltStorage) GenerateUUID(_ context.Context) (int64, error) {
+	var id uint64
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = tx.Bucket(boltUserIDs).NextSequence()
+		return err
+	})
+	return int64(id), err
+}
+
+// Returns name of bucket of user, prefixed since bucket name cannot be empty.
+func boltUserKey(userID string) []byte {
+	return append([]byte{'u'}, userID...)
+}
+
+// Returns key of expiry bucket ordered by expiration time.
+func boltExpiryKey(expiresAt time.Time, shortURL string) []byte {
+	return append(boltUint64(uint64(expiresAt.UnixNano())), shortURL...)
+}
+
+// Reads saved url in transaction.
+func getBoltURL(tx *bolt.Tx, shortURL string) (boltURL, bool, error) {
+	var url boltURL
+	data := tx.Bucket(boltURLs).Get([]byte(shortURL))
+	if data == nil {
+		return url, false, nil
+	}
+	if err := json.Unmarshal(data, &url); err != nil {
+		return url, false, fmt.Errorf("failed to decode url %q: %w", shortURL, err)
+	}
+	return url, true, nil
+}
+
+// Writes saved url in transaction.
+func putBoltURL(tx *bolt.Tx, shortURL string, url boltURL) error {
+	data, err := json.Marshal(url)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltURLs).Put([]byte(shortURL), data)
+}
+
+// Returns saved url with creation time and deletion flag.
+func (url boltURL) stored(shortURL string) StoredURL {
+	return StoredURL{URLPair: URLPair{Short: shortURL, Long: url.Long, URLOptions: url.URLOptions}, Deleted: url.Deleted}
+}
+
+// Returns dedup key of saved url and whether it is deduplicated.
+func (s *BoltStorage) dedupKey(url boltURL) (string, bool) {
+	return s.DedupScope.Key(url.UserID, url.DedupKey(url.Long))
+}
+
+// Returns longURL from shortURL.
+func (s *BoltStorage) GetLongURLWithContext(ctx context.Context, shortURL string) (string, error) {
+	url, err := s.GetURLWithContext(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+	if url.Deleted {
+		return "", ErrDeletedURL
+	}
+	if url.ExpiredAt(time.Now()) {
+		return "", ErrExpiredURL
+	}
+	return url.Long, nil
+}
+
+// Returns saved url with its options and creation time, deleted and expired urls are returned too.
+func (s *BoltStorage) GetURLWithContext(_ context.Context, shortURL string) (StoredURL, error) {
+	var res StoredURL
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		url, has, err := getBoltURL(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return ErrNotFoundURL
+		}
+		res = url.stored(shortURL)
+		return nil
+	})
+	return res, err
+}
+
+// Returns shortURL by normalized form of longURL in dedup scope of user.
+func (s *BoltStorage) GetShortURLWithContext(_ context.Context, normalizedURL string, userID string) (string, error) {
+	key, dedup := s.DedupScope.Key(userID, normalizedURL)
+	if !dedup {
+		return "", ErrNotDeduplicated
+	}
+	var shortURL string
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltDedup).Get([]byte(key))
+		if value == nil {
+			return errors.New("no such longUrl")
+		}
+		shortURL = string(value)
+		return nil
+	})
+	return shortURL, err
+}
+
+// Returns short url saved under dedup key in transaction and whether it holds the key,
+// expired urls do not hold their keys.
+func boltKeyHolder(tx *bolt.Tx, key string) (string, bool, error) {
+	shortURL := tx.Bucket(boltDedup).Get([]byte(key))
+	if shortURL == nil {
+		return "", false, nil
+	}
+	url, has, err := getBoltURL(tx, string(shortURL))
+	if err != nil {
+		return "", false, err
+	}
+	return string(shortURL), !has || !url.ExpiredAt(time.Now()), nil
+}
+
+// Saves url in transaction if neither short url nor its dedup key is taken.
+//
+// Short url is taken if it is saved with another dedup key or expired, long url conflicts if its dedup key is held.
+func (s *BoltStorage) store(tx *bolt.Tx, longURL string, shortURL string, userID string, options URLOptions) error {
+	key, dedup := s.DedupScope.Key(userID, options.DedupKey(longURL))
+	existing, has, err := getBoltURL(tx, shortURL)
+	if err != nil {
+		return err
+	}
+	if has {
+		existingKey, existingDedup := s.dedupKey(existing)
+		if !dedup || !existingDedup || existingKey != key || existing.ExpiredAt(time.Now()) {
+			return ErrShortURLTaken
+		}
+	}
+	_, held, err := boltKeyHolder(tx, key)
+	if err != nil {
+		return err
+	}
+	if dedup && held {
+		return ErrConflictURL
+	}
+
+	if err = seeBoltUserID(tx, userID); err != nil {
+		return err
+	}
+	user, err := tx.Bucket(boltUsers).CreateBucketIfNotExists(boltUserKey(userID))
+	if err != nil {
+		return err
+	}
+	seq, err := user.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err = user.Put(boltUint64(seq), []byte(shortURL)); err != nil {
+		return err
+	}
+	if dedup {
+		if err = tx.Bucket(boltDedup).Put([]byte(key), []byte(shortURL)); err != nil {
+			return err
+		}
+	}
+	if !options.ExpiresAt.IsZero() {
+		if err = tx.Bucket(boltExpiry).Put(boltExpiryKey(options.ExpiresAt, shortURL), nil); err != nil {
+			return err
+		}
+	}
+	if options.NormalizedURL == longURL {
+		options.NormalizedURL = ""
+	}
+	if options.CreatedAt.IsZero() {
+		options.CreatedAt = time.Now().UTC()
+	}
+	return putBoltURL(tx, shortURL, boltURL{Long: longURL, UserID: userID, UserSeq: seq, URLOptions: options})
+}
+
+// Adds mapping longURL -> shortURL.
+func (s *BoltStorage) StoreWithContext(_ context.Context, longURL string, shortURL string, userID string, options URLOptions) error {
+	if shortURL == "" {
+		return errors.New("cannot save empty url")
+	}
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		return s.store(tx, longURL, shortURL, userID, options)
+	})
+}
+
+// Adds number of mappings longURL -> shortURL in one transaction.
+func (s *BoltStorage) StoreManyWithContext(_ context.Context, long2ShortUrls []URLPair, userID string) ([]error, error) {
+	var errs []error
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		errs = nil
+		for i := range long2ShortUrls {
+			if long2ShortUrls[i].Short == "" {
+				continue
+			}
+			err := s.store(tx, long2ShortUrls[i].Long, long2ShortUrls[i].Short, userID, long2ShortUrls[i].URLOptions)
+			if err != nil && !errors.Is(err, ErrShortURLTaken) && !errors.Is(err, ErrConflictURL) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store urls: %w", err)
+	}
+	return errs, nil
+}
+
+// Appends changes to history in transaction.
+func appendBoltHistory(tx *bolt.Tx, changes ...URLChange) error {
+	bucket := tx.Bucket(boltHistory)
+	for _, change := range changes {
+		var history []URLChange
+		if data := bucket.Get([]byte(change.ShortURL)); data != nil {
+			if err := json.Unmarshal(data, &history); err != nil {
+				return fmt.Errorf("failed to decode history of %q: %w", change.ShortURL, err)
+			}
+		}
+		data, err := json.Marshal(append(history, change))
+		if err != nil {
+			return err
+		}
+		if err = bucket.Put([]byte(change.ShortURL), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Points short url of user to new long url and saves change to history.
+//
+// Change to the same long url is not saved.
+func (s *BoltStorage) UpdateWithContext(_ context.Context, change URLChange) (URLChange, error) {
+	if change.LongURL == "" {
+		return change, ErrEmptyLongURL
+	}
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		url, has, err := getBoltURL(tx, change.ShortURL)
+		if err != nil {
+			return err
+		}
+		if !has || url.UserID != change.ChangedBy {
+			return ErrNotOwnedURL
+		}
+		if url.Deleted {
+			return ErrDeletedURL
+		}
+		change.PreviousURL = url.Long
+		if url.Long == change.LongURL {
+			return nil
+		}
+		dedupBucket := tx.Bucket(boltDedup)
+		key, dedup := s.DedupScope.Key(change.ChangedBy, URLOptions{NormalizedURL: change.NormalizedURL}.DedupKey(change.LongURL))
+		shortURL, held, err := boltKeyHolder(tx, key)
+		if err != nil {
+			return err
+		}
+		if dedup && held && shortURL != change.ShortURL {
+			return ErrConflictURL
+		}
+		if previousKey, previousDedup := s.dedupKey(url); previousDedup &&
+			bytes.Equal(dedupBucket.Get([]byte(previousKey)), []byte(change.ShortURL)) {
+			if err = dedupBucket.Delete([]byte(previousKey)); err != nil {
+				return err
+			}
+		}
+		if dedup {
+			if err = dedupBucket.Put([]byte(key), []byte(change.ShortURL)); err != nil {
+				return err
+			}
+		}
+		url.Long = change.LongURL
+		url.NormalizedURL = change.NormalizedURL
+		if url.NormalizedURL == url.Long {
+			url.NormalizedURL = ""
+		}
+		if err = putBoltURL(tx, change.ShortURL, url); err != nil {
+			return err
+		}
+		return appendBoltHistory(tx, change)
+	})
+	return change, err
+}
+
+// Appends changes made before to history without applying them.
+func (s *BoltStorage) RestoreHistory(changes ...URLChange) {
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		return appendBoltHistory(tx, changes...)
+	})
+	if err != nil {
+		logger.Log.Error("cannot restore url history", zap.Error(err))
+	}
+}
+
+// Returns changes of short url in order they were made.
+func (s *BoltStorage) GetHistoryWithContext(_ context.Context, shortURL string) ([]URLChange, error) {
+	var history []URLChange
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(boltHistory).Get([]byte(shortURL)); data != nil {
+			return json.Unmarshal(data, &history)
+		}
+		return nil
+	})
+	return history, err
+}
+
+// Clear all mappings.
+func (s *BoltStorage) Clear() error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltURLs, boltDedup, boltUsers, boltExpiry, boltHistory} {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Removes urls expired before given moment.
+func (s *BoltStorage) PurgeExpiredWithContext(_ context.Context, expiredBefore time.Time) ([]string, error) {
+	var purged []string
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		purged = nil
+		end := boltUint64(uint64(expiredBefore.UnixNano()))
+		expiry := tx.Bucket(boltExpiry)
+		var expired [][]byte
+		cursor := expiry.Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key[:8], end) < 0; key, _ = cursor.Next() {
+			expired = append(expired, key)
+		}
+		for _, key := range expired {
+			if err := expiry.Delete(key); err != nil {
+				return err
+			}
+			shortURL := string(key[8:])
+			url, has, err := getBoltURL(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			if !has {
+				continue
+			}
+			if err = s.remove(tx, shortURL, url); err != nil {
+				return err
+			}
+			purged = append(purged, shortURL)
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// Removes saved url from all buckets.
+func (s *BoltStorage) remove(tx *bolt.Tx, shortURL string, url boltURL) error {
+	if dedupKey, dedup := s.dedupKey(url); dedup &&
+		bytes.Equal(tx.Bucket(boltDedup).Get([]byte(dedupKey)), []byte(shortURL)) {
+		if err := tx.Bucket(boltDedup).Delete([]byte(dedupKey)); err != nil {
+			return err
+		}
+	}
+	if !url.ExpiresAt.IsZero() {
+		if err := tx.Bucket(boltExpiry).Delete(boltExpiryKey(url.ExpiresAt, shortURL)); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteUserURL(tx, url); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltHistory).Delete([]byte(shortURL)); err != nil {
+		return err
+	}
+	return tx.Bucket(boltURLs).Delete([]byte(shortURL))
+}
+
+// Removes url from bucket of its user, empty bucket is removed too.
+func (s *BoltStorage) deleteUserURL(tx *bolt.Tx, url boltURL) error {
+	users := tx.Bucket(boltUsers)
+	user := users.Bucket(boltUserKey(url.UserID))
+	if user == nil {
+		return nil
+	}
+	if err := user.Delete(boltUint64(url.UserSeq)); err != nil {
+		return err
+	}
+	if key, _ := user.Cursor().First(); key == nil {
+		return users.DeleteBucket(boltUserKey(url.UserID))
+	}
+	return nil
+}
+
+// Calls fn for every saved url including deleted and expired ones in order of creation.
+//
+// Urls are read in one transaction, so fn sees consistent state of storage.
+func (s *BoltStorage) ForEachURLWithContext(_ context.Context, fn func(url OwnedURL) error) error {
+	var urls []OwnedURL
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltURLs).ForEach(func(key, value []byte) error {
+			var url boltURL
+			if err := json.Unmarshal(value, &url); err != nil {
+				return fmt.Errorf("failed to decode url %q: %w", key, err)
+			}
+			urls = append(urls, OwnedURL{StoredURL: url.stored(string(key)), UserID: url.UserID})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	sortOwnedURLs(urls)
+	for _, url := range urls {
+		if err = fn(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Saves url with its owner and deletion flag instead of url saved under the same short url.
+func (s *BoltStorage) ReplaceWithContext(_ context.Context, url OwnedURL) error {
+	if url.Short == "" {
+		return errors.New("cannot save empty url")
+	}
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		key, dedup := s.DedupScope.Key(url.UserID, url.DedupKey(url.Long))
+		if shortURL := tx.Bucket(boltDedup).Get([]byte(key)); dedup && shortURL != nil && string(shortURL) != url.Short {
+			return ErrConflictURL
+		}
+		existing, has, err := getBoltURL(tx, url.Short)
+		if err != nil {
+			return err
+		}
+		if has {
+			if err = s.remove(tx, url.Short, existing); err != nil {
+				return err
+			}
+		}
+		if err = s.store(tx, url.Long, url.Short, url.UserID, url.URLOptions); err != nil || !url.Deleted {
+			return err
+		}
+		stored, _, err := getBoltURL(tx, url.Short)
+		if err != nil {
+			return err
+		}
+		stored.Deleted = true
+		return putBoltURL(tx, url.Short, stored)
+	})
+}
+
+// Check whether storage alive.
+func (s *BoltStorage) Ping() error {
+	return s.DB.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// Reads urls of user in order of saving.
+func (s *BoltStorage) userURLs(userID string) ([]StoredURL, error) {
+	var urls []StoredURL
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		user := tx.Bucket(boltUsers).Bucket(boltUserKey(userID))
+		if user == nil {
+			return nil
+		}
+		return user.ForEach(func(_, value []byte) error {
+			url, has, err := getBoltURL(tx, string(value))
+			if err == nil && has {
+				urls = append(urls, url.stored(string(value)))
+			}
+			return err
+		})
+	})
+	return urls, err
+}
+
+// Returns all urls saved by user.
+func (s *BoltStorage) GetUserURLs(_ context.Context, userID string) ([]URLPair, error) {
+	urls, err := s.userURLs(userID)
+	if err != nil {
+		return nil, err
+	}
+	var res []URLPair
+	for _, url := range urls {
+		url.CreatedAt = time.Time{}
+		res = append(res, url.URLPair)
+	}
+	return res, nil
+}
+
+// Returns page of urls saved by user ordered by creation time.
+func (s *BoltStorage) GetUserURLsPageWithContext(_ context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	urls, err := s.userURLs(userID)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	return paginate(urls, query)
+}
+
+// Returns number of stored not deleted urls.
+func (s *BoltStorage) CountURLsWithContext(_ context.Context) (int64, error) {
+	var count int64
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltURLs).ForEach(func(key, value []byte) error {
+			var url boltURL
+			if err := json.Unmarshal(value, &url); err != nil {
+				return fmt.Errorf("failed to decode url %q: %w", key, err)
+			}
+			if !url.Deleted {
+				count++
+			}
+			return nil
+		})
+	})
+	return count, err
+}
+
+// Returns whether short url is saved by user.
+func (s *BoltStorage) OwnsURLWithContext(_ context.Context, userID string, shortURL string) (bool, error) {
+	var owns bool
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		url, has, err := getBoltURL(tx, shortURL)
+		owns = has && url.UserID == userID
+		return err
+	})
+	return owns, err
+}
+
+// Returns number of users that have saved urls.
+func (s *BoltStorage) CountUsersWithContext(_ context.Context) (int64, error) {
+	var count int64
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsers).ForEach(func(_, _ []byte) error {
+			count++
+			return nil
+		})
+	})
+	return count, err
+}
+
+// Deletes given urls previously saved by user.
+//
+// Urls saved by other users are silently skipped.
+func (s *BoltStorage) DeleteUserURLs(_ context.Context, urlsByUser ...URLsForDelete) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		for _, urls := range urlsByUser {
+			for _, shortURL := range urls.ShortURLs {
+				url, has, err := getBoltURL(tx, shortURL)
+				if err != nil {
+					return err
+				}
+				if !has || url.UserID != urls.UserID || url.Deleted {
+					continue
+				}
+				url.Deleted = true
+				if err = putBoltURL(tx, shortURL, url); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Writes consistent copy of database to file while storage keeps serving requests.
+//
+// Copy is written to temporary file first, so existing backup is replaced only by complete one.
+func (s *BoltStorage) Backup(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(tmp)
+		return err
+	})
+	if err = errors.Join(err, tmp.Sync(), tmp.Close()); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Backups database to file with given interval until context is done.
+func (s *BoltStorage) BackupEvery(ctx context.Context, path string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Backup(path); err != nil {
+				logger.Log.Error("cannot backup storage", zap.String("path", path), zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package urlstorage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Statistics of url cache.
+type CacheStats struct {
+	Hits   int64 // lookups answered from cache
+	Misses int64 // lookups that queried storage
+	Size   int   // number of cached urls
+}
+
+// Cached lookup of short url.
+type cacheEntry struct {
+	shortURL  string
+	url       StoredURL
+	err       error // ErrNotFoundURL for cached absence of url
+	expiresAt time.Time
+}
+
+// Wrapper over url storages that caches lookups of short urls in memory.
+//
+// Least recently used urls are evicted above capacity, entries live for TTL,
+// absent urls are cached for NegativeTTL. Concurrent misses of one url make single query.
+// Cache is invalidated by changes made through wrapper only,
+// so changes made by other replicas are seen after TTL.
+type CacheWrapper struct {
+	URLStorage
+	UserURLStorage UserURLStorage
+	Capacity       int
+	TTL            time.Duration
+	NegativeTTL    time.Duration
+	Now            func() time.Time
+	mutex          sync.Mutex
+	order          *list.List // most recently used first
+	entries        map[string]*list.Element
+	version        uint64 // incremented by every invalidation
+	loads          singleflight.Group
+	hits           atomic.Int64
+	misses         atomic.Int64
+}
+
+// New caching wrapper over url storages keeping up to capacity urls.
+func NewCacheWrapper(storage URLStorage, userStorage UserURLStorage, capacity int, ttl time.Duration, negativeTTL time.Duration) *CacheWrapper {
+	return &CacheWrapper{
+		URLStorage:     storage,
+		UserURLStorage: userStorage,
+		Capacity:       capacity,
+		TTL:            ttl,
+		NegativeTTL:    negativeTTL,
+		Now:            time.Now,
+		order:          list.New(),
+		entries:        make(map[string]*list.Element),
+	}
+}
+
+// Returns cached lookup of short url if it has not expired.
+func (c *CacheWrapper) get(shortURL string) (cacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, has := c.entries[shortURL]
+	if !has {
+		return cacheEntry{}, false
+	}
+	entry := element.Value.(cacheEntry)
+	if !c.Now().Before(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, shortURL)
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry, true
+}
+
+// Caches lookup unless cache has been invalidated since version.
+func (c *CacheWrapper) add(entry cacheEntry, version uint64) {
+	ttl := c.TTL
+	if entry.err != nil {
+		ttl = c.NegativeTTL
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if ttl <= 0 || c.version != version {
+		return
+	}
+	entry.expiresAt = c.Now().Add(ttl)
+	if element, has := c.entries[entry.shortURL]; has {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.shortURL] = c.order.PushFront(entry)
+	for c.order.Len() > c.Capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cacheEntry).shortURL)
+	}
+}
+
+// Removes short urls from cache.
+func (c *CacheWrapper) invalidate(shortURLs ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.version++
+	for _, shortURL := range shortURLs {
+		if element, has := c.entries[shortURL]; has {
+			c.order.Remove(element)
+			delete(c.entries, shortURL)
+		}
+	}
+}
+
+// Removes all urls from cache.
+func (c *CacheWrapper) invalidateAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.version++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// Returns saved url from cache or from storage.
+//
+// Concurrent misses share one query that is not canceled with context of any of them,
+// misses that join after query has finished are answered from cache.
+func (c *CacheWrapper) GetURLWithContext(ctx context.Context, shortURL string) (StoredURL, error) {
+	if entry, has := c.get(shortURL); has {
+		c.hits.Add(1)
+		return entry.url, entry.err
+	}
+	c.misses.Add(1)
+	res, err, _ := c.loads.Do(shortURL, func() (any, error) {
+		if entry, has := c.get(shortURL); has {
+			return entry.url, entry.err
+		}
+		c.mutex.Lock()
+		version := c.version
+		c.mutex.Unlock()
+		url, err := c.URLStorage.GetURLWithContext(context.WithoutCancel(ctx), shortURL)
+		if err == nil || errors.Is(err, ErrNotFoundURL) {
+			c.add(cacheEntry{shortURL: shortURL, url: url, err: err}, version)
+		}
+		return url, err
+	})
+	return res.(StoredURL), err
+}
+
+// Returns long url of short url from cache or from storage.
+func (c *CacheWrapper) GetLongURLWithContext(ctx context.Context, shortURL string) (string, error) {
+	url, err := c.GetURLWithContext(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+	if url.Deleted {
+		return "", ErrDeletedURL
+	}
+	if url.ExpiredAt(c.Now()) {
+		return "", ErrExpiredURL
+	}
+	return url.Long, nil
+}
+
+// Adds mapping longURL -> shortURL forgetting cached absence of short url.
+func (c *CacheWrapper) StoreWithContext(ctx context.Context, longURL string, shortURL string, userID string, options URLOptions) error {
+	err := c.URLStorage.StoreWithContext(ctx, longURL, shortURL, userID, options)
+	c.invalidate(shortURL)
+	return err
+}
+
+// Adds number of mappings longURL -> shortURL forgetting cached absence of short urls.
+func (c *CacheWrapper) StoreManyWithContext(ctx context.Context, long2ShortUrls []URLPair, userID string) ([]error, error) {
+	errs, err := c.URLStorage.StoreManyWithContext(ctx, long2ShortUrls, userID)
+	shortURLs := make([]string, len(long2ShortUrls))
+	for i := range long2ShortUrls {
+		shortURLs[i] = long2ShortUrls[i].Short
+	}
+	c.invalidate(shortURLs...)
+	return errs, err
+}
+
+// Points short url to new long url and removes it from cache.
+func (c *CacheWrapper) UpdateWithContext(ctx context.Context, change URLChange) (URLChange, error) {
+	updated, err := c.URLStorage.UpdateWithContext(ctx, change)
+	c.invalidate(change.ShortURL)
+	return updated, err
+}
+
+// Removes expired urls from storage and clears cache.
+func (c *CacheWrapper) PurgeExpiredWithContext(ctx context.Context, expiredBefore time.Time) ([]string, error) {
+	purged, err := c.URLStorage.PurgeExpiredWithContext(ctx, expiredBefore)
+	if len(purged) != 0 {
+		c.invalidateAll()
+	}
+	return purged, err
+}
+
+// Clear all mappings and cache.
+func (c *CacheWrapper) Clear() error {
+	err := c.URLStorage.Clear()
+	c.invalidateAll()
+	return err
+}
+
+// Returns all urls saved by user.
+func (c *CacheWrapper) GetUserURLs(ctx context.Context, userID string) ([]URLPair, error) {
+	return c.UserURLStorage.GetUserURLs(ctx, userID)
+}
+
+// Returns page of urls saved by user ordered by creation time.
+func (c *CacheWrapper) GetUserURLsPageWithContext(ctx context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	return c.UserURLStorage.GetUserURLsPageWithContext(ctx, userID, query)
+}
+
+// Returns whether short url is saved by user.
+func (c *CacheWrapper) OwnsURLWithContext(ctx context.Context, userID string, shortURL string) (bool, error) {
+	return c.UserURLStorage.OwnsURLWithContext(ctx, userID, shortURL)
+}
+
+// Returns number of users that have saved urls.
+func (c *CacheWrapper) CountUsersWithContext(ctx context.Context) (int64, error) {
+	return c.UserURLStorage.CountUsersWithContext(ctx)
+}
+
+// Deletes given urls previously saved by user and removes them from cache.
+func (c *CacheWrapper) DeleteUserURLs(ctx context.Context, urlsByUser ...URLsForDelete) error {
+	err := c.UserURLStorage.DeleteUserURLs(ctx, urlsByUser...)
+	for _, urls := range urlsByUser {
+		c.invalidate(urls.ShortURLs...)
+	}
+	return err
+}
+
+// Returns cache statistics.
+func (c *CacheWrapper) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: c.order.Len()}
+}
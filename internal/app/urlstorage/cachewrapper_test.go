@@ -0,0 +1,114 @@
+package urlstorage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/mocks"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+func TestCacheWrapper_ReadThrough(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockStorage.On("GetURLWithContext", mock.Anything, "a").
+		Return(urlstorage.StoredURL{URLPair: urlstorage.URLPair{Long: "url_a", Short: "a"}}, nil).Twice()
+	mockStorage.On("GetURLWithContext", mock.Anything, "b").
+		Return(urlstorage.StoredURL{}, urlstorage.ErrNotFoundURL).Twice()
+	cache := urlstorage.NewCacheWrapper(mockStorage, mockUserStorage, 10, time.Minute, 10*time.Second)
+	cache.Now = func() time.Time { return now }
+
+	for range 3 {
+		longURL, err := cache.GetLongURLWithContext(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "url_a", longURL)
+		_, err = cache.GetLongURLWithContext(ctx, "b")
+		require.ErrorIs(t, err, urlstorage.ErrNotFoundURL)
+	}
+	assert.Equal(t, urlstorage.CacheStats{Hits: 4, Misses: 2, Size: 2}, cache.Stats())
+
+	now = now.Add(30 * time.Second)
+	_, err := cache.GetLongURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	_, err = cache.GetLongURLWithContext(ctx, "b")
+	require.ErrorIs(t, err, urlstorage.ErrNotFoundURL)
+
+	now = now.Add(time.Minute)
+	_, err = cache.GetLongURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, urlstorage.CacheStats{Hits: 5, Misses: 4, Size: 2}, cache.Stats())
+}
+
+func TestCacheWrapper_Invalidation(t *testing.T) {
+	ctx := context.Background()
+	storage := urlstorage.NewSimpleMapLockStorage()
+	cache := urlstorage.NewCacheWrapper(storage, storage, 10, time.Hour, time.Hour)
+
+	_, err := cache.GetLongURLWithContext(ctx, "a")
+	require.ErrorIs(t, err, urlstorage.ErrNotFoundURL)
+	require.NoError(t, cache.StoreWithContext(ctx, "url_a", "a", "user", urlstorage.URLOptions{}))
+	longURL, err := cache.GetLongURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "url_a", longURL)
+
+	_, err = cache.UpdateWithContext(ctx, urlstorage.URLChange{ShortURL: "a", LongURL: "url_a2", ChangedBy: "user"})
+	require.NoError(t, err)
+	longURL, err = cache.GetLongURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "url_a2", longURL)
+
+	require.NoError(t, cache.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user", ShortURLs: []string{"a"}}))
+	_, err = cache.GetLongURLWithContext(ctx, "a")
+	require.ErrorIs(t, err, urlstorage.ErrDeletedURL)
+
+	require.NoError(t, cache.Clear())
+	assert.Equal(t, 0, cache.Stats().Size)
+}
+
+func TestCacheWrapper_Eviction(t *testing.T) {
+	ctx := context.Background()
+	storage := urlstorage.NewSimpleMapLockStorage()
+	cache := urlstorage.NewCacheWrapper(storage, storage, 2, time.Hour, time.Hour)
+	_, err := cache.StoreManyWithContext(ctx, []urlstorage.URLPair{
+		{Long: "url_a", Short: "a"}, {Long: "url_b", Short: "b"}, {Long: "url_c", Short: "c"}}, "")
+	require.NoError(t, err)
+
+	for _, shortURL := range []string{"a", "b", "a", "c", "a", "b"} {
+		_, err := cache.GetLongURLWithContext(ctx, shortURL)
+		require.NoError(t, err)
+	}
+	// b is evicted by c, then c is evicted by b.
+	assert.Equal(t, urlstorage.CacheStats{Hits: 2, Misses: 4, Size: 2}, cache.Stats())
+}
+
+func TestCacheWrapper_CollapseMisses(t *testing.T) {
+	ctx := context.Background()
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	release := make(chan time.Time)
+	mockStorage.On("GetURLWithContext", mock.Anything, "a").
+		WaitUntil(release).
+		Return(urlstorage.StoredURL{URLPair: urlstorage.URLPair{Long: "url_a", Short: "a"}}, nil).Once()
+	cache := urlstorage.NewCacheWrapper(mockStorage, mockUserStorage, 10, time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			longURL, err := cache.GetLongURLWithContext(ctx, "a")
+			assert.NoError(t, err)
+			assert.Equal(t, "url_a", longURL)
+		}()
+	}
+	require.Eventually(t, func() bool { return cache.Stats().Misses == 10 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,102 @@
+package urlstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Columns of temporary table batch of urls is copied into.
+var copyColumns = []string{"ord", "user_id", "short_url", "long_url", "expires_at", "created_at",
+	"normalized_url", "dedup_key", "interstitial", "redirect_code"}
+
+// Saves batch by copying it into temporary table and moving new urls to shortener by one statement.
+//
+// Returns the same errors as saving urls one by one.
+func (s *DatabaseStorage) copyMany(ctx context.Context, long2ShortUrls []URLPair, userID string) ([]error, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "CREATE TEMP TABLE shortener_batch (ord INTEGER, user_id TEXT, short_url TEXT, long_url TEXT, expires_at TIMESTAMPTZ, created_at TIMESTAMPTZ, normalized_url TEXT, dedup_key TEXT, interstitial BOOLEAN, redirect_code SMALLINT) ON COMMIT DROP")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch table: %w", err)
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"shortener_batch"}, copyColumns,
+		pgx.CopyFromSlice(len(long2ShortUrls), func(i int) ([]any, error) {
+			url := long2ShortUrls[i]
+			normalizedURL := url.DedupKey(url.Long)
+			return []any{i, userID, url.Short, url.Long, nullTime(url.ExpiresAt), nullTime(url.CreatedAt),
+				normalizedURL, s.dedupKey(userID, normalizedURL), url.Interstitial, url.RedirectCode}, nil
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy rows: %w", err)
+	}
+
+	// Dedup keys held by expired urls are released, so that their long urls can be saved again before urls are purged.
+	_, err = tx.Exec(ctx,
+		"UPDATE shortener SET dedup_key = NULL WHERE dedup_key IN (SELECT dedup_key FROM shortener_batch) AND expires_at <= now()")
+	if err != nil {
+		return nil, fmt.Errorf("failed to release dedup keys: %w", err)
+	}
+
+	// Urls are inserted in batch order, so of urls with the same short url or dedup key the first one is saved.
+	rows, err := tx.Query(ctx,
+		"INSERT INTO shortener (user_id, short_url, long_url, expires_at, created_at, normalized_url, dedup_key, interstitial, redirect_code) SELECT user_id, short_url, long_url, expires_at, COALESCE(created_at, now()), normalized_url, dedup_key, interstitial, redirect_code FROM shortener_batch ORDER BY ord ON CONFLICT DO NOTHING RETURNING short_url")
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert rows: %w", err)
+	}
+	shortURLs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert rows: %w", err)
+	}
+	inserted := make(map[string]bool, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		inserted[shortURL] = true
+	}
+
+	rows, err = tx.Query(ctx,
+		"SELECT shortener.short_url, shortener.dedup_key FROM shortener JOIN shortener_batch ON shortener.short_url = shortener_batch.short_url")
+	if err != nil {
+		return nil, fmt.Errorf("failed to select conflicting rows: %w", err)
+	}
+	existingKeys := make(map[string]sql.NullString)
+	var shortURL string
+	var key sql.NullString
+	_, err = pgx.ForEachRow(rows, []any{&shortURL, &key}, func() error {
+		existingKeys[shortURL] = key
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select conflicting rows: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return s.batchErrors(long2ShortUrls, userID, inserted, existingKeys), nil
+}
+
+// Returns errors of batch urls saved by one statement.
+//
+// Inserted contains saved short urls, existingKeys contains dedup keys saved under short urls of batch.
+func (s *DatabaseStorage) batchErrors(long2ShortUrls []URLPair, userID string, inserted map[string]bool, existingKeys map[string]sql.NullString) []error {
+	errs := make([]error, len(long2ShortUrls))
+	for i, url := range long2ShortUrls {
+		if inserted[url.Short] {
+			// Next urls with the same short url conflict with this one.
+			delete(inserted, url.Short)
+			continue
+		}
+		if key, ok := existingKeys[url.Short]; ok && key != s.dedupKey(userID, url.DedupKey(url.Long)) {
+			errs[i] = ErrShortURLTaken
+		} else {
+			errs[i] = ErrConflictURL
+		}
+	}
+	return errs
+}
@@ -3,58 +3,92 @@ package urlstorage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Storage storing urls in postgresql.
 type DatabaseStorage struct {
-	DB *sql.DB
+	DB         *sql.DB
+	DedupScope DedupScope // scope of unique dedup_key column, zero value is global
+	// Native pool DB is opened from, batches are saved by copy through it if set.
+	Pool *pgxpool.Pool
+	// Number of urls in batch starting from which they are saved by copy, zero disables copy.
+	CopyThreshold int
 }
 
 // New postgresql storage.
+//
+// Schema is expected to be created by migrations package.
 func NewDatabaseStorage(db *sql.DB) *DatabaseStorage {
-	ret := &DatabaseStorage{DB: db}
-	ret.init()
-	return ret
+	return &DatabaseStorage{DB: db}
 }
 
-// Creates all tables if needed.
-func (s *DatabaseStorage) init() error {
-	tx, err := s.DB.BeginTx(context.Background(), nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	tx.Exec(`CREATE TABLE shortener("user_id" TEXT, "short_url" TEXT, "long_url" TEXT, "deleted" BOOLEAN DEFAULT false)`)
-	tx.Exec(`CREATE INDEX user_id_index ON shortener USING btree(user_id)`)
-	tx.Exec(`CREATE UNIQUE INDEX long_url_index ON shortener USING btree(long_url)`)
-	return tx.Commit()
+// Returns dedup key of url saved by user, NULL if urls are not deduplicated.
+func (s *DatabaseStorage) dedupKey(userID string, normalizedURL string) sql.NullString {
+	key, dedup := s.DedupScope.Key(userID, normalizedURL)
+	return sql.NullString{String: key, Valid: dedup}
+}
+
+// Converts zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
 
 // Returns longURL from shortURL.
 func (s *DatabaseStorage) GetLongURLWithContext(ctx context.Context, shortURL string) (string, error) {
 	row := s.DB.QueryRowContext(ctx,
-		"SELECT long_url, deleted FROM shortener WHERE short_url = $1", shortURL)
+		"SELECT long_url, deleted, expires_at FROM shortener WHERE short_url = $1", shortURL)
 	var longURL string
 	var deleted bool
-	err := row.Scan(&longURL, &deleted)
+	var expiresAt sql.NullTime
+	err := row.Scan(&longURL, &deleted, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFoundURL
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to scan rows: %w", err)
 	}
 	if deleted {
 		return "", ErrDeletedURL
 	}
+	if (URLOptions{ExpiresAt: expiresAt.Time}).ExpiredAt(time.Now()) {
+		return "", ErrExpiredURL
+	}
 	return longURL, nil
 }
 
-// Returns shortURL from longURL.
-func (s *DatabaseStorage) GetShortURLWithContext(ctx context.Context, longURL string) (string, error) {
+// Returns saved url with its options and creation time, deleted and expired urls are returned too.
+func (s *DatabaseStorage) GetURLWithContext(ctx context.Context, shortURL string) (StoredURL, error) {
+	row := s.DB.QueryRowContext(ctx,
+		"SELECT long_url, expires_at, created_at, deleted, interstitial, redirect_code FROM shortener WHERE short_url = $1", shortURL)
+	url := StoredURL{URLPair: URLPair{Short: shortURL}}
+	var expiresAt sql.NullTime
+	err := row.Scan(&url.Long, &expiresAt, &url.CreatedAt, &url.Deleted, &url.Interstitial, &url.RedirectCode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StoredURL{}, ErrNotFoundURL
+	}
+	if err != nil {
+		return StoredURL{}, fmt.Errorf("failed to scan rows: %w", err)
+	}
+	url.ExpiresAt = expiresAt.Time
+	return url, nil
+}
+
+// Returns shortURL by normalized form of longURL in dedup scope of user.
+func (s *DatabaseStorage) GetShortURLWithContext(ctx context.Context, normalizedURL string, userID string) (string, error) {
+	key := s.dedupKey(userID, normalizedURL)
+	if !key.Valid {
+		return "", ErrNotDeduplicated
+	}
 	row := s.DB.QueryRowContext(ctx,
-		"SELECT short_url FROM shortener WHERE long_url = $1", longURL)
+		"SELECT short_url FROM shortener WHERE dedup_key = $1", key.String)
 	var shortURL string
 	err := row.Scan(&shortURL)
 	if err != nil {
@@ -63,21 +97,102 @@ func (s *DatabaseStorage) GetShortURLWithContext(ctx context.Context, longURL st
 	return shortURL, nil
 }
 
-// Adds mapping longURL -> shortURL.
-func (s *DatabaseStorage) StoreWithContext(ctx context.Context, longURL string, shortURL string, userID string) error {
-	if longURL == "" {
-		return ErrEmptyLongURL
+// Executor of statements, either database or transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Drops dedup key of expired url holding it, so that long url can be saved again before url is purged.
+// Returns whether key was released.
+func releaseExpiredKey(ctx context.Context, db execer, key sql.NullString) (bool, error) {
+	if !key.Valid {
+		return false, nil
 	}
+	res, err := db.ExecContext(ctx,
+		"UPDATE shortener SET dedup_key = NULL WHERE dedup_key = $1 AND expires_at <= now()", key.String)
+	if err != nil {
+		return false, fmt.Errorf("failed to release dedup key: %w", err)
+	}
+	released, _ := res.RowsAffected()
+	return released > 0, nil
+}
+
+// Inserts url, mapping unique violations to storage errors.
+func (s *DatabaseStorage) insert(ctx context.Context, longURL string, shortURL string, userID string, options URLOptions) error {
 	_, err := s.DB.ExecContext(ctx,
-		"INSERT into shortener (user_id, short_url, long_url) VALUES($1, $2, $3)", userID, shortURL, longURL)
+		"INSERT into shortener (user_id, short_url, long_url, expires_at, created_at, normalized_url, dedup_key, interstitial, redirect_code) VALUES($1, $2, $3, $4, COALESCE($5, now()), $6, $7, $8, $9)",
+		userID, shortURL, longURL, nullTime(options.ExpiresAt), nullTime(options.CreatedAt), options.DedupKey(longURL),
+		s.dedupKey(userID, options.DedupKey(longURL)), options.Interstitial, options.RedirectCode)
 	if e, ok := err.(*pgconn.PgError); ok && e.Code == pgerrcode.UniqueViolation {
 		err = ErrConflictURL
+		if e.ConstraintName == "short_url_index" {
+			err = ErrShortURLTaken
+		}
 	}
 	return err
 }
 
+// Adds mapping longURL -> shortURL.
+func (s *DatabaseStorage) StoreWithContext(ctx context.Context, longURL string, shortURL string, userID string, options URLOptions) error {
+	if longURL == "" {
+		return ErrEmptyLongURL
+	}
+	err := s.insert(ctx, longURL, shortURL, userID, options)
+	if errors.Is(err, ErrConflictURL) {
+		// Dedup key held by expired url is released and insert is retried once.
+		released, releaseErr := releaseExpiredKey(ctx, s.DB, s.dedupKey(userID, options.DedupKey(longURL)))
+		if releaseErr != nil {
+			return releaseErr
+		}
+		if released {
+			err = s.insert(ctx, longURL, shortURL, userID, options)
+		}
+	}
+	return err
+}
+
+// Finds out why url has not been inserted by statement of StoreManyWithContext and
+// inserts it again if its dedup key is released by expired url.
+func (s *DatabaseStorage) retryExpiredConflict(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, url URLPair, userID string) error {
+	reason := s.conflictReason(ctx, tx, url, userID)
+	if reason != ErrConflictURL {
+		return reason
+	}
+	normalizedURL := url.DedupKey(url.Long)
+	released, err := releaseExpiredKey(ctx, tx, s.dedupKey(userID, normalizedURL))
+	if err != nil {
+		return err
+	}
+	if !released {
+		return reason
+	}
+	res, err := stmt.ExecContext(ctx, userID, url.Short, url.Long, nullTime(url.ExpiresAt), nullTime(url.CreatedAt),
+		normalizedURL, s.dedupKey(userID, normalizedURL), url.Interstitial, url.RedirectCode)
+	if err != nil {
+		return fmt.Errorf("failed to insert rows: %w", err)
+	}
+	if c, _ := res.RowsAffected(); c == 0 {
+		return s.conflictReason(ctx, tx, url, userID)
+	}
+	return nil
+}
+
+// Finds out why url has not been inserted.
+func (s *DatabaseStorage) conflictReason(ctx context.Context, tx *sql.Tx, url URLPair, userID string) error {
+	var existingKey sql.NullString
+	err := tx.QueryRowContext(ctx,
+		"SELECT dedup_key FROM shortener WHERE short_url = $1", url.Short).Scan(&existingKey)
+	if err == nil && existingKey != s.dedupKey(userID, url.DedupKey(url.Long)) {
+		return ErrShortURLTaken
+	}
+	return ErrConflictURL
+}
+
 // Adds number of mappings longURL -> shortURL.
 func (s *DatabaseStorage) StoreManyWithContext(ctx context.Context, long2ShortUrls []URLPair, userID string) ([]error, error) {
+	if s.Pool != nil && s.CopyThreshold > 0 && len(long2ShortUrls) >= s.CopyThreshold {
+		return s.copyMany(ctx, long2ShortUrls, userID)
+	}
 	var errs []error
 	tx, errDB := s.DB.Begin()
 	if errDB != nil {
@@ -86,16 +201,25 @@ func (s *DatabaseStorage) StoreManyWithContext(ctx context.Context, long2ShortUr
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(ctx,
-		"INSERT INTO shortener (user_id, short_url, long_url) VALUES($1, $2, $3) ON CONFLICT do nothing")
+		"INSERT INTO shortener (user_id, short_url, long_url, expires_at, created_at, normalized_url, dedup_key, interstitial, redirect_code) VALUES($1, $2, $3, $4, COALESCE($5, now()), $6, $7, $8, $9) ON CONFLICT do nothing")
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert rows: %w", err)
 	}
 	defer stmt.Close()
 
 	for i := range long2ShortUrls {
-		res, errExec := stmt.ExecContext(ctx, userID, long2ShortUrls[i].Short, long2ShortUrls[i].Long)
+		normalizedURL := long2ShortUrls[i].DedupKey(long2ShortUrls[i].Long)
+		res, errExec := stmt.ExecContext(ctx, userID, long2ShortUrls[i].Short, long2ShortUrls[i].Long,
+			nullTime(long2ShortUrls[i].ExpiresAt), nullTime(long2ShortUrls[i].CreatedAt), normalizedURL,
+			s.dedupKey(userID, normalizedURL), long2ShortUrls[i].Interstitial, long2ShortUrls[i].RedirectCode)
+		if errExec != nil {
+			return nil, fmt.Errorf("failed to insert rows: %w", errExec)
+		}
 		if c, _ := res.RowsAffected(); c == 0 {
-			errExec = ErrConflictURL
+			errExec = s.retryExpiredConflict(ctx, tx, stmt, long2ShortUrls[i], userID)
+			if !errors.Is(errExec, ErrConflictURL) && !errors.Is(errExec, ErrShortURLTaken) && errExec != nil {
+				return nil, errExec
+			}
 		}
 		errs = append(errs, errExec)
 	}
@@ -105,11 +229,91 @@ func (s *DatabaseStorage) StoreManyWithContext(ctx context.Context, long2ShortUr
 	return errs, nil
 }
 
+// Points short url of user to new long url and saves change to history in one transaction.
+//
+// Change to the same long url is not saved.
+func (s *DatabaseStorage) UpdateWithContext(ctx context.Context, change URLChange) (URLChange, error) {
+	if change.LongURL == "" {
+		return change, ErrEmptyLongURL
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return change, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT long_url, deleted FROM shortener WHERE short_url = $1 AND user_id = $2 FOR UPDATE",
+		change.ShortURL, change.ChangedBy).Scan(&change.PreviousURL, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return change, ErrNotOwnedURL
+	}
+	if err != nil {
+		return change, fmt.Errorf("failed to select url: %w", err)
+	}
+	if deleted {
+		return change, ErrDeletedURL
+	}
+	if change.PreviousURL == change.LongURL {
+		return change, nil
+	}
+
+	normalizedURL := URLOptions{NormalizedURL: change.NormalizedURL}.DedupKey(change.LongURL)
+	// Failed statement aborts transaction, so key of expired url is released before update.
+	if _, err = releaseExpiredKey(ctx, tx, s.dedupKey(change.ChangedBy, normalizedURL)); err != nil {
+		return change, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE shortener SET long_url = $2, normalized_url = $3, dedup_key = $4 WHERE short_url = $1",
+		change.ShortURL, change.LongURL, normalizedURL, s.dedupKey(change.ChangedBy, normalizedURL))
+	if e, ok := err.(*pgconn.PgError); ok && e.Code == pgerrcode.UniqueViolation {
+		return change, ErrConflictURL
+	}
+	if err != nil {
+		return change, fmt.Errorf("failed to update url: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO url_history (short_url, previous_url, long_url, changed_by, changed_at) VALUES($1, $2, $3, $4, $5)",
+		change.ShortURL, change.PreviousURL, change.LongURL, change.ChangedBy, change.ChangedAt)
+	if err != nil {
+		return change, fmt.Errorf("failed to insert url history: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return change, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return change, nil
+}
+
+// Returns changes of short url in order they were made.
+func (s *DatabaseStorage) GetHistoryWithContext(ctx context.Context, shortURL string) ([]URLChange, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT short_url, previous_url, long_url, changed_by, changed_at FROM url_history WHERE short_url = $1 ORDER BY id",
+		shortURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select url history: %w", err)
+	}
+	defer rows.Close()
+	var res []URLChange
+	for rows.Next() {
+		var change URLChange
+		err = rows.Scan(&change.ShortURL, &change.PreviousURL, &change.LongURL, &change.ChangedBy, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get rows: %w", err)
+	}
+	return res, nil
+}
+
 // Returns all urls saved by user.
 func (s *DatabaseStorage) GetUserURLs(ctx context.Context, userID string) ([]URLPair, error) {
 	var res []URLPair
 	rows, err := s.DB.QueryContext(ctx,
-		"SELECT short_url, long_url FROM shortener WHERE user_id = $1", userID)
+		"SELECT short_url, long_url, expires_at FROM shortener WHERE user_id = $1", userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin select query: %w", err)
 	}
@@ -119,10 +323,12 @@ func (s *DatabaseStorage) GetUserURLs(ctx context.Context, userID string) ([]URL
 	defer rows.Close()
 	for rows.Next() {
 		var userURL URLPair
-		err = rows.Scan(&userURL.Short, &userURL.Long)
+		var expiresAt sql.NullTime
+		err = rows.Scan(&userURL.Short, &userURL.Long, &expiresAt)
 		if err != nil {
 			return nil, err
 		}
+		userURL.ExpiresAt = expiresAt.Time
 
 		res = append(res, userURL)
 	}
@@ -130,6 +336,109 @@ func (s *DatabaseStorage) GetUserURLs(ctx context.Context, userID string) ([]URL
 	return res, nil
 }
 
+// Returns page of urls saved by user ordered by creation time.
+//
+// Page is selected by index on user id, creation time and short url.
+func (s *DatabaseStorage) GetUserURLsPageWithContext(ctx context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	var conditions strings.Builder
+	args := []any{userID}
+	addCondition := func(condition string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i := range values {
+			args = append(args, values[i])
+			placeholders[i] = len(args)
+		}
+		fmt.Fprintf(&conditions, " AND "+condition, placeholders...)
+	}
+	if !query.IncludeDeleted {
+		addCondition("NOT deleted")
+	}
+	if query.LongURLPart != "" {
+		addCondition("strpos(long_url, $%d) > 0", query.LongURLPart)
+	}
+	if !query.CreatedAfter.IsZero() {
+		addCondition("created_at > $%d", query.CreatedAfter)
+	}
+	if !query.CreatedBefore.IsZero() {
+		addCondition("created_at < $%d", query.CreatedBefore)
+	}
+	order, compare := "ASC", ">"
+	if query.Descending {
+		order, compare = "DESC", "<"
+	}
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return UserURLsPage{}, err
+		}
+		addCondition("(created_at, short_url) "+compare+" ($%d, $%d)", cursor.createdAt, cursor.shortURL)
+	}
+	limit := ""
+	if query.Limit > 0 {
+		args = append(args, query.Limit+1)
+		limit = fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT short_url, long_url, expires_at, created_at, deleted FROM shortener WHERE user_id = $1"+
+			conditions.String()+" ORDER BY created_at "+order+", short_url "+order+limit, args...)
+	if err != nil {
+		return UserURLsPage{}, fmt.Errorf("failed to select user urls: %w", err)
+	}
+	defer rows.Close()
+	var page UserURLsPage
+	for rows.Next() {
+		var url StoredURL
+		var expiresAt sql.NullTime
+		if err = rows.Scan(&url.Short, &url.Long, &expiresAt, &url.CreatedAt, &url.Deleted); err != nil {
+			return UserURLsPage{}, err
+		}
+		url.ExpiresAt = expiresAt.Time
+		page.URLs = append(page.URLs, url)
+	}
+	if err = rows.Err(); err != nil {
+		return UserURLsPage{}, fmt.Errorf("failed to get rows: %w", err)
+	}
+	if query.Limit > 0 && len(page.URLs) > query.Limit {
+		page.URLs = page.URLs[:query.Limit]
+		page.NextCursor = encodeCursor(page.URLs[query.Limit-1])
+	}
+	return page, nil
+}
+
+// Returns number of stored not deleted urls.
+func (s *DatabaseStorage) CountURLsWithContext(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT count(*) FROM shortener WHERE NOT deleted").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count urls: %w", err)
+	}
+	return count, nil
+}
+
+// Returns whether short url is saved by user.
+func (s *DatabaseStorage) OwnsURLWithContext(ctx context.Context, userID string, shortURL string) (bool, error) {
+	var owns bool
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM shortener WHERE short_url = $1 AND user_id = $2)", shortURL, userID).Scan(&owns)
+	if err != nil {
+		return false, fmt.Errorf("failed to select url owner: %w", err)
+	}
+	return owns, nil
+}
+
+// Returns number of users that have saved urls.
+func (s *DatabaseStorage) CountUsersWithContext(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT count(DISTINCT user_id) FROM shortener").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
 // Deletes given urls previously saved by user.
 func (s *DatabaseStorage) DeleteUserURLs(ctx context.Context, urlsByUser ...URLsForDelete) error {
 	query :=
@@ -156,6 +465,109 @@ func (s *DatabaseStorage) DeleteUserURLs(ctx context.Context, urlsByUser ...URLs
 	return tx.Commit()
 }
 
+// Removes urls expired before given moment together with their history.
+func (s *DatabaseStorage) PurgeExpiredWithContext(ctx context.Context, expiredBefore time.Time) ([]string, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM url_history WHERE short_url IN (SELECT short_url FROM shortener WHERE expires_at < $1)", expiredBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired url history: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx,
+		"DELETE FROM shortener WHERE expires_at < $1 RETURNING short_url", expiredBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired urls: %w", err)
+	}
+	defer rows.Close()
+	var purged []string
+	for rows.Next() {
+		var shortURL string
+		if err = rows.Scan(&shortURL); err != nil {
+			return nil, fmt.Errorf("failed to scan rows: %w", err)
+		}
+		purged = append(purged, shortURL)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to delete expired urls: %w", err)
+	}
+	return purged, tx.Commit()
+}
+
+// Calls fn for every saved url including deleted and expired ones in order of creation.
+//
+// Rows are streamed, so fn is called while query is open.
+func (s *DatabaseStorage) ForEachURLWithContext(ctx context.Context, fn func(url OwnedURL) error) error {
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT user_id, short_url, long_url, normalized_url, expires_at, created_at, deleted, interstitial, redirect_code FROM shortener ORDER BY created_at, short_url")
+	if err != nil {
+		return fmt.Errorf("failed to select urls: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url OwnedURL
+		var normalizedURL sql.NullString
+		var expiresAt sql.NullTime
+		err = rows.Scan(&url.UserID, &url.Short, &url.Long, &normalizedURL, &expiresAt, &url.CreatedAt,
+			&url.Deleted, &url.Interstitial, &url.RedirectCode)
+		if err != nil {
+			return fmt.Errorf("failed to scan rows: %w", err)
+		}
+		if normalizedURL.String != url.Long {
+			url.NormalizedURL = normalizedURL.String
+		}
+		url.ExpiresAt = expiresAt.Time
+		if err = fn(url); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to get rows: %w", err)
+	}
+	return nil
+}
+
+// Saves url with its owner and deletion flag instead of url saved under the same short url.
+func (s *DatabaseStorage) ReplaceWithContext(ctx context.Context, url OwnedURL) error {
+	if url.Long == "" {
+		return ErrEmptyLongURL
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	key := s.dedupKey(url.UserID, url.DedupKey(url.Long))
+	var shortURL string
+	err = tx.QueryRowContext(ctx,
+		"SELECT short_url FROM shortener WHERE dedup_key = $1", key).Scan(&shortURL)
+	if err == nil && shortURL != url.Short {
+		return ErrConflictURL
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to check conflicting url: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM url_history WHERE short_url = $1", url.Short); err != nil {
+		return fmt.Errorf("failed to delete url history: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM shortener WHERE short_url = $1", url.Short); err != nil {
+		return fmt.Errorf("failed to delete url: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO shortener (user_id, short_url, long_url, expires_at, created_at, normalized_url, dedup_key, interstitial, redirect_code, deleted) VALUES($1, $2, $3, $4, COALESCE($5, now()), $6, $7, $8, $9, $10)",
+		url.UserID, url.Short, url.Long, nullTime(url.ExpiresAt), nullTime(url.CreatedAt), url.DedupKey(url.Long),
+		key, url.Interstitial, url.RedirectCode, url.Deleted)
+	if err != nil {
+		return fmt.Errorf("failed to insert url: %w", err)
+	}
+	return tx.Commit()
+}
+
 // Clear all mappings.
 func (s *DatabaseStorage) Clear() error {
 	tx, err := s.DB.BeginTx(context.Background(), nil)
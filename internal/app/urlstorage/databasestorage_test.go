@@ -2,9 +2,13 @@ package urlstorage
 
 import (
 	"context"
+	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -34,7 +38,7 @@ func TestDatabaseStorage_GetLongURL(t *testing.T) {
 			if tt.wantErr && !tt.deleted {
 				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{}))
 			} else {
-				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"long_url", "deleted"}).AddRow(tt.want, tt.deleted))
+				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"long_url", "deleted", "expires_at"}).AddRow(tt.want, tt.deleted, nil))
 			}
 			got, err := tt.s.GetLongURLWithContext(context.Background(), tt.shortURL)
 			if tt.deleted {
@@ -73,7 +77,7 @@ func TestDatabaseStorage_GetShortURL(t *testing.T) {
 			} else {
 				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow(tt.want))
 			}
-			got, err := tt.s.GetShortURLWithContext(context.Background(), tt.longURL)
+			got, err := tt.s.GetShortURLWithContext(context.Background(), tt.longURL, "")
 			if !tt.wantErr {
 				require.NoError(t, err)
 			}
@@ -104,7 +108,7 @@ func TestDatabaseStorage_Store(t *testing.T) {
 			if tt.expectedError == nil {
 				mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(1, 1))
 			}
-			err := storage.StoreWithContext(context.Background(), tt.longURL, tt.shortURL, "")
+			err := storage.StoreWithContext(context.Background(), tt.longURL, tt.shortURL, "", URLOptions{})
 			require.Equal(t, tt.expectedError, err)
 
 		})
@@ -141,6 +145,65 @@ func TestDatabaseStorage_StoreMany(t *testing.T) {
 	}
 }
 
+func TestDatabaseStorage_StoreManyConflicts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	mock.ExpectBegin()
+	prepare := mock.ExpectPrepare("INSERT INTO shortener")
+	prepare.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT dedup_key FROM shortener WHERE short_url").WithArgs("a").
+		WillReturnRows(sqlmock.NewRows([]string{"dedup_key"}).AddRow("url_other"))
+	prepare.ExpectExec().WithArgs("", "b", "URL_B", nil, nil, "url_b", "url_b", false, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT dedup_key FROM shortener WHERE short_url").WithArgs("b").
+		WillReturnRows(sqlmock.NewRows([]string{"dedup_key"}).AddRow("url_b"))
+	mock.ExpectExec("UPDATE shortener SET dedup_key = NULL").WithArgs("url_b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	errs, err := storage.StoreManyWithContext(context.Background(), []URLPair{
+		{Long: "url_a", Short: "a"},
+		{Long: "URL_B", Short: "b", URLOptions: URLOptions{NormalizedURL: "url_b"}}}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []error{ErrShortURLTaken, ErrConflictURL}, errs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_StoreOverExpired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	dedupViolation := &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "dedup_key_index"}
+	mock.ExpectExec("INSERT").WithArgs("", "b", "url_a", nil, nil, "url_a", "url_a", false, 0).WillReturnError(dedupViolation)
+	mock.ExpectExec("UPDATE shortener SET dedup_key = NULL WHERE dedup_key = (.+) AND expires_at <= now()").
+		WithArgs("url_a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT").WithArgs("", "b", "url_a", nil, nil, "url_a", "url_a", false, 0).WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, storage.StoreWithContext(context.Background(), "url_a", "b", "", URLOptions{}), "key of expired url is released")
+
+	mock.ExpectExec("INSERT").WithArgs("", "c", "url_a", nil, nil, "url_a", "url_a", false, 0).WillReturnError(dedupViolation)
+	mock.ExpectExec("UPDATE shortener SET dedup_key = NULL").WithArgs("url_a").WillReturnResult(sqlmock.NewResult(0, 0))
+	require.ErrorIs(t, storage.StoreWithContext(context.Background(), "url_a", "c", "", URLOptions{}), ErrConflictURL)
+
+	mock.ExpectBegin()
+	prepare := mock.ExpectPrepare("INSERT INTO shortener")
+	prepare.ExpectExec().WithArgs("", "d", "url_d", nil, nil, "url_d", "url_d", false, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT dedup_key FROM shortener WHERE short_url").WithArgs("d").WillReturnRows(sqlmock.NewRows([]string{"dedup_key"}))
+	mock.ExpectExec("UPDATE shortener SET dedup_key = NULL").WithArgs("url_d").WillReturnResult(sqlmock.NewResult(0, 1))
+	prepare.ExpectExec().WithArgs("", "d", "url_d", nil, nil, "url_d", "url_d", false, 0).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	errs, err := storage.StoreManyWithContext(context.Background(), []URLPair{{Long: "url_d", Short: "d"}}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil}, errs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestDatabaseStorage_GetUserURLs(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -160,9 +223,9 @@ func TestDatabaseStorage_GetUserURLs(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.wantError {
-				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url"}))
+				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "expires_at"}))
 			} else {
-				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url"}).AddRow("a", "url_a"))
+				mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "expires_at"}).AddRow("a", "url_a", nil))
 			}
 			rows, err := storage.GetUserURLs(context.Background(), tt.user)
 			if !tt.wantError {
@@ -188,21 +251,51 @@ func TestDatabaseStorage_DeleteUserURLs(t *testing.T) {
 	storage.DeleteUserURLs(context.Background(), URLsForDelete{UserID: "user_1", ShortURLs: []string{"a", "b"}})
 }
 
-func TestDatabaseStorage_init(t *testing.T) {
+func TestDatabaseStorage_OwnsURL(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
 
-	mock.ExpectBegin()
-	mock.ExpectExec("CREATE TABLE shortener").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("CREATE INDEX user_id_index").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("CREATE UNIQUE INDEX long_url_index").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectCommit()
+	storage := NewDatabaseStorage(db)
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("a", "user").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	owns, err := storage.OwnsURLWithContext(context.Background(), "user", "a")
+	require.NoError(t, err)
+	assert.True(t, owns)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_Expiration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
 
 	storage := NewDatabaseStorage(db)
-	storage.init()
+	expiresAt := time.Now().Add(-time.Minute)
+	mock.ExpectQuery("SELECT long_url, deleted, expires_at").
+		WillReturnRows(sqlmock.NewRows([]string{"long_url", "deleted", "expires_at"}).AddRow("url_a", false, expiresAt))
+	_, err = storage.GetLongURLWithContext(context.Background(), "a")
+	require.ErrorIs(t, err, ErrExpiredURL)
+
+	mock.ExpectExec("INSERT into shortener").WithArgs("user", "b", "url_b", expiresAt, nil, "url_b", "url_b", false, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = storage.StoreWithContext(context.Background(), "url_b", "b", "user", URLOptions{ExpiresAt: expiresAt})
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM url_history").WithArgs(expiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("DELETE FROM shortener WHERE expires_at <").WithArgs(expiresAt).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow("a").AddRow("b"))
+	mock.ExpectCommit()
+	purged, err := storage.PurgeExpiredWithContext(context.Background(), expiresAt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, purged)
+	require.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestDatabaseStorage_Clear(t *testing.T) {
@@ -219,3 +312,244 @@ func TestDatabaseStorage_Clear(t *testing.T) {
 	storage := NewDatabaseStorage(db)
 	storage.Clear()
 }
+
+func TestDatabaseStorage_Count(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM shortener WHERE NOT deleted").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectQuery("SELECT count\\(DISTINCT user_id\\) FROM shortener").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	urls, err := storage.CountURLsWithContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), urls)
+	users, err := storage.CountUsersWithContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), users)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_Update(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	now := time.Now()
+	selectURL := "SELECT long_url, deleted FROM shortener WHERE short_url = (.+) FOR UPDATE"
+	urlColumns := []string{"long_url", "deleted"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectURL).WithArgs("a", "user_1").
+		WillReturnRows(sqlmock.NewRows(urlColumns).AddRow("url_a", false))
+	mock.ExpectExec("UPDATE shortener SET dedup_key = NULL").WithArgs("url_b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE shortener SET long_url").WithArgs("a", "URL_B", "url_b", "url_b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO url_history").WithArgs("a", "url_a", "URL_B", "user_1", now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	change, err := storage.UpdateWithContext(context.Background(),
+		URLChange{ShortURL: "a", LongURL: "URL_B", NormalizedURL: "url_b", ChangedBy: "user_1", ChangedAt: now})
+	require.NoError(t, err)
+	assert.Equal(t, "url_a", change.PreviousURL)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectURL).WithArgs("a", "user_2").WillReturnRows(sqlmock.NewRows(urlColumns))
+	mock.ExpectRollback()
+	_, err = storage.UpdateWithContext(context.Background(),
+		URLChange{ShortURL: "a", LongURL: "url_c", ChangedBy: "user_2", ChangedAt: now})
+	require.ErrorIs(t, err, ErrNotOwnedURL)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectURL).WithArgs("d", "user_1").
+		WillReturnRows(sqlmock.NewRows(urlColumns).AddRow("url_d", true))
+	mock.ExpectRollback()
+	_, err = storage.UpdateWithContext(context.Background(),
+		URLChange{ShortURL: "d", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now})
+	require.ErrorIs(t, err, ErrDeletedURL)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectURL).WithArgs("a", "user_1").
+		WillReturnRows(sqlmock.NewRows(urlColumns).AddRow("url_b", false))
+	mock.ExpectExec("UPDATE shortener SET dedup_key = NULL").WithArgs("url_c").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE shortener SET long_url").WithArgs("a", "url_c", "url_c", "url_c").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "dedup_key_index"})
+	mock.ExpectRollback()
+	_, err = storage.UpdateWithContext(context.Background(),
+		URLChange{ShortURL: "a", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now})
+	require.ErrorIs(t, err, ErrConflictURL)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_GetHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	now := time.Now()
+	mock.ExpectQuery("SELECT (.+) FROM url_history WHERE short_url").WithArgs("a").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "previous_url", "long_url", "changed_by", "changed_at"}).
+			AddRow("a", "url_a", "url_b", "user_1", now).
+			AddRow("a", "url_b", "url_c", "user_1", now))
+
+	history, err := storage.GetHistoryWithContext(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, []URLChange{
+		{ShortURL: "a", PreviousURL: "url_a", LongURL: "url_b", ChangedBy: "user_1", ChangedAt: now},
+		{ShortURL: "a", PreviousURL: "url_b", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now},
+	}, history)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_GetUserURLsPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	createdAt := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"short_url", "long_url", "expires_at", "created_at", "deleted"}
+	mock.ExpectQuery(`SELECT (.+) FROM shortener WHERE user_id = \$1 AND NOT deleted AND strpos\(long_url, \$2\) > 0 `+
+		`AND created_at > \$3 ORDER BY created_at ASC, short_url ASC LIMIT \$4`).
+		WithArgs("user", "site", createdAt, 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("a", "http://site/a", nil, createdAt.Add(time.Hour), false).
+			AddRow("b", "http://site/b", nil, createdAt.Add(2*time.Hour), false).
+			AddRow("c", "http://site/c", nil, createdAt.Add(3*time.Hour), false))
+
+	page, err := storage.GetUserURLsPageWithContext(context.Background(), "user",
+		UserURLsQuery{Limit: 2, LongURLPart: "site", CreatedAfter: createdAt})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 2)
+	assert.Equal(t, "b", page.URLs[1].Short)
+	require.NotEmpty(t, page.NextCursor)
+
+	mock.ExpectQuery(`SELECT (.+) FROM shortener WHERE user_id = \$1 AND \(created_at, short_url\) < \(\$2, \$3\) `+
+		`ORDER BY created_at DESC, short_url DESC LIMIT \$4`).
+		WithArgs("user", createdAt.Add(2*time.Hour), "b", 3).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("a", "http://site/a", nil, createdAt.Add(time.Hour), true))
+	page, err = storage.GetUserURLsPageWithContext(context.Background(), "user",
+		UserURLsQuery{Limit: 2, Cursor: page.NextCursor, IncludeDeleted: true, Descending: true})
+	require.NoError(t, err)
+	require.Len(t, page.URLs, 1)
+	assert.True(t, page.URLs[0].Deleted)
+	assert.Empty(t, page.NextCursor)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_DedupScope(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	storage.DedupScope = DedupPerUser
+	mock.ExpectExec("INSERT into shortener").WithArgs("user_1", "a", "url_a", nil, nil, "url_a", "user_1 url_a", false, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT short_url FROM shortener WHERE dedup_key").WithArgs("user_1 url_a").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow("a"))
+	require.NoError(t, storage.StoreWithContext(context.Background(), "url_a", "a", "user_1", URLOptions{}))
+	shortURL, err := storage.GetShortURLWithContext(context.Background(), "url_a", "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", shortURL)
+
+	storage.DedupScope = DedupNone
+	mock.ExpectExec("INSERT into shortener").WithArgs("user_1", "b", "url_a", nil, nil, "url_a", nil, false, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, storage.StoreWithContext(context.Background(), "url_a", "b", "user_1", URLOptions{}))
+	_, err = storage.GetShortURLWithContext(context.Background(), "url_a", "user_1")
+	require.ErrorIs(t, err, ErrNotDeduplicated)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_GetURL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT long_url, expires_at, created_at, deleted, interstitial, redirect_code FROM shortener").WithArgs("a").
+		WillReturnRows(sqlmock.NewRows([]string{"long_url", "expires_at", "created_at", "deleted", "interstitial", "redirect_code"}).
+			AddRow("url_a", nil, createdAt, true, true, 308))
+	mock.ExpectQuery("SELECT long_url").WithArgs("b").WillReturnRows(sqlmock.NewRows([]string{}))
+
+	url, err := storage.GetURLWithContext(context.Background(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, StoredURL{URLPair: URLPair{Short: "a", Long: "url_a",
+		URLOptions: URLOptions{CreatedAt: createdAt, Interstitial: true, RedirectCode: 308}}, Deleted: true}, url)
+	_, err = storage.GetURLWithContext(context.Background(), "b")
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_Transfer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	storage := NewDatabaseStorage(db)
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mock.ExpectQuery("SELECT user_id, short_url, long_url").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "short_url", "long_url", "normalized_url", "expires_at", "created_at", "deleted", "interstitial", "redirect_code"}).
+			AddRow("user_1", "a", "url_a", "", nil, createdAt, true, false, 301))
+	var urls []OwnedURL
+	require.NoError(t, storage.ForEachURLWithContext(context.Background(), func(url OwnedURL) error {
+		urls = append(urls, url)
+		return nil
+	}))
+	assert.Equal(t, []OwnedURL{{UserID: "user_1", StoredURL: StoredURL{URLPair: URLPair{Short: "a", Long: "url_a",
+		URLOptions: URLOptions{CreatedAt: createdAt, RedirectCode: 301}}, Deleted: true}}}, urls)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT short_url FROM shortener WHERE dedup_key").WithArgs("url_a").WillReturnRows(sqlmock.NewRows([]string{}))
+	mock.ExpectExec("DELETE FROM url_history").WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM shortener").WithArgs("a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO shortener").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	require.NoError(t, storage.ReplaceWithContext(context.Background(), urls[0]))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT short_url FROM shortener WHERE dedup_key").WithArgs("url_a").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow("b"))
+	mock.ExpectRollback()
+	require.ErrorIs(t, storage.ReplaceWithContext(context.Background(), urls[0]), ErrConflictURL)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDatabaseStorage_BatchErrors(t *testing.T) {
+	storage := NewDatabaseStorage(nil)
+	urls := []URLPair{
+		{Short: "a", Long: "url_a"},
+		{Short: "a", Long: "url_b"},
+		{Short: "c", Long: "url_c"},
+		{Short: "d", Long: "url_d"},
+		{Short: "e", Long: "url_a"},
+	}
+	inserted := map[string]bool{"a": true}
+	existingKeys := map[string]sql.NullString{
+		"a": {String: "url_a", Valid: true},
+		"c": {String: "url_c", Valid: true},
+		"d": {String: "url_x", Valid: true},
+	}
+	errs := storage.batchErrors(urls, "user_1", inserted, existingKeys)
+	assert.Equal(t, []error{nil, ErrShortURLTaken, ErrConflictURL, ErrShortURLTaken, ErrConflictURL}, errs)
+}
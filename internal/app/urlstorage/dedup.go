@@ -0,0 +1,44 @@
+package urlstorage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error in case urls are not deduplicated so short url cannot be found by long url.
+var ErrNotDeduplicated = errors.New("urls are not deduplicated")
+
+// Scope in which equal long urls share one short url.
+type DedupScope string
+
+const (
+	DedupGlobal  DedupScope = "global" // one short url for all users
+	DedupPerUser DedupScope = "user"   // one short url for every user
+	DedupNone    DedupScope = "none"   // new short url every time
+)
+
+// Parses dedup scope, empty string means global scope.
+func ParseDedupScope(scope string) (DedupScope, error) {
+	switch DedupScope(scope) {
+	case DedupGlobal, "":
+		return DedupGlobal, nil
+	case DedupPerUser, DedupNone:
+		return DedupScope(scope), nil
+	default:
+		return "", fmt.Errorf("unknown dedup scope %q", scope)
+	}
+}
+
+// Returns key that must be unique among saved urls and whether urls are deduplicated at all.
+//
+// Zero scope is global. Space separates user from url since sanitized urls have no spaces.
+func (s DedupScope) Key(userID string, normalizedURL string) (string, bool) {
+	switch s {
+	case DedupNone:
+		return "", false
+	case DedupPerUser:
+		return userID + " " + normalizedURL, true
+	default:
+		return normalizedURL, true
+	}
+}
@@ -0,0 +1,95 @@
+package urlstorage_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+func TestParseDedupScope(t *testing.T) {
+	tests := []struct {
+		scope   string
+		want    urlstorage.DedupScope
+		wantErr bool
+	}{
+		{scope: "", want: urlstorage.DedupGlobal},
+		{scope: "global", want: urlstorage.DedupGlobal},
+		{scope: "user", want: urlstorage.DedupPerUser},
+		{scope: "none", want: urlstorage.DedupNone},
+		{scope: "tenant", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			got, err := urlstorage.ParseDedupScope(tt.scope)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDedupScope_Key(t *testing.T) {
+	key, dedup := urlstorage.DedupScope("").Key("user_1", "url_a")
+	assert.Equal(t, "url_a", key)
+	assert.True(t, dedup)
+	key, dedup = urlstorage.DedupPerUser.Key("user_1", "url_a")
+	assert.Equal(t, "user_1 url_a", key)
+	assert.True(t, dedup)
+	_, dedup = urlstorage.DedupNone.Key("user_1", "url_a")
+	assert.False(t, dedup)
+}
+
+func TestStorage_ExpiredURLReleasesDedupKey(t *testing.T) {
+	storages := map[string]func(t *testing.T) transferStorage{
+		"simple_map": func(t *testing.T) transferStorage { return urlstorage.NewSimpleMapLockStorage() },
+		"sharded":    func(t *testing.T) transferStorage { return urlstorage.NewShardedStorage(4) },
+		"bolt":       func(t *testing.T) transferStorage { return newBoltStorage(t) },
+		"file": func(t *testing.T) transferStorage {
+			storage := urlstorage.NewSimpleMapLockStorage()
+			wrapper, err := urlstorage.NewFileDumpWrapper(filepath.Join(t.TempDir(), "dump"), storage, storage, 0)
+			require.NoError(t, err)
+			t.Cleanup(func() { wrapper.Close() })
+			return wrapper
+		},
+	}
+	expired := urlstorage.URLOptions{ExpiresAt: time.Now().Add(-time.Hour)}
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			ctx := context.Background()
+			require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", expired))
+			require.NoError(t, storage.StoreWithContext(ctx, "url_x", "x", "user_1", expired))
+			require.NoError(t, storage.StoreWithContext(ctx, "url_c", "c", "user_1", urlstorage.URLOptions{}))
+
+			require.NoError(t, storage.StoreWithContext(ctx, "url_a", "b", "user_1", urlstorage.URLOptions{}), "expired url does not hold long url")
+			shortURL, err := storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+			require.NoError(t, err)
+			assert.Equal(t, "b", shortURL)
+			require.ErrorIs(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{}), urlstorage.ErrShortURLTaken)
+			_, err = storage.GetLongURLWithContext(ctx, "a")
+			require.ErrorIs(t, err, urlstorage.ErrExpiredURL)
+			require.ErrorIs(t, storage.StoreWithContext(ctx, "url_a", "d", "user_1", urlstorage.URLOptions{}), urlstorage.ErrConflictURL)
+
+			_, err = storage.UpdateWithContext(ctx, urlstorage.URLChange{ShortURL: "c", LongURL: "url_x", ChangedBy: "user_1"})
+			require.NoError(t, err, "expired url does not block update")
+
+			purged, err := storage.PurgeExpiredWithContext(ctx, time.Now())
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"a", "x"}, purged)
+			shortURL, err = storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+			require.NoError(t, err)
+			assert.Equal(t, "b", shortURL, "purge keeps key of new url")
+			shortURL, err = storage.GetShortURLWithContext(ctx, "url_x", "user_1")
+			require.NoError(t, err)
+			assert.Equal(t, "c", shortURL)
+		})
+	}
+}
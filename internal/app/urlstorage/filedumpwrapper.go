@@ -4,22 +4,55 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/valinurovdenis/urlshortener/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// Type of record in dump log.
+type DumpType string
+
+const (
+	DumpStore      DumpType = "store"       // single mapping saved by user
+	DumpStoreBatch DumpType = "store_batch" // number of mappings saved by user
+	DumpDelete     DumpType = "delete"      // urls deleted by user
+	DumpPurge      DumpType = "purge"       // expired urls removed
+	DumpUpdate     DumpType = "update"      // long url of short url changed by user
+	DumpUser       DumpType = "user"        // id issued to new user
 )
 
-// Dump of mapping shortURL <-> longURL for saving to file.
+// Record of dump log.
+//
+// Records without type are treated as DumpStore for compatibility with old dumps.
 type URLDump struct {
-	UUID        int64  `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	UUID         int64       `json:"uuid"`
+	Type         DumpType    `json:"type,omitempty"`
+	UserID       string      `json:"user_id,omitempty"`
+	ShortURL     string      `json:"short_url,omitempty"`
+	OriginalURL  string      `json:"original_url,omitempty"`
+	Deleted      bool        `json:"deleted,omitempty"`
+	URLs         []URLPair   `json:"urls,omitempty"`
+	ShortURLs    []string    `json:"short_urls,omitempty"`
+	PurgedBefore time.Time   `json:"purged_before,omitzero"`
+	ChangedAt    time.Time   `json:"changed_at,omitzero"`
+	History      []URLChange `json:"history,omitempty"`
+	LastUserID   int64       `json:"last_user_id,omitempty"`
+	URLOptions
 }
 
 // Writes mapping dumps to file.
 type DumpWriter struct {
 	file   *os.File
 	writer *bufio.Writer
+	size   int64
 }
 
 // New dump writer.
@@ -28,10 +61,16 @@ func NewDumpWriter(filename string) (*DumpWriter, error) {
 	if err != nil {
 		return nil, err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
 
 	return &DumpWriter{
 		file:   file,
 		writer: bufio.NewWriter(file),
+		size:   info.Size(),
 	}, nil
 }
 
@@ -46,70 +85,497 @@ func (p *DumpWriter) Write(dump URLDump) error {
 	if _, err := p.writer.Write(data); err != nil {
 		return err
 	}
+	p.size += int64(len(data))
 	return p.writer.Flush()
 }
 
-// Wrapper that stores url mappings into file.
+// Size of dump file in bytes.
+func (p *DumpWriter) Size() int64 {
+	return p.size
+}
+
+// Flushes and closes dump file.
+func (p *DumpWriter) Close() error {
+	err := p.writer.Flush()
+	return errors.Join(err, p.file.Close())
+}
+
+// State of storage collected from dump records.
+type dumpState struct {
+	order      []string
+	entries    map[string]*URLDump
+	lastUserID int64 // greatest user id issued or seen in urls
+}
+
+func newDumpState() *dumpState {
+	return &dumpState{entries: make(map[string]*URLDump)}
+}
+
+func (s *dumpState) add(shortURL string, longURL string, userID string, deleted bool, options URLOptions, history []URLChange) {
+	if _, has := s.entries[shortURL]; !has {
+		s.order = append(s.order, shortURL)
+	}
+	s.entries[shortURL] = &URLDump{
+		Type: DumpStore, UserID: userID, ShortURL: shortURL, OriginalURL: longURL, Deleted: deleted, URLOptions: options,
+		History: history}
+}
+
+// Applies dump record to state.
+func (s *dumpState) apply(dump URLDump) {
+	// Dumps written before user ids were saved only have ids in urls of users.
+	if id, err := strconv.ParseInt(dump.UserID, 10, 64); err == nil {
+		s.lastUserID = max(s.lastUserID, id)
+	}
+	switch dump.Type {
+	case DumpStore, "":
+		s.add(dump.ShortURL, dump.OriginalURL, dump.UserID, dump.Deleted, dump.URLOptions, dump.History)
+	case DumpStoreBatch:
+		for _, url := range dump.URLs {
+			s.add(url.Short, url.Long, dump.UserID, false, url.URLOptions, nil)
+		}
+	case DumpUpdate:
+		if entry, has := s.entries[dump.ShortURL]; has && entry.UserID == dump.UserID {
+			entry.History = append(entry.History, URLChange{
+				ShortURL:      dump.ShortURL,
+				PreviousURL:   entry.OriginalURL,
+				LongURL:       dump.OriginalURL,
+				ChangedBy:     dump.UserID,
+				ChangedAt:     dump.ChangedAt,
+				NormalizedURL: dump.NormalizedURL,
+			})
+			entry.OriginalURL = dump.OriginalURL
+			entry.NormalizedURL = dump.NormalizedURL
+		}
+	case DumpDelete:
+		for _, shortURL := range dump.ShortURLs {
+			if entry, has := s.entries[shortURL]; has && entry.UserID == dump.UserID {
+				entry.Deleted = true
+			}
+		}
+	case DumpUser:
+		s.lastUserID = max(s.lastUserID, dump.LastUserID)
+	case DumpPurge:
+		s.order = slices.DeleteFunc(s.order, func(shortURL string) bool {
+			if expiresAt := s.entries[shortURL].ExpiresAt; !expiresAt.IsZero() && expiresAt.Before(dump.PurgedBefore) {
+				delete(s.entries, shortURL)
+				return true
+			}
+			return false
+		})
+	}
+}
+
+// Reads all records from file into state.
+// Returns uuid of last record.
+func readDump(filename string, state *dumpState) (int64, error) {
+	var lastUUID int64
+	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	data, err := reader.ReadBytes('\n')
+	for err == nil {
+		var dump URLDump
+		if err = json.Unmarshal(data, &dump); err != nil {
+			return 0, err
+		}
+		state.apply(dump)
+		lastUUID = dump.UUID
+		data, err = reader.ReadBytes('\n')
+	}
+	if err != io.EOF {
+		return 0, err
+	}
+	return lastUUID, nil
+}
+
+// Writes state as sequence of store records into new file.
+func writeSnapshot(filename string, state *dumpState) error {
+	os.Remove(filename)
+	writer, err := NewDumpWriter(filename)
+	if err != nil {
+		return err
+	}
+	var uuid int64
+	if state.lastUserID != 0 {
+		uuid++
+		err = writer.Write(URLDump{UUID: uuid, Type: DumpUser, LastUserID: state.lastUserID})
+	}
+	for _, shortURL := range state.order {
+		if err != nil {
+			break
+		}
+		dump := *state.entries[shortURL]
+		uuid++
+		dump.UUID = uuid
+		err = writer.Write(dump)
+	}
+	return errors.Join(err, writer.file.Sync(), writer.Close())
+}
+
+// Appends content of file src to the end of file dst.
+func appendFile(dst string, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return errors.Join(err, out.Sync(), out.Close())
+}
+
+// Wrapper that logs all changes of url storages into file.
+//
+// File is compacted into snapshot in background once it grows past compactSize.
 type FileDumpWrapper struct {
 	URLStorage
-	filename   string
-	dumpWriter *DumpWriter
-	counter    int64
-	dumpMutex  sync.Mutex
+	UserURLStorage UserURLStorage
+	filename       string
+	dumpWriter     *DumpWriter
+	counter        int64
+	lastUserID     int64
+	compactSize    int64
+	compacting     bool
+	compactWG      sync.WaitGroup
+	dumpMutex      sync.Mutex
+}
+
+// Writes record to file and starts compaction if needed. Must be called under lock.
+func (f *FileDumpWrapper) write(dump URLDump) error {
+	f.counter += 1
+	dump.UUID = f.counter
+	if err := f.dumpWriter.Write(dump); err != nil {
+		return err
+	}
+	if f.compactSize > 0 && !f.compacting && f.dumpWriter.Size() > f.compactSize {
+		f.compacting = true
+		f.compactWG.Add(1)
+		go func() {
+			defer f.compactWG.Done()
+			if err := f.compact(); err != nil {
+				logger.Log.Error("cannot compact dump", zap.Error(err))
+			}
+		}()
+	}
+	return nil
+}
+
+// Sets creation time of url to now if not set, so that it is same in storage and in dump.
+func withCreatedAt(options URLOptions) URLOptions {
+	if options.CreatedAt.IsZero() {
+		options.CreatedAt = time.Now().UTC()
+	}
+	return options
 }
 
 // Wrapper over url storage that saves obtained mapping longURL -> shortURL.
-func (f *FileDumpWrapper) StoreWithContext(ctx context.Context, longURL string, shortURL string, _ string) error {
-	if err := f.URLStorage.StoreWithContext(ctx, longURL, shortURL, ""); err != nil {
+func (f *FileDumpWrapper) StoreWithContext(ctx context.Context, longURL string, shortURL string, userID string, options URLOptions) error {
+	options = withCreatedAt(options)
+	if err := f.URLStorage.StoreWithContext(ctx, longURL, shortURL, userID, options); err != nil {
 		return err
 	}
 
 	f.dumpMutex.Lock()
 	defer f.dumpMutex.Unlock()
-	f.counter += 1
-	dump := URLDump{UUID: f.counter, ShortURL: shortURL, OriginalURL: longURL}
-	return f.dumpWriter.Write(dump)
+	return f.write(URLDump{Type: DumpStore, UserID: userID, ShortURL: shortURL, OriginalURL: longURL, URLOptions: options})
+}
+
+// Wrapper over url storage that saves successfully stored mappings.
+func (f *FileDumpWrapper) StoreManyWithContext(ctx context.Context, long2ShortUrls []URLPair, userID string) ([]error, error) {
+	long2ShortUrls = slices.Clone(long2ShortUrls)
+	for i := range long2ShortUrls {
+		long2ShortUrls[i].URLOptions = withCreatedAt(long2ShortUrls[i].URLOptions)
+	}
+	errs, err := f.URLStorage.StoreManyWithContext(ctx, long2ShortUrls, userID)
+	if err != nil {
+		return errs, err
+	}
+
+	var stored []URLPair
+	for i := range errs {
+		if errs[i] == nil {
+			stored = append(stored, long2ShortUrls[i])
+		}
+	}
+	if len(stored) == 0 {
+		return errs, nil
+	}
+
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	return errs, f.write(URLDump{Type: DumpStoreBatch, UserID: userID, URLs: stored})
+}
+
+// Wrapper over url storage that saves changes of long urls.
+func (f *FileDumpWrapper) UpdateWithContext(ctx context.Context, change URLChange) (URLChange, error) {
+	change, err := f.URLStorage.UpdateWithContext(ctx, change)
+	if err != nil || change.PreviousURL == change.LongURL {
+		return change, err
+	}
+
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	return change, f.write(URLDump{Type: DumpUpdate, UserID: change.ChangedBy, ShortURL: change.ShortURL,
+		OriginalURL: change.LongURL, ChangedAt: change.ChangedAt, URLOptions: URLOptions{NormalizedURL: change.NormalizedURL}})
+}
+
+// Wrapper over url storage that saves purging of expired urls.
+func (f *FileDumpWrapper) PurgeExpiredWithContext(ctx context.Context, expiredBefore time.Time) ([]string, error) {
+	purged, err := f.URLStorage.PurgeExpiredWithContext(ctx, expiredBefore)
+	if err != nil || len(purged) == 0 {
+		return purged, err
+	}
+
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	return purged, f.write(URLDump{Type: DumpPurge, PurgedBefore: expiredBefore})
+}
+
+// Returns all urls saved by user.
+func (f *FileDumpWrapper) GetUserURLs(ctx context.Context, userID string) ([]URLPair, error) {
+	return f.UserURLStorage.GetUserURLs(ctx, userID)
+}
+
+// Returns page of urls saved by user ordered by creation time.
+func (f *FileDumpWrapper) GetUserURLsPageWithContext(ctx context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	return f.UserURLStorage.GetUserURLsPageWithContext(ctx, userID, query)
+}
+
+// Returns whether short url is saved by user.
+func (f *FileDumpWrapper) OwnsURLWithContext(ctx context.Context, userID string, shortURL string) (bool, error) {
+	return f.UserURLStorage.OwnsURLWithContext(ctx, userID, shortURL)
+}
+
+// Returns number of users that have saved urls.
+func (f *FileDumpWrapper) CountUsersWithContext(ctx context.Context) (int64, error) {
+	return f.UserURLStorage.CountUsersWithContext(ctx)
+}
+
+// Wrapper over user url storage that saves deletions.
+func (f *FileDumpWrapper) DeleteUserURLs(ctx context.Context, urlsByUser ...URLsForDelete) error {
+	if err := f.UserURLStorage.DeleteUserURLs(ctx, urlsByUser...); err != nil {
+		return err
+	}
+
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	for _, urls := range urlsByUser {
+		err := f.write(URLDump{Type: DumpDelete, UserID: urls.UserID, ShortURLs: urls.ShortURLs})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lists urls of wrapped url storage.
+func (f *FileDumpWrapper) ForEachURLWithContext(ctx context.Context, fn func(url OwnedURL) error) error {
+	storage, ok := f.URLStorage.(TransferStorage)
+	if !ok {
+		return ErrTransferNotSupported
+	}
+	return storage.ForEachURLWithContext(ctx, fn)
+}
+
+// Wrapper over url storage that saves replaced urls.
+func (f *FileDumpWrapper) ReplaceWithContext(ctx context.Context, url OwnedURL) error {
+	storage, ok := f.URLStorage.(TransferStorage)
+	if !ok {
+		return ErrTransferNotSupported
+	}
+	url.URLOptions = withCreatedAt(url.URLOptions)
+	if err := storage.ReplaceWithContext(ctx, url); err != nil {
+		return err
+	}
+
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	return f.write(URLDump{Type: DumpStore, UserID: url.UserID, ShortURL: url.Short, OriginalURL: url.Long,
+		Deleted: url.Deleted, URLOptions: url.URLOptions})
 }
 
 // Loads into url storage all urls from file.
+//
+// Restores owners of urls and marks deleted urls as deleted.
+// Restores last issued user id, so that ids of previous runs are not issued again.
+// Restores history of urls if url storage is HistoryRestorer.
+// Finishes compaction interrupted by previous shutdown.
 func (f *FileDumpWrapper) RestoreFromDump() error {
 	f.URLStorage.Clear()
-	var long2ShortUrls []URLPair
-	file, err := os.OpenFile(f.filename, os.O_RDONLY|os.O_CREATE, 0666)
+	state := newDumpState()
+	compactingName := f.filename + ".compacting"
+	_, errStat := os.Stat(compactingName)
+	interrupted := errStat == nil
+	if interrupted {
+		if _, err := readDump(compactingName, state); err != nil {
+			return err
+		}
+	}
+	lastUUID, err := readDump(f.filename, state)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	reader := bufio.NewReader(file)
-	data, err := reader.ReadBytes('\n')
-	dump := URLDump{}
-	for err == nil {
-		err = json.Unmarshal(data, &dump)
+
+	var users []string
+	urlsByUser := make(map[string][]URLPair)
+	deletedByUser := make(map[string][]string)
+	var history []URLChange
+	for _, shortURL := range state.order {
+		entry := state.entries[shortURL]
+		if _, has := urlsByUser[entry.UserID]; !has {
+			users = append(users, entry.UserID)
+		}
+		urlsByUser[entry.UserID] = append(urlsByUser[entry.UserID],
+			URLPair{Short: entry.ShortURL, Long: entry.OriginalURL, URLOptions: entry.URLOptions})
+		if entry.Deleted {
+			deletedByUser[entry.UserID] = append(deletedByUser[entry.UserID], entry.ShortURL)
+		}
+		history = append(history, entry.History...)
+	}
+
+	var deleted []URLsForDelete
+	for _, userID := range users {
+		errs, err := f.URLStorage.StoreManyWithContext(context.Background(), urlsByUser[userID], userID)
 		if err != nil {
 			return err
 		}
-		url := URLPair{Short: dump.ShortURL, Long: dump.OriginalURL}
-		long2ShortUrls = append(long2ShortUrls, url)
-		f.counter = dump.UUID
-		data, err = reader.ReadBytes('\n')
+		for i, err := range errs {
+			switch {
+			case err == nil:
+			case errors.Is(err, ErrConflictURL), errors.Is(err, ErrShortURLTaken):
+				// Url may conflict with earlier one if dedup scope was changed since it was saved.
+				logger.Log.Warn("url of dump conflicts with restored url, skipped",
+					zap.String("short_url", urlsByUser[userID][i].Short), zap.Error(err))
+			default:
+				return fmt.Errorf("failed to restore url %q: %w", urlsByUser[userID][i].Short, err)
+			}
+		}
+		if shortURLs, has := deletedByUser[userID]; has {
+			deleted = append(deleted, URLsForDelete{UserID: userID, ShortURLs: shortURLs})
+		}
 	}
-	if err != io.EOF {
+	if len(deleted) != 0 {
+		if err = f.UserURLStorage.DeleteUserURLs(context.Background(), deleted...); err != nil {
+			return err
+		}
+	}
+	if restorer, ok := f.URLStorage.(HistoryRestorer); ok && len(history) != 0 {
+		restorer.RestoreHistory(history...)
+	}
+
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	f.counter = lastUUID
+	f.lastUserID = state.lastUserID
+	if !interrupted {
+		return nil
+	}
+	return f.replaceDump(state, func() error { return nil })
+}
+
+// Replaces dump file with snapshot of state followed by records
+// that appended to dump after that state was collected. Must be called under lock.
+func (f *FileDumpWrapper) replaceDump(state *dumpState, appendTail func() error) error {
+	tmpName := f.filename + ".tmp"
+	if err := writeSnapshot(tmpName, state); err != nil {
 		return err
 	}
-	f.URLStorage.StoreManyWithContext(context.Background(), long2ShortUrls, "")
-	return nil
+	err := f.dumpWriter.Close()
+	if err == nil {
+		err = appendTail()
+	}
+	if err == nil {
+		err = os.Rename(tmpName, f.filename)
+	}
+	if err == nil {
+		os.Remove(f.filename + ".compacting")
+	}
+
+	dumpWriter, errOpen := NewDumpWriter(f.filename)
+	if errOpen != nil {
+		return errors.Join(err, errOpen)
+	}
+	f.dumpWriter = dumpWriter
+	return err
+}
+
+// Compacts dump file into snapshot.
+//
+// New records are written into fresh file while snapshot is built
+// and appended to snapshot afterwards.
+func (f *FileDumpWrapper) compact() error {
+	defer func() {
+		f.dumpMutex.Lock()
+		f.compacting = false
+		f.dumpMutex.Unlock()
+	}()
+
+	compactingName := f.filename + ".compacting"
+	f.dumpMutex.Lock()
+	err := f.dumpWriter.Close()
+	if err == nil {
+		err = os.Rename(f.filename, compactingName)
+	}
+	dumpWriter, errOpen := NewDumpWriter(f.filename)
+	if errOpen == nil {
+		f.dumpWriter = dumpWriter
+	}
+	f.dumpMutex.Unlock()
+	if err = errors.Join(err, errOpen); err != nil {
+		return err
+	}
+
+	state := newDumpState()
+	if _, err = readDump(compactingName, state); err != nil {
+		return err
+	}
+
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	return f.replaceDump(state, func() error {
+		return appendFile(f.filename+".tmp", f.filename)
+	})
+}
+
+// Generates id for new user after every id issued before, including ids of previous runs restored from dump.
+func (f *FileDumpWrapper) GenerateUUID(_ context.Context) (int64, error) {
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	if err := f.write(URLDump{Type: DumpUser, LastUserID: f.lastUserID + 1}); err != nil {
+		return 0, err
+	}
+	f.lastUserID++
+	return f.lastUserID, nil
+}
+
+// Waits for background compaction and closes dump file.
+func (f *FileDumpWrapper) Close() error {
+	f.compactWG.Wait()
+	f.dumpMutex.Lock()
+	defer f.dumpMutex.Unlock()
+	return f.dumpWriter.Close()
 }
 
-// New file saving wrapper over url storage.
-func NewFileDumpWrapper(filename string, storage URLStorage) (*FileDumpWrapper, error) {
+// New file saving wrapper over url storages.
+//
+// Dump is compacted when its size exceeds compactSize bytes, zero disables compaction.
+func NewFileDumpWrapper(filename string, storage URLStorage, userStorage UserURLStorage, compactSize int64) (*FileDumpWrapper, error) {
 	dumpWriter, err := NewDumpWriter(filename)
 	if err != nil {
 		return nil, err
 	}
 	return &FileDumpWrapper{
-		URLStorage: storage,
-		filename:   filename,
-		dumpWriter: dumpWriter,
-		counter:    0,
+		URLStorage:     storage,
+		UserURLStorage: userStorage,
+		filename:       filename,
+		dumpWriter:     dumpWriter,
+		counter:        0,
+		compactSize:    compactSize,
 	}, nil
 }
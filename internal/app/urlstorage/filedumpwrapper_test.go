@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -60,13 +62,15 @@ func TestFileDumpWrapper_testDump(t *testing.T) {
 	testFilename := "test_dump"
 	defer os.Remove(testFilename)
 	mockStorage := mocks.NewURLStorage(t)
-	mockStorage.On("StoreWithContext", mock.Anything, "http://youtube.ru/1", "1", "").Return(nil).Once()
-	mockStorage.On("StoreWithContext", mock.Anything, "http://youtube.ru/2", "2", "").Return(nil).Once()
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	options := urlstorage.URLOptions{CreatedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	mockStorage.On("StoreWithContext", mock.Anything, "http://youtube.ru/1", "1", "", options).Return(nil).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://youtube.ru/2", "2", "", options).Return(nil).Once()
 	{
-		dumpWrapper, _ := urlstorage.NewFileDumpWrapper(testFilename, mockStorage)
+		dumpWrapper, _ := urlstorage.NewFileDumpWrapper(testFilename, mockStorage, mockUserStorage, 0)
 
-		dumpWrapper.StoreWithContext(context.Background(), "http://youtube.ru/1", "1", "")
-		dumpWrapper.StoreWithContext(context.Background(), "http://youtube.ru/2", "2", "")
+		dumpWrapper.StoreWithContext(context.Background(), "http://youtube.ru/1", "1", "", options)
+		dumpWrapper.StoreWithContext(context.Background(), "http://youtube.ru/2", "2", "", options)
 	}
 
 	consumer, _ := NewConsumer(testFilename)
@@ -79,23 +83,262 @@ func TestFileDumpWrapper_testDump(t *testing.T) {
 			require.Equal(t, nil, err)
 			expectedDump := urlstorage.URLDump{
 				UUID:        int64(i),
+				Type:        urlstorage.DumpStore,
 				OriginalURL: "http://youtube.ru/" + strconv.Itoa(i),
-				ShortURL:    strconv.Itoa(i)}
+				ShortURL:    strconv.Itoa(i),
+				URLOptions:  options}
 			assert.Equal(t, expectedDump, *dump)
 		}
 	}
 	checkEqualDumps(2)
+	content, err := os.ReadFile(testFilename)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "expires_at", "zero expiration is not dumped")
 
 	mockStorage.On("Clear").Return(nil).Once()
 	mockStorage.On("StoreManyWithContext", mock.Anything, []urlstorage.URLPair{
-		{Long: "http://youtube.ru/1", Short: "1"},
-		{Long: "http://youtube.ru/2", Short: "2"}}, "").Return([]error{nil, nil}, nil).Once()
-	mockStorage.On("StoreWithContext", mock.Anything, "http://youtube.ru/3", "3", "").Return(nil).Once()
+		{Long: "http://youtube.ru/1", Short: "1", URLOptions: options},
+		{Long: "http://youtube.ru/2", Short: "2", URLOptions: options}}, "").Return([]error{nil, nil}, nil).Once()
+	mockStorage.On("StoreWithContext", mock.Anything, "http://youtube.ru/3", "3", "", options).Return(nil).Once()
 	{
-		dumpWrapper, _ := urlstorage.NewFileDumpWrapper(testFilename, mockStorage)
+		dumpWrapper, _ := urlstorage.NewFileDumpWrapper(testFilename, mockStorage, mockUserStorage, 0)
 
 		dumpWrapper.RestoreFromDump()
-		dumpWrapper.StoreWithContext(context.Background(), "http://youtube.ru/3", "3", "")
+		dumpWrapper.StoreWithContext(context.Background(), "http://youtube.ru/3", "3", "", options)
 	}
 	checkEqualDumps(3)
 }
+
+func TestFileDumpWrapper_RestoreErrors(t *testing.T) {
+	testFilename := "test_dump_errors"
+	defer os.Remove(testFilename)
+	mockStorage := mocks.NewURLStorage(t)
+	mockUserStorage := mocks.NewUserURLStorage(t)
+	mockStorage.On("StoreWithContext", mock.Anything, mock.Anything, mock.Anything, "user", mock.Anything).Return(nil).Twice()
+	{
+		dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, mockStorage, mockUserStorage, 0)
+		require.NoError(t, err)
+		require.NoError(t, dumpWrapper.StoreWithContext(context.Background(), "url_a", "a", "user", urlstorage.URLOptions{}))
+		require.NoError(t, dumpWrapper.StoreWithContext(context.Background(), "url_b", "b", "user", urlstorage.URLOptions{}))
+		require.NoError(t, dumpWrapper.Close())
+	}
+
+	storeErr := errors.New("storage is read only")
+	tests := []struct {
+		name    string
+		errs    []error
+		wantErr error
+	}{
+		{name: "conflict_skipped", errs: []error{urlstorage.ErrConflictURL, nil}},
+		{name: "other_error_fails", errs: []error{urlstorage.ErrConflictURL, storeErr}, wantErr: storeErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage.On("Clear").Return(nil).Once()
+			mockStorage.On("StoreManyWithContext", mock.Anything, mock.Anything, "user").Return(tt.errs, nil).Once()
+			dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, mockStorage, mockUserStorage, 0)
+			require.NoError(t, err)
+			defer dumpWrapper.Close()
+			err = dumpWrapper.RestoreFromDump()
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileDumpWrapper_RestoreUsersAndDeletions(t *testing.T) {
+	testFilename := "test_dump_users"
+	defer os.Remove(testFilename)
+	ctx := context.Background()
+	{
+		storage := urlstorage.NewSimpleMapLockStorage()
+		dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 0)
+		require.NoError(t, err)
+		require.NoError(t, dumpWrapper.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{}))
+		_, err = dumpWrapper.StoreManyWithContext(ctx, []urlstorage.URLPair{
+			{Long: "url_b", Short: "b"},
+			{Long: "url_a", Short: "a2"}}, "user_2")
+		require.NoError(t, err)
+		require.NoError(t, dumpWrapper.DeleteUserURLs(ctx,
+			urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"a", "b"}}))
+		require.NoError(t, dumpWrapper.Close())
+	}
+
+	storage := urlstorage.NewSimpleMapLockStorage()
+	dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 0)
+	require.NoError(t, err)
+	defer dumpWrapper.Close()
+	require.NoError(t, dumpWrapper.RestoreFromDump())
+
+	urls, err := dumpWrapper.GetUserURLs(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.URLPair{{Long: "url_a", Short: "a"}}, urls)
+	urls, err = dumpWrapper.GetUserURLs(ctx, "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.URLPair{{Long: "url_b", Short: "b"}}, urls)
+
+	_, err = dumpWrapper.GetLongURLWithContext(ctx, "a")
+	require.ErrorIs(t, err, urlstorage.ErrDeletedURL)
+	longURL, err := dumpWrapper.GetLongURLWithContext(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "url_b", longURL)
+}
+
+func TestFileDumpWrapper_Compact(t *testing.T) {
+	testFilename := "test_dump_compact"
+	defer os.Remove(testFilename)
+	ctx := context.Background()
+	{
+		storage := urlstorage.NewSimpleMapLockStorage()
+		dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 512)
+		require.NoError(t, err)
+		for i := 0; i < 20; i++ {
+			shortURL := strconv.Itoa(i)
+			require.NoError(t, dumpWrapper.StoreWithContext(ctx, "http://youtube.ru/"+shortURL, shortURL, "user", urlstorage.URLOptions{}))
+			require.NoError(t, dumpWrapper.DeleteUserURLs(ctx,
+				urlstorage.URLsForDelete{UserID: "user", ShortURLs: []string{shortURL}}))
+		}
+		require.NoError(t, dumpWrapper.Close())
+	}
+
+	consumer, _ := NewConsumer(testFilename)
+	defer consumer.Close()
+	var dumps []*urlstorage.URLDump
+	for dump, err := consumer.ReadDump(); dump != nil; dump, err = consumer.ReadDump() {
+		require.NoError(t, err)
+		dumps = append(dumps, dump)
+	}
+	assert.Less(t, len(dumps), 40, "dump must be compacted")
+
+	storage := urlstorage.NewSimpleMapLockStorage()
+	dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 0)
+	require.NoError(t, err)
+	defer dumpWrapper.Close()
+	require.NoError(t, dumpWrapper.RestoreFromDump())
+	urls, err := dumpWrapper.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, urls, 20)
+	for _, url := range urls {
+		_, err = dumpWrapper.GetLongURLWithContext(ctx, url.Short)
+		require.ErrorIs(t, err, urlstorage.ErrDeletedURL)
+	}
+}
+
+func TestFileDumpWrapper_RestoreUserIDs(t *testing.T) {
+	testFilename := "test_dump_user_ids"
+	defer os.Remove(testFilename)
+	ctx := context.Background()
+	restore := func() *urlstorage.FileDumpWrapper {
+		storage := urlstorage.NewSimpleMapLockStorage()
+		dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 0)
+		require.NoError(t, err)
+		require.NoError(t, dumpWrapper.RestoreFromDump())
+		return dumpWrapper
+	}
+
+	dumpWrapper := restore()
+	for want := int64(1); want <= 3; want++ {
+		id, err := dumpWrapper.GenerateUUID(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, id)
+	}
+	require.NoError(t, dumpWrapper.Close())
+
+	dumpWrapper = restore()
+	id, err := dumpWrapper.GenerateUUID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), id, "user without urls keeps its id")
+	require.NoError(t, dumpWrapper.StoreWithContext(ctx, "url_a", "a", "9", urlstorage.URLOptions{}))
+	require.NoError(t, dumpWrapper.Close())
+
+	dumpWrapper = restore()
+	defer dumpWrapper.Close()
+	id, err = dumpWrapper.GenerateUUID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), id, "ids of users with urls are not reissued")
+}
+
+func TestFileDumpWrapper_RestorePurged(t *testing.T) {
+	testFilename := "test_dump_purged"
+	defer os.Remove(testFilename)
+	ctx := context.Background()
+	now := time.Now()
+	expiresAt := now.Add(-time.Hour).UTC()
+	{
+		storage := urlstorage.NewSimpleMapLockStorage()
+		dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 0)
+		require.NoError(t, err)
+		require.NoError(t, dumpWrapper.StoreWithContext(ctx, "url_a", "a", "user",
+			urlstorage.URLOptions{ExpiresAt: expiresAt}))
+		require.NoError(t, dumpWrapper.StoreWithContext(ctx, "url_b", "b", "user",
+			urlstorage.URLOptions{ExpiresAt: expiresAt}))
+		purged, err := dumpWrapper.PurgeExpiredWithContext(ctx, now)
+		require.NoError(t, err)
+		require.Len(t, purged, 2)
+		require.NoError(t, dumpWrapper.StoreWithContext(ctx, "url_c", "c", "user",
+			urlstorage.URLOptions{ExpiresAt: expiresAt}))
+		require.NoError(t, dumpWrapper.Close())
+	}
+
+	storage := urlstorage.NewSimpleMapLockStorage()
+	dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 0)
+	require.NoError(t, err)
+	defer dumpWrapper.Close()
+	require.NoError(t, dumpWrapper.RestoreFromDump())
+	urls, err := dumpWrapper.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "c", urls[0].Short)
+	assert.True(t, expiresAt.Equal(urls[0].ExpiresAt))
+	_, err = dumpWrapper.GetLongURLWithContext(ctx, "c")
+	require.ErrorIs(t, err, urlstorage.ErrExpiredURL)
+}
+
+func TestFileDumpWrapper_RestoreHistory(t *testing.T) {
+	testFilename := "test_dump_history"
+	defer os.Remove(testFilename)
+	ctx := context.Background()
+	changedAt := time.Now().UTC()
+	{
+		storage := urlstorage.NewSimpleMapLockStorage()
+		dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 512)
+		require.NoError(t, err)
+		require.NoError(t, dumpWrapper.StoreWithContext(ctx, "url_0", "a", "user", urlstorage.URLOptions{}))
+		for i := 1; i <= 20; i++ {
+			_, err = dumpWrapper.UpdateWithContext(ctx, urlstorage.URLChange{
+				ShortURL: "a", LongURL: "url_" + strconv.Itoa(i), ChangedBy: "user", ChangedAt: changedAt})
+			require.NoError(t, err)
+		}
+		_, err = dumpWrapper.UpdateWithContext(ctx, urlstorage.URLChange{
+			ShortURL: "a", LongURL: "url_x", ChangedBy: "other_user", ChangedAt: changedAt})
+		require.ErrorIs(t, err, urlstorage.ErrNotOwnedURL)
+		require.NoError(t, dumpWrapper.StoreWithContext(ctx, "url_0", "b", "user", urlstorage.URLOptions{}))
+		require.NoError(t, dumpWrapper.Close())
+	}
+
+	storage := urlstorage.NewSimpleMapLockStorage()
+	dumpWrapper, err := urlstorage.NewFileDumpWrapper(testFilename, storage, storage, 0)
+	require.NoError(t, err)
+	defer dumpWrapper.Close()
+	require.NoError(t, dumpWrapper.RestoreFromDump())
+
+	longURL, err := dumpWrapper.GetLongURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "url_20", longURL)
+	longURL, err = dumpWrapper.GetLongURLWithContext(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "url_0", longURL)
+
+	history, err := dumpWrapper.GetHistoryWithContext(ctx, "a")
+	require.NoError(t, err)
+	require.Len(t, history, 20)
+	assert.Equal(t, "url_0", history[0].PreviousURL)
+	assert.Equal(t, "url_1", history[0].LongURL)
+	assert.Equal(t, "url_19", history[19].PreviousURL)
+	assert.Equal(t, "url_20", history[19].LongURL)
+	assert.Equal(t, "user", history[19].ChangedBy)
+	assert.True(t, changedAt.Equal(history[19].ChangedAt))
+}
@@ -0,0 +1,43 @@
+package urlstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+func TestStorage_OwnsURL(t *testing.T) {
+	storages := map[string]func(t *testing.T) transferStorage{
+		"simple_map": func(t *testing.T) transferStorage { return urlstorage.NewSimpleMapLockStorage() },
+		"sharded":    func(t *testing.T) transferStorage { return urlstorage.NewShardedStorage(4) },
+		"bolt":       func(t *testing.T) transferStorage { return newBoltStorage(t) },
+	}
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			ctx := context.Background()
+			require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{}))
+			require.NoError(t, storage.StoreWithContext(ctx, "url_b", "b", "user_1", urlstorage.URLOptions{}))
+			require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"b"}}))
+
+			tests := []struct {
+				userID   string
+				shortURL string
+				want     bool
+			}{
+				{userID: "user_1", shortURL: "a", want: true},
+				{userID: "user_1", shortURL: "b", want: true},
+				{userID: "user_2", shortURL: "a", want: false},
+				{userID: "user_1", shortURL: "c", want: false},
+			}
+			for _, tt := range tests {
+				owns, err := storage.OwnsURLWithContext(ctx, tt.userID, tt.shortURL)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, owns, "%s owns %s", tt.userID, tt.shortURL)
+			}
+		})
+	}
+}
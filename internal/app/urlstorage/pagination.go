@@ -0,0 +1,121 @@
+package urlstorage
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error in case page cursor is malformed.
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// Parameters of page of user urls.
+type UserURLsQuery struct {
+	Limit          int       // maximal number of urls in page
+	Cursor         string    // cursor returned with previous page, empty for first page
+	LongURLPart    string    // substring long url must contain, empty matches any url
+	CreatedAfter   time.Time // zero means no lower bound of creation time
+	CreatedBefore  time.Time // zero means no upper bound of creation time
+	IncludeDeleted bool      // whether urls deleted by user are returned
+	Descending     bool      // newest urls first
+}
+
+// Url saved by user with its state.
+type StoredURL struct {
+	URLPair
+	Deleted bool
+}
+
+// Page of user urls ordered by creation time.
+type UserURLsPage struct {
+	URLs       []StoredURL
+	NextCursor string // empty if page is last
+}
+
+// Position in user urls ordered by creation time and short url.
+type pageCursor struct {
+	createdAt time.Time
+	shortURL  string
+}
+
+// Encodes position after given url.
+func encodeCursor(url StoredURL) string {
+	raw := strconv.FormatInt(url.CreatedAt.UnixNano(), 10) + ":" + url.Short
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Decodes position encoded by encodeCursor.
+func decodeCursor(cursor string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	nanos, shortURL, found := strings.Cut(string(raw), ":")
+	if !found {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return pageCursor{}, ErrInvalidCursor
+	}
+	return pageCursor{createdAt: time.Unix(0, unixNano).UTC(), shortURL: shortURL}, nil
+}
+
+// Compares url with cursor position in ascending order.
+func (c pageCursor) compare(url StoredURL) int {
+	if res := url.CreatedAt.Compare(c.createdAt); res != 0 {
+		return res
+	}
+	return strings.Compare(url.Short, c.shortURL)
+}
+
+// Whether url satisfies filters of query.
+func (q UserURLsQuery) matches(url StoredURL) bool {
+	return (q.IncludeDeleted || !url.Deleted) &&
+		strings.Contains(url.Long, q.LongURLPart) &&
+		(q.CreatedAfter.IsZero() || url.CreatedAt.After(q.CreatedAfter)) &&
+		(q.CreatedBefore.IsZero() || url.CreatedAt.Before(q.CreatedBefore))
+}
+
+// Selects page of urls satisfying query, used by storages keeping urls in memory.
+func paginate(urls []StoredURL, query UserURLsQuery) (UserURLsPage, error) {
+	var cursor *pageCursor
+	if query.Cursor != "" {
+		decoded, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return UserURLsPage{}, err
+		}
+		cursor = &decoded
+	}
+	var selected []StoredURL
+	for _, url := range urls {
+		if !query.matches(url) {
+			continue
+		}
+		if cursor != nil {
+			res := cursor.compare(url)
+			if query.Descending && res >= 0 || !query.Descending && res <= 0 {
+				continue
+			}
+		}
+		selected = append(selected, url)
+	}
+	slices.SortFunc(selected, func(a, b StoredURL) int {
+		res := pageCursor{createdAt: b.CreatedAt, shortURL: b.Short}.compare(a)
+		if query.Descending {
+			return -res
+		}
+		return res
+	})
+
+	var page UserURLsPage
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+		page.NextCursor = encodeCursor(selected[len(selected)-1])
+	}
+	page.URLs = selected
+	return page, nil
+}
@@ -0,0 +1,510 @@
+package urlstorage
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Default number of shards of sharded storage.
+const DefaultShards = 64
+
+// Saved short url with everything known about it.
+type shardedURL struct {
+	long    string
+	userID  string
+	options URLOptions // normalized url is kept only if it differs from long url
+	deleted bool
+	history []URLChange
+}
+
+// Part of sharded storage, every index entry is kept in shard of its key.
+type urlShard struct {
+	sync.RWMutex
+	urls      map[string]shardedURL // urls by short urls
+	shortURLs map[string]string     // short urls by dedup keys of normalized long urls
+	userURLs  map[string][]string   // short urls of user in order of saving
+}
+
+// Storage storing urls in memory split into shards by keys.
+//
+// Short urls, dedup keys and users are indexed in shards chosen by their hashes,
+// so lookups of different urls do not wait for each other and for writes.
+// Operations touching several shards lock them in order of shard numbers.
+type ShardedStorage struct {
+	DedupScope DedupScope // scope of dedup keys, zero value is global
+	seed       maphash.Seed
+	shards     []urlShard
+}
+
+// New inmemory url storage split into given number of shards, non-positive number means default.
+func NewShardedStorage(shards int) *ShardedStorage {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	s := &ShardedStorage{seed: maphash.MakeSeed(), shards: make([]urlShard, shards)}
+	for i := range s.shards {
+		s.shards[i].reset()
+	}
+	return s
+}
+
+// Drops all entries of shard. Must be called under lock.
+func (sh *urlShard) reset() {
+	sh.urls = make(map[string]shardedURL)
+	sh.shortURLs = make(map[string]string)
+	sh.userURLs = make(map[string][]string)
+}
+
+// Returns number of shard of key.
+func (s *ShardedStorage) index(key string) int {
+	return int(maphash.String(s.seed, key) % uint64(len(s.shards)))
+}
+
+// Returns shard of key.
+func (s *ShardedStorage) shard(key string) *urlShard {
+	return &s.shards[s.index(key)]
+}
+
+// Locks shards of given keys for writing in order of shard numbers and returns function unlocking them.
+func (s *ShardedStorage) lock(keys ...string) func() {
+	indexes := make([]int, len(keys))
+	for i, key := range keys {
+		indexes[i] = s.index(key)
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+	for _, i := range indexes {
+		s.shards[i].Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			s.shards[i].Unlock()
+		}
+	}
+}
+
+// Returns saved url and whether it exists.
+func (s *ShardedStorage) get(shortURL string) (shardedURL, bool) {
+	sh := s.shard(shortURL)
+	sh.RLock()
+	defer sh.RUnlock()
+	url, has := sh.urls[shortURL]
+	return url, has
+}
+
+// Returns dedup key of saved url and whether it is deduplicated.
+func (s *ShardedStorage) dedupKey(url shardedURL) (string, bool) {
+	return s.DedupScope.Key(url.userID, url.options.DedupKey(url.long))
+}
+
+// Returns saved url with creation time and deletion flag.
+func (url shardedURL) stored(shortURL string) StoredURL {
+	return StoredURL{URLPair: URLPair{Short: shortURL, Long: url.long, URLOptions: url.options}, Deleted: url.deleted}
+}
+
+// Returns normalized url if it differs from long url.
+func distinctNormalizedURL(longURL string, normalizedURL string) string {
+	if normalizedURL == longURL {
+		return ""
+	}
+	return normalizedURL
+}
+
+// Returns longURL from shortURL.
+func (s *ShardedStorage) GetLongURLWithContext(_ context.Context, shortURL string) (string, error) {
+	url, has := s.get(shortURL)
+	if !has {
+		return "", ErrNotFoundURL
+	}
+	if url.deleted {
+		return "", ErrDeletedURL
+	}
+	if url.options.ExpiredAt(time.Now()) {
+		return "", ErrExpiredURL
+	}
+	return url.long, nil
+}
+
+// Returns saved url with its options and creation time, deleted and expired urls are returned too.
+func (s *ShardedStorage) GetURLWithContext(_ context.Context, shortURL string) (StoredURL, error) {
+	url, has := s.get(shortURL)
+	if !has {
+		return StoredURL{}, ErrNotFoundURL
+	}
+	return url.stored(shortURL), nil
+}
+
+// Returns shortURL by normalized form of longURL in dedup scope of user.
+func (s *ShardedStorage) GetShortURLWithContext(_ context.Context, normalizedURL string, userID string) (string, error) {
+	key, dedup := s.DedupScope.Key(userID, normalizedURL)
+	if !dedup {
+		return "", ErrNotDeduplicated
+	}
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	shortURL, has := sh.shortURLs[key]
+	if !has {
+		return "", errors.New("no such longUrl")
+	}
+	return shortURL, nil
+}
+
+// Returns short url saved under dedup key, empty if key is not saved.
+func (s *ShardedStorage) keyHolder(key string) string {
+	sh := s.shard(key)
+	sh.RLock()
+	defer sh.RUnlock()
+	return sh.shortURLs[key]
+}
+
+// Whether url saved under dedup key still holds it, expired urls do not hold their keys.
+// Must be called under locks of dedup key and its holder.
+func (s *ShardedStorage) holdsKey(key string) (string, bool) {
+	holder, has := s.shard(key).shortURLs[key]
+	if !has {
+		return "", false
+	}
+	url, has := s.shard(holder).urls[holder]
+	return holder, !has || !url.options.ExpiredAt(time.Now())
+}
+
+// Saves url if neither short url nor its dedup key is taken.
+//
+// Short url is taken if it is saved with another dedup key or expired, long url conflicts if its dedup key is held.
+func (s *ShardedStorage) store(longURL string, shortURL string, userID string, options URLOptions) error {
+	key, dedup := s.DedupScope.Key(userID, options.DedupKey(longURL))
+	// Expiration of url holding dedup key is checked under its lock, so it is locked after holder is read and checked again.
+	for {
+		holder := s.keyHolder(key)
+		unlock := s.lock(shortURL, key, userID, holder)
+		if s.shard(key).shortURLs[key] != holder {
+			unlock()
+			continue
+		}
+		defer unlock()
+		if existing, has := s.shard(shortURL).urls[shortURL]; has {
+			existingKey, existingDedup := s.dedupKey(existing)
+			if !dedup || !existingDedup || existingKey != key || existing.options.ExpiredAt(time.Now()) {
+				return ErrShortURLTaken
+			}
+		}
+		if _, held := s.holdsKey(key); dedup && held {
+			return ErrConflictURL
+		}
+		s.insert(shortURL, shardedURL{long: longURL, userID: userID, options: options})
+		return nil
+	}
+}
+
+// Writes url to all indexes. Must be called under locks of short url, its dedup key and its user.
+func (s *ShardedStorage) insert(shortURL string, url shardedURL) {
+	url.options.NormalizedURL = distinctNormalizedURL(url.long, url.options.NormalizedURL)
+	if url.options.CreatedAt.IsZero() {
+		url.options.CreatedAt = time.Now().UTC()
+	}
+	s.shard(shortURL).urls[shortURL] = url
+	if key, dedup := s.dedupKey(url); dedup {
+		s.shard(key).shortURLs[key] = shortURL
+	}
+	user := s.shard(url.userID)
+	user.userURLs[url.userID] = append(user.userURLs[url.userID], shortURL)
+}
+
+// Removes url from all indexes. Must be called under locks of short url, its dedup key and its user.
+func (s *ShardedStorage) remove(shortURL string, url shardedURL) {
+	delete(s.shard(shortURL).urls, shortURL)
+	if key, dedup := s.dedupKey(url); dedup && s.shard(key).shortURLs[key] == shortURL {
+		delete(s.shard(key).shortURLs, key)
+	}
+	user := s.shard(url.userID)
+	user.userURLs[url.userID] = slices.DeleteFunc(user.userURLs[url.userID], func(u string) bool { return u == shortURL })
+	if len(user.userURLs[url.userID]) == 0 {
+		delete(user.userURLs, url.userID)
+	}
+}
+
+// Adds mapping longURL -> shortURL.
+func (s *ShardedStorage) StoreWithContext(_ context.Context, longURL string, shortURL string, userID string, options URLOptions) error {
+	if shortURL == "" {
+		return errors.New("cannot save empty url")
+	}
+	return s.store(longURL, shortURL, userID, options)
+}
+
+// Adds number of mappings longURL -> shortURL.
+//
+// Every mapping is saved atomically, but other operations may see part of batch saved.
+func (s *ShardedStorage) StoreManyWithContext(_ context.Context, long2ShortUrls []URLPair, userID string) ([]error, error) {
+	var errs []error
+	for i := range long2ShortUrls {
+		if long2ShortUrls[i].Short == "" {
+			continue
+		}
+		errs = append(errs, s.store(long2ShortUrls[i].Long, long2ShortUrls[i].Short, userID, long2ShortUrls[i].URLOptions))
+	}
+	return errs, nil
+}
+
+// Points short url of user to new long url and saves change to history.
+//
+// Change to the same long url is not saved.
+func (s *ShardedStorage) UpdateWithContext(_ context.Context, change URLChange) (URLChange, error) {
+	if change.LongURL == "" {
+		return change, ErrEmptyLongURL
+	}
+	key, dedup := s.DedupScope.Key(change.ChangedBy, URLOptions{NormalizedURL: change.NormalizedURL}.DedupKey(change.LongURL))
+	// Previous dedup key depends on previous url, so it is locked after url is read and checked again.
+	for {
+		url, has := s.get(change.ShortURL)
+		if !has || url.userID != change.ChangedBy {
+			return change, ErrNotOwnedURL
+		}
+		previousKey, previousDedup := s.dedupKey(url)
+		holder := s.keyHolder(key)
+		unlock := s.lock(change.ShortURL, key, previousKey, holder)
+		current, has := s.shard(change.ShortURL).urls[change.ShortURL]
+		if currentKey, _ := s.dedupKey(current); has && currentKey != previousKey || s.shard(key).shortURLs[key] != holder {
+			unlock()
+			continue
+		}
+		defer unlock()
+		if !has {
+			return change, ErrNotOwnedURL
+		}
+		if current.deleted {
+			return change, ErrDeletedURL
+		}
+		change.PreviousURL = current.long
+		if current.long == change.LongURL {
+			return change, nil
+		}
+		if shortURL, held := s.holdsKey(key); dedup && held && shortURL != change.ShortURL {
+			return change, ErrConflictURL
+		}
+		if previous := s.shard(previousKey); previousDedup && previous.shortURLs[previousKey] == change.ShortURL {
+			delete(previous.shortURLs, previousKey)
+		}
+		if dedup {
+			s.shard(key).shortURLs[key] = change.ShortURL
+		}
+		current.long = change.LongURL
+		current.options.NormalizedURL = distinctNormalizedURL(change.LongURL, change.NormalizedURL)
+		current.history = append(current.history, change)
+		s.shard(change.ShortURL).urls[change.ShortURL] = current
+		return change, nil
+	}
+}
+
+// Appends changes made before to history without applying them.
+//
+// Changes of short urls that are not saved are skipped.
+func (s *ShardedStorage) RestoreHistory(changes ...URLChange) {
+	for _, change := range changes {
+		sh := s.shard(change.ShortURL)
+		sh.Lock()
+		if url, has := sh.urls[change.ShortURL]; has {
+			url.history = append(url.history, change)
+			sh.urls[change.ShortURL] = url
+		}
+		sh.Unlock()
+	}
+}
+
+// Returns changes of short url in order they were made.
+func (s *ShardedStorage) GetHistoryWithContext(_ context.Context, shortURL string) ([]URLChange, error) {
+	url, _ := s.get(shortURL)
+	return slices.Clone(url.history), nil
+}
+
+// Clear all mappings.
+func (s *ShardedStorage) Clear() error {
+	for i := range s.shards {
+		s.shards[i].Lock()
+	}
+	for i := range s.shards {
+		s.shards[i].reset()
+		s.shards[i].Unlock()
+	}
+	return nil
+}
+
+// Removes urls expired before given moment.
+func (s *ShardedStorage) PurgeExpiredWithContext(_ context.Context, expiredBefore time.Time) ([]string, error) {
+	var purged []string
+	for i := range s.shards {
+		var expired []string
+		s.shards[i].RLock()
+		for shortURL, url := range s.shards[i].urls {
+			if !url.options.ExpiresAt.IsZero() && url.options.ExpiresAt.Before(expiredBefore) {
+				expired = append(expired, shortURL)
+			}
+		}
+		s.shards[i].RUnlock()
+		for _, shortURL := range expired {
+			if s.purge(shortURL, expiredBefore) {
+				purged = append(purged, shortURL)
+			}
+		}
+	}
+	return purged, nil
+}
+
+// Removes url if it is still expired before given moment.
+func (s *ShardedStorage) purge(shortURL string, expiredBefore time.Time) bool {
+	url, has := s.get(shortURL)
+	if !has {
+		return false
+	}
+	key, _ := s.dedupKey(url)
+	unlock := s.lock(shortURL, key, url.userID)
+	defer unlock()
+	// Url may have been changed after it was read, it is purged next time then.
+	current, has := s.shard(shortURL).urls[shortURL]
+	if currentKey, _ := s.dedupKey(current); !has || currentKey != key || current.userID != url.userID ||
+		!current.options.ExpiresAt.Before(expiredBefore) {
+		return false
+	}
+	s.remove(shortURL, current)
+	return true
+}
+
+// Calls fn for every saved url including deleted and expired ones in order of creation.
+func (s *ShardedStorage) ForEachURLWithContext(_ context.Context, fn func(url OwnedURL) error) error {
+	var urls []OwnedURL
+	for i := range s.shards {
+		s.shards[i].RLock()
+		for shortURL, url := range s.shards[i].urls {
+			urls = append(urls, OwnedURL{StoredURL: url.stored(shortURL), UserID: url.userID})
+		}
+		s.shards[i].RUnlock()
+	}
+	sortOwnedURLs(urls)
+	for _, url := range urls {
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Saves url with its owner and deletion flag instead of url saved under the same short url.
+func (s *ShardedStorage) ReplaceWithContext(_ context.Context, url OwnedURL) error {
+	if url.Short == "" {
+		return errors.New("cannot save empty url")
+	}
+	replacement := shardedURL{long: url.Long, userID: url.UserID, options: url.URLOptions, deleted: url.Deleted}
+	key, dedup := s.dedupKey(replacement)
+	// Indexes of replaced url depend on it, so they are locked after url is read and checked again.
+	for {
+		existing, has := s.get(url.Short)
+		existingKey, _ := s.dedupKey(existing)
+		unlock := s.lock(url.Short, key, url.UserID, existingKey, existing.userID)
+		current, currentHas := s.shard(url.Short).urls[url.Short]
+		if currentKey, _ := s.dedupKey(current); currentHas != has || currentKey != existingKey || current.userID != existing.userID {
+			unlock()
+			continue
+		}
+		defer unlock()
+		if shortURL, taken := s.shard(key).shortURLs[key]; dedup && taken && shortURL != url.Short {
+			return ErrConflictURL
+		}
+		if has {
+			s.remove(url.Short, current)
+		}
+		s.insert(url.Short, replacement)
+		return nil
+	}
+}
+
+// Check whether storage alive.
+func (s *ShardedStorage) Ping() error {
+	return nil
+}
+
+// Returns short urls of user in order of saving.
+func (s *ShardedStorage) userURLs(userID string) []string {
+	sh := s.shard(userID)
+	sh.RLock()
+	defer sh.RUnlock()
+	return slices.Clone(sh.userURLs[userID])
+}
+
+// Returns all urls saved by user.
+func (s *ShardedStorage) GetUserURLs(_ context.Context, userID string) ([]URLPair, error) {
+	var res []URLPair
+	for _, shortURL := range s.userURLs(userID) {
+		if url, has := s.get(shortURL); has {
+			options := url.options
+			options.CreatedAt = time.Time{}
+			res = append(res, URLPair{Short: shortURL, Long: url.long, URLOptions: options})
+		}
+	}
+	return res, nil
+}
+
+// Returns page of urls saved by user ordered by creation time.
+func (s *ShardedStorage) GetUserURLsPageWithContext(_ context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	shortURLs := s.userURLs(userID)
+	urls := make([]StoredURL, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		if url, has := s.get(shortURL); has {
+			urls = append(urls, url.stored(shortURL))
+		}
+	}
+	return paginate(urls, query)
+}
+
+// Returns number of stored not deleted urls.
+func (s *ShardedStorage) CountURLsWithContext(_ context.Context) (int64, error) {
+	var count int64
+	for i := range s.shards {
+		s.shards[i].RLock()
+		for _, url := range s.shards[i].urls {
+			if !url.deleted {
+				count++
+			}
+		}
+		s.shards[i].RUnlock()
+	}
+	return count, nil
+}
+
+// Returns whether short url is saved by user.
+func (s *ShardedStorage) OwnsURLWithContext(_ context.Context, userID string, shortURL string) (bool, error) {
+	url, has := s.get(shortURL)
+	return has && url.userID == userID, nil
+}
+
+// Returns number of users that have saved urls.
+func (s *ShardedStorage) CountUsersWithContext(_ context.Context) (int64, error) {
+	var count int64
+	for i := range s.shards {
+		s.shards[i].RLock()
+		count += int64(len(s.shards[i].userURLs))
+		s.shards[i].RUnlock()
+	}
+	return count, nil
+}
+
+// Deletes given urls previously saved by user.
+//
+// Urls saved by other users are silently skipped.
+func (s *ShardedStorage) DeleteUserURLs(_ context.Context, urlsByUser ...URLsForDelete) error {
+	for _, urls := range urlsByUser {
+		for _, shortURL := range urls.ShortURLs {
+			sh := s.shard(shortURL)
+			sh.Lock()
+			if url, has := sh.urls[shortURL]; has && url.userID == urls.UserID {
+				url.deleted = true
+				sh.urls[shortURL] = url
+			}
+			sh.Unlock()
+		}
+	}
+	return nil
+}
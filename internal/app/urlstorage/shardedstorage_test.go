@@ -0,0 +1,211 @@
+package urlstorage_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+func TestShardedStorage_Store(t *testing.T) {
+	storage := urlstorage.NewShardedStorage(4)
+	ctx := context.Background()
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "", urlstorage.URLOptions{}))
+	tests := []struct {
+		name          string
+		longURL       string
+		shortURL      string
+		expectedError error
+	}{
+		{name: "store_a", longURL: "url_a", shortURL: "a", expectedError: urlstorage.ErrConflictURL},
+		{name: "store_taken", longURL: "url_c", shortURL: "a", expectedError: urlstorage.ErrShortURLTaken},
+		{name: "store_b", longURL: "url_b", shortURL: "b", expectedError: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := storage.StoreWithContext(ctx, tt.longURL, tt.shortURL, "", urlstorage.URLOptions{})
+			require.Equal(t, tt.expectedError, err)
+		})
+	}
+	require.EqualError(t, storage.StoreWithContext(ctx, "", "", "", urlstorage.URLOptions{}), "cannot save empty url")
+
+	errs, err := storage.StoreManyWithContext(ctx, []urlstorage.URLPair{
+		{Long: "url_c", Short: "c"}, {Long: "url_b", Short: "d"}, {Long: "url_e", Short: ""}}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []error{nil, urlstorage.ErrConflictURL}, errs)
+
+	longURL, err := storage.GetLongURLWithContext(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, "url_c", longURL)
+	shortURL, err := storage.GetShortURLWithContext(ctx, "url_b", "")
+	require.NoError(t, err)
+	assert.Equal(t, "b", shortURL)
+	_, err = storage.GetLongURLWithContext(ctx, "d")
+	require.ErrorIs(t, err, urlstorage.ErrNotFoundURL)
+	_, err = storage.GetShortURLWithContext(ctx, "url_d", "")
+	require.EqualError(t, err, "no such longUrl")
+
+	count, err := storage.CountURLsWithContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	require.NoError(t, storage.Clear())
+	count, err = storage.CountURLsWithContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestShardedStorage_Update(t *testing.T) {
+	storage := urlstorage.NewShardedStorage(4)
+	ctx := context.Background()
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_b", "b", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_d", "d", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"d"}}))
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		change  urlstorage.URLChange
+		wantErr error
+	}{
+		{name: "update", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now}},
+		{name: "same_url", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now}},
+		{name: "other_user", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_e", ChangedBy: "user_2"},
+			wantErr: urlstorage.ErrNotOwnedURL},
+		{name: "unknown", change: urlstorage.URLChange{ShortURL: "x", LongURL: "url_e", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrNotOwnedURL},
+		{name: "deleted", change: urlstorage.URLChange{ShortURL: "d", LongURL: "url_e", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrDeletedURL},
+		{name: "conflict", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_b", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrConflictURL},
+		{name: "empty", change: urlstorage.URLChange{ShortURL: "a", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrEmptyLongURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := storage.UpdateWithContext(ctx, tt.change)
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	shortURL, err := storage.GetShortURLWithContext(ctx, "url_c", "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", shortURL)
+	_, err = storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+	require.Error(t, err, "old long url must be released")
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "e", "user_2", urlstorage.URLOptions{}))
+
+	history, err := storage.GetHistoryWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.URLChange{
+		{ShortURL: "a", PreviousURL: "url_a", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now}}, history)
+}
+
+func TestShardedStorage_UserURLs(t *testing.T) {
+	storage := urlstorage.NewShardedStorage(4)
+	storage.DedupScope = urlstorage.DedupPerUser
+	ctx := context.Background()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	options := urlstorage.URLOptions{CreatedAt: createdAt, NormalizedURL: "url_a", RedirectCode: 301}
+	require.NoError(t, storage.StoreWithContext(ctx, "URL_A", "a", "user_1", options))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "b", "user_2", urlstorage.URLOptions{CreatedAt: createdAt}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_c", "c", "user_1", urlstorage.URLOptions{CreatedAt: createdAt.Add(time.Hour)}))
+	require.NoError(t, storage.DeleteUserURLs(ctx,
+		urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"a", "b"}}))
+
+	urls, err := storage.GetUserURLs(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.URLPair{
+		{Short: "a", Long: "URL_A", URLOptions: urlstorage.URLOptions{NormalizedURL: "url_a", RedirectCode: 301}},
+		{Short: "c", Long: "url_c"}}, urls)
+
+	page, err := storage.GetUserURLsPageWithContext(ctx, "user_1", urlstorage.UserURLsQuery{Descending: true, IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.StoredURL{
+		{URLPair: urlstorage.URLPair{Short: "c", Long: "url_c", URLOptions: urlstorage.URLOptions{CreatedAt: createdAt.Add(time.Hour)}}},
+		{URLPair: urlstorage.URLPair{Short: "a", Long: "URL_A", URLOptions: options}, Deleted: true}}, page.URLs)
+
+	_, err = storage.GetLongURLWithContext(ctx, "a")
+	require.ErrorIs(t, err, urlstorage.ErrDeletedURL)
+	longURL, err := storage.GetLongURLWithContext(ctx, "b")
+	require.NoError(t, err, "url of other user is not deleted")
+	assert.Equal(t, "url_a", longURL)
+	users, err := storage.CountUsersWithContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), users)
+}
+
+func TestShardedStorage_PurgeExpired(t *testing.T) {
+	storage := urlstorage.NewShardedStorage(4)
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{ExpiresAt: now.Add(-time.Hour)}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_b", "b", "user_1", urlstorage.URLOptions{ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_c", "c", "user_2", urlstorage.URLOptions{ExpiresAt: now.Add(-time.Hour)}))
+
+	_, err := storage.GetLongURLWithContext(ctx, "a")
+	require.ErrorIs(t, err, urlstorage.ErrExpiredURL)
+	purged, err := storage.PurgeExpiredWithContext(ctx, now)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "c"}, purged)
+
+	_, err = storage.GetURLWithContext(ctx, "a")
+	require.ErrorIs(t, err, urlstorage.ErrNotFoundURL)
+	urls, err := storage.GetUserURLs(ctx, "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.URLPair{{Short: "b", Long: "url_b", URLOptions: urlstorage.URLOptions{ExpiresAt: now.Add(time.Hour)}}}, urls)
+	users, err := storage.CountUsersWithContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), users)
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "d", "user_2", urlstorage.URLOptions{}), "long url of purged url is released")
+}
+
+func TestShardedStorage_Concurrent(t *testing.T) {
+	storage := urlstorage.NewShardedStorage(4)
+	ctx := context.Background()
+	stored := make([][]string, 8)
+	var wg sync.WaitGroup
+	for worker := range stored {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID := "user_" + strconv.Itoa(worker)
+			for i := range 100 {
+				shortURL := userID + "_" + strconv.Itoa(i)
+				// Pairs of workers store the same long urls and move them to the same new long urls.
+				longURL := "url_" + strconv.Itoa((worker/2)*100+i)
+				err := storage.StoreWithContext(ctx, longURL, shortURL, userID, urlstorage.URLOptions{})
+				if err != nil {
+					assert.ErrorIs(t, err, urlstorage.ErrConflictURL)
+					continue
+				}
+				stored[worker] = append(stored[worker], shortURL)
+				_, err = storage.UpdateWithContext(ctx, urlstorage.URLChange{ShortURL: shortURL, LongURL: longURL + "_new", ChangedBy: userID})
+				if err != nil {
+					assert.ErrorIs(t, err, urlstorage.ErrConflictURL)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var total int64
+	for _, shortURLs := range stored {
+		for _, shortURL := range shortURLs {
+			longURL, err := storage.GetLongURLWithContext(ctx, shortURL)
+			require.NoError(t, err)
+			found, err := storage.GetShortURLWithContext(ctx, longURL, "")
+			require.NoError(t, err)
+			assert.Equal(t, shortURL, found, "long url must point back to its short url")
+			total++
+		}
+	}
+	count, err := storage.CountURLsWithContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, total, count)
+}
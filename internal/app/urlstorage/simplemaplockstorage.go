@@ -3,21 +3,42 @@ package urlstorage
 import (
 	"context"
 	"errors"
+	"slices"
 	"sync"
+	"time"
 )
 
 // Storage storing urls in memory.
 type SimpleMapLockStorage struct {
-	ShortURL2Url map[string]string
-	URL2ShortURL map[string]string
-	Mutex        sync.Mutex // for thread safe storage operations
+	ShortURL2Url  map[string]string
+	URL2ShortURL  map[string]string      // short urls by dedup keys of normalized long urls
+	ShortURL2User map[string]string      // owner of every short url
+	UserURLs      map[string][]string    // short urls of user in order of saving
+	DeletedURLs   map[string]bool        // short urls deleted by owner
+	ExpiresAt     map[string]time.Time   // expiration time of expiring short urls
+	CreatedAt     map[string]time.Time   // creation time of short urls
+	NormalizedURL map[string]string      // normalized long urls of short urls differing from long urls
+	Interstitial  map[string]bool        // short urls showing warning page before redirect
+	RedirectCode  map[string]int         // redirect statuses of short urls not using default one
+	History       map[string][]URLChange // changes of long urls of short urls
+	DedupScope    DedupScope             // scope of URL2ShortURL keys, zero value is global
+	Mutex         sync.Mutex             // for thread safe storage operations
 }
 
 // New inmemory url storage.
 func NewSimpleMapLockStorage() *SimpleMapLockStorage {
 	return &SimpleMapLockStorage{
-		ShortURL2Url: make(map[string]string),
-		URL2ShortURL: make(map[string]string)}
+		ShortURL2Url:  make(map[string]string),
+		URL2ShortURL:  make(map[string]string),
+		ShortURL2User: make(map[string]string),
+		UserURLs:      make(map[string][]string),
+		DeletedURLs:   make(map[string]bool),
+		ExpiresAt:     make(map[string]time.Time),
+		CreatedAt:     make(map[string]time.Time),
+		NormalizedURL: make(map[string]string),
+		Interstitial:  make(map[string]bool),
+		RedirectCode:  make(map[string]int),
+		History:       make(map[string][]URLChange)}
 }
 
 // Returns longURL from shortURL.
@@ -26,17 +47,39 @@ func (s *SimpleMapLockStorage) GetLongURLWithContext(_ context.Context, shortURL
 	defer s.Mutex.Unlock()
 	val, has := s.ShortURL2Url[shortURL]
 	if !has {
-		return "", errors.New("no such shortUrl")
-	} else {
-		return val, nil
+		return "", ErrNotFoundURL
+	}
+	if s.DeletedURLs[shortURL] {
+		return "", ErrDeletedURL
 	}
+	if s.options(shortURL).ExpiredAt(time.Now()) {
+		return "", ErrExpiredURL
+	}
+	return val, nil
 }
 
-// Returns shortURL from longURL.
-func (s *SimpleMapLockStorage) GetShortURLWithContext(_ context.Context, longURL string) (string, error) {
+// Returns saved url with its options and creation time, deleted and expired urls are returned too.
+func (s *SimpleMapLockStorage) GetURLWithContext(_ context.Context, shortURL string) (StoredURL, error) {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
-	val, has := s.URL2ShortURL[longURL]
+	longURL, has := s.ShortURL2Url[shortURL]
+	if !has {
+		return StoredURL{}, ErrNotFoundURL
+	}
+	options := s.options(shortURL)
+	options.CreatedAt = s.CreatedAt[shortURL]
+	return StoredURL{URLPair: URLPair{Short: shortURL, Long: longURL, URLOptions: options}, Deleted: s.DeletedURLs[shortURL]}, nil
+}
+
+// Returns shortURL by normalized form of longURL in dedup scope of user.
+func (s *SimpleMapLockStorage) GetShortURLWithContext(_ context.Context, normalizedURL string, userID string) (string, error) {
+	key, dedup := s.DedupScope.Key(userID, normalizedURL)
+	if !dedup {
+		return "", ErrNotDeduplicated
+	}
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	val, has := s.URL2ShortURL[key]
 	if !has {
 		return "", errors.New("no such longUrl")
 	} else {
@@ -44,25 +87,115 @@ func (s *SimpleMapLockStorage) GetShortURLWithContext(_ context.Context, longURL
 	}
 }
 
+// Returns options of saved url. Must be called under lock.
+func (s *SimpleMapLockStorage) options(shortURL string) URLOptions {
+	return URLOptions{
+		ExpiresAt:     s.ExpiresAt[shortURL],
+		NormalizedURL: s.NormalizedURL[shortURL],
+		Interstitial:  s.Interstitial[shortURL],
+		RedirectCode:  s.RedirectCode[shortURL],
+	}
+}
+
+// Returns dedup key of saved short url and whether it is deduplicated. Must be called under lock.
+func (s *SimpleMapLockStorage) dedupKey(shortURL string) (string, bool) {
+	return s.DedupScope.Key(s.ShortURL2User[shortURL], s.options(shortURL).DedupKey(s.ShortURL2Url[shortURL]))
+}
+
+// Returns short url holding dedup key, expired urls do not hold their keys. Must be called under lock.
+func (s *SimpleMapLockStorage) keyHolder(key string, dedup bool) (string, bool) {
+	shortURL, has := s.URL2ShortURL[key]
+	if !dedup || !has || s.options(shortURL).ExpiredAt(time.Now()) {
+		return "", false
+	}
+	return shortURL, true
+}
+
+// Checks whether url can be saved under short url. Must be called under lock.
+//
+// Short url is taken if it is saved with another dedup key or expired, long url conflicts if its dedup key is held.
+func (s *SimpleMapLockStorage) checkStore(shortURL string, key string, dedup bool) error {
+	if _, has := s.ShortURL2Url[shortURL]; has {
+		existingKey, existingDedup := s.dedupKey(shortURL)
+		if !dedup || !existingDedup || existingKey != key || s.options(shortURL).ExpiredAt(time.Now()) {
+			return ErrShortURLTaken
+		}
+	}
+	if _, held := s.keyHolder(key, dedup); held {
+		return ErrConflictURL
+	}
+	return nil
+}
+
+// Saves normalized long url of short url if it differs from long url. Must be called under lock.
+func (s *SimpleMapLockStorage) setNormalizedURL(shortURL string, longURL string, normalizedURL string) {
+	if s.NormalizedURL == nil {
+		s.NormalizedURL = make(map[string]string)
+	}
+	delete(s.NormalizedURL, shortURL)
+	if normalizedURL != "" && normalizedURL != longURL {
+		s.NormalizedURL[shortURL] = normalizedURL
+	}
+}
+
+// Removes dedup key of saved short url. Must be called under lock.
+func (s *SimpleMapLockStorage) deleteDedupKey(shortURL string) {
+	if key, dedup := s.dedupKey(shortURL); dedup && s.URL2ShortURL[key] == shortURL {
+		delete(s.URL2ShortURL, key)
+	}
+}
+
+// Saves mapping and its owner. Must be called under lock.
+func (s *SimpleMapLockStorage) store(longURL string, shortURL string, userID string, options URLOptions) {
+	s.ShortURL2Url[shortURL] = longURL
+	if key, dedup := s.DedupScope.Key(userID, options.DedupKey(longURL)); dedup {
+		s.URL2ShortURL[key] = shortURL
+	}
+	s.setNormalizedURL(shortURL, longURL, options.NormalizedURL)
+	s.ShortURL2User[shortURL] = userID
+	s.UserURLs[userID] = append(s.UserURLs[userID], shortURL)
+	if !options.ExpiresAt.IsZero() {
+		s.ExpiresAt[shortURL] = options.ExpiresAt
+	}
+	if options.Interstitial {
+		if s.Interstitial == nil {
+			s.Interstitial = make(map[string]bool)
+		}
+		s.Interstitial[shortURL] = true
+	}
+	if options.RedirectCode != 0 {
+		if s.RedirectCode == nil {
+			s.RedirectCode = make(map[string]int)
+		}
+		s.RedirectCode[shortURL] = options.RedirectCode
+	}
+	if s.CreatedAt == nil {
+		s.CreatedAt = make(map[string]time.Time)
+	}
+	s.CreatedAt[shortURL] = options.CreatedAt
+	if options.CreatedAt.IsZero() {
+		s.CreatedAt[shortURL] = time.Now().UTC()
+	}
+}
+
 // Adds mapping longURL -> shortURL.
-func (s *SimpleMapLockStorage) StoreWithContext(_ context.Context, longURL string, shortURL string, _ string) error {
+func (s *SimpleMapLockStorage) StoreWithContext(_ context.Context, longURL string, shortURL string, userID string, options URLOptions) error {
 	if shortURL == "" {
 		return errors.New("cannot save empty url")
 	}
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
-	_, has := s.URL2ShortURL[longURL]
-	if has {
-		return ErrConflictURL
+	key, dedup := s.DedupScope.Key(userID, options.DedupKey(longURL))
+	if err := s.checkStore(shortURL, key, dedup); err != nil {
+		return err
 	}
 
-	s.ShortURL2Url[shortURL] = longURL
-	s.URL2ShortURL[longURL] = shortURL
+	s.store(longURL, shortURL, userID, options)
 	return nil
 }
 
 // Adds number of mappings longURL -> shortURL.
-func (s *SimpleMapLockStorage) StoreManyWithContext(_ context.Context, long2ShortUrls []URLPair, _ string) ([]error, error) {
+func (s *SimpleMapLockStorage) StoreManyWithContext(_ context.Context, long2ShortUrls []URLPair, userID string) ([]error, error) {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
 	var errs []error
@@ -72,22 +205,163 @@ func (s *SimpleMapLockStorage) StoreManyWithContext(_ context.Context, long2Shor
 		if shortURL == "" {
 			continue
 		}
-		_, has := s.URL2ShortURL[longURL]
-		if has {
-			errs = append(errs, ErrConflictURL)
-		} else {
-			s.ShortURL2Url[shortURL] = longURL
-			s.URL2ShortURL[longURL] = shortURL
-			errs = append(errs, nil)
+		key, dedup := s.DedupScope.Key(userID, long2ShortUrls[i].DedupKey(longURL))
+		err := s.checkStore(shortURL, key, dedup)
+		if err == nil {
+			s.store(longURL, shortURL, userID, long2ShortUrls[i].URLOptions)
 		}
+		errs = append(errs, err)
 	}
 	return errs, nil
 }
 
+// Points short url of user to new long url and saves change to history.
+//
+// Change to the same long url is not saved.
+func (s *SimpleMapLockStorage) UpdateWithContext(_ context.Context, change URLChange) (URLChange, error) {
+	if change.LongURL == "" {
+		return change, ErrEmptyLongURL
+	}
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	previousURL, has := s.ShortURL2Url[change.ShortURL]
+	if !has || s.ShortURL2User[change.ShortURL] != change.ChangedBy {
+		return change, ErrNotOwnedURL
+	}
+	if s.DeletedURLs[change.ShortURL] {
+		return change, ErrDeletedURL
+	}
+	change.PreviousURL = previousURL
+	if previousURL == change.LongURL {
+		return change, nil
+	}
+	key, dedup := s.DedupScope.Key(change.ChangedBy, URLOptions{NormalizedURL: change.NormalizedURL}.DedupKey(change.LongURL))
+	if shortURL, held := s.keyHolder(key, dedup); held && shortURL != change.ShortURL {
+		return change, ErrConflictURL
+	}
+	s.deleteDedupKey(change.ShortURL)
+	if dedup {
+		s.URL2ShortURL[key] = change.ShortURL
+	}
+	s.ShortURL2Url[change.ShortURL] = change.LongURL
+	s.setNormalizedURL(change.ShortURL, change.LongURL, change.NormalizedURL)
+	s.appendHistory(change)
+	return change, nil
+}
+
+// Appends changes to history. Must be called under lock.
+func (s *SimpleMapLockStorage) appendHistory(changes ...URLChange) {
+	if s.History == nil {
+		s.History = make(map[string][]URLChange)
+	}
+	for _, change := range changes {
+		s.History[change.ShortURL] = append(s.History[change.ShortURL], change)
+	}
+}
+
+// Appends changes made before to history without applying them.
+func (s *SimpleMapLockStorage) RestoreHistory(changes ...URLChange) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.appendHistory(changes...)
+}
+
+// Returns changes of short url in order they were made.
+func (s *SimpleMapLockStorage) GetHistoryWithContext(_ context.Context, shortURL string) ([]URLChange, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return slices.Clone(s.History[shortURL]), nil
+}
+
 // Clear all mappings.
 func (s *SimpleMapLockStorage) Clear() error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
 	s.ShortURL2Url = make(map[string]string)
 	s.URL2ShortURL = make(map[string]string)
+	s.ShortURL2User = make(map[string]string)
+	s.UserURLs = make(map[string][]string)
+	s.DeletedURLs = make(map[string]bool)
+	s.ExpiresAt = make(map[string]time.Time)
+	s.CreatedAt = make(map[string]time.Time)
+	s.NormalizedURL = make(map[string]string)
+	s.Interstitial = make(map[string]bool)
+	s.RedirectCode = make(map[string]int)
+	s.History = make(map[string][]URLChange)
+	return nil
+}
+
+// Removes urls expired before given moment.
+func (s *SimpleMapLockStorage) PurgeExpiredWithContext(_ context.Context, expiredBefore time.Time) ([]string, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var purged []string
+	for shortURL, expiresAt := range s.ExpiresAt {
+		if !expiresAt.Before(expiredBefore) {
+			continue
+		}
+		s.remove(shortURL)
+		purged = append(purged, shortURL)
+	}
+	return purged, nil
+}
+
+// Removes saved url with its history. Must be called under lock.
+func (s *SimpleMapLockStorage) remove(shortURL string) {
+	userID := s.ShortURL2User[shortURL]
+	s.UserURLs[userID] = slices.DeleteFunc(s.UserURLs[userID], func(url string) bool { return url == shortURL })
+	s.deleteDedupKey(shortURL)
+	delete(s.ShortURL2Url, shortURL)
+	delete(s.NormalizedURL, shortURL)
+	delete(s.Interstitial, shortURL)
+	delete(s.RedirectCode, shortURL)
+	delete(s.ShortURL2User, shortURL)
+	delete(s.DeletedURLs, shortURL)
+	delete(s.ExpiresAt, shortURL)
+	delete(s.CreatedAt, shortURL)
+	delete(s.History, shortURL)
+}
+
+// Calls fn for every saved url including deleted and expired ones in order of creation.
+func (s *SimpleMapLockStorage) ForEachURLWithContext(_ context.Context, fn func(url OwnedURL) error) error {
+	s.Mutex.Lock()
+	urls := make([]OwnedURL, 0, len(s.ShortURL2Url))
+	for shortURL, longURL := range s.ShortURL2Url {
+		options := s.options(shortURL)
+		options.CreatedAt = s.CreatedAt[shortURL]
+		urls = append(urls, OwnedURL{
+			StoredURL: StoredURL{URLPair: URLPair{Short: shortURL, Long: longURL, URLOptions: options}, Deleted: s.DeletedURLs[shortURL]},
+			UserID:    s.ShortURL2User[shortURL],
+		})
+	}
+	s.Mutex.Unlock()
+	sortOwnedURLs(urls)
+	for _, url := range urls {
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Saves url with its owner and deletion flag instead of url saved under the same short url.
+func (s *SimpleMapLockStorage) ReplaceWithContext(_ context.Context, url OwnedURL) error {
+	if url.Short == "" {
+		return errors.New("cannot save empty url")
+	}
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	key, dedup := s.DedupScope.Key(url.UserID, url.DedupKey(url.Long))
+	if shortURL, has := s.URL2ShortURL[key]; dedup && has && shortURL != url.Short {
+		return ErrConflictURL
+	}
+	if _, has := s.ShortURL2Url[url.Short]; has {
+		s.remove(url.Short)
+	}
+	s.store(url.Long, url.Short, url.UserID, url.URLOptions)
+	if url.Deleted {
+		s.DeletedURLs[url.Short] = true
+	}
 	return nil
 }
 
@@ -96,16 +370,79 @@ func (s *SimpleMapLockStorage) Ping() error {
 	return nil
 }
 
-// TODO: implement
-//
 // Returns all urls saved by user.
-func (s *SimpleMapLockStorage) GetUserURLs(ctx context.Context, userID string) ([]URLPair, error) {
-	return nil, errors.New("not implemented")
+func (s *SimpleMapLockStorage) GetUserURLs(_ context.Context, userID string) ([]URLPair, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var res []URLPair
+	for _, shortURL := range s.UserURLs[userID] {
+		res = append(res, URLPair{Short: shortURL, Long: s.ShortURL2Url[shortURL], URLOptions: s.options(shortURL)})
+	}
+	return res, nil
+}
+
+// Returns page of urls saved by user ordered by creation time.
+func (s *SimpleMapLockStorage) GetUserURLsPageWithContext(_ context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	s.Mutex.Lock()
+	urls := make([]StoredURL, 0, len(s.UserURLs[userID]))
+	for _, shortURL := range s.UserURLs[userID] {
+		options := s.options(shortURL)
+		options.CreatedAt = s.CreatedAt[shortURL]
+		urls = append(urls, StoredURL{
+			URLPair: URLPair{Short: shortURL, Long: s.ShortURL2Url[shortURL], URLOptions: options},
+			Deleted: s.DeletedURLs[shortURL],
+		})
+	}
+	s.Mutex.Unlock()
+	return paginate(urls, query)
+}
+
+// Returns number of stored not deleted urls.
+func (s *SimpleMapLockStorage) CountURLsWithContext(_ context.Context) (int64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var count int64
+	for shortURL := range s.ShortURL2Url {
+		if !s.DeletedURLs[shortURL] {
+			count++
+		}
+	}
+	return count, nil
 }
 
-// TODO: implement
+// Returns whether short url is saved by user.
+func (s *SimpleMapLockStorage) OwnsURLWithContext(_ context.Context, userID string, shortURL string) (bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	owner, has := s.ShortURL2User[shortURL]
+	return has && owner == userID, nil
+}
+
+// Returns number of users that have saved urls.
+func (s *SimpleMapLockStorage) CountUsersWithContext(_ context.Context) (int64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var count int64
+	for _, shortURLs := range s.UserURLs {
+		if len(shortURLs) != 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Deletes given urls previously saved by user.
 //
-// Returns all urls saved by user.
-func (s *SimpleMapLockStorage) DeleteUserURLs(context context.Context, urls ...URLsForDelete) error {
-	return errors.New("not implemented")
+// Urls saved by other users are silently skipped.
+func (s *SimpleMapLockStorage) DeleteUserURLs(_ context.Context, urlsByUser ...URLsForDelete) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for _, urls := range urlsByUser {
+		for _, shortURL := range urls.ShortURLs {
+			if owner, has := s.ShortURL2User[shortURL]; has && owner == urls.UserID {
+				s.DeletedURLs[shortURL] = true
+			}
+		}
+	}
+	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,8 +13,11 @@ import (
 
 func TestSimpleMapLockStorage_GetLongURL(t *testing.T) {
 	storage := urlstorage.SimpleMapLockStorage{
-		ShortURL2Url: map[string]string{"a": "url_a"},
-		URL2ShortURL: map[string]string{"url_a": "a"}}
+		ShortURL2Url:  map[string]string{"a": "url_a"},
+		URL2ShortURL:  map[string]string{"url_a": "a"},
+		ShortURL2User: map[string]string{"a": ""},
+		UserURLs:      map[string][]string{"": {"a"}},
+		DeletedURLs:   map[string]bool{}}
 	tests := []struct {
 		name     string
 		s        *urlstorage.SimpleMapLockStorage
@@ -40,8 +44,11 @@ func TestSimpleMapLockStorage_GetLongURL(t *testing.T) {
 
 func TestSimpleMapLockStorage_GetShortURL(t *testing.T) {
 	storage := urlstorage.SimpleMapLockStorage{
-		ShortURL2Url: map[string]string{"a": "url_a"},
-		URL2ShortURL: map[string]string{"url_a": "a"}}
+		ShortURL2Url:  map[string]string{"a": "url_a"},
+		URL2ShortURL:  map[string]string{"url_a": "a"},
+		ShortURL2User: map[string]string{"a": ""},
+		UserURLs:      map[string][]string{"": {"a"}},
+		DeletedURLs:   map[string]bool{}}
 	tests := []struct {
 		name    string
 		s       *urlstorage.SimpleMapLockStorage
@@ -55,7 +62,7 @@ func TestSimpleMapLockStorage_GetShortURL(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.s.GetShortURLWithContext(context.Background(), tt.longURL)
+			got, err := tt.s.GetShortURLWithContext(context.Background(), tt.longURL, "")
 			if tt.wantErr != nil {
 				require.EqualError(t, err, tt.wantErr.Error())
 			} else {
@@ -68,8 +75,11 @@ func TestSimpleMapLockStorage_GetShortURL(t *testing.T) {
 
 func TestSimpleMapLockStorage_Store(t *testing.T) {
 	storage := urlstorage.SimpleMapLockStorage{
-		ShortURL2Url: map[string]string{"a": "url_a"},
-		URL2ShortURL: map[string]string{"url_a": "a"}}
+		ShortURL2Url:  map[string]string{"a": "url_a"},
+		URL2ShortURL:  map[string]string{"url_a": "a"},
+		ShortURL2User: map[string]string{"a": ""},
+		UserURLs:      map[string][]string{"": {"a"}},
+		DeletedURLs:   map[string]bool{}}
 	tests := []struct {
 		name          string
 		longURL       string
@@ -77,12 +87,13 @@ func TestSimpleMapLockStorage_Store(t *testing.T) {
 		expectedError error
 	}{
 		{name: "store_a", longURL: "url_a", shortURL: "a", expectedError: urlstorage.ErrConflictURL},
+		{name: "store_taken", longURL: "url_c", shortURL: "a", expectedError: urlstorage.ErrShortURLTaken},
 		{name: "store_b", longURL: "url_b", shortURL: "b", expectedError: nil},
 		{name: "store_empty", longURL: "", shortURL: "", expectedError: errors.New("cannot save empty url")},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := storage.StoreWithContext(context.Background(), tt.longURL, tt.shortURL, "")
+			err := storage.StoreWithContext(context.Background(), tt.longURL, tt.shortURL, "", urlstorage.URLOptions{})
 			require.Equal(t, tt.expectedError, err)
 			if err == nil {
 				assert.Subset(t, storage.URL2ShortURL, map[string]string{tt.longURL: tt.shortURL})
@@ -94,8 +105,11 @@ func TestSimpleMapLockStorage_Store(t *testing.T) {
 
 func TestSimpleMapLockStorage_StoreMany(t *testing.T) {
 	storage := urlstorage.SimpleMapLockStorage{
-		ShortURL2Url: map[string]string{"a": "url_a"},
-		URL2ShortURL: map[string]string{"url_a": "a"}}
+		ShortURL2Url:  map[string]string{"a": "url_a"},
+		URL2ShortURL:  map[string]string{"url_a": "a"},
+		ShortURL2User: map[string]string{"a": ""},
+		UserURLs:      map[string][]string{"": {"a"}},
+		DeletedURLs:   map[string]bool{}}
 	tests := []struct {
 		name          string
 		urlsToStore   []urlstorage.URLPair
@@ -143,14 +157,305 @@ func TestSimpleMapLockStorage_Ping(t *testing.T) {
 
 func TestSimpleMapLockStorage_GetUserURLs(t *testing.T) {
 	storage := urlstorage.NewSimpleMapLockStorage()
-	_, err := storage.GetUserURLs(context.Background(), "user_1")
-	// TODO: write tests
-	require.Error(t, err)
+	storage.StoreWithContext(context.Background(), "url_a", "a", "user_1", urlstorage.URLOptions{})
+	storage.StoreManyWithContext(context.Background(), []urlstorage.URLPair{
+		{Long: "url_b", Short: "b"},
+		{Long: "url_c", Short: "c"}}, "user_1")
+	storage.StoreWithContext(context.Background(), "url_d", "d", "user_2", urlstorage.URLOptions{})
+
+	tests := []struct {
+		name string
+		user string
+		want []urlstorage.URLPair
+	}{
+		{name: "user_1", user: "user_1", want: []urlstorage.URLPair{
+			{Long: "url_a", Short: "a"}, {Long: "url_b", Short: "b"}, {Long: "url_c", Short: "c"}}},
+		{name: "user_2", user: "user_2", want: []urlstorage.URLPair{{Long: "url_d", Short: "d"}}},
+		{name: "no_urls", user: "user_3", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storage.GetUserURLs(context.Background(), tt.user)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
 }
 
 func TestSimpleMapLockStorage_DeleteUserURLs(t *testing.T) {
 	storage := urlstorage.NewSimpleMapLockStorage()
-	err := storage.DeleteUserURLs(context.Background(), urlstorage.URLsForDelete{UserID: "user", ShortURLs: []string{"short1", "short2"}})
-	// TODO: write tests
+	storage.StoreManyWithContext(context.Background(), []urlstorage.URLPair{
+		{Long: "url_a", Short: "a"},
+		{Long: "url_b", Short: "b"}}, "user_1")
+	storage.StoreWithContext(context.Background(), "url_c", "c", "user_2", urlstorage.URLOptions{})
+
+	err := storage.DeleteUserURLs(context.Background(),
+		urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"a", "c", "unknown"}})
+	require.NoError(t, err)
+
+	_, err = storage.GetLongURLWithContext(context.Background(), "a")
+	require.ErrorIs(t, err, urlstorage.ErrDeletedURL)
+
+	got, err := storage.GetLongURLWithContext(context.Background(), "b")
+	require.NoError(t, err)
+	assert.Equal(t, "url_b", got)
+
+	got, err = storage.GetLongURLWithContext(context.Background(), "c")
+	require.NoError(t, err, "url of other user must not be deleted")
+	assert.Equal(t, "url_c", got)
+
+	err = storage.StoreWithContext(context.Background(), "url_a", "a2", "user_1", urlstorage.URLOptions{})
+	require.Equal(t, urlstorage.ErrConflictURL, err)
+}
+
+func TestSimpleMapLockStorage_Expiration(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	now := time.Now()
+	expired := urlstorage.URLOptions{ExpiresAt: now.Add(-time.Hour)}
+	storage.StoreWithContext(context.Background(), "url_a", "a", "user_1", expired)
+	storage.StoreManyWithContext(context.Background(), []urlstorage.URLPair{
+		{Long: "url_b", Short: "b", URLOptions: urlstorage.URLOptions{ExpiresAt: now.Add(time.Hour)}},
+		{Long: "url_c", Short: "c"}}, "user_1")
+
+	_, err := storage.GetLongURLWithContext(context.Background(), "a")
+	require.ErrorIs(t, err, urlstorage.ErrExpiredURL)
+	got, err := storage.GetLongURLWithContext(context.Background(), "b")
+	require.NoError(t, err)
+	assert.Equal(t, "url_b", got)
+
+	purged, err := storage.PurgeExpiredWithContext(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, purged)
+
+	urls, err := storage.GetUserURLs(context.Background(), "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.URLPair{
+		{Long: "url_b", Short: "b", URLOptions: urlstorage.URLOptions{ExpiresAt: now.Add(time.Hour)}},
+		{Long: "url_c", Short: "c"}}, urls)
+	_, err = storage.GetShortURLWithContext(context.Background(), "url_a", "user_1")
+	require.Error(t, err)
+}
+
+func TestSimpleMapLockStorage_Count(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	require.NoError(t, storage.StoreWithContext(context.Background(), "url_a", "a", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(context.Background(), "url_b", "b", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(context.Background(), "url_c", "c", "user_2", urlstorage.URLOptions{}))
+	require.NoError(t, storage.DeleteUserURLs(context.Background(),
+		urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"a"}}))
+
+	urls, err := storage.CountURLsWithContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), urls)
+	users, err := storage.CountUsersWithContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), users)
+}
+
+func TestSimpleMapLockStorage_Update(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	ctx := context.Background()
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_b", "b", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_d", "d", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"d"}}))
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		change  urlstorage.URLChange
+		wantErr error
+	}{
+		{name: "update", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now}},
+		{name: "same_url", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now}},
+		{name: "other_user", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_e", ChangedBy: "user_2"},
+			wantErr: urlstorage.ErrNotOwnedURL},
+		{name: "unknown", change: urlstorage.URLChange{ShortURL: "x", LongURL: "url_e", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrNotOwnedURL},
+		{name: "deleted", change: urlstorage.URLChange{ShortURL: "d", LongURL: "url_e", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrDeletedURL},
+		{name: "conflict", change: urlstorage.URLChange{ShortURL: "a", LongURL: "url_b", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrConflictURL},
+		{name: "empty", change: urlstorage.URLChange{ShortURL: "a", ChangedBy: "user_1"},
+			wantErr: urlstorage.ErrEmptyLongURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := storage.UpdateWithContext(ctx, tt.change)
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	longURL, err := storage.GetLongURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "url_c", longURL)
+	shortURL, err := storage.GetShortURLWithContext(ctx, "url_c", "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", shortURL)
+	_, err = storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+	require.Error(t, err, "old long url must be released")
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "e", "user_2", urlstorage.URLOptions{}))
+
+	history, err := storage.GetHistoryWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []urlstorage.URLChange{
+		{ShortURL: "a", PreviousURL: "url_a", LongURL: "url_c", ChangedBy: "user_1", ChangedAt: now}}, history)
+}
+
+func TestSimpleMapLockStorage_NormalizedURL(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	ctx := context.Background()
+	normalized := urlstorage.URLOptions{NormalizedURL: "url_a"}
+	require.NoError(t, storage.StoreWithContext(ctx, "URL_A", "a", "user_1", normalized))
+	require.ErrorIs(t, storage.StoreWithContext(ctx, "url_a", "b", "user_2", urlstorage.URLOptions{}), urlstorage.ErrConflictURL)
+	require.ErrorIs(t, storage.StoreWithContext(ctx, "url_a", "a", "user_2", urlstorage.URLOptions{}), urlstorage.ErrConflictURL,
+		"same normalized url under same short url is conflict rather than taken short url")
+
+	shortURL, err := storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", shortURL)
+	_, err = storage.GetShortURLWithContext(ctx, "URL_A", "user_1")
+	require.Error(t, err)
+	longURL, err := storage.GetLongURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "URL_A", longURL)
+
+	change, err := storage.UpdateWithContext(ctx, urlstorage.URLChange{ShortURL: "a", LongURL: "URL_B", NormalizedURL: "url_b", ChangedBy: "user_1"})
+	require.NoError(t, err)
+	assert.Equal(t, "URL_A", change.PreviousURL)
+	_, err = storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+	require.Error(t, err)
+	shortURL, err = storage.GetShortURLWithContext(ctx, "url_b", "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", shortURL)
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "c", "user_2", urlstorage.URLOptions{}))
+}
+
+func TestSimpleMapLockStorage_GetUserURLsPage(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, shortURL := range []string{"a", "b", "c", "d", "e"} {
+		options := urlstorage.URLOptions{CreatedAt: start.Add(time.Duration(i) * time.Hour)}
+		require.NoError(t, storage.StoreWithContext(ctx, "http://site.ru/"+shortURL, shortURL, "user", options))
+	}
+	require.NoError(t, storage.StoreWithContext(ctx, "http://other.ru", "f", "user", urlstorage.URLOptions{CreatedAt: start}))
+	require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user", ShortURLs: []string{"c"}}))
+
+	shorts := func(page urlstorage.UserURLsPage) []string {
+		var res []string
+		for _, url := range page.URLs {
+			res = append(res, url.Short)
+		}
+		return res
+	}
+	tests := []struct {
+		name  string
+		query urlstorage.UserURLsQuery
+		pages [][]string
+	}{
+		{name: "all", query: urlstorage.UserURLsQuery{}, pages: [][]string{{"a", "f", "b", "d", "e"}}},
+		{name: "paged", query: urlstorage.UserURLsQuery{Limit: 2},
+			pages: [][]string{{"a", "f"}, {"b", "d"}, {"e"}}},
+		{name: "descending", query: urlstorage.UserURLsQuery{Limit: 2, Descending: true},
+			pages: [][]string{{"e", "d"}, {"b", "f"}, {"a"}}},
+		{name: "include_deleted", query: urlstorage.UserURLsQuery{Limit: 3, IncludeDeleted: true},
+			pages: [][]string{{"a", "f", "b"}, {"c", "d", "e"}}},
+		{name: "contains", query: urlstorage.UserURLsQuery{LongURLPart: "site.ru"},
+			pages: [][]string{{"a", "b", "d", "e"}}},
+		{name: "created_range", query: urlstorage.UserURLsQuery{
+			CreatedAfter: start, CreatedBefore: start.Add(4 * time.Hour), IncludeDeleted: true},
+			pages: [][]string{{"b", "c", "d"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			for i, want := range tt.pages {
+				page, err := storage.GetUserURLsPageWithContext(ctx, "user", query)
+				require.NoError(t, err)
+				assert.Equal(t, want, shorts(page))
+				if i == len(tt.pages)-1 {
+					assert.Empty(t, page.NextCursor)
+				} else {
+					require.NotEmpty(t, page.NextCursor)
+				}
+				query.Cursor = page.NextCursor
+			}
+		})
+	}
+
+	page, err := storage.GetUserURLsPageWithContext(ctx, "user", urlstorage.UserURLsQuery{Limit: 1, IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, start, page.URLs[0].CreatedAt)
+	_, err = storage.GetUserURLsPageWithContext(ctx, "user", urlstorage.UserURLsQuery{Cursor: "???"})
+	require.ErrorIs(t, err, urlstorage.ErrInvalidCursor)
+}
+
+func TestSimpleMapLockStorage_DedupScope(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		scope         urlstorage.DedupScope
+		sameUserErr   error
+		otherUserErr  error
+		sameShortErr  error
+		wantLookupErr error
+	}{
+		{name: "global", scope: urlstorage.DedupGlobal,
+			sameUserErr: urlstorage.ErrConflictURL, otherUserErr: urlstorage.ErrConflictURL, sameShortErr: urlstorage.ErrConflictURL},
+		{name: "user", scope: urlstorage.DedupPerUser,
+			sameUserErr: urlstorage.ErrConflictURL, sameShortErr: urlstorage.ErrShortURLTaken},
+		{name: "none", scope: urlstorage.DedupNone,
+			sameShortErr: urlstorage.ErrShortURLTaken, wantLookupErr: urlstorage.ErrNotDeduplicated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := urlstorage.NewSimpleMapLockStorage()
+			storage.DedupScope = tt.scope
+			require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{}))
+			require.ErrorIs(t, storage.StoreWithContext(ctx, "url_a", "b", "user_1", urlstorage.URLOptions{}), tt.sameUserErr)
+			require.ErrorIs(t, storage.StoreWithContext(ctx, "url_a", "c", "user_2", urlstorage.URLOptions{}), tt.otherUserErr)
+			require.ErrorIs(t, storage.StoreWithContext(ctx, "url_a", "a", "user_3", urlstorage.URLOptions{}), tt.sameShortErr)
+
+			shortURL, err := storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+			require.ErrorIs(t, err, tt.wantLookupErr)
+			if tt.wantLookupErr == nil {
+				assert.Equal(t, "a", shortURL)
+			}
+		})
+	}
+}
+
+func TestSimpleMapLockStorage_DedupPerUserUpdate(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	storage.DedupScope = urlstorage.DedupPerUser
+	ctx := context.Background()
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{}))
+	require.NoError(t, storage.StoreWithContext(ctx, "url_b", "b", "user_2", urlstorage.URLOptions{}))
+
+	_, err := storage.UpdateWithContext(ctx, urlstorage.URLChange{ShortURL: "a", LongURL: "url_b", ChangedBy: "user_1"})
+	require.NoError(t, err, "url of other user does not conflict")
+	shortURL, err := storage.GetShortURLWithContext(ctx, "url_b", "user_1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", shortURL)
+	shortURL, err = storage.GetShortURLWithContext(ctx, "url_b", "user_2")
+	require.NoError(t, err)
+	assert.Equal(t, "b", shortURL)
+	_, err = storage.GetShortURLWithContext(ctx, "url_a", "user_1")
+	require.Error(t, err, "old long url must be released")
+}
+
+func TestSimpleMapLockStorage_GetURL(t *testing.T) {
+	storage := urlstorage.NewSimpleMapLockStorage()
+	ctx := context.Background()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	options := urlstorage.URLOptions{CreatedAt: createdAt, Interstitial: true}
+	require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", options))
+	require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user_1", ShortURLs: []string{"a"}}))
+
+	url, err := storage.GetURLWithContext(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, urlstorage.StoredURL{URLPair: urlstorage.URLPair{Short: "a", Long: "url_a", URLOptions: options}, Deleted: true}, url)
+	_, err = storage.GetURLWithContext(ctx, "b")
 	require.Error(t, err)
 }
@@ -0,0 +1,34 @@
+package urlstorage
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// Error in case storage cannot list or replace urls.
+var ErrTransferNotSupported = errors.New("storage cannot list and replace urls")
+
+// Saved url with its owner, unit of moving urls between storages.
+type OwnedURL struct {
+	StoredURL
+	UserID string
+}
+
+// Storage that can list and replace all saved urls, used to move urls between storages.
+type TransferStorage interface {
+	// Calls fn for every saved url including deleted and expired ones in order of creation.
+	// Stops at first error returned by fn.
+	ForEachURLWithContext(context context.Context, fn func(url OwnedURL) error) error
+
+	// Saves url with its owner and deletion flag instead of url saved under the same short url.
+	// Returns ErrConflictURL if long url is saved under another short url.
+	ReplaceWithContext(context context.Context, url OwnedURL) error
+}
+
+// Orders urls by creation time and short url.
+func sortOwnedURLs(urls []OwnedURL) {
+	slices.SortFunc(urls, func(a, b OwnedURL) int {
+		return pageCursor{createdAt: b.CreatedAt, shortURL: b.Short}.compare(a.StoredURL)
+	})
+}
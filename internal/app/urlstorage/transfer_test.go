@@ -0,0 +1,68 @@
+package urlstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valinurovdenis/urlshortener/internal/app/urlstorage"
+)
+
+type transferStorage interface {
+	urlstorage.URLStorage
+	urlstorage.UserURLStorage
+	urlstorage.TransferStorage
+}
+
+func TestTransferStorage_ForEachAndReplace(t *testing.T) {
+	storages := map[string]func(t *testing.T) transferStorage{
+		"simple_map": func(t *testing.T) transferStorage { return urlstorage.NewSimpleMapLockStorage() },
+		"sharded":    func(t *testing.T) transferStorage { return urlstorage.NewShardedStorage(4) },
+		"bolt":       func(t *testing.T) transferStorage { return newBoltStorage(t) },
+	}
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			ctx := context.Background()
+			require.NoError(t, storage.StoreWithContext(ctx, "url_b", "b", "user_1", urlstorage.URLOptions{CreatedAt: createdAt.Add(time.Hour)}))
+			require.NoError(t, storage.StoreWithContext(ctx, "url_a", "a", "user_1", urlstorage.URLOptions{CreatedAt: createdAt, RedirectCode: 301}))
+			require.NoError(t, storage.StoreWithContext(ctx, "url_c", "c", "user_2", urlstorage.URLOptions{CreatedAt: createdAt}))
+			require.NoError(t, storage.DeleteUserURLs(ctx, urlstorage.URLsForDelete{UserID: "user_2", ShortURLs: []string{"c"}}))
+
+			var urls []urlstorage.OwnedURL
+			require.NoError(t, storage.ForEachURLWithContext(ctx, func(url urlstorage.OwnedURL) error {
+				urls = append(urls, url)
+				return nil
+			}))
+			require.Len(t, urls, 3)
+			assert.Equal(t, []string{"a", "c", "b"}, []string{urls[0].Short, urls[1].Short, urls[2].Short})
+			assert.Equal(t, "user_1", urls[0].UserID)
+			assert.Equal(t, 301, urls[0].RedirectCode)
+			assert.True(t, urls[1].Deleted)
+
+			replaced := urlstorage.OwnedURL{UserID: "user_3", StoredURL: urlstorage.StoredURL{
+				URLPair: urlstorage.URLPair{Short: "a", Long: "url_d", URLOptions: urlstorage.URLOptions{CreatedAt: createdAt}}, Deleted: true}}
+			require.NoError(t, storage.ReplaceWithContext(ctx, replaced))
+			_, err := storage.GetLongURLWithContext(ctx, "a")
+			require.ErrorIs(t, err, urlstorage.ErrDeletedURL)
+			_, err = storage.GetShortURLWithContext(ctx, "url_a", "")
+			require.Error(t, err, "long url of replaced url is released")
+			userURLs, err := storage.GetUserURLs(ctx, "user_1")
+			require.NoError(t, err)
+			assert.Equal(t, []urlstorage.URLPair{{Short: "b", Long: "url_b"}}, userURLs)
+
+			require.NoError(t, storage.ReplaceWithContext(ctx, urlstorage.OwnedURL{UserID: "user_1", StoredURL: urlstorage.StoredURL{
+				URLPair: urlstorage.URLPair{Short: "e", Long: "url_e"}}}), "absent url is saved")
+			longURL, err := storage.GetLongURLWithContext(ctx, "e")
+			require.NoError(t, err)
+			assert.Equal(t, "url_e", longURL)
+
+			err = storage.ReplaceWithContext(ctx, urlstorage.OwnedURL{UserID: "user_1", StoredURL: urlstorage.StoredURL{
+				URLPair: urlstorage.URLPair{Short: "f", Long: "url_b"}}})
+			require.ErrorIs(t, err, urlstorage.ErrConflictURL)
+		})
+	}
+}
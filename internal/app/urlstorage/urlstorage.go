@@ -4,6 +4,7 @@ package urlstorage
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 // Error in case url already has been saved.
@@ -15,10 +16,59 @@ var ErrDeletedURL = errors.New("url has been deleted")
 // Empty URL
 var ErrEmptyLongURL = errors.New("cannot save empty url")
 
+// Error in case short url is already used for another url.
+var ErrShortURLTaken = errors.New("short url is already taken")
+
+// Error in case url expiration time has passed.
+var ErrExpiredURL = errors.New("url has expired")
+
+// Error in case short url is not saved.
+var ErrNotFoundURL = errors.New("no such shortUrl")
+
+// Error in case short url does not exist or is saved by another user.
+var ErrNotOwnedURL = errors.New("short url is not saved by user")
+
+// Optional properties of saved url.
+type URLOptions struct {
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // zero time means url never expires
+	CreatedAt time.Time `json:"created_at,omitzero"` // zero time is replaced with moment of saving
+	// Canonical form of long url by which urls are deduplicated, empty means long url itself.
+	NormalizedURL string `json:"normalized_url,omitempty"`
+	// Whether redirect shows warning page with destination instead of redirecting at once.
+	Interstitial bool `json:"interstitial,omitempty"`
+	// Status of redirect to long url, zero means default status of service.
+	RedirectCode int `json:"redirect_code,omitempty"`
+}
+
+// Whether url has expired by given moment.
+func (o URLOptions) ExpiredAt(moment time.Time) bool {
+	return !o.ExpiresAt.IsZero() && !moment.Before(o.ExpiresAt)
+}
+
+// Returns form of long url by which urls are deduplicated.
+func (o URLOptions) DedupKey(longURL string) string {
+	if o.NormalizedURL != "" {
+		return o.NormalizedURL
+	}
+	return longURL
+}
+
 // Auxiliary struct for mapping longURL <-> shortURL.
 type URLPair struct {
-	Short string
-	Long  string
+	Short string `json:"short_url"`
+	Long  string `json:"original_url"`
+	URLOptions
+}
+
+// Change of long url that short url points to.
+type URLChange struct {
+	ShortURL    string    `json:"short_url"`
+	PreviousURL string    `json:"previous_url"`
+	LongURL     string    `json:"original_url"`
+	ChangedBy   string    `json:"changed_by"`
+	ChangedAt   time.Time `json:"changed_at"`
+	// Canonical form of new long url, empty means long url itself.
+	NormalizedURL string `json:"normalized_url,omitempty"`
 }
 
 // Auxiliary struct for user urls for delete.
@@ -34,15 +84,32 @@ type URLStorage interface {
 	// Returns longURL from shortURL.
 	GetLongURLWithContext(context context.Context, shortURL string) (string, error)
 
-	// Returns shortURL from longURL.
-	GetShortURLWithContext(context context.Context, longURL string) (string, error)
+	// Returns saved url with its options and creation time, deleted and expired urls are returned too.
+	GetURLWithContext(context context.Context, shortURL string) (StoredURL, error)
+
+	// Returns shortURL by normalized form of longURL in dedup scope of user.
+	GetShortURLWithContext(context context.Context, normalizedURL string, userID string) (string, error)
 
 	// Adds mapping longURL -> shortURL.
-	StoreWithContext(context context.Context, longURL string, shortURL string, userID string) error
+	StoreWithContext(context context.Context, longURL string, shortURL string, userID string, options URLOptions) error
 
 	// Adds number of mappings longURL -> shortURL.
 	StoreManyWithContext(context context.Context, long2ShortUrls []URLPair, userID string) ([]error, error)
 
+	// Points short url of user to new long url and saves change to history.
+	// Previous url of change is filled by storage.
+	UpdateWithContext(context context.Context, change URLChange) (URLChange, error)
+
+	// Returns changes of short url in order they were made.
+	GetHistoryWithContext(context context.Context, shortURL string) ([]URLChange, error)
+
+	// Removes urls expired before given moment.
+	// Returns short urls of removed urls.
+	PurgeExpiredWithContext(context context.Context, expiredBefore time.Time) ([]string, error)
+
+	// Returns number of stored not deleted urls.
+	CountURLsWithContext(context context.Context) (int64, error)
+
 	// Clear all mappings.
 	Clear() error
 
@@ -50,6 +117,12 @@ type URLStorage interface {
 	Ping() error
 }
 
+// Storage that can load url history from backup, used by dump wrapper on restore.
+type HistoryRestorer interface {
+	// Appends changes made before to history without applying them.
+	RestoreHistory(changes ...URLChange)
+}
+
 // Storage contains urls saved and deleted by user.
 //
 //go:generate mockery --name UserURLStorage
@@ -57,9 +130,18 @@ type UserURLStorage interface {
 	// Returns all urls saved by user.
 	GetUserURLs(context context.Context, userID string) ([]URLPair, error)
 
+	// Returns page of urls saved by user ordered by creation time.
+	GetUserURLsPageWithContext(context context.Context, userID string, query UserURLsQuery) (UserURLsPage, error)
+
+	// Returns whether short url is saved by user, deleted urls are still owned by user.
+	OwnsURLWithContext(context context.Context, userID string, shortURL string) (bool, error)
+
 	// Deletes given urls previously saved by user.
 	DeleteUserURLs(context context.Context, urls ...URLsForDelete) error
 
+	// Returns number of users that have saved urls.
+	CountUsersWithContext(context context.Context) (int64, error)
+
 	// Clear all user urls.
 	Clear() error
 
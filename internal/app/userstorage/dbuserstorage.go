@@ -11,21 +11,10 @@ type DatabaseUserStorage struct {
 }
 
 // New postgresql user storage.
+//
+// Schema is expected to be created by migrations package.
 func NewDatabaseUserStorage(db *sql.DB) *DatabaseUserStorage {
-	ret := &DatabaseUserStorage{DB: db}
-	ret.init()
-	return ret
-}
-
-// Create all tables if needed.
-func (s *DatabaseUserStorage) init() error {
-	tx, err := s.DB.BeginTx(context.Background(), nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	tx.Exec(`CREATE TABLE user_id("id" SERIAL)`)
-	return tx.Commit()
+	return &DatabaseUserStorage{DB: db}
 }
 
 // Generates uuid for new user with no collision.